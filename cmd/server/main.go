@@ -3,6 +3,10 @@ package main
 import (
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"bookify/internal/db"
 	"bookify/internal/handlers"
@@ -35,8 +39,6 @@ func main() {
 		log.Println("✓ OAuth configuration found")
 	}
 
-	queueService := services.NewQueueService(dbService, driveService)
-
 	e := echo.New()
 	e.HideBanner = true
 	e.Use(middleware.Logger())
@@ -51,27 +53,192 @@ func main() {
 		log.Printf("Warning: Failed to create temp directory: %v", err)
 	}
 
+	dataDir := os.Getenv("DATA_DIR")
+	if dataDir == "" {
+		dataDir = "./data"
+	}
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		log.Printf("Warning: Failed to create data directory: %v", err)
+	}
+
+	queueService := services.NewQueueService(dbService, driveService)
+	queueService.SetTempDir(tempDir)
+	queueService.SetDataDir(dataDir)
+
+	var store *services.StoreService
+	if storeEnabled, _ := strconv.ParseBool(os.Getenv("STORE_ENABLED")); storeEnabled {
+		storeDir := os.Getenv("STORE_DIR")
+		if storeDir == "" {
+			storeDir = filepath.Join(dataDir, "store")
+		}
+		var opts services.StoreOptions
+		if days, err := strconv.Atoi(os.Getenv("STORE_RETENTION_DAYS")); err == nil && days > 0 {
+			opts.Retention = time.Duration(days) * 24 * time.Hour
+		}
+		if quotaMB, err := strconv.ParseInt(os.Getenv("STORE_QUOTA_MB"), 10, 64); err == nil && quotaMB > 0 {
+			opts.Quota = quotaMB << 20
+		}
+		store = services.NewStoreService(dbService, storeDir, opts)
+		queueService.SetStore(store)
+		log.Printf("✓ Keeping uploads and converted books in %s", storeDir)
+	}
+
 	h := &handlers.Handlers{
-		DB:      dbService,
-		Drive:   driveService,
-		TempDir: tempDir,
+		DB:             dbService,
+		Drive:          driveService,
+		Covers:         services.NewCoverService(dataDir),
+		Store:          store,
+		TempDir:        tempDir,
+		CalibreLibrary: os.Getenv("CALIBRE_LIBRARY"),
+		HotFolderRoot:  os.Getenv("HOT_FOLDER_ROOT"),
+		Fetch:          services.DefaultFetchOptions,
+	}
+	if maxMB, err := strconv.ParseInt(os.Getenv("URL_MAX_SIZE_MB"), 10, 64); err == nil && maxMB > 0 {
+		h.Fetch.MaxSize = maxMB << 20
+	}
+	if seconds, err := strconv.Atoi(os.Getenv("URL_TIMEOUT_SECONDS")); err == nil && seconds > 0 {
+		h.Fetch.Timeout = time.Duration(seconds) * time.Second
+	}
+	h.Fetch.AllowPrivate, _ = strconv.ParseBool(os.Getenv("URL_ALLOW_PRIVATE"))
+
+	// Sign-in with an OpenID Connect provider
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		provider := services.NewOIDCProvider(services.OIDCConfig{
+			Issuer:        issuer,
+			ClientID:      os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret:  os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:   os.Getenv("OIDC_REDIRECT_URL"),
+			Scopes:        strings.Fields(os.Getenv("OIDC_SCOPES")),
+			UsernameClaim: os.Getenv("OIDC_USERNAME_CLAIM"),
+			RolesClaim:    os.Getenv("OIDC_ROLES_CLAIM"),
+			AdminValues:   listEnv("OIDC_ADMIN_VALUES"),
+			AllowedValues: listEnv("OIDC_ALLOWED_VALUES"),
+		})
+		linkUsers, _ := strconv.ParseBool(os.Getenv("OIDC_LINK_USERS"))
+		h.OIDC = handlers.NewOIDCLogin(provider, os.Getenv("OIDC_NAME"), linkUsers)
+		log.Printf("✓ Signing in with OpenID Connect provider %s", issuer)
+	}
+
+	// Users signed in by a reverse proxy in front of Bookify
+	if header := os.Getenv("PROXY_AUTH_HEADER"); header != "" {
+		proxyAuth, err := handlers.NewProxyAuth(header, listEnv("PROXY_AUTH_TRUSTED"))
+		if err != nil {
+			log.Fatal("Invalid proxy authentication settings:", err)
+		}
+		proxyAuth.GroupsHeader = os.Getenv("PROXY_AUTH_GROUPS_HEADER")
+		proxyAuth.AdminGroups = listEnv("PROXY_AUTH_ADMIN_GROUPS")
+		h.ProxyAuth = proxyAuth
+		log.Printf("✓ Trusting the %s header from %s", header, os.Getenv("PROXY_AUTH_TRUSTED"))
 	}
 
 	oauthHandlers := handlers.NewOAuthHandlers(dbService)
 
-	e.GET("/", h.IndexPage)
-	e.GET("/setup", h.SetupPage)
-	e.POST("/setup", h.CreateAccount)
-	e.POST("/upload", h.UploadHandler)
-	e.GET("/api/queue", h.QueueStatusAPI)
-	e.GET("/api/job/:id", h.JobStatusAPI)
+	e.GET("/login", h.LoginPage)
+	e.POST("/login", h.Login)
+	e.GET("/login/oidc", h.LoginOIDC)
+	e.GET("/login/oidc/callback", h.OIDCCallback)
+	e.POST("/logout", h.Logout)
+
+	// Everything else in the web interface needs a signed-in user
+	app := e.Group("", h.RequireLogin)
+	app.GET("/", h.IndexPage)
+	app.GET("/setup", h.SetupPage)
+	app.POST("/setup", h.CreateAccount)
+	app.GET("/library", h.LibraryPage)
+	app.POST("/library/reprocess", h.ReprocessBooks)
+	app.GET("/calibre", h.CalibrePage)
+	app.POST("/calibre/import", h.ImportCalibreBooks)
+	app.GET("/search", h.SearchPage)
+	app.GET("/accounts", h.AccountsPage)
+	app.GET("/password", h.PasswordPage)
+	app.POST("/password", h.ChangePassword)
+	app.GET("/tokens", h.TokensPage)
+	app.POST("/tokens", h.CreateAPIToken)
+	app.POST("/tokens/:id/revoke", h.RevokeAPIToken)
+	app.GET("/accounts/:id/settings", h.AccountSettingsPage)
+	app.POST("/accounts/:id/settings", h.UpdateAccountSettings)
+	app.GET("/accounts/:id/kobo", h.KoboDevicesPage)
+	app.POST("/accounts/:id/kobo", h.CreateKoboDevice)
+	app.POST("/accounts/:id/kobo/:device/delete", h.DeleteKoboDevice)
+	app.POST("/accounts/:id/kobo/:device/reset", h.ResetKoboDevice)
+	app.POST("/upload", h.UploadHandler)
+	app.GET("/batches/:id", h.BatchPage)
+	app.GET("/batches/:id/progress", h.BatchProgressPartial)
+
+	users := app.Group("/users", h.RequireAdmin)
+	users.GET("", h.UsersPage)
+	users.POST("", h.CreateUser)
+	users.POST("/:id/password", h.ResetUserPassword)
+	users.POST("/:id/delete", h.DeleteUser)
+
+	// Scripts use API tokens instead
+	e.POST("/api/upload/url", h.UploadURLAPI, h.RequireAPIToken(db.ScopeUpload))
+	// Read by the web interface, and by scripts with a read token
+	api := e.Group("/api", h.RequireLoginOrAPIToken(db.ScopeRead))
+	api.GET("/queue", h.QueueStatusAPI)
+	api.GET("/job/:id", h.JobStatusAPI)
+	api.GET("/job/:id/cover", h.JobCoverAPI)
+	api.GET("/job/:id/original", h.JobOriginalAPI)
+	api.GET("/job/:id/output", h.JobOutputAPI)
+	api.GET("/batch/:id", h.BatchStatusAPI)
+	api.GET("/search", h.SearchAPI)
+	h.RegisterAPIv1(e.Group("/api/v1"))
+
+	// OPDS catalogue, as Atom (OPDS 1.2) and JSON (OPDS 2.0)
+	opds := e.Group("/opds", h.OPDSAuth())
+	opds.GET("", h.OPDSRoot)
+	opds.GET("/books", h.OPDSBooks)
+	opds.GET("/authors", h.OPDSAuthors)
+	opds.GET("/series", h.OPDSSeries)
+	opds.GET("/opensearch.xml", h.OPDSOpenSearch)
+	opds.GET("/books/:id/file", h.OPDSDownload)
+	opds.GET("/books/:id/cover", h.OPDSCover)
+	opds.GET("/v2", h.OPDSRoot)
+	opds.GET("/v2/books", h.OPDSBooks)
+	opds.GET("/v2/authors", h.OPDSAuthors)
+	opds.GET("/v2/series", h.OPDSSeries)
+
+	// Kobo store API, for Kobos syncing straight from Bookify
+	kobo := e.Group("/kobo/:token", h.KoboAuth)
+	kobo.GET("/v1/initialization", h.KoboInitialization)
+	kobo.GET("/v1/library/sync", h.KoboLibrarySync)
+	kobo.GET("/v1/library/:uuid/metadata", h.KoboBookMetadata)
+	kobo.GET("/download/:uuid", h.KoboDownload)
+	kobo.GET("/:uuid/:width/:height/false/image.jpg", h.KoboCover)
+	kobo.GET("/:uuid/:width/:height/:quality/:greyscale/image.jpg", h.KoboCover)
+	kobo.Any("/*", h.KoboFallback)
 
 	// OAuth routes
-	e.GET("/oauth/start", oauthHandlers.StartOAuth)
-	e.GET("/oauth/callback", oauthHandlers.OAuthCallback)
+	app.GET("/oauth/start", oauthHandlers.StartOAuth)
+	app.GET("/oauth/callback", oauthHandlers.OAuthCallback)
 
 	go queueService.StartWorker()
 	go queueService.StartCleanupWorker()
+	go services.NewHotFolderService(dbService, tempDir).StartWatcher()
+	go driveService.StartInboxWatcher(tempDir)
+
+	// Email-in, from a Maildir or an IMAP mailbox
+	emailOpts := services.EmailOptions{
+		IMAPAddr:   os.Getenv("EMAIL_IMAP_ADDR"),
+		Username:   os.Getenv("EMAIL_IMAP_USERNAME"),
+		Password:   os.Getenv("EMAIL_IMAP_PASSWORD"),
+		Mailbox:    os.Getenv("EMAIL_IMAP_MAILBOX"),
+		TLS:        true,
+		Maildir:    os.Getenv("EMAIL_MAILDIR"),
+		AuthServID: os.Getenv("EMAIL_AUTHSERV_ID"),
+	}
+	if useTLS, err := strconv.ParseBool(os.Getenv("EMAIL_IMAP_TLS")); err == nil {
+		emailOpts.TLS = useTLS
+	}
+	if maxMB, err := strconv.ParseInt(os.Getenv("EMAIL_MAX_SIZE_MB"), 10, 64); err == nil && maxMB > 0 {
+		emailOpts.MaxSize = maxMB << 20
+	}
+	if emailOpts.Maildir != "" || emailOpts.IMAPAddr != "" {
+		if emailOpts.AuthServID == "" {
+			log.Println("WARNING: EMAIL_AUTHSERV_ID is not set, so anyone can send books as an allowed sender")
+		}
+		go services.NewEmailService(dbService, tempDir, emailOpts).StartWatcher()
+	}
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -81,3 +248,14 @@ func main() {
 	log.Printf("Starting server on port %s", port)
 	log.Fatal(e.Start(":" + port))
 }
+
+// listEnv reads a comma-separated list from the environment.
+func listEnv(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
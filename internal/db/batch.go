@@ -0,0 +1,122 @@
+package db
+
+import (
+	"encoding/json"
+
+	"github.com/google/uuid"
+)
+
+// BatchSkip is a file of an upload that wasn't queued, and why.
+type BatchSkip struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// SkippedFiles returns the files of the upload that never became jobs.
+func (b *Batch) SkippedFiles() []BatchSkip {
+	var skipped []BatchSkip
+	if b.Skipped != "" {
+		_ = json.Unmarshal([]byte(b.Skipped), &skipped) // Malformed lists read as empty
+	}
+	return skipped
+}
+
+// BatchProgress counts a batch's jobs by status.
+type BatchProgress struct {
+	Total      int `json:"total"`
+	Queued     int `json:"queued"`
+	Processing int `json:"processing"`
+	Completed  int `json:"completed"`
+	Failed     int `json:"failed"`
+	Skipped    int `json:"skipped"`
+	Cancelled  int `json:"cancelled"`
+}
+
+// Finished counts the jobs that have completed, failed, were skipped as
+// duplicates or were cancelled.
+func (p BatchProgress) Finished() int {
+	return p.Completed + p.Failed + p.Skipped + p.Cancelled
+}
+
+// Done reports whether every job of the batch has finished.
+func (p BatchProgress) Done() bool {
+	return p.Queued == 0 && p.Processing == 0
+}
+
+// Percent is how much of the batch has finished.
+func (p BatchProgress) Percent() int {
+	if p.Total == 0 {
+		return 100
+	}
+	return p.Finished() * 100 / p.Total
+}
+
+func (s *Service) CreateBatch(accountID uint, name string) (*Batch, error) {
+	batch := &Batch{
+		ID:        uuid.New().String(),
+		AccountID: accountID,
+		Name:      name,
+	}
+	err := s.db.Create(batch).Error
+	return batch, err
+}
+
+func (s *Service) GetBatch(id string) (*Batch, error) {
+	var batch Batch
+	err := s.ofOwnAccounts(s.db, "account_id").Preload("Account").First(&batch, "id = ?", id).Error
+	return &batch, err
+}
+
+// SetBatchSkipped records the files of an upload that weren't queued.
+func (s *Service) SetBatchSkipped(batch *Batch, skipped []BatchSkip) error {
+	data, err := json.Marshal(skipped)
+	if err != nil {
+		return err
+	}
+	batch.Skipped = string(data)
+	return s.db.Model(batch).Update("skipped", batch.Skipped).Error
+}
+
+// DeleteBatch removes a batch none of whose files were queued.
+func (s *Service) DeleteBatch(id string) error {
+	return s.db.Delete(&Batch{}, "id = ?", id).Error
+}
+
+// ListBatchJobs returns the jobs of a batch in the order they were queued.
+func (s *Service) ListBatchJobs(batchID string) ([]Job, error) {
+	var jobs []Job
+	err := s.ownedBy(s.db, "user_id").Preload("Account").Where("batch_id = ?", batchID).Order("created_at").Find(&jobs).Error
+	return jobs, err
+}
+
+// GetBatchProgress counts the jobs queued from a batch by status.
+func (s *Service) GetBatchProgress(batchID string) (BatchProgress, error) {
+	var rows []struct {
+		Status string
+		Count  int
+	}
+	var progress BatchProgress
+	err := s.db.Model(&Job{}).Select("status, count(*) as count").
+		Where("batch_id = ?", batchID).Group("status").Scan(&rows).Error
+	if err != nil {
+		return progress, err
+	}
+	for _, row := range rows {
+		progress.Total += row.Count
+		switch row.Status {
+		case "queued":
+			progress.Queued = row.Count
+		case "processing":
+			progress.Processing = row.Count
+		case "completed":
+			progress.Completed = row.Count
+		case "failed":
+			progress.Failed = row.Count
+		case "skipped":
+			progress.Skipped = row.Count
+		case "cancelled":
+			progress.Cancelled = row.Count
+		}
+	}
+	return progress, nil
+}
@@ -0,0 +1,146 @@
+package db
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const DefaultBookPageSize = 24
+
+// BookFilter narrows down the library. Zero values match everything.
+type BookFilter struct {
+	Query     string
+	Author    string
+	Series    string
+	AccountID uint
+	From      time.Time
+	To        time.Time
+	Page      int
+	PageSize  int
+}
+
+func (s *Service) CreateBook(book *Book) error {
+	if book.UUID == "" {
+		book.UUID = uuid.New().String()
+	}
+	return s.db.Create(book).Error
+}
+
+func (s *Service) GetBook(id uint) (*Book, error) {
+	var book Book
+	err := s.ofOwnAccounts(s.db, "account_id").Preload("Account").First(&book, id).Error
+	return &book, err
+}
+
+func (s *Service) GetBookByUUID(id string) (*Book, error) {
+	var book Book
+	err := s.ofOwnAccounts(s.db, "account_id").Preload("Account").Where("uuid = ?", id).First(&book).Error
+	return &book, err
+}
+
+// UpdateBook saves a book after its file was replaced.
+func (s *Service) UpdateBook(book *Book) error {
+	return s.db.Omit("Account").Save(book).Error
+}
+
+// assignBookUUIDs gives books added before books had UUIDs one.
+func assignBookUUIDs(db *gorm.DB) error {
+	var ids []uint
+	if err := db.Model(&Book{}).Where("uuid IS NULL OR uuid = ''").Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := db.Model(&Book{}).Where("id = ?", id).Update("uuid", uuid.New().String()).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListBooks returns one page of books matching the filter, newest first,
+// together with the number of matching books.
+func (s *Service) ListBooks(filter BookFilter) ([]Book, int64, error) {
+	query := s.ofOwnAccounts(s.db.Model(&Book{}), "account_id")
+	if q := strings.TrimSpace(filter.Query); q != "" {
+		like := "%" + escapeLike(q) + "%"
+		query = query.Where("title LIKE ? ESCAPE '\\' OR author LIKE ? ESCAPE '\\' OR series LIKE ? ESCAPE '\\'", like, like, like)
+	}
+	if filter.Author != "" {
+		query = query.Where("author = ?", filter.Author)
+	}
+	if filter.Series != "" {
+		query = query.Where("series = ?", filter.Series)
+	}
+	if filter.AccountID != 0 {
+		query = query.Where("account_id = ?", filter.AccountID)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("delivered_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("delivered_at < ?", filter.To)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	pageSize := filter.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultBookPageSize
+	}
+	page := filter.Page
+	if page < 1 {
+		page = 1
+	}
+
+	var books []Book
+	order := "delivered_at desc, id desc"
+	if filter.Series != "" {
+		order = "series_index asc, title asc"
+	}
+	err := query.Preload("Account").Order(order).
+		Limit(pageSize).Offset((page - 1) * pageSize).
+		Find(&books).Error
+	return books, total, err
+}
+
+// ListBookAuthors returns every author in the library, or in one account's
+// books when accountID is set, for filtering.
+func (s *Service) ListBookAuthors(accountID uint) ([]string, error) {
+	var authors []string
+	err := s.booksOfAccount(accountID).Where("author <> ''").Distinct().Order("author").Pluck("author", &authors).Error
+	return authors, err
+}
+
+// ListBookSeries returns every series in the library, or in one account's
+// books when accountID is set, for filtering.
+func (s *Service) ListBookSeries(accountID uint) ([]string, error) {
+	var series []string
+	err := s.booksOfAccount(accountID).Where("series <> ''").Distinct().Order("series").Pluck("series", &series).Error
+	return series, err
+}
+
+// ListBookIDs returns the IDs of every book in the library, or in one
+// account's books when accountID is set.
+func (s *Service) ListBookIDs(accountID uint) ([]uint, error) {
+	var ids []uint
+	err := s.booksOfAccount(accountID).Order("id").Pluck("id", &ids).Error
+	return ids, err
+}
+
+func (s *Service) booksOfAccount(accountID uint) *gorm.DB {
+	query := s.ofOwnAccounts(s.db.Model(&Book{}), "account_id")
+	if accountID != 0 {
+		query = query.Where("account_id = ?", accountID)
+	}
+	return query
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
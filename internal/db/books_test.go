@@ -0,0 +1,81 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"bookify/internal/testutil"
+)
+
+func TestDBService_ListBooks(t *testing.T) {
+	database := testutil.SetupTestDB(t)
+	err := database.AutoMigrate(&Account{}, &Job{}, &Book{})
+	if err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	service := NewService(database)
+
+	home, _ := service.CreateAccount("Home", "folder1")
+	work, _ := service.CreateAccount("Work", "folder2")
+
+	day := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	books := []Book{
+		{AccountID: home.ID, Title: "Dune", Author: "Frank Herbert", Series: "Dune", SeriesIndex: 1, DeliveredAt: day},
+		{AccountID: home.ID, Title: "Dune Messiah", Author: "Frank Herbert", Series: "Dune", SeriesIndex: 2, DeliveredAt: day.AddDate(0, 0, 1)},
+		{AccountID: work.ID, Title: "The Go Programming Language", Author: "Alan Donovan", DeliveredAt: day.AddDate(0, 0, 2)},
+		{AccountID: work.ID, Title: "100% Pure_Fiction", Author: "Anon", DeliveredAt: day.AddDate(0, 0, 3)},
+	}
+	for i := range books {
+		books[i].JobID = fmt.Sprintf("job-%d", i)
+		if err := service.CreateBook(&books[i]); err != nil {
+			t.Fatalf("CreateBook() failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter BookFilter
+		want   []string
+	}{
+		{name: "everything newest first", filter: BookFilter{}, want: []string{"100% Pure_Fiction", "The Go Programming Language", "Dune Messiah", "Dune"}},
+		{name: "search title or author", filter: BookFilter{Query: "herbert"}, want: []string{"Dune Messiah", "Dune"}},
+		{name: "search wildcards literally", filter: BookFilter{Query: "%"}, want: []string{"100% Pure_Fiction"}},
+		{name: "series in reading order", filter: BookFilter{Series: "Dune"}, want: []string{"Dune", "Dune Messiah"}},
+		{name: "author", filter: BookFilter{Author: "Alan Donovan"}, want: []string{"The Go Programming Language"}},
+		{name: "account", filter: BookFilter{AccountID: work.ID}, want: []string{"100% Pure_Fiction", "The Go Programming Language"}},
+		{name: "date range", filter: BookFilter{From: day.AddDate(0, 0, 1), To: day.AddDate(0, 0, 3)}, want: []string{"The Go Programming Language", "Dune Messiah"}},
+		{name: "second page", filter: BookFilter{Page: 2, PageSize: 3}, want: []string{"Dune"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, total, err := service.ListBooks(tt.filter)
+			if err != nil {
+				t.Fatalf("ListBooks() failed: %v", err)
+			}
+			var titles []string
+			for _, book := range got {
+				titles = append(titles, book.Title)
+			}
+			if fmt.Sprint(titles) != fmt.Sprint(tt.want) {
+				t.Errorf("ListBooks() = %v, want %v", titles, tt.want)
+			}
+			if tt.filter.Page == 0 && total != int64(len(tt.want)) {
+				t.Errorf("ListBooks() total = %d, want %d", total, len(tt.want))
+			}
+		})
+	}
+
+	authors, err := service.ListBookAuthors(0)
+	if err != nil {
+		t.Fatalf("ListBookAuthors(0) failed: %v", err)
+	}
+	if fmt.Sprint(authors) != "[Alan Donovan Anon Frank Herbert]" {
+		t.Errorf("ListBookAuthors(0) = %v", authors)
+	}
+	series, _ := service.ListBookSeries(0)
+	if fmt.Sprint(series) != "[Dune]" {
+		t.Errorf("ListBookSeries(0) = %v", series)
+	}
+}
@@ -0,0 +1,33 @@
+package db
+
+import "strings"
+
+// SplitEmailSenders returns the addresses of an account's sender list,
+// lowercased. Addresses are separated by lines, commas or spaces.
+func SplitEmailSenders(senders string) []string {
+	var addresses []string
+	for _, field := range strings.FieldsFunc(senders, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' ' || r == '\t' || r == '\r' || r == '\n'
+	}) {
+		addresses = append(addresses, strings.ToLower(field))
+	}
+	return addresses
+}
+
+// GetAccountByEmailSender returns the account whose sender list has the
+// address, or nil if no account accepts books from it.
+func (s *Service) GetAccountByEmailSender(address string) (*Account, error) {
+	var accounts []Account
+	if err := s.db.Where("email_senders <> ''").Find(&accounts).Error; err != nil {
+		return nil, err
+	}
+	address = strings.ToLower(strings.TrimSpace(address))
+	for i := range accounts {
+		for _, sender := range SplitEmailSenders(accounts[i].EmailSenders) {
+			if sender == address {
+				return &accounts[i], nil
+			}
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,21 @@
+package db
+
+// ListHotFolderAccounts returns the accounts watching a local directory.
+func (s *Service) ListHotFolderAccounts() ([]Account, error) {
+	var accounts []Account
+	err := s.db.Where("hot_folder <> ''").Find(&accounts).Error
+	return accounts, err
+}
+
+// ListHotFolderJobs returns the account's jobs whose hot folder file hasn't
+// been moved out of the way yet.
+func (s *Service) ListHotFolderJobs(accountID uint) ([]Job, error) {
+	var jobs []Job
+	err := s.db.Where("account_id = ? AND hot_folder_path <> ''", accountID).Find(&jobs).Error
+	return jobs, err
+}
+
+// ClearHotFolderPath records that the job's hot folder file was moved.
+func (s *Service) ClearHotFolderPath(jobID string) error {
+	return s.db.Model(&Job{}).Where("id = ?", jobID).Update("hot_folder_path", "").Error
+}
@@ -0,0 +1,27 @@
+package db
+
+// ListInboxAccounts returns the accounts watching a Drive inbox folder.
+func (s *Service) ListInboxAccounts() ([]Account, error) {
+	var accounts []Account
+	err := s.db.Where("inbox_folder_id <> ''").Find(&accounts).Error
+	return accounts, err
+}
+
+// SetInboxPageToken stores where the next look at the Drive changes of the
+// account starts.
+func (s *Service) SetInboxPageToken(accountID uint, token string) error {
+	return s.db.Model(&Account{}).Where("id = ?", accountID).Update("inbox_page_token", token).Error
+}
+
+// ListInboxJobs returns the account's jobs whose inbox file hasn't been
+// archived yet.
+func (s *Service) ListInboxJobs(accountID uint) ([]Job, error) {
+	var jobs []Job
+	err := s.db.Where("account_id = ? AND inbox_file_id <> ''", accountID).Find(&jobs).Error
+	return jobs, err
+}
+
+// ClearInboxFileID records that the job's inbox file was dealt with.
+func (s *Service) ClearInboxFileID(jobID string) error {
+	return s.db.Model(&Job{}).Where("id = ?", jobID).Update("inbox_file_id", "").Error
+}
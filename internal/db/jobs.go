@@ -0,0 +1,90 @@
+package db
+
+import "gorm.io/gorm"
+
+// JobFilter narrows down the jobs listed by the API. Zero values match
+// everything.
+type JobFilter struct {
+	AccountID uint
+	BatchID   string
+	Status    string
+	Page      int
+	PageSize  int
+}
+
+// JobStatuses are the states a job can be in.
+var JobStatuses = []string{"queued", "processing", "completed", "failed", "skipped", "cancelled"}
+
+// ListJobs returns one page of jobs matching the filter, newest first,
+// together with the number of matching jobs.
+func (s *Service) ListJobs(filter JobFilter) ([]Job, int64, error) {
+	query := s.ownedBy(s.db.Model(&Job{}), "user_id")
+	if filter.AccountID != 0 {
+		query = query.Where("account_id = ?", filter.AccountID)
+	}
+	if filter.BatchID != "" {
+		query = query.Where("batch_id = ?", filter.BatchID)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var jobs []Job
+	err := query.Order("created_at desc").
+		Offset((filter.Page - 1) * filter.PageSize).Limit(filter.PageSize).
+		Find(&jobs).Error
+	return jobs, total, err
+}
+
+// ClaimJob marks a queued job as processing, reporting false if it was
+// cancelled or taken in the meantime.
+func (s *Service) ClaimJob(id string) (bool, error) {
+	result := s.db.Model(&Job{}).Where("id = ? AND status = ?", id, "queued").
+		Updates(map[string]interface{}{"status": "processing", "stage": "starting"})
+	return result.RowsAffected == 1, result.Error
+}
+
+// CancelJob stops a job that hasn't started, reporting false if it isn't
+// queued.
+func (s *Service) CancelJob(id string) (bool, error) {
+	result := s.ownedBy(s.db.Model(&Job{}), "user_id").Where("id = ? AND status = ?", id, "queued").
+		Updates(map[string]interface{}{"status": "cancelled", "stage": "cancelled", "message": "Cancelled"})
+	return result.RowsAffected == 1, result.Error
+}
+
+// RequeueJob queues a failed or cancelled job again, reporting false if it
+// is in any other state.
+func (s *Service) RequeueJob(job *Job) (bool, error) {
+	result := s.ownedBy(s.db.Model(&Job{}), "user_id").Where("id = ? AND status IN ?", job.ID, []string{"failed", "cancelled"}).
+		Updates(map[string]interface{}{
+			"original_filename": job.OriginalFilename,
+			"status":            "queued",
+			"stage":             "queued",
+			"progress":          0,
+			"error":             "",
+			"message":           "",
+			"completed_at":      gorm.Expr("NULL"),
+		})
+	return result.RowsAffected == 1, result.Error
+}
+
+// ListBatches returns one page of batches, newest first, together with the
+// number of batches. An account ID of 0 lists every account's.
+func (s *Service) ListBatches(accountID uint, page, pageSize int) ([]Batch, int64, error) {
+	query := s.ofOwnAccounts(s.db.Model(&Batch{}), "account_id")
+	if accountID != 0 {
+		query = query.Where("account_id = ?", accountID)
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var batches []Batch
+	err := query.Order("created_at desc").Offset((page - 1) * pageSize).Limit(pageSize).Find(&batches).Error
+	return batches, total, err
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+func (s *Service) CreateKoboDevice(accountID uint, name string) (*KoboDevice, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	device := &KoboDevice{
+		AccountID: accountID,
+		Name:      name,
+		Token:     hex.EncodeToString(token),
+	}
+	err := s.db.Create(device).Error
+	return device, err
+}
+
+func (s *Service) ListKoboDevices(accountID uint) ([]KoboDevice, error) {
+	var devices []KoboDevice
+	err := s.ofOwnAccounts(s.db, "account_id").Where("account_id = ?", accountID).Order("created_at").Find(&devices).Error
+	return devices, err
+}
+
+func (s *Service) GetKoboDevice(id uint) (*KoboDevice, error) {
+	var device KoboDevice
+	err := s.ofOwnAccounts(s.db, "account_id").Preload("Account").First(&device, id).Error
+	return &device, err
+}
+
+func (s *Service) GetKoboDeviceByToken(token string) (*KoboDevice, error) {
+	var device KoboDevice
+	err := s.db.Preload("Account").Where("token = ?", token).First(&device).Error
+	return &device, err
+}
+
+func (s *Service) DeleteKoboDevice(id uint) error {
+	if err := s.ResetKoboSync(id); err != nil {
+		return err
+	}
+	return s.db.Delete(&KoboDevice{}, id).Error
+}
+
+// ResetKoboSync forgets which books a device has, so the next sync sends
+// them all again.
+func (s *Service) ResetKoboSync(deviceID uint) error {
+	return s.db.Where("device_id = ?", deviceID).Delete(&KoboSyncedBook{}).Error
+}
+
+// ListUnsyncedBooks returns up to limit of the account's books the device
+// hasn't been sent yet, or that changed since it was, oldest first, and
+// whether there are more.
+func (s *Service) ListUnsyncedBooks(device *KoboDevice, limit int) ([]Book, bool, error) {
+	var books []Book
+	err := s.db.Joins("LEFT JOIN kobo_synced_books ON kobo_synced_books.book_id = books.id AND kobo_synced_books.device_id = ?", device.ID).
+		Where("books.account_id = ?", device.AccountID).
+		Where("kobo_synced_books.book_id IS NULL OR books.updated_at > kobo_synced_books.synced_at").
+		Order("books.delivered_at, books.id").
+		Limit(limit + 1).
+		Find(&books).Error
+	if err != nil {
+		return nil, false, err
+	}
+	if len(books) > limit {
+		return books[:limit], true, nil
+	}
+	return books, false, nil
+}
+
+// SyncedBookIDs returns which of the books the device has been sent before.
+func (s *Service) SyncedBookIDs(device *KoboDevice, books []Book) (map[uint]bool, error) {
+	ids := make([]uint, len(books))
+	for i, book := range books {
+		ids[i] = book.ID
+	}
+	var synced []uint
+	err := s.db.Model(&KoboSyncedBook{}).Where("device_id = ? AND book_id IN ?", device.ID, ids).Pluck("book_id", &synced).Error
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[uint]bool, len(synced))
+	for _, id := range synced {
+		found[id] = true
+	}
+	return found, nil
+}
+
+// MarkBooksSynced records that the books were sent to the device.
+func (s *Service) MarkBooksSynced(device *KoboDevice, books []Book) error {
+	now := time.Now()
+	for _, book := range books {
+		if err := s.db.Save(&KoboSyncedBook{DeviceID: device.ID, BookID: book.ID, SyncedAt: now}).Error; err != nil {
+			return err
+		}
+	}
+	device.LastSyncAt = &now
+	return s.db.Model(device).Update("last_sync_at", now).Error
+}
@@ -0,0 +1,87 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"bookify/internal/testutil"
+)
+
+func TestDBService_ListUnsyncedBooks(t *testing.T) {
+	database := testutil.SetupTestDB(t)
+	err := database.AutoMigrate(&Account{}, &Job{}, &Book{}, &KoboDevice{}, &KoboSyncedBook{})
+	if err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	service := NewService(database)
+
+	account, _ := service.CreateAccount("Home", "folder1")
+	device, err := service.CreateKoboDevice(account.ID, "Clara")
+	if err != nil {
+		t.Fatalf("CreateKoboDevice() failed: %v", err)
+	}
+	if len(device.Token) != 32 {
+		t.Errorf("token %q is too short", device.Token)
+	}
+
+	day := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		book := &Book{JobID: fmt.Sprintf("job-%d", i), AccountID: account.ID, Title: fmt.Sprintf("Book %d", i), DeliveredAt: day.AddDate(0, 0, i)}
+		if err := service.CreateBook(book); err != nil {
+			t.Fatalf("CreateBook() failed: %v", err)
+		}
+		if book.UUID == "" {
+			t.Errorf("CreateBook() did not assign a UUID")
+		}
+	}
+
+	books, more, err := service.ListUnsyncedBooks(device, 3)
+	if err != nil {
+		t.Fatalf("ListUnsyncedBooks() failed: %v", err)
+	}
+	if len(books) != 3 || !more || books[0].Title != "Book 0" {
+		t.Fatalf("first batch = %d books (more %v), want the oldest 3 and more", len(books), more)
+	}
+	if err := service.MarkBooksSynced(device, books); err != nil {
+		t.Fatalf("MarkBooksSynced() failed: %v", err)
+	}
+	sent := books
+
+	books, more, _ = service.ListUnsyncedBooks(device, 3)
+	if len(books) != 2 || more || books[0].Title != "Book 3" {
+		t.Errorf("second batch = %d books (more %v), want the last 2", len(books), more)
+	}
+
+	// A reprocessed book is sent again
+	changed := sent[1]
+	if err := service.UpdateBook(&changed); err != nil {
+		t.Fatalf("UpdateBook() failed: %v", err)
+	}
+	books, _, _ = service.ListUnsyncedBooks(device, 3)
+	if len(books) != 3 || books[0].Title != "Book 1" {
+		t.Errorf("after reprocessing, %d books to sync starting with %q, want Book 1 and the last 2", len(books), books[0].Title)
+	}
+	synced, err := service.SyncedBookIDs(device, books)
+	if err != nil {
+		t.Fatalf("SyncedBookIDs() failed: %v", err)
+	}
+	if len(synced) != 1 || !synced[changed.ID] {
+		t.Errorf("SyncedBookIDs() = %v, want only the reprocessed book", synced)
+	}
+
+	// Another device starts from scratch
+	other, _ := service.CreateKoboDevice(account.ID, "Libra")
+	if books, _, _ := service.ListUnsyncedBooks(other, 10); len(books) != 5 {
+		t.Errorf("new device has %d books to sync, want 5", len(books))
+	}
+
+	if err := service.DeleteKoboDevice(device.ID); err != nil {
+		t.Fatalf("DeleteKoboDevice() failed: %v", err)
+	}
+	var remaining int64
+	database.Model(&KoboSyncedBook{}).Where("device_id = ?", device.ID).Count(&remaining)
+	if remaining != 0 {
+		t.Errorf("deleting a device left %d synced book records", remaining)
+	}
+}
@@ -9,32 +9,219 @@ import (
 
 type Account struct {
 	ID           uint      `gorm:"primaryKey" json:"id"`
-	Name         string    `gorm:"uniqueIndex;not null" json:"name"`
+	UserID       uint      `gorm:"index;uniqueIndex:idx_accounts_user_name;not null;default:0" json:"user_id"`
+	Name         string    `gorm:"uniqueIndex:idx_accounts_user_name;not null" json:"name"`
 	FolderID     string    `gorm:"not null" json:"folder_id"`
 	AccessToken  string    `gorm:"size:2048" json:"-"`
 	RefreshToken string    `gorm:"size:512" json:"-"`
 	TokenExpiry  time.Time `json:"-"`
 	UserEmail    string    `json:"user_email"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	Jobs         []Job     `gorm:"foreignKey:AccountID" json:"-"`
+	// Optional image optimisation for a target Kobo model
+	DeviceProfile string `json:"device_profile"`
+	Greyscale     bool   `gorm:"default:false" json:"greyscale"`
+	// Comic archive (CBZ/CBR) import options
+	ComicRightToLeft  bool `gorm:"default:false" json:"comic_right_to_left"`
+	ComicSplitSpreads bool `gorm:"default:false" json:"comic_split_spreads"`
+	// kepubify options used when converting this account's books
+	Smartypants     bool `gorm:"default:false" json:"smartypants"`
+	Hyphenate       bool `gorm:"default:false" json:"hyphenate"`
+	FullScreenFixes bool `gorm:"default:false" json:"full_screen_fixes"`
+	// Local directory whose new books are queued for this account
+	HotFolder string `json:"hot_folder"`
+	// Drive folder whose new books are queued for this account, and the
+	// folder they are moved to once converted
+	InboxFolderID   string `json:"inbox_folder_id"`
+	ArchiveFolderID string `json:"archive_folder_id"`
+	InboxPageToken  string `json:"-"`
+	// Addresses whose emailed books are queued for this account, one per line
+	EmailSenders string `gorm:"type:text" json:"email_senders"`
+	// What to do with an upload identical to an earlier one: skip or force
+	DuplicatePolicy string `gorm:"default:skip" json:"duplicate_policy"`
+	// Login for the OPDS catalogue of this account's books, unique among
+	// accounts that have one
+	OPDSUsername     string    `gorm:"uniqueIndex:idx_accounts_opds_login,where:opds_username <> ''" json:"opds_username"`
+	OPDSPasswordHash string    `json:"-"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+	Jobs             []Job     `gorm:"foreignKey:AccountID" json:"-"`
 }
 
+const (
+	DuplicatePolicySkip  = "skip"
+	DuplicatePolicyForce = "force"
+)
+
+const (
+	JobTypeConvert   = "convert"
+	JobTypeReprocess = "reprocess"
+)
+
 type Job struct {
-	ID                string     `gorm:"primaryKey" json:"id"`
-	AccountID         uint       `gorm:"not null" json:"account_id"`
-	Account           Account    `gorm:"foreignKey:AccountID" json:"account,omitempty"`
-	OriginalFilename  string     `gorm:"not null" json:"original_filename"`
-	ProcessedFilename string     `json:"processed_filename"`
-	Status            string     `gorm:"not null;default:queued" json:"status"`
-	Progress          int        `gorm:"default:0" json:"progress"`
-	Stage             string     `gorm:"default:queued" json:"stage"`
-	Message           string     `json:"message"`
-	DriveURL          string     `json:"drive_url"`
-	Error             string     `json:"error"`
-	CreatedAt         time.Time  `json:"created_at"`
-	UpdatedAt         time.Time  `json:"updated_at"`
-	CompletedAt       *time.Time `json:"completed_at"`
+	ID               string  `gorm:"primaryKey" json:"id"`
+	AccountID        uint    `gorm:"not null" json:"account_id"`
+	Account          Account `gorm:"foreignKey:AccountID" json:"account,omitempty"`
+	UserID           uint    `gorm:"index;not null;default:0" json:"user_id"`
+	OriginalFilename string  `gorm:"not null" json:"original_filename"`
+	// Reprocess jobs convert a retained original again and replace the file
+	// of the library book they point at
+	Type   string `gorm:"not null;default:convert" json:"type"`
+	BookID uint   `gorm:"index" json:"book_id,omitempty"`
+	// Conversion profile chosen for this job as JSON, empty for the account's
+	Profile string `gorm:"type:text" json:"profile"`
+	// Metadata for books built from text, Markdown and HTML files, or taken
+	// from a Calibre library; it overrides what the EPUB says
+	Title             string  `json:"title"`
+	Author            string  `json:"author"`
+	Series            string  `json:"series,omitempty"`
+	SeriesIndex       float64 `json:"series_index,omitempty"`
+	Tags              string  `json:"tags,omitempty"`
+	Rating            int     `json:"rating,omitempty"`
+	ProcessedFilename string  `json:"processed_filename"`
+	Status            string  `gorm:"not null;default:queued" json:"status"`
+	Progress          int     `gorm:"default:0" json:"progress"`
+	Stage             string  `gorm:"default:queued" json:"stage"`
+	Message           string  `json:"message"`
+	DriveURL          string  `json:"drive_url"`
+	Error             string  `json:"error"`
+	HasCover          bool    `gorm:"default:false" json:"has_cover"`
+	ValidationReport  string  `gorm:"type:text" json:"validation_report"`
+	ImageBytesSaved   int64   `gorm:"default:0" json:"image_bytes_saved"`
+	// SHA-256 of the uploaded file and of the converted KEPUB
+	SourceHash string `gorm:"index" json:"source_hash"`
+	OutputHash string `gorm:"index" json:"output_hash"`
+	// Whether the upload and the KEPUB were kept in the store
+	Retained bool `gorm:"default:false" json:"retained"`
+	// File in the account's hot folder this job was queued from, until it is
+	// moved to done/ or failed/
+	HotFolderPath string `gorm:"index" json:"hot_folder_path,omitempty"`
+	// Drive inbox file this job was queued from, until it is archived
+	InboxFileID string `gorm:"index" json:"inbox_file_id,omitempty"`
+	// Link the book was downloaded from
+	SourceURL string `json:"source_url,omitempty"`
+	// Address of the email this job's book was attached to
+	EmailSender string `json:"email_sender,omitempty"`
+	// Upload this job was queued from
+	BatchID     string     `gorm:"index" json:"batch_id,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+}
+
+// Book is a converted book delivered to an account's Drive folder. Jobs come
+// and go from the queue; books stay in the library.
+type Book struct {
+	ID          uint    `gorm:"primaryKey" json:"id"`
+	UUID        string  `gorm:"index" json:"uuid"`
+	JobID       string  `gorm:"uniqueIndex;not null" json:"job_id"`
+	AccountID   uint    `gorm:"index;not null" json:"account_id"`
+	Account     Account `gorm:"foreignKey:AccountID" json:"account,omitempty"`
+	Title       string  `gorm:"index;not null" json:"title"`
+	Author      string  `gorm:"index" json:"author"`
+	Series      string  `gorm:"index" json:"series"`
+	SeriesIndex float64 `json:"series_index"`
+	Language    string  `json:"language"`
+	Publisher   string  `json:"publisher"`
+	Description string  `gorm:"type:text" json:"description"`
+	Identifier  string  `json:"identifier"`
+	// Comma-separated, and out of 10, for books imported from Calibre
+	Tags        string    `json:"tags"`
+	Rating      int       `json:"rating"`
+	Filename    string    `json:"filename"`
+	FileSize    int64     `json:"file_size"`
+	DriveURL    string    `json:"drive_url"`
+	DriveFileID string    `json:"drive_file_id"`
+	HasCover    bool      `gorm:"default:false" json:"has_cover"`
+	DeliveredAt time.Time `gorm:"index" json:"delivered_at"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Batch groups the jobs queued from one upload, including the books of an
+// uploaded archive.
+type Batch struct {
+	ID        string  `gorm:"primaryKey" json:"id"`
+	AccountID uint    `gorm:"index;not null" json:"account_id"`
+	Account   Account `gorm:"foreignKey:AccountID" json:"account,omitempty"`
+	// Filename of the upload, or of its first file
+	Name string `gorm:"not null" json:"name"`
+	// Files of the upload that never became jobs, as JSON
+	Skipped   string    `gorm:"type:text" json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+const (
+	RoleUser = "user"
+	// Admins see every user's accounts and jobs, and manage users
+	RoleAdmin = "admin"
+)
+
+// User signs in to the web interface. Accounts, their jobs and API tokens
+// belong to a user, through their UserID.
+type User struct {
+	ID           uint   `gorm:"primaryKey" json:"id"`
+	Username     string `gorm:"uniqueIndex;not null" json:"username"`
+	PasswordHash string `json:"-"`
+	Role         string `gorm:"not null;default:user" json:"role"`
+	// Subject of the user at the OpenID Connect provider, if they sign in there
+	OIDCSubject string    `gorm:"column:oidc_subject;index" json:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Session is a signed-in browser. Only a hash of its cookie is kept.
+type Session struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	UserID    uint      `gorm:"index;not null" json:"user_id"`
+	User      User      `gorm:"foreignKey:UserID" json:"-"`
+	Hash      string    `gorm:"uniqueIndex;not null" json:"-"`
+	ExpiresAt time.Time `gorm:"index" json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// APIToken lets scripts use the API. Only a hash of the token is kept.
+type APIToken struct {
+	ID     uint   `gorm:"primaryKey" json:"id"`
+	UserID uint   `gorm:"index;not null;default:0" json:"user_id"`
+	User   User   `gorm:"foreignKey:UserID" json:"-"`
+	Name   string `gorm:"not null" json:"name"`
+	// Start of the token, to tell tokens apart
+	Prefix string `json:"prefix"`
+	Hash   string `gorm:"uniqueIndex;not null" json:"-"`
+	// Comma-separated: read, upload or admin
+	Scopes     string     `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// StoredFile is a file in the content store, named by its SHA-256.
+type StoredFile struct {
+	Hash       string    `gorm:"primaryKey" json:"hash"`
+	Size       int64     `json:"size"`
+	LastUsedAt time.Time `gorm:"index" json:"last_used_at"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// KoboDevice is a Kobo e-reader that syncs an account's books straight from
+// Bookify, using the token in its api_endpoint setting.
+type KoboDevice struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	AccountID  uint       `gorm:"index;not null" json:"account_id"`
+	Account    Account    `gorm:"foreignKey:AccountID" json:"account,omitempty"`
+	Name       string     `gorm:"not null" json:"name"`
+	Token      string     `gorm:"uniqueIndex;not null" json:"-"`
+	LastSyncAt *time.Time `json:"last_sync_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// KoboSyncedBook records that a device has been sent a book, so the next
+// sync only sends what's new or changed.
+type KoboSyncedBook struct {
+	DeviceID uint      `gorm:"primaryKey" json:"device_id"`
+	BookID   uint      `gorm:"primaryKey" json:"book_id"`
+	SyncedAt time.Time `json:"synced_at"`
 }
 
 func InitDB(dbPath string) (*gorm.DB, error) {
@@ -43,10 +230,29 @@ func InitDB(dbPath string) (*gorm.DB, error) {
 		return nil, err
 	}
 
-	err = db.AutoMigrate(&Account{}, &Job{})
+	err = db.AutoMigrate(&Account{}, &Job{}, &Book{}, &BookContent{}, &KoboDevice{}, &KoboSyncedBook{}, &StoredFile{}, &Batch{}, &APIToken{}, &User{}, &Session{})
 	if err != nil {
 		return nil, err
 	}
 
+	// Account names were unique across all users before each user had
+	// their own accounts
+	if db.Migrator().HasIndex(&Account{}, "idx_accounts_name") {
+		if err := db.Migrator().DropIndex(&Account{}, "idx_accounts_name"); err != nil {
+			return nil, err
+		}
+	}
+
+	// Catalogue logins were only checked for clashes before saving
+	if db.Migrator().HasIndex(&Account{}, "idx_accounts_opds_username") {
+		if err := db.Migrator().DropIndex(&Account{}, "idx_accounts_opds_username"); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := assignBookUUIDs(db); err != nil {
+		return nil, err
+	}
+
 	return db, nil
 }
@@ -0,0 +1,288 @@
+package db
+
+import (
+	"log"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// BookContent holds the text of a book, extracted during conversion, for
+// full-text search.
+type BookContent struct {
+	BookID  uint   `gorm:"primaryKey" json:"book_id"`
+	Content string `gorm:"type:text" json:"-"`
+}
+
+// SnippetPart is a piece of a search result snippet. Parts with Match set
+// are the words that matched the query.
+type SnippetPart struct {
+	Text  string
+	Match bool
+}
+
+type SearchResult struct {
+	Book    Book
+	Snippet []SnippetPart
+}
+
+// Markers FTS5 puts around matches in snippets. They can't appear in
+// extracted text, so splitting on them is safe.
+const (
+	snippetStart = "\x02"
+	snippetEnd   = "\x03"
+)
+
+const snippetRadius = 80
+
+// fullTextSearch reports whether SQLite has FTS5, creating the search index
+// the first time it's called. Without FTS5, search falls back to LIKE.
+func (s *Service) fullTextSearch() bool {
+	s.fts.once.Do(func() {
+		err := s.db.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS book_search USING fts5(
+			title, author, series, description, content,
+			tokenize = 'unicode61 remove_diacritics 2'
+		)`).Error
+		if err != nil {
+			log.Printf("Full-text search index unavailable, using simple search: %v", err)
+			return
+		}
+		s.fts.enabled = true
+	})
+	return s.fts.enabled
+}
+
+// IndexBook stores the book's text and adds it to the search index.
+func (s *Service) IndexBook(book *Book, content string) error {
+	if err := s.db.Save(&BookContent{BookID: book.ID, Content: content}).Error; err != nil {
+		return err
+	}
+	if !s.fullTextSearch() {
+		return nil
+	}
+	if err := s.db.Exec("DELETE FROM book_search WHERE rowid = ?", book.ID).Error; err != nil {
+		return err
+	}
+	return s.db.Exec("INSERT INTO book_search (rowid, title, author, series, description, content) VALUES (?, ?, ?, ?, ?, ?)",
+		book.ID, book.Title, book.Author, book.Series, book.Description, content).Error
+}
+
+// SearchBooks finds books whose metadata or text contain every word of the
+// query, best matches first.
+func (s *Service) SearchBooks(query string, limit, offset int) ([]SearchResult, int64, error) {
+	terms := strings.Fields(query)
+	if len(terms) == 0 {
+		return nil, 0, nil
+	}
+	if s.fullTextSearch() {
+		return s.searchFTS(terms, limit, offset)
+	}
+	return s.searchLike(terms, limit, offset)
+}
+
+func (s *Service) searchFTS(terms []string, limit, offset int) ([]SearchResult, int64, error) {
+	// Quote every word so that user input is never read as FTS5 syntax, and
+	// match prefixes so that partial words still find something
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"*`
+	}
+	match := strings.Join(quoted, " ")
+
+	// Only the books of the user's accounts, for services limited to a user
+	where, args := "book_search MATCH ?", []interface{}{match}
+	if s.restricted() {
+		where += " AND rowid IN (SELECT books.id FROM books JOIN accounts ON accounts.id = books.account_id WHERE accounts.user_id = ?)"
+		args = append(args, s.user.ID)
+	}
+
+	var total int64
+	if err := s.db.Raw("SELECT count(*) FROM book_search WHERE "+where, args...).Scan(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var rows []struct {
+		ID      uint
+		Snippet string
+	}
+	err := s.db.Raw(`SELECT rowid AS id, snippet(book_search, -1, ?, ?, '…', 24) AS snippet
+		FROM book_search WHERE `+where+` ORDER BY bm25(book_search) LIMIT ? OFFSET ?`,
+		append(append([]interface{}{snippetStart, snippetEnd}, args...), limit, offset)...).Scan(&rows).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ids := make([]uint, len(rows))
+	for i, row := range rows {
+		ids[i] = row.ID
+	}
+	books, err := s.booksByID(ids)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var results []SearchResult
+	for _, row := range rows {
+		book, ok := books[row.ID]
+		if !ok {
+			continue
+		}
+		results = append(results, SearchResult{Book: book, Snippet: parseSnippet(row.Snippet)})
+	}
+	return results, total, nil
+}
+
+func (s *Service) searchLike(terms []string, limit, offset int) ([]SearchResult, int64, error) {
+	query := s.ofOwnAccounts(s.db.Model(&Book{}), "books.account_id").
+		Joins("LEFT JOIN book_contents ON book_contents.book_id = books.id")
+	for _, term := range terms {
+		like := "%" + escapeLike(term) + "%"
+		query = query.Where(`books.title LIKE ? ESCAPE '\' OR books.author LIKE ? ESCAPE '\' OR books.series LIKE ? ESCAPE '\'
+			OR books.description LIKE ? ESCAPE '\' OR book_contents.content LIKE ? ESCAPE '\'`,
+			like, like, like, like, like)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var books []Book
+	err := query.Preload("Account").Order("books.delivered_at desc").Limit(limit).Offset(offset).Find(&books).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var results []SearchResult
+	for _, book := range books {
+		var content BookContent
+		s.db.Where("book_id = ?", book.ID).Limit(1).Find(&content)
+
+		text := content.Content
+		if findTerm(strings.ToLower(text), terms) == -1 {
+			text = book.Description
+		}
+		results = append(results, SearchResult{Book: book, Snippet: buildSnippet(text, terms)})
+	}
+	return results, total, nil
+}
+
+func (s *Service) booksByID(ids []uint) (map[uint]Book, error) {
+	books := map[uint]Book{}
+	if len(ids) == 0 {
+		return books, nil
+	}
+	var found []Book
+	if err := s.ofOwnAccounts(s.db, "account_id").Preload("Account").Where("id IN ?", ids).Find(&found).Error; err != nil {
+		return nil, err
+	}
+	for _, book := range found {
+		books[book.ID] = book
+	}
+	return books, nil
+}
+
+func parseSnippet(snippet string) []SnippetPart {
+	var parts []SnippetPart
+	for snippet != "" {
+		start := strings.Index(snippet, snippetStart)
+		if start == -1 {
+			parts = append(parts, SnippetPart{Text: snippet})
+			break
+		}
+		if start > 0 {
+			parts = append(parts, SnippetPart{Text: snippet[:start]})
+		}
+		snippet = snippet[start+len(snippetStart):]
+		end := strings.Index(snippet, snippetEnd)
+		if end == -1 {
+			end = len(snippet)
+		}
+		parts = append(parts, SnippetPart{Text: snippet[:end], Match: true})
+		snippet = strings.TrimPrefix(snippet[end:], snippetEnd)
+	}
+	return parts
+}
+
+// buildSnippet cuts the text around the first matching term and marks every
+// term in it, like FTS5's snippet() does.
+func buildSnippet(text string, terms []string) []SnippetPart {
+	text = strings.Join(strings.Fields(text), " ")
+	lower := strings.ToLower(text)
+	if len(lower) != len(text) {
+		// Case folding changed byte offsets; match on the text as is
+		lower = text
+	}
+
+	start, end := 0, len(text)
+	if first := findTerm(lower, terms); first != -1 {
+		start = max(0, first-snippetRadius)
+		end = min(len(text), first+snippetRadius)
+	} else if end > 2*snippetRadius {
+		end = 2 * snippetRadius
+	}
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+
+	var parts []SnippetPart
+	if start > 0 {
+		parts = append(parts, SnippetPart{Text: "…"})
+	}
+	segment, lowerSegment := text[start:end], lower[start:end]
+	for segment != "" {
+		at, length := -1, 0
+		for _, term := range terms {
+			if i := indexWord(lowerSegment, strings.ToLower(term)); i != -1 && (at == -1 || i < at) {
+				at, length = i, len(term)
+			}
+		}
+		if at == -1 {
+			parts = append(parts, SnippetPart{Text: segment})
+			break
+		}
+		if at > 0 {
+			parts = append(parts, SnippetPart{Text: segment[:at]})
+		}
+		parts = append(parts, SnippetPart{Text: segment[at : at+length], Match: true})
+		segment, lowerSegment = segment[at+length:], lowerSegment[at+length:]
+	}
+	if end < len(text) {
+		parts = append(parts, SnippetPart{Text: "…"})
+	}
+	return parts
+}
+
+func findTerm(lower string, terms []string) int {
+	first := -1
+	for _, term := range terms {
+		if i := indexWord(lower, strings.ToLower(term)); i != -1 && (first == -1 || i < first) {
+			first = i
+		}
+	}
+	return first
+}
+
+// indexWord finds term at the start of a word, so that "cat" matches "Cats"
+// but not "concatenate".
+func indexWord(s, term string) int {
+	offset := 0
+	for {
+		i := strings.Index(s[offset:], term)
+		if i == -1 {
+			return -1
+		}
+		at := offset + i
+		if at == 0 {
+			return at
+		}
+		prev, _ := utf8.DecodeLastRuneInString(s[:at])
+		if !unicode.IsLetter(prev) && !unicode.IsDigit(prev) {
+			return at
+		}
+		offset = at + len(term)
+	}
+}
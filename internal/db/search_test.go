@@ -0,0 +1,139 @@
+package db
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+
+	"bookify/internal/testutil"
+)
+
+func snippetString(parts []SnippetPart) string {
+	var b strings.Builder
+	for _, part := range parts {
+		if part.Match {
+			b.WriteString("[" + part.Text + "]")
+		} else {
+			b.WriteString(part.Text)
+		}
+	}
+	return b.String()
+}
+
+func testSearchBooks(t *testing.T, database *gorm.DB, wantFTS bool) {
+	t.Helper()
+
+	err := database.AutoMigrate(&Account{}, &Job{}, &Book{}, &BookContent{})
+	if err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	service := NewService(database)
+	if service.fullTextSearch() != wantFTS {
+		t.Fatalf("fullTextSearch() = %v, want %v", !wantFTS, wantFTS)
+	}
+
+	account, _ := service.CreateAccount("Home", "folder1")
+	day := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	books := []struct {
+		book    Book
+		content string
+	}{
+		{Book{Title: "Moby-Dick", Author: "Herman Melville"}, "Call me Ishmael. Some years ago, never mind how long precisely, I thought I would sail about a little and see the watery part of the world."},
+		{Book{Title: "Dune", Author: "Frank Herbert", Description: "A desert planet and its sandworms."}, "I must not fear. Fear is the mind-killer."},
+		{Book{Title: "Whale Songs", Author: "Anon"}, "Poems about the sea."},
+	}
+	for i := range books {
+		book := &books[i].book
+		book.AccountID = account.ID
+		book.JobID = fmt.Sprintf("job-%d", i)
+		book.DeliveredAt = day.AddDate(0, 0, i)
+		if err := service.CreateBook(book); err != nil {
+			t.Fatalf("CreateBook() failed: %v", err)
+		}
+		if err := service.IndexBook(book, books[i].content); err != nil {
+			t.Fatalf("IndexBook() failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		query   string
+		want    []string
+		snippet string
+	}{
+		{query: "ishmael", want: []string{"Moby-Dick"}, snippet: "[Ishmael]"},
+		{query: "watery world", want: []string{"Moby-Dick"}, snippet: "[watery]"},
+		{query: "mind", want: []string{"Moby-Dick", "Dune"}},
+		{query: "sandworm", want: []string{"Dune"}},
+		{query: "whale", want: []string{"Whale Songs"}},
+		{query: `"fear" OR`, want: nil},
+		{query: "nothing-like-this", want: nil},
+	}
+
+	for _, tt := range tests {
+		results, total, err := service.SearchBooks(tt.query, 10, 0)
+		if err != nil {
+			t.Fatalf("SearchBooks(%q) failed: %v", tt.query, err)
+		}
+		var got []string
+		for _, result := range results {
+			got = append(got, result.Book.Title)
+			if result.Book.Account.Name != "Home" {
+				t.Errorf("SearchBooks(%q) did not load the account", tt.query)
+			}
+		}
+		if total != int64(len(tt.want)) || len(got) != len(tt.want) {
+			t.Errorf("SearchBooks(%q) = %v (total %d), want %v", tt.query, got, total, tt.want)
+			continue
+		}
+		for _, title := range tt.want {
+			if !strings.Contains(strings.Join(got, "|"), title) {
+				t.Errorf("SearchBooks(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+		}
+		if tt.snippet != "" && !strings.Contains(snippetString(results[0].Snippet), tt.snippet) {
+			t.Errorf("SearchBooks(%q) snippet = %q, want it to contain %q", tt.query, snippetString(results[0].Snippet), tt.snippet)
+		}
+	}
+
+	// Re-indexing replaces the old text
+	if err := service.IndexBook(&books[0].book, "A different text entirely."); err != nil {
+		t.Fatalf("IndexBook() failed: %v", err)
+	}
+	if _, total, _ := service.SearchBooks("ishmael", 10, 0); total != 0 {
+		t.Errorf("re-indexed book still matches its old text")
+	}
+}
+
+func TestDBService_SearchBooks(t *testing.T) {
+	// The test driver has no FTS5, so this covers the fallback
+	testSearchBooks(t, testutil.SetupTestDB(t), false)
+}
+
+func TestDBService_SearchBooks_FTS(t *testing.T) {
+	database, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "search.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	testSearchBooks(t, database, true)
+}
+
+func TestBuildSnippet(t *testing.T) {
+	text := strings.Repeat("filler ", 30) + "the Whale surfaced near the whaler and the whales" + strings.Repeat(" filler", 30)
+
+	got := snippetString(buildSnippet(text, []string{"whale"}))
+	if !strings.HasPrefix(got, "…") || !strings.HasSuffix(got, "…") {
+		t.Errorf("snippet %q should be cut on both sides", got)
+	}
+	if !strings.Contains(got, "the [Whale] surfaced near the [whale]r and the [whale]s") {
+		t.Errorf("snippet %q does not mark the matches", got)
+	}
+
+	if got := snippetString(parseSnippet("a \x02b\x03 c")); got != "a [b] c" {
+		t.Errorf("parseSnippet() = %q", got)
+	}
+}
@@ -1,6 +1,9 @@
 package db
 
 import (
+	"errors"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -9,15 +12,24 @@ import (
 
 type Service struct {
 	db *gorm.DB
+	// Set on services limited to one user's data, see ForUser
+	user *User
+
+	fts *ftsState
+}
+
+type ftsState struct {
+	once    sync.Once
+	enabled bool
 }
 
 func NewService(db *gorm.DB) *Service {
-	return &Service{db: db}
+	return &Service{db: db, fts: &ftsState{}}
 }
 
 func (s *Service) ListAccounts() ([]Account, error) {
 	var accounts []Account
-	err := s.db.Find(&accounts).Error
+	err := s.ownedBy(s.db, "user_id").Find(&accounts).Error
 	return accounts, err
 }
 
@@ -26,38 +38,85 @@ func (s *Service) CreateAccount(name, folderID string) (*Account, error) {
 		Name:     name,
 		FolderID: folderID,
 	}
-	err := s.db.Create(account).Error
+	err := s.CreateAccountWithOAuth(account)
 	return account, err
 }
 
+// CreateAccountWithOAuth saves a new account, owned by the service's user
+// unless it already has one.
 func (s *Service) CreateAccountWithOAuth(account *Account) error {
+	if account.UserID == 0 && s.user != nil {
+		account.UserID = s.user.ID
+	}
 	return s.db.Create(account).Error
 }
 
+// ErrOPDSUsernameTaken is returned when saving an account whose catalogue
+// username another account already has.
+var ErrOPDSUsernameTaken = errors.New("catalogue username is already used by another account")
+
 func (s *Service) UpdateAccount(account *Account) error {
-	return s.db.Save(account).Error
+	err := s.db.Save(account).Error
+	if err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed: accounts.opds_username") {
+		return ErrOPDSUsernameTaken
+	}
+	return err
 }
 
 func (s *Service) GetAccount(id uint) (*Account, error) {
 	var account Account
-	err := s.db.First(&account, id).Error
+	err := s.ownedBy(s.db, "user_id").First(&account, id).Error
 	return &account, err
 }
 
+// GetAccountByName finds one of the service's user's own accounts. Names
+// are only unique per user, so even an admin only sees their own here.
 func (s *Service) GetAccountByName(name string) (*Account, error) {
 	var account Account
-	err := s.db.Where("name = ?", name).First(&account).Error
+	err := s.db.Where("user_id = ? AND name = ?", s.userID(), name).First(&account).Error
+	return &account, err
+}
+
+func (s *Service) GetAccountByOPDSUsername(username string) (*Account, error) {
+	var account Account
+	err := s.db.Where("opds_username = ?", username).First(&account).Error
 	return &account, err
 }
 
 func (s *Service) CreateJob(accountID uint, originalFilename string) (*Job, error) {
 	job := &Job{
-		ID:               uuid.New().String(),
 		AccountID:        accountID,
 		OriginalFilename: originalFilename,
-		Status:           "queued",
-		Stage:            "queued",
-		Progress:         0,
+	}
+	err := s.QueueJob(job)
+	return job, err
+}
+
+// QueueJob saves a new job, with any metadata already set, as queued.
+func (s *Service) QueueJob(job *Job) error {
+	job.ID = uuid.New().String()
+	job.Status = "queued"
+	job.Stage = "queued"
+	job.Progress = 0
+	job.UserID = s.accountOwner(job.AccountID)
+	return s.db.Create(job).Error
+}
+
+// CreateSkippedJob records an upload that was not converted because it is
+// identical to an earlier one, pointing at that job's Drive file.
+func (s *Service) CreateSkippedJob(accountID uint, originalFilename, sourceHash string, duplicate *Job) (*Job, error) {
+	job := &Job{
+		ID:                uuid.New().String(),
+		AccountID:         accountID,
+		OriginalFilename:  originalFilename,
+		ProcessedFilename: duplicate.ProcessedFilename,
+		Status:            "skipped",
+		Stage:             "skipped",
+		Progress:          100,
+		Message:           "Duplicate of " + duplicate.OriginalFilename + ", uploaded " + duplicate.CreatedAt.Format("Jan 2, 2006"),
+		DriveURL:          duplicate.DriveURL,
+		SourceHash:        sourceHash,
+		UserID:            s.accountOwner(accountID),
 	}
 	err := s.db.Create(job).Error
 	return job, err
@@ -69,13 +128,13 @@ func (s *Service) UpdateJob(job *Job) error {
 
 func (s *Service) GetJob(id string) (*Job, error) {
 	var job Job
-	err := s.db.Preload("Account").First(&job, "id = ?", id).Error
+	err := s.ownedBy(s.db, "user_id").Preload("Account").First(&job, "id = ?", id).Error
 	return &job, err
 }
 
 func (s *Service) ListRecentJobs(limit int) ([]Job, error) {
 	var jobs []Job
-	err := s.db.Preload("Account").Order("created_at desc").Limit(limit).Find(&jobs).Error
+	err := s.ownedBy(s.db, "user_id").Preload("Account").Order("created_at desc").Limit(limit).Find(&jobs).Error
 	return jobs, err
 }
 
@@ -110,3 +169,44 @@ func (s *Service) GetNextQueuedJob() (*Job, error) {
 	}
 	return &job, nil
 }
+
+// FindDuplicateJob returns the most recent completed job of the account for
+// the same source file, or nil if there is none.
+func (s *Service) FindDuplicateJob(accountID uint, sourceHash string) (*Job, error) {
+	var job Job
+	err := s.db.Where("account_id = ? AND source_hash = ? AND status = ?", accountID, sourceHash, "completed").
+		Order("completed_at desc").First(&job).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// FindPreviousConversion returns the most recent other completed conversion
+// of the same source file, or nil if there is none.
+func (s *Service) FindPreviousConversion(job *Job) (*Job, error) {
+	var previous Job
+	err := s.db.Where("account_id = ? AND source_hash = ? AND status = ? AND id <> ? AND output_hash <> ''",
+		job.AccountID, job.SourceHash, "completed", job.ID).
+		Order("completed_at desc").First(&previous).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &previous, nil
+}
+
+// HasPendingReprocess reports whether the book already has a reprocess job
+// waiting or running.
+func (s *Service) HasPendingReprocess(bookID uint) (bool, error) {
+	var count int64
+	err := s.db.Model(&Job{}).
+		Where("type = ? AND book_id = ? AND status IN ?", JobTypeReprocess, bookID, []string{"queued", "processing"}).
+		Count(&count).Error
+	return count > 0, err
+}
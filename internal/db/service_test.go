@@ -1,7 +1,11 @@
 package db
 
 import (
+	"errors"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"bookify/internal/testutil"
 )
@@ -188,3 +192,247 @@ func TestDBService_DuplicateAccountName(t *testing.T) {
 		t.Errorf("CreateAccount() with duplicate name should return error")
 	}
 }
+
+func TestInitDB_AccountNameIndex(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "bookify.db")
+	database, err := InitDB(dbPath)
+	if err != nil {
+		t.Fatalf("InitDB() failed: %v", err)
+	}
+	// Databases from before users had their own accounts
+	if err := database.Exec("CREATE UNIQUE INDEX idx_accounts_name ON accounts(name)").Error; err != nil {
+		t.Fatalf("Failed to create the old index: %v", err)
+	}
+	if sqlDB, err := database.DB(); err == nil {
+		_ = sqlDB.Close()
+	}
+
+	database, err = InitDB(dbPath)
+	if err != nil {
+		t.Fatalf("InitDB() of an old database failed: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := database.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+	service := NewService(database)
+	for _, userID := range []uint{1, 2} {
+		if err := service.CreateAccountWithOAuth(&Account{UserID: userID, Name: "Kobo", FolderID: "folder"}); err != nil {
+			t.Errorf("CreateAccountWithOAuth() for user %d failed: %v", userID, err)
+		}
+	}
+	if err := service.CreateAccountWithOAuth(&Account{UserID: 1, Name: "Kobo", FolderID: "folder"}); err == nil {
+		t.Error("a user has two accounts named Kobo")
+	}
+}
+
+func TestInitDB_OPDSLoginIndex(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "bookify.db")
+	database, err := InitDB(dbPath)
+	if err != nil {
+		t.Fatalf("InitDB() failed: %v", err)
+	}
+	// Databases from before catalogue logins had to be unique
+	if err := database.Exec("CREATE INDEX idx_accounts_opds_username ON accounts(opds_username)").Error; err != nil {
+		t.Fatalf("Failed to create the old index: %v", err)
+	}
+	if sqlDB, err := database.DB(); err == nil {
+		_ = sqlDB.Close()
+	}
+
+	database, err = InitDB(dbPath)
+	if err != nil {
+		t.Fatalf("InitDB() of an old database failed: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := database.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+	if database.Migrator().HasIndex(&Account{}, "idx_accounts_opds_username") {
+		t.Error("the old catalogue login index was kept")
+	}
+
+	service := NewService(database)
+	home, _ := service.CreateAccount("Home", "folder1")
+	work, _ := service.CreateAccount("Work", "folder2")
+	if _, err := service.CreateAccount("Spare", "folder3"); err != nil {
+		t.Errorf("accounts without a catalogue login clash: %v", err)
+	}
+	home.OPDSUsername = "reader"
+	if err := service.UpdateAccount(home); err != nil {
+		t.Fatalf("UpdateAccount() failed: %v", err)
+	}
+	work.OPDSUsername = "reader"
+	if err := service.UpdateAccount(work); !errors.Is(err, ErrOPDSUsernameTaken) {
+		t.Errorf("UpdateAccount() with a taken login = %v, want ErrOPDSUsernameTaken", err)
+	}
+}
+
+func TestDBService_FindDuplicateJob(t *testing.T) {
+	database := testutil.SetupTestDB(t)
+	err := database.AutoMigrate(&Account{}, &Job{})
+	if err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	service := NewService(database)
+
+	account, _ := service.CreateAccount("Account", "folder1")
+	other, _ := service.CreateAccount("Other", "folder2")
+
+	failed := &Job{AccountID: account.ID, OriginalFilename: "failed.epub", SourceHash: "hash-1"}
+	if err := service.QueueJob(failed); err != nil {
+		t.Fatalf("QueueJob() failed: %v", err)
+	}
+	_ = service.MarkJobFailed(failed.ID, "broken")
+
+	// Failed or unfinished attempts and other accounts' uploads are not
+	// duplicates
+	if err := service.QueueJob(&Job{AccountID: account.ID, OriginalFilename: "queued.epub", SourceHash: "hash-1"}); err != nil {
+		t.Fatalf("QueueJob() failed: %v", err)
+	}
+	if err := service.QueueJob(&Job{AccountID: other.ID, OriginalFilename: "book.epub", SourceHash: "hash-1"}); err != nil {
+		t.Fatalf("QueueJob() failed: %v", err)
+	}
+	duplicate, err := service.FindDuplicateJob(account.ID, "hash-1")
+	if err != nil {
+		t.Fatalf("FindDuplicateJob() failed: %v", err)
+	}
+	if duplicate != nil {
+		t.Errorf("FindDuplicateJob() = %s, want none", duplicate.ID)
+	}
+
+	completed := &Job{AccountID: account.ID, OriginalFilename: "book.epub", SourceHash: "hash-1"}
+	if err := service.QueueJob(completed); err != nil {
+		t.Fatalf("QueueJob() failed: %v", err)
+	}
+	_ = service.MarkJobCompleted(completed.ID, "book.kepub.epub", "https://drive.example.com/book")
+
+	duplicate, err = service.FindDuplicateJob(account.ID, "hash-1")
+	if err != nil {
+		t.Fatalf("FindDuplicateJob() failed: %v", err)
+	}
+	if duplicate == nil || duplicate.ID != completed.ID {
+		t.Fatalf("FindDuplicateJob() = %v, want completed job", duplicate)
+	}
+
+	skipped, err := service.CreateSkippedJob(account.ID, "copy.epub", "hash-1", duplicate)
+	if err != nil {
+		t.Fatalf("CreateSkippedJob() failed: %v", err)
+	}
+	if skipped.Status != "skipped" || skipped.DriveURL != "https://drive.example.com/book" {
+		t.Errorf("CreateSkippedJob() = %s %q, want skipped job linking to the existing file", skipped.Status, skipped.DriveURL)
+	}
+
+	// Skipped jobs never count as the original
+	duplicate, _ = service.FindDuplicateJob(account.ID, "hash-1")
+	if duplicate == nil || duplicate.ID != completed.ID {
+		t.Errorf("FindDuplicateJob() after skip = %v, want completed job", duplicate)
+	}
+}
+
+func TestDBService_FindPreviousConversion(t *testing.T) {
+	database := testutil.SetupTestDB(t)
+	err := database.AutoMigrate(&Account{}, &Job{})
+	if err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	service := NewService(database)
+
+	account, _ := service.CreateAccount("Account", "folder1")
+
+	first := &Job{AccountID: account.ID, OriginalFilename: "book.epub", SourceHash: "hash-1", OutputHash: "out-1"}
+	if err := service.QueueJob(first); err != nil {
+		t.Fatalf("QueueJob() failed: %v", err)
+	}
+	_ = service.MarkJobCompleted(first.ID, "book.kepub.epub", "https://drive.example.com/book")
+
+	second := &Job{AccountID: account.ID, OriginalFilename: "book.epub", SourceHash: "hash-1"}
+	if err := service.QueueJob(second); err != nil {
+		t.Fatalf("QueueJob() failed: %v", err)
+	}
+
+	previous, err := service.FindPreviousConversion(second)
+	if err != nil {
+		t.Fatalf("FindPreviousConversion() failed: %v", err)
+	}
+	if previous == nil || previous.OutputHash != "out-1" {
+		t.Errorf("FindPreviousConversion() = %v, want first conversion", previous)
+	}
+
+	previous, _ = service.FindPreviousConversion(first)
+	if previous != nil {
+		t.Errorf("FindPreviousConversion() for the first job = %s, want none", previous.ID)
+	}
+}
+
+func TestSplitEmailSenders(t *testing.T) {
+	got := SplitEmailSenders(" Reader@Example.com,\nother@example.com ; third@example.com\n\n")
+	want := []string{"reader@example.com", "other@example.com", "third@example.com"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("SplitEmailSenders() = %v, want %v", got, want)
+	}
+}
+
+func TestDBService_GetAccountByEmailSender(t *testing.T) {
+	database := testutil.SetupTestDB(t)
+	if err := database.AutoMigrate(&Account{}, &Job{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	service := NewService(database)
+
+	account, _ := service.CreateAccount("reader", "folder-123")
+	account.EmailSenders = "reader@example.com\nkindle@example.com"
+	if err := service.UpdateAccount(account); err != nil {
+		t.Fatalf("Failed to save account: %v", err)
+	}
+
+	found, err := service.GetAccountByEmailSender("Kindle@Example.com")
+	if err != nil || found == nil || found.ID != account.ID {
+		t.Errorf("GetAccountByEmailSender() = %v, %v, want the reader account", found, err)
+	}
+	if found, err := service.GetAccountByEmailSender("stranger@example.com"); err != nil || found != nil {
+		t.Errorf("GetAccountByEmailSender() = %v, %v, want no account", found, err)
+	}
+}
+
+func TestDBService_APITokens(t *testing.T) {
+	database := testutil.SetupTestDB(t)
+	if err := database.AutoMigrate(&APIToken{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	service := NewService(database)
+
+	secret, token, err := service.CreateAPIToken("ci", []string{ScopeRead}, nil)
+	if err != nil {
+		t.Fatalf("CreateAPIToken() failed: %v", err)
+	}
+	if !strings.HasPrefix(secret, token.Prefix) || token.Hash == secret {
+		t.Errorf("CreateAPIToken() = %q, %+v", secret, token)
+	}
+	if !token.HasScope(ScopeRead) || token.HasScope(ScopeUpload) || token.Expired(time.Now()) {
+		t.Errorf("token scopes = %v", token.ScopeList())
+	}
+
+	found, err := service.GetAPITokenBySecret(secret)
+	if err != nil || found.ID != token.ID {
+		t.Fatalf("GetAPITokenBySecret() = %+v, %v", found, err)
+	}
+	if _, err := service.GetAPITokenBySecret(secret + "x"); err == nil {
+		t.Error("GetAPITokenBySecret() found a wrong secret")
+	}
+
+	// Uses within a minute of each other aren't written down
+	now := time.Now()
+	_ = service.TouchAPIToken(found, now)
+	_ = service.TouchAPIToken(found, now.Add(30*time.Second))
+	if !found.LastUsedAt.Equal(now) {
+		t.Errorf("LastUsedAt = %v, want %v", found.LastUsedAt, now)
+	}
+
+	admin := &APIToken{Scopes: ScopeAdmin}
+	if !admin.HasScope(ScopeUpload) {
+		t.Error("admin tokens should have every scope")
+	}
+}
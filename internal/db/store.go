@@ -0,0 +1,45 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// SaveStoredFile records a file added to the store, or marks an existing one
+// as just used.
+func (s *Service) SaveStoredFile(hash string, size int64) error {
+	now := time.Now()
+	return s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "hash"}},
+		DoUpdates: clause.Assignments(map[string]any{"last_used_at": now}),
+	}).Create(&StoredFile{Hash: hash, Size: size, LastUsedAt: now}).Error
+}
+
+func (s *Service) GetStoredFile(hash string) (*StoredFile, error) {
+	var file StoredFile
+	err := s.db.Where("hash = ?", hash).First(&file).Error
+	return &file, err
+}
+
+func (s *Service) TouchStoredFile(hash string) error {
+	return s.db.Model(&StoredFile{}).Where("hash = ?", hash).Update("last_used_at", time.Now()).Error
+}
+
+// ListStoredFiles returns every stored file, least recently used first.
+func (s *Service) ListStoredFiles() ([]StoredFile, error) {
+	var files []StoredFile
+	err := s.db.Order("last_used_at, hash").Find(&files).Error
+	return files, err
+}
+
+// DeleteStoredFile forgets a file removed from the store. Jobs that kept
+// it are no longer retained.
+func (s *Service) DeleteStoredFile(hash string) error {
+	if err := s.db.Delete(&StoredFile{}, "hash = ?", hash).Error; err != nil {
+		return err
+	}
+	return s.db.Model(&Job{}).
+		Where("retained = ? AND (source_hash = ? OR output_hash = ?)", true, hash, hash).
+		Update("retained", false).Error
+}
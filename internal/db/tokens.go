@@ -0,0 +1,95 @@
+package db
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	ScopeRead   = "read"
+	ScopeUpload = "upload"
+	// Admin tokens can do everything
+	ScopeAdmin = "admin"
+)
+
+var APIScopes = []string{ScopeRead, ScopeUpload, ScopeAdmin}
+
+const apiTokenPrefix = "bk_"
+
+// How often the last use of a token is written down
+const tokenUseResolution = time.Minute
+
+// ScopeList returns the token's scopes.
+func (t *APIToken) ScopeList() []string {
+	if t.Scopes == "" {
+		return nil
+	}
+	return strings.Split(t.Scopes, ",")
+}
+
+// HasScope reports whether the token grants a scope.
+func (t *APIToken) HasScope(scope string) bool {
+	scopes := t.ScopeList()
+	return slices.Contains(scopes, scope) || slices.Contains(scopes, ScopeAdmin)
+}
+
+func (t *APIToken) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIToken creates a token and returns it in full, which is the only
+// time it can be seen.
+func (s *Service) CreateAPIToken(name string, scopes []string, expiresAt *time.Time) (string, *APIToken, error) {
+	random := make([]byte, 24)
+	if _, err := rand.Read(random); err != nil {
+		return "", nil, err
+	}
+	secret := apiTokenPrefix + hex.EncodeToString(random)
+	token := &APIToken{
+		UserID:    s.userID(),
+		Name:      name,
+		Prefix:    secret[:len(apiTokenPrefix)+8],
+		Hash:      hashSecret(secret),
+		Scopes:    strings.Join(scopes, ","),
+		ExpiresAt: expiresAt,
+	}
+	if err := s.db.Create(token).Error; err != nil {
+		return "", nil, err
+	}
+	return secret, token, nil
+}
+
+func (s *Service) ListAPITokens() ([]APIToken, error) {
+	var tokens []APIToken
+	err := s.ownedBy(s.db, "user_id").Order("created_at desc").Find(&tokens).Error
+	return tokens, err
+}
+
+// GetAPITokenBySecret finds the token a request presented.
+func (s *Service) GetAPITokenBySecret(secret string) (*APIToken, error) {
+	var token APIToken
+	err := s.db.Preload("User").Where("hash = ?", hashSecret(secret)).First(&token).Error
+	return &token, err
+}
+
+// TouchAPIToken records that a token was used, at most once a minute.
+func (s *Service) TouchAPIToken(token *APIToken, now time.Time) error {
+	if token.LastUsedAt != nil && now.Sub(*token.LastUsedAt) < tokenUseResolution {
+		return nil
+	}
+	token.LastUsedAt = &now
+	return s.db.Model(token).Update("last_used_at", now).Error
+}
+
+func (s *Service) DeleteAPIToken(id uint) error {
+	return s.ownedBy(s.db, "user_id").Delete(&APIToken{}, id).Error
+}
@@ -0,0 +1,226 @@
+package db
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrLastAdmin = errors.New("the last admin can't be removed")
+
+// ForUser returns a service limited to the accounts, jobs, books and API
+// tokens of the user. Admins see everything.
+func (s *Service) ForUser(user *User) *Service {
+	return &Service{db: s.db, user: user, fts: s.fts}
+}
+
+// restricted reports whether the service only sees one user's data.
+func (s *Service) restricted() bool {
+	return s.user != nil && s.user.Role != RoleAdmin
+}
+
+// ownedBy limits a query to the rows whose column is the service's user.
+func (s *Service) ownedBy(query *gorm.DB, column string) *gorm.DB {
+	if !s.restricted() {
+		return query
+	}
+	return query.Where(column+" = ?", s.user.ID)
+}
+
+// ofOwnAccounts limits a query to the rows whose column is one of the
+// service's user's accounts.
+func (s *Service) ofOwnAccounts(query *gorm.DB, column string) *gorm.DB {
+	if !s.restricted() {
+		return query
+	}
+	return query.Where(column+" IN (?)", s.db.Model(&Account{}).Select("id").Where("user_id = ?", s.user.ID))
+}
+
+// userID is the service's user, or 0 for a service that isn't limited to
+// one.
+func (s *Service) userID() uint {
+	if s.user == nil {
+		return 0
+	}
+	return s.user.ID
+}
+
+// accountOwner returns the user an account belongs to, for the jobs queued
+// for it.
+func (s *Service) accountOwner(accountID uint) uint {
+	var owners []uint
+	s.db.Model(&Account{}).Where("id = ?", accountID).Limit(1).Pluck("user_id", &owners)
+	if len(owners) == 0 {
+		return 0
+	}
+	return owners[0]
+}
+
+func (s *Service) CountUsers() (int64, error) {
+	var count int64
+	err := s.db.Model(&User{}).Count(&count).Error
+	return count, err
+}
+
+// CreateUser adds a user. The first user is always an admin, and takes over
+// the accounts, jobs and API tokens made before there were users.
+func (s *Service) CreateUser(username, passwordHash, role string) (*User, error) {
+	user := &User{Username: username, PasswordHash: passwordHash, Role: role}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&User{}).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			user.Role = RoleAdmin
+		}
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+		for _, model := range []interface{}{&Account{}, &Job{}, &APIToken{}} {
+			if err := tx.Model(model).Where("user_id = 0").Update("user_id", user.ID).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return user, err
+}
+
+func (s *Service) GetUser(id uint) (*User, error) {
+	var user User
+	err := s.db.First(&user, id).Error
+	return &user, err
+}
+
+func (s *Service) GetUserByUsername(username string) (*User, error) {
+	var user User
+	err := s.db.Where("username = ?", username).First(&user).Error
+	return &user, err
+}
+
+func (s *Service) GetUserByOIDCSubject(subject string) (*User, error) {
+	var user User
+	err := s.db.Where("oidc_subject = ? AND oidc_subject != ''", subject).First(&user).Error
+	return &user, err
+}
+
+// LinkUserOIDC lets a user sign in as the subject at the OpenID Connect
+// provider.
+func (s *Service) LinkUserOIDC(id uint, subject string) error {
+	return s.db.Model(&User{}).Where("id = ?", id).Update("oidc_subject", subject).Error
+}
+
+// SetUserRole changes a user's role, keeping at least one admin.
+func (s *Service) SetUserRole(id uint, role string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var user User
+		if err := tx.First(&user, id).Error; err != nil {
+			return err
+		}
+		if user.Role == RoleAdmin && role != RoleAdmin {
+			var admins int64
+			if err := tx.Model(&User{}).Where("role = ?", RoleAdmin).Count(&admins).Error; err != nil {
+				return err
+			}
+			if admins <= 1 {
+				return ErrLastAdmin
+			}
+		}
+		return tx.Model(&User{}).Where("id = ?", id).Update("role", role).Error
+	})
+}
+
+func (s *Service) ListUsers() ([]User, error) {
+	var users []User
+	err := s.db.Order("username").Find(&users).Error
+	return users, err
+}
+
+// SetUserPassword changes a user's password and signs them out everywhere.
+func (s *Service) SetUserPassword(id uint, passwordHash string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&User{}).Where("id = ?", id).Update("password_hash", passwordHash).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", id).Delete(&Session{}).Error
+	})
+}
+
+// DeleteUser removes a user with their sessions and API tokens. Their
+// accounts and jobs go to the heir.
+func (s *Service) DeleteUser(id, heirID uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var user User
+		if err := tx.First(&user, id).Error; err != nil {
+			return err
+		}
+		if user.Role == RoleAdmin {
+			var admins int64
+			if err := tx.Model(&User{}).Where("role = ?", RoleAdmin).Count(&admins).Error; err != nil {
+				return err
+			}
+			if admins <= 1 {
+				return ErrLastAdmin
+			}
+		}
+		// Account names are only unique per user, and the heir may already
+		// have one of the same name
+		var clashes []Account
+		heirNames := tx.Model(&Account{}).Select("name").Where("user_id = ?", heirID)
+		if err := tx.Where("user_id = ? AND name IN (?)", id, heirNames).Find(&clashes).Error; err != nil {
+			return err
+		}
+		for _, account := range clashes {
+			if err := tx.Model(&Account{}).Where("id = ?", account.ID).Update("name", account.Name+" ("+user.Username+")").Error; err != nil {
+				return err
+			}
+		}
+		for _, model := range []interface{}{&Account{}, &Job{}} {
+			if err := tx.Model(model).Where("user_id = ?", id).Update("user_id", heirID).Error; err != nil {
+				return err
+			}
+		}
+		for _, model := range []interface{}{&Session{}, &APIToken{}} {
+			if err := tx.Where("user_id = ?", id).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&user).Error
+	})
+}
+
+// CreateSession signs a user in and returns the secret for their cookie.
+func (s *Service) CreateSession(userID uint, expiresAt time.Time) (string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	secret := hex.EncodeToString(random)
+	session := &Session{UserID: userID, Hash: hashSecret(secret), ExpiresAt: expiresAt}
+	if err := s.db.Create(session).Error; err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// GetSessionBySecret finds an unexpired session with its user.
+func (s *Service) GetSessionBySecret(secret string, now time.Time) (*Session, error) {
+	var session Session
+	err := s.db.Preload("User").Where("hash = ? AND expires_at > ?", hashSecret(secret), now).First(&session).Error
+	return &session, err
+}
+
+func (s *Service) DeleteSession(secret string) error {
+	return s.db.Where("hash = ?", hashSecret(secret)).Delete(&Session{}).Error
+}
+
+func (s *Service) DeleteExpiredSessions(now time.Time) error {
+	return s.db.Where("expires_at <= ?", now).Delete(&Session{}).Error
+}
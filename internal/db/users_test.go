@@ -0,0 +1,176 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"bookify/internal/testutil"
+)
+
+func TestDBService_UserIsolation(t *testing.T) {
+	database := testutil.SetupTestDB(t)
+	err := database.AutoMigrate(&Account{}, &Job{}, &Book{}, &BookContent{}, &Batch{}, &KoboDevice{}, &APIToken{}, &User{}, &Session{})
+	if err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	service := NewService(database)
+
+	// Made before there were users
+	legacy, _ := service.CreateAccount("Legacy", "folder0")
+	legacyJob, _ := service.CreateJob(legacy.ID, "old.epub")
+
+	admin, err := service.CreateUser("admin", "hash", RoleUser)
+	if err != nil {
+		t.Fatalf("CreateUser() failed: %v", err)
+	}
+	if admin.Role != RoleAdmin {
+		t.Errorf("first user role = %s, want admin", admin.Role)
+	}
+	if job, _ := service.GetJob(legacyJob.ID); job.UserID != admin.ID {
+		t.Errorf("the first user didn't take over existing jobs: %+v", job)
+	}
+
+	alice, _ := service.CreateUser("alice", "hash", RoleUser)
+	bob, _ := service.CreateUser("bob", "hash", RoleUser)
+	if alice.Role != RoleUser {
+		t.Errorf("second user role = %s, want user", alice.Role)
+	}
+	asAlice, asBob, asAdmin := service.ForUser(alice), service.ForUser(bob), service.ForUser(admin)
+
+	aliceAccount, _ := asAlice.CreateAccount("Alice", "folder1")
+	bobAccount, _ := asBob.CreateAccount("Bob", "folder2")
+	if aliceAccount.UserID != alice.ID {
+		t.Errorf("account owner = %d, want %d", aliceAccount.UserID, alice.ID)
+	}
+	aliceJob, _ := asAlice.CreateJob(aliceAccount.ID, "alice.epub")
+	bobJob, _ := service.CreateJob(bobAccount.ID, "bob.epub")
+	if aliceJob.UserID != alice.ID || bobJob.UserID != bob.ID {
+		t.Errorf("jobs belong to %d and %d, want their accounts' owners", aliceJob.UserID, bobJob.UserID)
+	}
+	aliceBook := &Book{JobID: aliceJob.ID, AccountID: aliceAccount.ID, Title: "Alice's Book"}
+	bobBook := &Book{JobID: bobJob.ID, AccountID: bobAccount.ID, Title: "Bob's Book"}
+	_ = service.CreateBook(aliceBook)
+	_ = service.CreateBook(bobBook)
+	_ = service.IndexBook(aliceBook, "a shared word")
+	_ = service.IndexBook(bobBook, "a shared word")
+	bobBatch, _ := service.CreateBatch(bobAccount.ID, "bob.epub")
+
+	if accounts, _ := asAlice.ListAccounts(); len(accounts) != 1 || accounts[0].ID != aliceAccount.ID {
+		t.Errorf("alice's accounts = %+v", accounts)
+	}
+	if accounts, _ := asAdmin.ListAccounts(); len(accounts) != 3 {
+		t.Errorf("admin sees %d accounts, want 3", len(accounts))
+	}
+	if _, err := asAlice.GetAccount(bobAccount.ID); err == nil {
+		t.Error("alice got bob's account")
+	}
+	if _, err := asAlice.GetJob(bobJob.ID); err == nil {
+		t.Error("alice got bob's job")
+	}
+	if jobs, _ := asAlice.ListRecentJobs(10); len(jobs) != 1 {
+		t.Errorf("alice sees %d jobs, want 1", len(jobs))
+	}
+	if jobs, total, _ := asAlice.ListJobs(JobFilter{Page: 1, PageSize: 10}); len(jobs) != 1 || total != 1 {
+		t.Errorf("alice lists %d of %d jobs, want 1", len(jobs), total)
+	}
+	if cancelled, _ := asAlice.CancelJob(bobJob.ID); cancelled {
+		t.Error("alice cancelled bob's job")
+	}
+	if _, err := asAlice.GetBook(bobBook.ID); err == nil {
+		t.Error("alice got bob's book")
+	}
+	if books, total, _ := asAlice.ListBooks(BookFilter{}); len(books) != 1 || total != 1 {
+		t.Errorf("alice's library has %d of %d books, want 1", len(books), total)
+	}
+	if results, total, _ := asAlice.SearchBooks("shared", 10, 0); len(results) != 1 || total != 1 {
+		t.Errorf("alice's search found %d of %d books, want 1", len(results), total)
+	}
+	if results, _, _ := asAdmin.SearchBooks("shared", 10, 0); len(results) != 2 {
+		t.Errorf("admin's search found %d books, want 2", len(results))
+	}
+	if _, err := asAlice.GetBatch(bobBatch.ID); err == nil {
+		t.Error("alice got bob's batch")
+	}
+
+	// Tokens belong to whoever made them
+	_, aliceToken, _ := asAlice.CreateAPIToken("alice", []string{ScopeRead}, nil)
+	if aliceToken.UserID != alice.ID {
+		t.Errorf("token owner = %d, want %d", aliceToken.UserID, alice.ID)
+	}
+	if tokens, _ := asBob.ListAPITokens(); len(tokens) != 0 {
+		t.Errorf("bob sees %d tokens, want 0", len(tokens))
+	}
+	_ = asBob.DeleteAPIToken(aliceToken.ID)
+	if tokens, _ := asAlice.ListAPITokens(); len(tokens) != 1 {
+		t.Error("bob revoked alice's token")
+	}
+
+	// Account names only need to be unique per user
+	bobKobo, err := asBob.CreateAccount("Kobo", "folder3")
+	if err != nil {
+		t.Fatalf("CreateAccount() for bob failed: %v", err)
+	}
+	if _, err := asAdmin.CreateAccount("Kobo", "folder4"); err != nil {
+		t.Errorf("CreateAccount() with another user's account name failed: %v", err)
+	}
+	if _, err := asBob.CreateAccount("Kobo", "folder5"); err == nil {
+		t.Error("bob has two accounts named Kobo")
+	}
+	if account, err := asBob.GetAccountByName("Kobo"); err != nil || account.ID != bobKobo.ID {
+		t.Errorf("GetAccountByName() for bob = %+v, %v", account, err)
+	}
+	if _, err := asAlice.GetAccountByName("Kobo"); err == nil {
+		t.Error("alice found bob's account by name")
+	}
+
+	// Removing a user hands their accounts over
+	if err := service.DeleteUser(bob.ID, admin.ID); err != nil {
+		t.Fatalf("DeleteUser() failed: %v", err)
+	}
+	if account, _ := service.GetAccount(bobKobo.ID); account.Name != "Kobo (bob)" {
+		t.Errorf("bob's Kobo account is named %q after the handover", account.Name)
+	}
+	if account, _ := service.GetAccount(bobAccount.ID); account.UserID != admin.ID {
+		t.Errorf("bob's account belongs to %d, want the admin", account.UserID)
+	}
+	if err := service.DeleteUser(admin.ID, alice.ID); !errors.Is(err, ErrLastAdmin) {
+		t.Errorf("DeleteUser(last admin) = %v, want ErrLastAdmin", err)
+	}
+}
+
+func TestDBService_Sessions(t *testing.T) {
+	database := testutil.SetupTestDB(t)
+	if err := database.AutoMigrate(&Account{}, &Job{}, &APIToken{}, &User{}, &Session{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	service := NewService(database)
+	user, _ := service.CreateUser("reader", "hash", RoleUser)
+
+	now := time.Now()
+	secret, err := service.CreateSession(user.ID, now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("CreateSession() failed: %v", err)
+	}
+	session, err := service.GetSessionBySecret(secret, now)
+	if err != nil || session.User.Username != "reader" {
+		t.Fatalf("GetSessionBySecret() = %+v, %v", session, err)
+	}
+	if _, err := service.GetSessionBySecret(secret, now.Add(2*time.Hour)); err == nil {
+		t.Error("an expired session was accepted")
+	}
+
+	// A new password signs the user out
+	if err := service.SetUserPassword(user.ID, "new hash"); err != nil {
+		t.Fatalf("SetUserPassword() failed: %v", err)
+	}
+	if _, err := service.GetSessionBySecret(secret, now); err == nil {
+		t.Error("the session survived a password change")
+	}
+
+	secret, _ = service.CreateSession(user.ID, now.Add(time.Hour))
+	_ = service.DeleteSession(secret)
+	if _, err := service.GetSessionBySecret(secret, now); err == nil {
+		t.Error("the session survived signing out")
+	}
+}
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"bookify/internal/db"
+	"bookify/internal/services"
+	"bookify/internal/templates"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func (h *Handlers) AccountsPage(c echo.Context) error {
+	accounts, err := h.userDB(c).ListAccounts()
+	if err != nil {
+		return err
+	}
+
+	return render(c, templates.AccountsPage(accounts))
+}
+
+func (h *Handlers) AccountSettingsPage(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.Redirect(http.StatusFound, "/accounts")
+	}
+
+	account, err := h.userDB(c).GetAccount(uint(id))
+	if err != nil {
+		return c.Redirect(http.StatusFound, "/accounts")
+	}
+
+	return render(c, templates.AccountSettingsPage(*account, services.DeviceProfiles, "", ""))
+}
+
+func (h *Handlers) UpdateAccountSettings(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.Redirect(http.StatusFound, "/accounts")
+	}
+
+	account, err := h.userDB(c).GetAccount(uint(id))
+	if err != nil {
+		return c.Redirect(http.StatusFound, "/accounts")
+	}
+
+	deviceProfile := c.FormValue("device_profile")
+	if _, ok := services.LookupDeviceProfile(deviceProfile); deviceProfile != "" && !ok {
+		return render(c, templates.AccountSettingsPage(*account, services.DeviceProfiles, "", "Unknown device profile"))
+	}
+
+	duplicatePolicy := c.FormValue("duplicate_policy")
+	if duplicatePolicy == "" {
+		duplicatePolicy = db.DuplicatePolicySkip
+	}
+	if duplicatePolicy != db.DuplicatePolicySkip && duplicatePolicy != db.DuplicatePolicyForce {
+		return render(c, templates.AccountSettingsPage(*account, services.DeviceProfiles, "", "Unknown duplicate policy"))
+	}
+
+	hotFolder := strings.TrimSpace(c.FormValue("hot_folder"))
+	if hotFolder != "" && hotFolder != account.HotFolder {
+		if !filepath.IsAbs(hotFolder) {
+			return render(c, templates.AccountSettingsPage(*account, services.DeviceProfiles, "", "Hot folder must be an absolute path"))
+		}
+		resolved, ok := h.allowedHotFolder(c, account, hotFolder)
+		if !ok {
+			return render(c, templates.AccountSettingsPage(*account, services.DeviceProfiles, "", "Hot folder must be a directory you're allowed to watch"))
+		}
+		hotFolder = resolved
+	}
+
+	inboxFolderID := strings.TrimSpace(c.FormValue("inbox_folder_id"))
+	archiveFolderID := strings.TrimSpace(c.FormValue("archive_folder_id"))
+	if inboxFolderID != "" && inboxFolderID == account.FolderID {
+		return render(c, templates.AccountSettingsPage(*account, services.DeviceProfiles, "", "The inbox folder must not be the folder books are delivered to"))
+	}
+	if archiveFolderID != "" && archiveFolderID == inboxFolderID {
+		return render(c, templates.AccountSettingsPage(*account, services.DeviceProfiles, "", "The archive folder must not be the inbox folder"))
+	}
+
+	emailSenders, errorMsg := parseEmailSenders(h.DB, account, c.FormValue("email_senders"))
+	if errorMsg != "" {
+		return render(c, templates.AccountSettingsPage(*account, services.DeviceProfiles, "", errorMsg))
+	}
+
+	if errorMsg := setOPDSLogin(h.DB, account, c.FormValue("opds_username"), c.FormValue("opds_password")); errorMsg != "" {
+		return render(c, templates.AccountSettingsPage(*account, services.DeviceProfiles, "", errorMsg))
+	}
+
+	account.DeviceProfile = deviceProfile
+	account.HotFolder = hotFolder
+	if inboxFolderID != account.InboxFolderID {
+		// A new inbox is listed in full on the next check
+		account.InboxPageToken = ""
+	}
+	account.InboxFolderID = inboxFolderID
+	account.ArchiveFolderID = archiveFolderID
+	account.EmailSenders = emailSenders
+	account.DuplicatePolicy = duplicatePolicy
+	account.Greyscale = c.FormValue("greyscale") == "1"
+	account.ComicRightToLeft = c.FormValue("comic_right_to_left") == "1"
+	account.ComicSplitSpreads = c.FormValue("comic_split_spreads") == "1"
+	account.Smartypants = c.FormValue("smartypants") == "1"
+	account.Hyphenate = c.FormValue("hyphenate") == "1"
+	account.FullScreenFixes = c.FormValue("full_screen_fixes") == "1"
+
+	if err := h.userDB(c).UpdateAccount(account); err != nil {
+		errorMsg := "Failed to save settings"
+		if errors.Is(err, db.ErrOPDSUsernameTaken) {
+			errorMsg = opdsUsernameTaken
+		}
+		return render(c, templates.AccountSettingsPage(*account, services.DeviceProfiles, "", errorMsg))
+	}
+
+	return render(c, templates.AccountSettingsPage(*account, services.DeviceProfiles, "Settings saved", ""))
+}
+
+// allowedHotFolder resolves a hot folder and checks the signed-in user may
+// watch it. Admins may pick any directory; other users only one inside
+// HotFolderRoot that no other account watches. Whether the path exists isn't
+// told apart from whether it's allowed.
+func (h *Handlers) allowedHotFolder(c echo.Context, account *db.Account, path string) (string, bool) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", false
+	}
+	if info, err := os.Stat(resolved); err != nil || !info.IsDir() {
+		return "", false
+	}
+	if user := currentUser(c); user != nil && user.Role == db.RoleAdmin {
+		return resolved, true
+	}
+
+	if h.HotFolderRoot == "" {
+		return "", false
+	}
+	root, err := filepath.EvalSymlinks(h.HotFolderRoot)
+	if err != nil || !isInside(root, resolved) {
+		return "", false
+	}
+	accounts, err := h.DB.ListHotFolderAccounts()
+	if err != nil {
+		return "", false
+	}
+	for _, other := range accounts {
+		if other.ID != account.ID && (other.HotFolder == resolved || isInside(other.HotFolder, resolved) || isInside(resolved, other.HotFolder)) {
+			return "", false
+		}
+	}
+	return resolved, true
+}
+
+// isInside reports whether path is below dir, not dir itself.
+func isInside(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// parseEmailSenders checks the addresses allowed to email books to the
+// account and returns them one per line. An address can only belong to one
+// account, which its books are delivered to.
+func parseEmailSenders(dbService *db.Service, account *db.Account, senders string) (string, string) {
+	addresses := db.SplitEmailSenders(senders)
+	for _, address := range addresses {
+		if parsed, err := mail.ParseAddress(address); err != nil || parsed.Address != address {
+			return "", "Invalid sender address: " + address
+		}
+		if other, err := dbService.GetAccountByEmailSender(address); err == nil && other != nil && other.ID != account.ID {
+			return "", "Sender " + address + " is already allowed for " + other.Name
+		}
+	}
+	return strings.Join(addresses, "\n"), ""
+}
+
+const opdsUsernameTaken = "Catalogue username is already used by another account"
+
+// setOPDSLogin updates the account's catalogue login. An empty username
+// turns the catalogue off; an empty password keeps the current one.
+func setOPDSLogin(dbService *db.Service, account *db.Account, username, password string) string {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		account.OPDSUsername = ""
+		account.OPDSPasswordHash = ""
+		return ""
+	}
+
+	if other, err := dbService.GetAccountByOPDSUsername(username); err == nil && other.ID != account.ID {
+		return opdsUsernameTaken
+	}
+	if password == "" && account.OPDSPasswordHash == "" {
+		return "Choose a password for the catalogue login"
+	}
+	if password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return "Failed to save the catalogue password"
+		}
+		account.OPDSPasswordHash = string(hash)
+	}
+	account.OPDSUsername = username
+	return ""
+}
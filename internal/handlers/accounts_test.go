@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bookify/internal/db"
+	"bookify/internal/testutil"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestHandlers_UpdateAccountSettings(t *testing.T) {
+	testDB := testutil.SetupTestDB(t)
+	err := testDB.AutoMigrate(&db.Account{}, &db.Job{})
+	if err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+
+	dbService := db.NewService(testDB)
+	handlers := &Handlers{DB: dbService}
+
+	account, err := dbService.CreateAccount("test-account", "folder-123")
+	if err != nil {
+		t.Fatalf("Failed to create test account: %v", err)
+	}
+
+	tests := []struct {
+		name            string
+		form            url.Values
+		expectContent   string
+		expectProfile   string
+		expectGreyscale bool
+	}{
+		{
+			name:            "set device profile",
+			form:            url.Values{"device_profile": {"sage"}, "greyscale": {"1"}},
+			expectContent:   "Settings saved",
+			expectProfile:   "sage",
+			expectGreyscale: true,
+		},
+		{
+			name:          "unknown device profile",
+			form:          url.Values{"device_profile": {"kindle"}},
+			expectContent: "Unknown device profile",
+			expectProfile: "sage",
+			// Rejected updates keep the stored settings
+			expectGreyscale: true,
+		},
+		{
+			name:          "disable optimisation",
+			form:          url.Values{"device_profile": {""}},
+			expectContent: "Settings saved",
+			expectProfile: "",
+		},
+	}
+
+	e := echo.New()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := fmt.Sprintf("/accounts/%d/settings", account.ID)
+			req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(tt.form.Encode()))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("id")
+			c.SetParamValues(fmt.Sprintf("%d", account.ID))
+
+			if err := handlers.UpdateAccountSettings(c); err != nil {
+				t.Fatalf("UpdateAccountSettings() error = %v", err)
+			}
+			testutil.AssertResponseContains(t, rec, tt.expectContent)
+
+			updated, err := dbService.GetAccount(account.ID)
+			if err != nil {
+				t.Fatalf("Failed to reload account: %v", err)
+			}
+			if updated.DeviceProfile != tt.expectProfile {
+				t.Errorf("DeviceProfile = %q, want %q", updated.DeviceProfile, tt.expectProfile)
+			}
+			if updated.Greyscale != tt.expectGreyscale {
+				t.Errorf("Greyscale = %v, want %v", updated.Greyscale, tt.expectGreyscale)
+			}
+		})
+	}
+}
+
+func TestHandlers_AccountSettingsPage_UnknownAccount(t *testing.T) {
+	testDB := testutil.SetupTestDB(t)
+	err := testDB.AutoMigrate(&db.Account{}, &db.Job{})
+	if err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+
+	handlers := &Handlers{DB: db.NewService(testDB)}
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/accounts/42/settings", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues("42")
+
+	if err := handlers.AccountSettingsPage(c); err != nil {
+		t.Fatalf("AccountSettingsPage() error = %v", err)
+	}
+
+	testutil.AssertResponseStatus(t, rec, http.StatusFound)
+}
+
+func TestHandlers_UpdateAccountSettings_HotFolder(t *testing.T) {
+	testDB := testutil.SetupTestDB(t)
+	if err := testDB.AutoMigrate(&db.Account{}, &db.Job{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	dbService := db.NewService(testDB)
+	root, _ := filepath.EvalSymlinks(t.TempDir())
+	handlers := &Handlers{DB: dbService, HotFolderRoot: root}
+	admin := &db.User{ID: 1, Username: "admin", Role: db.RoleAdmin}
+	reader := &db.User{ID: 2, Username: "reader", Role: db.RoleUser}
+	account, _ := dbService.ForUser(reader).CreateAccount("test-account", "folder-123")
+	other, _ := dbService.CreateAccount("other-account", "folder-456")
+	for _, dir := range []string{"mine", "theirs", "mine/nested"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+	other.HotFolder = filepath.Join(root, "theirs")
+	_ = dbService.UpdateAccount(other)
+	outside, _ := filepath.EvalSymlinks(t.TempDir())
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	const notAllowed = "must be a directory you&#39;re allowed to watch"
+
+	tests := []struct {
+		name          string
+		user          *db.User
+		hotFolder     string
+		expectContent string
+		expectFolder  string
+	}{
+		{name: "relative path", user: admin, hotFolder: "books", expectContent: "must be an absolute path"},
+		{name: "missing directory", user: admin, hotFolder: outside + "/missing", expectContent: notAllowed},
+		{name: "admin anywhere", user: admin, hotFolder: outside + "/", expectContent: "Settings saved", expectFolder: outside},
+		{name: "unchanged for a user", user: reader, hotFolder: outside, expectContent: "Settings saved", expectFolder: outside},
+		{name: "user outside the root", user: reader, hotFolder: "/", expectContent: notAllowed},
+		{name: "user missing directory", user: reader, hotFolder: root + "/missing", expectContent: notAllowed},
+		{name: "user the root itself", user: reader, hotFolder: root, expectContent: notAllowed},
+		{name: "user symlink out of the root", user: reader, hotFolder: root + "/escape", expectContent: notAllowed},
+		{name: "user another account's folder", user: reader, hotFolder: root + "/theirs", expectContent: notAllowed},
+		{name: "user inside the root", user: reader, hotFolder: root + "/mine", expectContent: "Settings saved", expectFolder: filepath.Join(root, "mine")},
+		{name: "signed out", hotFolder: outside, expectContent: notAllowed},
+		{name: "turn off", user: reader, hotFolder: " ", expectContent: "Settings saved"},
+	}
+
+	e := echo.New()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"hot_folder": {tt.hotFolder}}
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("id")
+			c.SetParamValues(fmt.Sprintf("%d", account.ID))
+			if tt.user != nil {
+				c.Set(userKey, tt.user)
+			}
+
+			if err := handlers.UpdateAccountSettings(c); err != nil {
+				t.Fatalf("UpdateAccountSettings() error = %v", err)
+			}
+			testutil.AssertResponseContains(t, rec, tt.expectContent)
+
+			if tt.expectContent == "Settings saved" {
+				updated, _ := dbService.GetAccount(account.ID)
+				if updated.HotFolder != tt.expectFolder {
+					t.Errorf("HotFolder = %q, want %q", updated.HotFolder, tt.expectFolder)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlers_UpdateAccountSettings_DriveInbox(t *testing.T) {
+	testDB := testutil.SetupTestDB(t)
+	if err := testDB.AutoMigrate(&db.Account{}, &db.Job{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	dbService := db.NewService(testDB)
+	handlers := &Handlers{DB: dbService}
+	account, _ := dbService.CreateAccount("test-account", "folder-123")
+	account.InboxFolderID = "old-inbox"
+	account.InboxPageToken = "42"
+	_ = dbService.UpdateAccount(account)
+
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(fmt.Sprintf("%d", account.ID))
+		if err := handlers.UpdateAccountSettings(c); err != nil {
+			t.Fatalf("UpdateAccountSettings() error = %v", err)
+		}
+		return rec
+	}
+
+	rec := post(url.Values{"inbox_folder_id": {"folder-123"}})
+	testutil.AssertResponseContains(t, rec, "must not be the folder books are delivered to")
+
+	rec = post(url.Values{"inbox_folder_id": {"inbox"}, "archive_folder_id": {"inbox"}})
+	testutil.AssertResponseContains(t, rec, "must not be the inbox folder")
+
+	// Keeping the inbox keeps its place in the changes
+	rec = post(url.Values{"inbox_folder_id": {"old-inbox"}, "archive_folder_id": {"archive"}})
+	testutil.AssertResponseContains(t, rec, "Settings saved")
+	updated, _ := dbService.GetAccount(account.ID)
+	if updated.InboxPageToken != "42" || updated.ArchiveFolderID != "archive" {
+		t.Errorf("account = %+v", updated)
+	}
+
+	rec = post(url.Values{"inbox_folder_id": {"new-inbox"}})
+	testutil.AssertResponseContains(t, rec, "Settings saved")
+	updated, _ = dbService.GetAccount(account.ID)
+	if updated.InboxFolderID != "new-inbox" || updated.InboxPageToken != "" {
+		t.Errorf("changing the inbox should start over: %+v", updated)
+	}
+}
+
+func TestHandlers_UpdateAccountSettings_EmailSenders(t *testing.T) {
+	testDB := testutil.SetupTestDB(t)
+	if err := testDB.AutoMigrate(&db.Account{}, &db.Job{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	dbService := db.NewService(testDB)
+	handlers := &Handlers{DB: dbService}
+	account, _ := dbService.CreateAccount("test-account", "folder-123")
+	other, _ := dbService.CreateAccount("other-account", "folder-456")
+	other.EmailSenders = "taken@example.com"
+	_ = dbService.UpdateAccount(other)
+
+	post := func(senders string) *httptest.ResponseRecorder {
+		form := url.Values{"email_senders": {senders}}
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(fmt.Sprintf("%d", account.ID))
+		if err := handlers.UpdateAccountSettings(c); err != nil {
+			t.Fatalf("UpdateAccountSettings() error = %v", err)
+		}
+		return rec
+	}
+
+	rec := post("not an address")
+	testutil.AssertResponseContains(t, rec, "Invalid sender address")
+
+	rec = post("me@example.com\nTaken@Example.com")
+	testutil.AssertResponseContains(t, rec, "already allowed for other-account")
+
+	rec = post(" Me@Example.com, kindle@example.com ")
+	testutil.AssertResponseContains(t, rec, "Settings saved")
+	updated, _ := dbService.GetAccount(account.ID)
+	if updated.EmailSenders != "me@example.com\nkindle@example.com" {
+		t.Errorf("EmailSenders = %q", updated.EmailSenders)
+	}
+}
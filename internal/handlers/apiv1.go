@@ -0,0 +1,468 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"bookify/internal/db"
+	"bookify/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	apiDefaultPageSize = 20
+	apiMaxPageSize     = 100
+)
+
+// apiJob is a job as the v1 API returns it.
+type apiJob struct {
+	ID             string     `json:"id"`
+	AccountID      uint       `json:"account_id"`
+	BatchID        string     `json:"batch_id"`
+	Type           string     `json:"type"`
+	Filename       string     `json:"filename"`
+	Status         string     `json:"status"`
+	Stage          string     `json:"stage"`
+	Progress       int        `json:"progress"`
+	Message        string     `json:"message"`
+	Error          string     `json:"error"`
+	Title          string     `json:"title"`
+	Author         string     `json:"author"`
+	OutputFilename string     `json:"output_filename"`
+	DriveURL       string     `json:"drive_url"`
+	SourceURL      string     `json:"source_url"`
+	HasCover       bool       `json:"has_cover"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	CompletedAt    *time.Time `json:"completed_at"`
+}
+
+func newAPIJob(job db.Job) apiJob {
+	return apiJob{
+		ID:             job.ID,
+		AccountID:      job.AccountID,
+		BatchID:        job.BatchID,
+		Type:           job.Type,
+		Filename:       job.OriginalFilename,
+		Status:         job.Status,
+		Stage:          job.Stage,
+		Progress:       job.Progress,
+		Message:        job.Message,
+		Error:          job.Error,
+		Title:          job.Title,
+		Author:         job.Author,
+		OutputFilename: job.ProcessedFilename,
+		DriveURL:       job.DriveURL,
+		SourceURL:      job.SourceURL,
+		HasCover:       job.HasCover,
+		CreatedAt:      job.CreatedAt,
+		UpdatedAt:      job.UpdatedAt,
+		CompletedAt:    job.CompletedAt,
+	}
+}
+
+// apiAccount is an account as the v1 API returns it, without its
+// credentials.
+type apiAccount struct {
+	ID              uint      `json:"id"`
+	Name            string    `json:"name"`
+	FolderID        string    `json:"folder_id"`
+	UserEmail       string    `json:"user_email"`
+	Connected       bool      `json:"connected"`
+	DeviceProfile   string    `json:"device_profile"`
+	DuplicatePolicy string    `json:"duplicate_policy"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+func newAPIAccount(account db.Account) apiAccount {
+	return apiAccount{
+		ID:              account.ID,
+		Name:            account.Name,
+		FolderID:        account.FolderID,
+		UserEmail:       account.UserEmail,
+		Connected:       account.AccessToken != "",
+		DeviceProfile:   account.DeviceProfile,
+		DuplicatePolicy: account.DuplicatePolicy,
+		CreatedAt:       account.CreatedAt,
+	}
+}
+
+// apiBatch is an upload's batch as the v1 API returns it.
+type apiBatch struct {
+	ID        string           `json:"id"`
+	AccountID uint             `json:"account_id"`
+	Name      string           `json:"name"`
+	Progress  db.BatchProgress `json:"progress"`
+	Done      bool             `json:"done"`
+	Skipped   []db.BatchSkip   `json:"skipped"`
+	CreatedAt time.Time        `json:"created_at"`
+}
+
+func (h *Handlers) newAPIBatch(batch db.Batch) (apiBatch, error) {
+	progress, err := h.DB.GetBatchProgress(batch.ID)
+	if err != nil {
+		return apiBatch{}, err
+	}
+	skipped := batch.SkippedFiles()
+	if skipped == nil {
+		skipped = []db.BatchSkip{}
+	}
+	return apiBatch{
+		ID:        batch.ID,
+		AccountID: batch.AccountID,
+		Name:      batch.Name,
+		Progress:  progress,
+		Done:      progress.Done(),
+		Skipped:   skipped,
+		CreatedAt: batch.CreatedAt,
+	}, nil
+}
+
+type apiPagination struct {
+	Page    int   `json:"page"`
+	PerPage int   `json:"per_page"`
+	Total   int64 `json:"total"`
+}
+
+type apiErrorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// apiError answers with the v1 error envelope.
+func apiError(c echo.Context, status int, message string) error {
+	code := "internal_error"
+	switch status {
+	case http.StatusBadRequest:
+		code = "invalid_request"
+	case http.StatusUnauthorized:
+		code = "unauthorized"
+	case http.StatusForbidden:
+		code = "forbidden"
+	case http.StatusNotFound:
+		code = "not_found"
+	case http.StatusMethodNotAllowed:
+		code = "method_not_allowed"
+	case http.StatusConflict:
+		code = "conflict"
+	case http.StatusUnprocessableEntity:
+		code = "unprocessable"
+	}
+	return c.JSON(status, map[string]apiErrorBody{
+		"error": {Code: code, Message: message},
+	})
+}
+
+func apiData(c echo.Context, status int, data interface{}) error {
+	return c.JSON(status, map[string]interface{}{"data": data})
+}
+
+func apiList(c echo.Context, data interface{}, page apiPagination) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{"data": data, "pagination": page})
+}
+
+// apiErrors turns errors returned by v1 handlers and routing, such as an
+// unknown path, into the error envelope.
+func apiErrors(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err := next(c)
+		if err == nil || c.Response().Committed {
+			return err
+		}
+		var httpErr *echo.HTTPError
+		if errors.As(err, &httpErr) {
+			return apiError(c, httpErr.Code, fmt.Sprint(httpErr.Message))
+		}
+		log.Printf("API error on %s: %v", c.Path(), err)
+		return apiError(c, http.StatusInternalServerError, "Internal error")
+	}
+}
+
+// apiPage reads page and per_page.
+func apiPage(c echo.Context) (apiPagination, error) {
+	page := apiPagination{Page: 1, PerPage: apiDefaultPageSize}
+	if value := c.QueryParam("page"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return page, errors.New("page must be a positive number")
+		}
+		page.Page = n
+	}
+	if value := c.QueryParam("per_page"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > apiMaxPageSize {
+			return page, fmt.Errorf("per_page must be between 1 and %d", apiMaxPageSize)
+		}
+		page.PerPage = n
+	}
+	return page, nil
+}
+
+func apiAccountID(value string) (uint, error) {
+	if value == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, errors.New("account_id must be a number")
+	}
+	return uint(id), nil
+}
+
+func (h *Handlers) APIListJobs(c echo.Context) error {
+	page, err := apiPage(c)
+	if err != nil {
+		return apiError(c, http.StatusBadRequest, err.Error())
+	}
+	accountID, err := apiAccountID(c.QueryParam("account_id"))
+	if err != nil {
+		return apiError(c, http.StatusBadRequest, err.Error())
+	}
+	status := c.QueryParam("status")
+	if status != "" && !slices.Contains(db.JobStatuses, status) {
+		return apiError(c, http.StatusBadRequest, "status must be one of "+strings.Join(db.JobStatuses, ", "))
+	}
+
+	jobs, total, err := h.userDB(c).ListJobs(db.JobFilter{
+		AccountID: accountID,
+		BatchID:   c.QueryParam("batch_id"),
+		Status:    status,
+		Page:      page.Page,
+		PageSize:  page.PerPage,
+	})
+	if err != nil {
+		return err
+	}
+	data := make([]apiJob, 0, len(jobs))
+	for _, job := range jobs {
+		data = append(data, newAPIJob(job))
+	}
+	page.Total = total
+	return apiList(c, data, page)
+}
+
+func (h *Handlers) APIGetJob(c echo.Context) error {
+	job, err := h.userDB(c).GetJob(c.Param("id"))
+	if err != nil {
+		return apiError(c, http.StatusNotFound, "Job not found")
+	}
+	return apiData(c, http.StatusOK, newAPIJob(*job))
+}
+
+// APICancelJob stops a job that hasn't started yet.
+func (h *Handlers) APICancelJob(c echo.Context) error {
+	job, err := h.userDB(c).GetJob(c.Param("id"))
+	if err != nil {
+		return apiError(c, http.StatusNotFound, "Job not found")
+	}
+	cancelled, err := h.userDB(c).CancelJob(job.ID)
+	if err != nil {
+		return err
+	}
+	if !cancelled {
+		return apiError(c, http.StatusConflict, "Only queued jobs can be cancelled; this one is "+job.Status)
+	}
+	if job, err = h.userDB(c).GetJob(job.ID); err != nil {
+		return err
+	}
+	return apiData(c, http.StatusOK, newAPIJob(*job))
+}
+
+// APIRetryJob queues a failed or cancelled job again, as long as its
+// original is still in the temp directory or the store.
+func (h *Handlers) APIRetryJob(c echo.Context) error {
+	job, err := h.userDB(c).GetJob(c.Param("id"))
+	if err != nil {
+		return apiError(c, http.StatusNotFound, "Job not found")
+	}
+	if job.Status != "failed" && job.Status != "cancelled" {
+		return apiError(c, http.StatusConflict, "Only failed or cancelled jobs can be retried; this one is "+job.Status)
+	}
+	if err := h.restoreJobInput(job); err != nil {
+		return apiError(c, http.StatusConflict, err.Error())
+	}
+	requeued, err := h.userDB(c).RequeueJob(job)
+	if err != nil {
+		return err
+	}
+	if !requeued {
+		return apiError(c, http.StatusConflict, "The job changed while it was being retried")
+	}
+	if job, err = h.userDB(c).GetJob(job.ID); err != nil {
+		return err
+	}
+	return apiData(c, http.StatusOK, newAPIJob(*job))
+}
+
+// restoreJobInput makes sure the queue will find the job's original,
+// copying it back from the store if it has left the temp directory.
+func (h *Handlers) restoreJobInput(job *db.Job) error {
+	stored := h.Store != nil && job.SourceHash != "" && h.Store.Has(job.SourceHash)
+	if job.Type == db.JobTypeReprocess {
+		// The queue restores reprocess originals from the store itself
+		if !stored {
+			return errors.New("the original is no longer in the store")
+		}
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(h.tempDir(), job.OriginalFilename)); err == nil {
+		return nil
+	}
+	if !stored {
+		return errors.New("the original upload is no longer available; upload it again")
+	}
+	if err := os.MkdirAll(h.tempDir(), 0755); err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	tempFile, filename, err := services.CreateTempFile(h.tempDir(), job.OriginalFilename)
+	if err != nil {
+		return fmt.Errorf("failed to restore the original: %w", err)
+	}
+	tempPath := tempFile.Name()
+	_ = tempFile.Close() // Only the name is needed; the store writes the file
+	if err := h.Store.CopyTo(job.SourceHash, tempPath); err != nil {
+		_ = os.Remove(tempPath) // Error ignored
+		return fmt.Errorf("failed to restore the original: %w", err)
+	}
+	job.OriginalFilename = filename
+	return nil
+}
+
+func (h *Handlers) APIListAccounts(c echo.Context) error {
+	accounts, err := h.userDB(c).ListAccounts()
+	if err != nil {
+		return err
+	}
+	data := make([]apiAccount, 0, len(accounts))
+	for _, account := range accounts {
+		data = append(data, newAPIAccount(account))
+	}
+	return apiList(c, data, apiPagination{Page: 1, PerPage: len(data), Total: int64(len(data))})
+}
+
+func (h *Handlers) APIGetAccount(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return apiError(c, http.StatusNotFound, "Account not found")
+	}
+	account, err := h.userDB(c).GetAccount(uint(id))
+	if err != nil {
+		return apiError(c, http.StatusNotFound, "Account not found")
+	}
+	return apiData(c, http.StatusOK, newAPIAccount(*account))
+}
+
+func (h *Handlers) APIListBatches(c echo.Context) error {
+	page, err := apiPage(c)
+	if err != nil {
+		return apiError(c, http.StatusBadRequest, err.Error())
+	}
+	accountID, err := apiAccountID(c.QueryParam("account_id"))
+	if err != nil {
+		return apiError(c, http.StatusBadRequest, err.Error())
+	}
+	batches, total, err := h.userDB(c).ListBatches(accountID, page.Page, page.PerPage)
+	if err != nil {
+		return err
+	}
+	data := make([]apiBatch, 0, len(batches))
+	for _, batch := range batches {
+		item, err := h.newAPIBatch(batch)
+		if err != nil {
+			return err
+		}
+		data = append(data, item)
+	}
+	page.Total = total
+	return apiList(c, data, page)
+}
+
+func (h *Handlers) APIGetBatch(c echo.Context) error {
+	batch, err := h.userDB(c).GetBatch(c.Param("id"))
+	if err != nil {
+		return apiError(c, http.StatusNotFound, "Batch not found")
+	}
+	data, err := h.newAPIBatch(*batch)
+	if err != nil {
+		return err
+	}
+	return apiData(c, http.StatusOK, data)
+}
+
+// APIUpload queues uploaded files and links like the upload form, and
+// returns their batch.
+func (h *Handlers) APIUpload(c echo.Context) error {
+	params, err := c.FormParams()
+	if err != nil {
+		return apiError(c, http.StatusBadRequest, "Expected a multipart or URL-encoded form")
+	}
+	if params.Get("account_id") == "" {
+		return apiError(c, http.StatusBadRequest, "account_id is required")
+	}
+	accountID, err := apiAccountID(params.Get("account_id"))
+	if err != nil {
+		return apiError(c, http.StatusBadRequest, err.Error())
+	}
+	account, err := h.userDB(c).GetAccount(accountID)
+	if err != nil {
+		return apiError(c, http.StatusNotFound, "Account not found")
+	}
+
+	var files []*multipart.FileHeader
+	if form := c.Request().MultipartForm; form != nil {
+		files = form.File["files"]
+	}
+	var links []string
+	for _, link := range params["url"] {
+		if link = strings.TrimSpace(link); link != "" {
+			links = append(links, link)
+		}
+	}
+	if len(files) == 0 && len(links) == 0 {
+		return apiError(c, http.StatusBadRequest, "No files or url provided")
+	}
+	if err := os.MkdirAll(h.tempDir(), 0755); err != nil {
+		return err
+	}
+
+	result, err := h.uploadBatch(account, files, links, uploadOptions{
+		Title:          strings.TrimSpace(params.Get("title")),
+		Author:         strings.TrimSpace(params.Get("author")),
+		SkipDuplicates: account.DuplicatePolicy != db.DuplicatePolicyForce && params.Get("force") != "1" && params.Get("force") != "true",
+	})
+	if err != nil {
+		return err
+	}
+	if result.Batch == nil {
+		return apiError(c, http.StatusUnprocessableEntity, result.noJobsMessage())
+	}
+	data, err := h.newAPIBatch(*result.Batch)
+	if err != nil {
+		return err
+	}
+	return apiData(c, http.StatusCreated, data)
+}
+
+// APIOpenAPI serves the OpenAPI document of the v1 API.
+func (h *Handlers) APIOpenAPI(c echo.Context) error {
+	return c.JSON(http.StatusOK, openAPIDocument(h.apiRoutes()))
+}
+
+// RegisterAPIv1 adds the v1 API's routes to a group.
+func (h *Handlers) RegisterAPIv1(g *echo.Group) {
+	g.Use(apiErrors)
+	for _, route := range h.apiRoutes() {
+		g.Add(route.Method, route.Path, route.Handler, h.RequireAPIToken(route.Scope))
+	}
+	g.GET("/openapi.json", h.APIOpenAPI)
+}
@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bookify/internal/db"
+	"bookify/internal/testutil"
+
+	"github.com/labstack/echo/v4"
+)
+
+func setupAPIv1(t *testing.T) (*echo.Echo, *Handlers, *db.Service, *db.Account, string) {
+	t.Helper()
+
+	testDB := testutil.SetupTestDB(t)
+	if err := testDB.AutoMigrate(&db.Account{}, &db.Job{}, &db.Batch{}, &db.APIToken{}, &db.User{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	dbService := db.NewService(testDB)
+	account, _ := dbService.CreateAccount("test-account", "folder-123")
+	user, _ := dbService.CreateUser("admin", "", db.RoleAdmin)
+	token, _, err := dbService.ForUser(user).CreateAPIToken("test", []string{db.ScopeAdmin}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create API token: %v", err)
+	}
+	account.AccessToken = "secret-token"
+	_ = dbService.UpdateAccount(account)
+
+	handlers := &Handlers{DB: dbService, TempDir: t.TempDir()}
+	e := echo.New()
+	handlers.RegisterAPIv1(e.Group("/api/v1"))
+	return e, handlers, dbService, account, token
+}
+
+// apiCall sends a request through the router and decodes the response.
+func apiCall(t *testing.T, e *echo.Echo, token, method, target string, body *strings.Reader, contentType string) (int, map[string]interface{}) {
+	t.Helper()
+
+	var req *http.Request
+	if body == nil {
+		req = httptest.NewRequest(method, target, nil)
+	} else {
+		req = httptest.NewRequest(method, target, body)
+		req.Header.Set(echo.HeaderContentType, contentType)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &decoded); err != nil {
+		t.Fatalf("%s %s: invalid JSON %q", method, target, rec.Body.String())
+	}
+	return rec.Code, decoded
+}
+
+func assertAPIError(t *testing.T, status int, body map[string]interface{}, wantStatus int, wantCode string) {
+	t.Helper()
+
+	errBody, _ := body["error"].(map[string]interface{})
+	if status != wantStatus || errBody["code"] != wantCode || errBody["message"] == "" {
+		t.Errorf("got %d %v, want %d with code %s", status, body, wantStatus, wantCode)
+	}
+}
+
+func TestAPIv1_Jobs(t *testing.T) {
+	e, handlers, dbService, account, token := setupAPIv1(t)
+
+	var jobs []*db.Job
+	for i := 0; i < 5; i++ {
+		job := &db.Job{AccountID: account.ID, OriginalFilename: fmt.Sprintf("book%d.epub", i)}
+		if err := dbService.QueueJob(job); err != nil {
+			t.Fatalf("Failed to queue job: %v", err)
+		}
+		jobs = append(jobs, job)
+	}
+	if err := dbService.MarkJobFailed(jobs[0].ID, "Conversion failed"); err != nil {
+		t.Fatalf("Failed to fail job: %v", err)
+	}
+
+	status, body := apiCall(t, e, token, http.MethodGet, "/api/v1/jobs?per_page=2&page=2", nil, "")
+	data, _ := body["data"].([]interface{})
+	pagination, _ := body["pagination"].(map[string]interface{})
+	if status != http.StatusOK || len(data) != 2 || pagination["total"] != float64(5) || pagination["page"] != float64(2) {
+		t.Errorf("page 2 = %d %v", status, body)
+	}
+	first, _ := data[0].(map[string]interface{})
+	if _, ok := first["account"]; ok || first["filename"] == nil {
+		t.Errorf("job resource = %v", first)
+	}
+
+	status, body = apiCall(t, e, token, http.MethodGet, "/api/v1/jobs?status=failed", nil, "")
+	data, _ = body["data"].([]interface{})
+	if status != http.StatusOK || len(data) != 1 {
+		t.Errorf("failed jobs = %v", body)
+	}
+
+	status, body = apiCall(t, e, token, http.MethodGet, "/api/v1/jobs?status=bogus", nil, "")
+	assertAPIError(t, status, body, http.StatusBadRequest, "invalid_request")
+	status, body = apiCall(t, e, token, http.MethodGet, "/api/v1/jobs?per_page=1000", nil, "")
+	assertAPIError(t, status, body, http.StatusBadRequest, "invalid_request")
+
+	status, body = apiCall(t, e, token, http.MethodGet, "/api/v1/jobs/"+jobs[1].ID, nil, "")
+	job, _ := body["data"].(map[string]interface{})
+	if status != http.StatusOK || job["id"] != jobs[1].ID || job["status"] != "queued" || job["completed_at"] != nil {
+		t.Errorf("job = %d %v", status, body)
+	}
+	status, body = apiCall(t, e, token, http.MethodGet, "/api/v1/jobs/missing", nil, "")
+	assertAPIError(t, status, body, http.StatusNotFound, "not_found")
+
+	// Cancelling
+	status, body = apiCall(t, e, token, http.MethodPost, "/api/v1/jobs/"+jobs[1].ID+"/cancel", nil, "")
+	job, _ = body["data"].(map[string]interface{})
+	if status != http.StatusOK || job["status"] != "cancelled" {
+		t.Errorf("cancel = %d %v", status, body)
+	}
+	status, body = apiCall(t, e, token, http.MethodPost, "/api/v1/jobs/"+jobs[1].ID+"/cancel", nil, "")
+	assertAPIError(t, status, body, http.StatusConflict, "conflict")
+	if claimed, _ := dbService.ClaimJob(jobs[1].ID); claimed {
+		t.Error("the queue claimed a cancelled job")
+	}
+
+	// Retrying needs the original, which is still in the temp directory
+	status, body = apiCall(t, e, token, http.MethodPost, "/api/v1/jobs/"+jobs[0].ID+"/retry", nil, "")
+	assertAPIError(t, status, body, http.StatusConflict, "conflict")
+	if err := os.WriteFile(filepath.Join(handlers.TempDir, "book0.epub"), []byte("PK"), 0644); err != nil {
+		t.Fatalf("Failed to write original: %v", err)
+	}
+	status, body = apiCall(t, e, token, http.MethodPost, "/api/v1/jobs/"+jobs[0].ID+"/retry", nil, "")
+	job, _ = body["data"].(map[string]interface{})
+	if status != http.StatusOK || job["status"] != "queued" || job["error"] != "" {
+		t.Errorf("retry = %d %v", status, body)
+	}
+	status, body = apiCall(t, e, token, http.MethodPost, "/api/v1/jobs/"+jobs[2].ID+"/retry", nil, "")
+	assertAPIError(t, status, body, http.StatusConflict, "conflict")
+
+	// Routing errors use the same envelope
+	status, body = apiCall(t, e, token, http.MethodGet, "/api/v1/nothing-here", nil, "")
+	assertAPIError(t, status, body, http.StatusNotFound, "not_found")
+}
+
+func TestAPIv1_AccountsBatchesUploads(t *testing.T) {
+	e, _, _, account, token := setupAPIv1(t)
+
+	status, body := apiCall(t, e, token, http.MethodGet, "/api/v1/accounts", nil, "")
+	data, _ := body["data"].([]interface{})
+	if status != http.StatusOK || len(data) != 1 {
+		t.Fatalf("accounts = %d %v", status, body)
+	}
+	resource, _ := data[0].(map[string]interface{})
+	if resource["connected"] != true || resource["name"] != "test-account" {
+		t.Errorf("account = %v", resource)
+	}
+	if strings.Contains(fmt.Sprint(body), "secret-token") {
+		t.Error("the account's credentials were returned")
+	}
+	status, body = apiCall(t, e, token, http.MethodGet, fmt.Sprintf("/api/v1/accounts/%d", account.ID+1), nil, "")
+	assertAPIError(t, status, body, http.StatusNotFound, "not_found")
+
+	epub := testutil.CreateValidEPUB(t, "book.epub", nil)
+	req := testutil.CreateMultipartRequest(t, http.MethodPost, "/api/v1/uploads",
+		map[string]string{"files": epub},
+		map[string]string{"account_id": fmt.Sprintf("%d", account.ID)})
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("upload = %d %s", rec.Code, rec.Body.String())
+	}
+	var created struct {
+		Data struct {
+			ID       string           `json:"id"`
+			Name     string           `json:"name"`
+			Progress db.BatchProgress `json:"progress"`
+			Skipped  []db.BatchSkip   `json:"skipped"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatalf("Failed to decode batch: %v", err)
+	}
+	if created.Data.Name != "book.epub" || created.Data.Progress.Queued != 1 || created.Data.Skipped == nil {
+		t.Errorf("batch = %+v", created.Data)
+	}
+
+	status, body = apiCall(t, e, token, http.MethodGet, "/api/v1/batches/"+created.Data.ID, nil, "")
+	if status != http.StatusOK || body["data"].(map[string]interface{})["done"] != false {
+		t.Errorf("batch = %d %v", status, body)
+	}
+	status, body = apiCall(t, e, token, http.MethodGet, fmt.Sprintf("/api/v1/batches?account_id=%d", account.ID), nil, "")
+	if data, _ := body["data"].([]interface{}); status != http.StatusOK || len(data) != 1 {
+		t.Errorf("batches = %d %v", status, body)
+	}
+	status, body = apiCall(t, e, token, http.MethodGet, "/api/v1/jobs?batch_id="+created.Data.ID, nil, "")
+	if data, _ := body["data"].([]interface{}); status != http.StatusOK || len(data) != 1 {
+		t.Errorf("batch jobs = %d %v", status, body)
+	}
+
+	form := url.Values{"account_id": {fmt.Sprintf("%d", account.ID)}, "url": {"ftp://example.com/book.epub"}}
+	status, body = apiCall(t, e, token, http.MethodPost, "/api/v1/uploads", strings.NewReader(form.Encode()), echo.MIMEApplicationForm)
+	assertAPIError(t, status, body, http.StatusUnprocessableEntity, "unprocessable")
+	status, body = apiCall(t, e, token, http.MethodPost, "/api/v1/uploads", strings.NewReader("account_id=1"), echo.MIMEApplicationForm)
+	assertAPIError(t, status, body, http.StatusBadRequest, "invalid_request")
+}
+
+func TestAPIv1_OpenAPI(t *testing.T) {
+	e, handlers, _, _, token := setupAPIv1(t)
+
+	status, spec := apiCall(t, e, token, http.MethodGet, "/api/v1/openapi.json", nil, "")
+	if status != http.StatusOK || spec["openapi"] != "3.0.3" {
+		t.Fatalf("spec = %d %v", status, spec)
+	}
+
+	// Every route is described
+	paths, _ := spec["paths"].(map[string]interface{})
+	for _, route := range handlers.apiRoutes() {
+		path := echoPathParam.ReplaceAllString(route.Path, "{$1}")
+		methods, _ := paths[path].(map[string]interface{})
+		if _, ok := methods[strings.ToLower(route.Method)]; !ok {
+			t.Errorf("%s %s is missing from the spec", route.Method, path)
+		}
+	}
+
+	schemas := spec["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	for _, name := range []string{"Job", "Account", "Batch", "BatchProgress", "BatchSkip", "Pagination", "Error"} {
+		if _, ok := schemas[name]; !ok {
+			t.Errorf("schema %s is missing", name)
+		}
+	}
+	job := schemas["Job"].(map[string]interface{})["properties"].(map[string]interface{})
+	completed, _ := job["completed_at"].(map[string]interface{})
+	if completed["format"] != "date-time" || completed["nullable"] != true {
+		t.Errorf("completed_at = %v", completed)
+	}
+}
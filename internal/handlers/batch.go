@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"net/http"
+
+	"bookify/internal/db"
+	"bookify/internal/templates"
+
+	"github.com/labstack/echo/v4"
+)
+
+// htmx stops polling an element when it gets this status
+const statusStopPolling = 286
+
+func (h *Handlers) batchView(c echo.Context) (*templates.BatchView, error) {
+	batch, err := h.userDB(c).GetBatch(c.Param("id"))
+	if err != nil {
+		return nil, err
+	}
+	progress, err := h.DB.GetBatchProgress(batch.ID)
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := h.userDB(c).ListBatchJobs(batch.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &templates.BatchView{Batch: *batch, Progress: progress, Jobs: jobs}, nil
+}
+
+func (h *Handlers) BatchPage(c echo.Context) error {
+	view, err := h.batchView(c)
+	if err != nil {
+		return c.String(http.StatusNotFound, "Batch not found")
+	}
+	return render(c, templates.BatchPage(*view))
+}
+
+// BatchProgressPartial renders the progress of a batch for the batch page to
+// poll, telling it to stop once every job has finished.
+func (h *Handlers) BatchProgressPartial(c echo.Context) error {
+	view, err := h.batchView(c)
+	if err != nil {
+		return c.String(http.StatusNotFound, "Batch not found")
+	}
+	if view.Progress.Done() {
+		c.Response().WriteHeader(statusStopPolling)
+	}
+	return render(c, templates.BatchProgressPanel(*view))
+}
+
+// BatchStatusAPI returns a batch with its jobs, their progress and the files
+// of the upload that weren't queued.
+func (h *Handlers) BatchStatusAPI(c echo.Context) error {
+	view, err := h.batchView(c)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Batch not found",
+		})
+	}
+	skipped := view.Batch.SkippedFiles()
+	if skipped == nil {
+		skipped = []db.BatchSkip{}
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"batch":    view.Batch,
+		"progress": view.Progress,
+		"done":     view.Progress.Done(),
+		"jobs":     view.Jobs,
+		"skipped":  skipped,
+	})
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"bookify/internal/db"
+	"bookify/internal/testutil"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestBatch(t *testing.T) {
+	testDB := testutil.SetupTestDB(t)
+	if err := testDB.AutoMigrate(&db.Account{}, &db.Job{}, &db.Batch{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	dbService := db.NewService(testDB)
+	handlers := &Handlers{DB: dbService, TempDir: t.TempDir()}
+	account, _ := dbService.CreateAccount("test-account", "folder-123")
+
+	epub, err := os.ReadFile(testutil.CreateValidEPUB(t, "book.epub", nil))
+	if err != nil {
+		t.Fatalf("Failed to read EPUB: %v", err)
+	}
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	_ = writer.WriteField("account_id", fmt.Sprintf("%d", account.ID))
+	for _, file := range []struct{ name, content string }{
+		{"one.epub", string(epub)},
+		{"two.epub", string(epub) + " "},
+		{"fake.epub", "not a zip"},
+		{"scan.pdf", "%PDF-1.4"},
+	} {
+		part, _ := writer.CreateFormFile("files", file.name)
+		_, _ = io.WriteString(part, file.content)
+	}
+	_ = writer.Close()
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+	rec := httptest.NewRecorder()
+	if err := handlers.UploadHandler(e.NewContext(req, rec)); err != nil {
+		t.Fatalf("UploadHandler() error = %v", err)
+	}
+	testutil.AssertResponseContains(t, rec, "Successfully queued 2 files")
+	testutil.AssertResponseContains(t, rec, "Track this upload")
+
+	jobs, _ := dbService.ListRecentJobs(10)
+	if len(jobs) != 2 || jobs[0].BatchID == "" || jobs[0].BatchID != jobs[1].BatchID {
+		t.Fatalf("jobs = %+v", jobs)
+	}
+	batchID := jobs[0].BatchID
+
+	get := func(handler echo.HandlerFunc, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(batchID)
+		if err := handler(c); err != nil {
+			t.Fatalf("%s error = %v", path, err)
+		}
+		return rec
+	}
+
+	// One of the two books fails
+	jobs[0].Status = "failed"
+	if err := dbService.UpdateJob(&jobs[0]); err != nil {
+		t.Fatalf("Failed to update job: %v", err)
+	}
+
+	rec = get(handlers.BatchStatusAPI, "/api/batch/"+batchID)
+	testutil.AssertResponseStatus(t, rec, http.StatusOK)
+	var status struct {
+		Batch    db.Batch         `json:"batch"`
+		Progress db.BatchProgress `json:"progress"`
+		Done     bool             `json:"done"`
+		Jobs     []db.Job         `json:"jobs"`
+		Skipped  []db.BatchSkip   `json:"skipped"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Failed to decode batch: %v", err)
+	}
+	if status.Batch.Name != "one.epub and 3 more" || status.Done || len(status.Jobs) != 2 {
+		t.Errorf("status = %+v", status)
+	}
+	if status.Progress != (db.BatchProgress{Total: 2, Queued: 1, Failed: 1}) {
+		t.Errorf("progress = %+v", status.Progress)
+	}
+	want := []db.BatchSkip{
+		{Name: "fake.epub", Reason: "file is not a valid book"},
+		{Name: "scan.pdf", Reason: "unsupported format"},
+	}
+	if fmt.Sprint(status.Skipped) != fmt.Sprint(want) {
+		t.Errorf("skipped = %+v, want %+v", status.Skipped, want)
+	}
+
+	rec = get(handlers.BatchPage, "/batches/"+batchID)
+	testutil.AssertResponseContains(t, rec, "1 of 2 done, 1 failed")
+	testutil.AssertResponseContains(t, rec, "unsupported format")
+	testutil.AssertResponseContains(t, rec, `hx-get="/batches/`+batchID+`/progress"`)
+
+	rec = get(handlers.BatchProgressPartial, "/batches/"+batchID+"/progress")
+	testutil.AssertResponseStatus(t, rec, http.StatusOK)
+
+	// Polling stops once every job has finished
+	jobs[1].Status = "completed"
+	if err := dbService.UpdateJob(&jobs[1]); err != nil {
+		t.Fatalf("Failed to update job: %v", err)
+	}
+	rec = get(handlers.BatchProgressPartial, "/batches/"+batchID+"/progress")
+	testutil.AssertResponseStatus(t, rec, statusStopPolling)
+	testutil.AssertResponseContains(t, rec, "2 of 2 done, 1 failed")
+
+	batchID = "missing"
+	rec = get(handlers.BatchStatusAPI, "/api/batch/missing")
+	testutil.AssertResponseStatus(t, rec, http.StatusNotFound)
+}
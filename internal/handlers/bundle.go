@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"bookify/internal/db"
+	"bookify/internal/services"
+)
+
+var errNotABundle = errors.New("archive holds no books")
+
+// bundleResult is what became of the books in an uploaded archive.
+type bundleResult struct {
+	Jobs    []*db.Job
+	Skipped []services.BundleSkip
+}
+
+// isZipArchive reports whether an upload is a plain ZIP, which may be a
+// comic or an archive of books.
+func isZipArchive(filename string) bool {
+	lower := strings.ToLower(filename)
+	return filepath.Ext(lower) == ".zip" && !strings.HasSuffix(lower, ".fb2.zip")
+}
+
+// queueBundle expands an uploaded archive of books into one job per book, in
+// the upload's batch. It returns errNotABundle for a ZIP of comic pages.
+func (h *Handlers) queueBundle(account *db.Account, src io.Reader, opts uploadOptions) (*bundleResult, error) {
+	staging, err := os.MkdirTemp(h.tempDir(), ".bundle-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(staging); err != nil {
+			log.Printf("Warning: Failed to remove staging directory: %v", err)
+		}
+	}()
+
+	archivePath := filepath.Join(staging, "archive.zip")
+	dst, err := os.Create(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save archive: %w", err)
+	}
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to save archive: %w", err)
+	}
+	if !services.IsBookBundle(archivePath) {
+		return nil, errNotABundle
+	}
+
+	entries, skipped, err := services.ExtractBundle(archivePath, filepath.Join(staging, "books"), services.DefaultBundleLimits)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &bundleResult{Skipped: skipped}
+	for _, entry := range entries {
+		job, err := h.queueBundleEntry(account, entry, opts)
+		if err != nil {
+			result.Skipped = append(result.Skipped, services.BundleSkip{Name: entry.Name, Reason: err.Error()})
+			continue
+		}
+		result.Jobs = append(result.Jobs, job)
+	}
+	return result, nil
+}
+
+func (h *Handlers) queueBundleEntry(account *db.Account, entry services.BundleEntry, opts uploadOptions) (*db.Job, error) {
+	src, err := os.Open(entry.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = src.Close() // Error ignored in cleanup
+	}()
+	return h.queueUpload(account, entry.Filename(), src, opts)
+}
+
+// summarizeSkips lists the first few skipped entries of an archive.
+func summarizeSkips(skipped []services.BundleSkip) string {
+	const shown = 5
+	var parts []string
+	for i, skip := range skipped {
+		if i == shown {
+			parts = append(parts, fmt.Sprintf("and %d more", len(skipped)-shown))
+			break
+		}
+		parts = append(parts, fmt.Sprintf("%s (%s)", skip.Name, skip.Reason))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"bookify/internal/db"
+	"bookify/internal/testutil"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestUploadHandler_Bundle(t *testing.T) {
+	testDB := testutil.SetupTestDB(t)
+	if err := testDB.AutoMigrate(&db.Account{}, &db.Job{}, &db.Batch{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	dbService := db.NewService(testDB)
+	handlers := &Handlers{DB: dbService, TempDir: t.TempDir()}
+	account, _ := dbService.CreateAccount("test-account", "folder-123")
+
+	epub, err := os.ReadFile(testutil.CreateValidEPUB(t, "book.epub", nil))
+	if err != nil {
+		t.Fatalf("Failed to read EPUB: %v", err)
+	}
+	archive := testutil.CreateEPUBArchive(t, "library.zip", map[string]string{
+		"Austen/Emma.epub":         string(epub),
+		"Austen/Persuasion.epub":   string(epub) + " ",
+		"Other Emma/Emma.epub":     string(epub) + "  ",
+		"Austen/Broken.epub":       "not a zip",
+		"../../etc/cron.d/x.epub":  string(epub),
+		"Austen/reading-notes.txt": "notes",
+	})
+
+	upload := func(path string) *httptest.ResponseRecorder {
+		req := testutil.CreateMultipartRequest(t, http.MethodPost, "/upload",
+			map[string]string{"files": path},
+			map[string]string{"account_id": fmt.Sprintf("%d", account.ID)})
+		rec := httptest.NewRecorder()
+		if err := handlers.UploadHandler(echo.New().NewContext(req, rec)); err != nil {
+			t.Fatalf("UploadHandler() error = %v", err)
+		}
+		return rec
+	}
+
+	rec := upload(archive)
+	testutil.AssertResponseContains(t, rec, "Successfully queued 3 files")
+	testutil.AssertResponseContains(t, rec, "left out 3 file(s) of library.zip")
+	testutil.AssertResponseContains(t, rec, "path leaves the archive")
+
+	jobs, _ := dbService.ListRecentJobs(10)
+	var names []string
+	for _, job := range jobs {
+		names = append(names, job.OriginalFilename)
+		if job.BatchID == "" || job.BatchID != jobs[0].BatchID {
+			t.Errorf("job %s has batch %q", job.OriginalFilename, job.BatchID)
+		}
+		if _, err := os.Stat(handlers.TempDir + "/" + job.OriginalFilename); err != nil {
+			t.Errorf("%s not in the temp directory: %v", job.OriginalFilename, err)
+		}
+	}
+	sort.Strings(names)
+	if strings.Join(names, "|") != "Emma (2).epub|Emma.epub|Persuasion.epub" {
+		t.Errorf("queued %v", names)
+	}
+
+	batch, err := dbService.GetBatch(jobs[0].BatchID)
+	if err != nil || batch.Name != "library.zip" || batch.AccountID != account.ID {
+		t.Fatalf("batch = %+v, %v", batch, err)
+	}
+	if skipped := batch.SkippedFiles(); len(skipped) != 3 {
+		t.Errorf("batch skipped %+v", skipped)
+	}
+	progress, err := dbService.GetBatchProgress(batch.ID)
+	if err != nil || progress.Total != 3 || progress.Queued != 3 || progress.Done() {
+		t.Errorf("progress = %+v, %v", progress, err)
+	}
+
+	// Only the queued books are left; the staging directory is gone
+	if entries, _ := os.ReadDir(handlers.TempDir); len(entries) != 3 {
+		t.Errorf("temp directory has %d entries, want 3", len(entries))
+	}
+
+	// A ZIP of pages is still a comic
+	comic := testutil.CreateEPUBArchive(t, "comic.zip", map[string]string{
+		"001.jpg": "\xFF\xD8\xFF",
+		"002.jpg": "\xFF\xD8\xFF",
+	})
+	rec = upload(comic)
+	testutil.AssertResponseContains(t, rec, "Successfully queued 1 files")
+	jobs, _ = dbService.ListRecentJobs(10)
+	if len(jobs) != 4 || jobs[0].OriginalFilename != "comic.zip" || jobs[0].BatchID == batch.ID {
+		t.Errorf("comic job = %+v", jobs[0])
+	}
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"bookify/internal/services"
+	"bookify/internal/templates"
+
+	"github.com/labstack/echo/v4"
+)
+
+func (h *Handlers) CalibrePage(c echo.Context) error {
+	filter := services.CalibreFilter{
+		Tag:    c.QueryParam("tag"),
+		Series: c.QueryParam("series"),
+		Shelf:  c.QueryParam("shelf"),
+	}
+	return h.renderCalibre(c, filter, "", "")
+}
+
+func (h *Handlers) renderCalibre(c echo.Context, filter services.CalibreFilter, message, errorMsg string) error {
+	accounts, err := h.userDB(c).ListAccounts()
+	if err != nil {
+		return err
+	}
+	view := templates.CalibreView{
+		Dir:      h.CalibreLibrary,
+		Accounts: accounts,
+		Filter:   filter,
+		Message:  message,
+		Error:    errorMsg,
+	}
+	if h.CalibreLibrary == "" {
+		return render(c, templates.CalibrePage(view))
+	}
+
+	library, err := services.OpenCalibreLibrary(h.CalibreLibrary)
+	if err != nil {
+		view.Error = err.Error()
+		return render(c, templates.CalibrePage(view))
+	}
+	defer func() {
+		if err := library.Close(); err != nil {
+			log.Printf("Warning: Failed to close Calibre library: %v", err)
+		}
+	}()
+
+	books, err := library.Books()
+	if err != nil {
+		view.Error = err.Error()
+		return render(c, templates.CalibrePage(view))
+	}
+	view.Tags, view.Series, view.Shelves = services.CalibreChoices(books)
+	for _, book := range books {
+		if filter.Matches(&book) {
+			view.Books = append(view.Books, book)
+		}
+	}
+	return render(c, templates.CalibrePage(view))
+}
+
+// ImportCalibreBooks queues the ticked books of the Calibre library for an
+// account, with their Calibre metadata.
+func (h *Handlers) ImportCalibreBooks(c echo.Context) error {
+	filter := services.CalibreFilter{
+		Tag:    c.FormValue("tag"),
+		Series: c.FormValue("series"),
+		Shelf:  c.FormValue("shelf"),
+	}
+	if h.CalibreLibrary == "" {
+		return h.renderCalibre(c, filter, "", "No Calibre library is configured")
+	}
+
+	accountID, err := strconv.ParseUint(c.FormValue("account_id"), 10, 32)
+	if err != nil {
+		return h.renderCalibre(c, filter, "", "Choose an account to import into")
+	}
+	account, err := h.userDB(c).GetAccount(uint(accountID))
+	if err != nil {
+		return h.renderCalibre(c, filter, "", "Account not found")
+	}
+
+	form, err := c.FormParams()
+	if err != nil {
+		return h.renderCalibre(c, filter, "", "Invalid form")
+	}
+	chosen := make(map[int]bool)
+	for _, value := range form["book"] {
+		if id, err := strconv.Atoi(value); err == nil {
+			chosen[id] = true
+		}
+	}
+	if len(chosen) == 0 {
+		return h.renderCalibre(c, filter, "", "No books selected")
+	}
+
+	library, err := services.OpenCalibreLibrary(h.CalibreLibrary)
+	if err != nil {
+		return h.renderCalibre(c, filter, "", err.Error())
+	}
+	defer func() {
+		if err := library.Close(); err != nil {
+			log.Printf("Warning: Failed to close Calibre library: %v", err)
+		}
+	}()
+	books, err := library.Books()
+	if err != nil {
+		return h.renderCalibre(c, filter, "", err.Error())
+	}
+	var selected []services.CalibreBook
+	for _, book := range books {
+		if chosen[book.ID] {
+			selected = append(selected, book)
+		}
+	}
+
+	queued, skipped, err := services.QueueCalibreBooks(h.DB, h.TempDir, account, library, selected)
+	if err != nil {
+		return h.renderCalibre(c, filter, "", fmt.Sprintf("Import stopped after %d book(s): %v", queued, err))
+	}
+	message := fmt.Sprintf("Queued %d book(s) for %s", queued, account.Name)
+	if skipped > 0 {
+		message += fmt.Sprintf("; skipped %d duplicate(s) or book(s) without an EPUB, FB2 or comic file", skipped)
+	}
+	return h.renderCalibre(c, filter, message, "")
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"bookify/internal/db"
+	"bookify/internal/testutil"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestHandlers_CalibrePage(t *testing.T) {
+	testDB := testutil.SetupTestDB(t)
+	if err := testDB.AutoMigrate(&db.Account{}, &db.Job{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	dbService := db.NewService(testDB)
+
+	get := func(handlers *Handlers, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/calibre?"+query, nil)
+		rec := httptest.NewRecorder()
+		if err := handlers.CalibrePage(echo.New().NewContext(req, rec)); err != nil {
+			t.Fatalf("CalibrePage() error = %v", err)
+		}
+		return rec
+	}
+
+	rec := get(&Handlers{DB: dbService}, "")
+	testutil.AssertResponseContains(t, rec, "CALIBRE_LIBRARY")
+
+	handlers := &Handlers{DB: dbService, CalibreLibrary: testutil.CreateCalibreLibrary(t)}
+	rec = get(handlers, "")
+	testutil.AssertResponseContains(t, rec, "3 books")
+	testutil.AssertResponseContains(t, rec, "No EPUB, FB2 or comic file")
+
+	rec = get(handlers, "shelf="+url.QueryEscape("To read"))
+	testutil.AssertResponseContains(t, rec, "1 books")
+	testutil.AssertResponseContains(t, rec, "The Obelisk Gate")
+	if strings.Contains(rec.Body.String(), "The Fifth Season</") {
+		t.Error("shelf filter should leave out The Fifth Season")
+	}
+}
+
+func TestHandlers_ImportCalibreBooks(t *testing.T) {
+	testDB := testutil.SetupTestDB(t)
+	if err := testDB.AutoMigrate(&db.Account{}, &db.Job{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	dbService := db.NewService(testDB)
+	account, _ := dbService.CreateAccount("test-account", "folder-123")
+	handlers := &Handlers{DB: dbService, TempDir: t.TempDir(), CalibreLibrary: testutil.CreateCalibreLibrary(t)}
+
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/calibre/import", strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		rec := httptest.NewRecorder()
+		if err := handlers.ImportCalibreBooks(echo.New().NewContext(req, rec)); err != nil {
+			t.Fatalf("ImportCalibreBooks() error = %v", err)
+		}
+		return rec
+	}
+	accountID := fmt.Sprintf("%d", account.ID)
+
+	rec := post(url.Values{"account_id": {accountID}})
+	testutil.AssertResponseContains(t, rec, "No books selected")
+
+	rec = post(url.Values{"account_id": {accountID}, "book": {"2"}})
+	testutil.AssertResponseContains(t, rec, "Queued 1 book(s) for test-account")
+
+	jobs, _ := dbService.ListRecentJobs(10)
+	if len(jobs) != 1 || jobs[0].Title != "The Obelisk Gate" || jobs[0].Rating != 7 {
+		t.Errorf("jobs = %+v", jobs)
+	}
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"bookify/internal/db"
+	"bookify/internal/services"
+	"bookify/internal/testutil"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestHandlers_JobCoverAPI(t *testing.T) {
+	testDB := testutil.SetupTestDB(t)
+	err := testDB.AutoMigrate(&db.Account{}, &db.Job{})
+	if err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+
+	dbService := db.NewService(testDB)
+	covers := services.NewCoverService(t.TempDir())
+
+	handlers := &Handlers{
+		DB:     dbService,
+		Covers: covers,
+	}
+
+	account, err := dbService.CreateAccount("test-account", "folder-123")
+	if err != nil {
+		t.Fatalf("Failed to create test account: %v", err)
+	}
+
+	withCover, err := dbService.CreateJob(account.ID, "covered.epub")
+	if err != nil {
+		t.Fatalf("Failed to create job: %v", err)
+	}
+	withCover.HasCover = true
+	if err := dbService.UpdateJob(withCover); err != nil {
+		t.Fatalf("Failed to update job: %v", err)
+	}
+	thumbPath := covers.ThumbnailPath(withCover.ID)
+	if err := os.MkdirAll(filepath.Dir(thumbPath), 0755); err != nil {
+		t.Fatalf("Failed to create cover directory: %v", err)
+	}
+	if err := os.WriteFile(thumbPath, []byte("\xff\xd8\xff\xe0fake-jpeg"), 0644); err != nil {
+		t.Fatalf("Failed to write thumbnail: %v", err)
+	}
+
+	withoutCover, err := dbService.CreateJob(account.ID, "plain.epub")
+	if err != nil {
+		t.Fatalf("Failed to create job: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		jobID          string
+		expectedStatus int
+	}{
+		{name: "job with cover", jobID: withCover.ID, expectedStatus: http.StatusOK},
+		{name: "job without cover", jobID: withoutCover.ID, expectedStatus: http.StatusNotFound},
+		{name: "unknown job", jobID: "does-not-exist", expectedStatus: http.StatusNotFound},
+	}
+
+	e := echo.New()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/job/"+tt.jobID+"/cover", nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("id")
+			c.SetParamValues(tt.jobID)
+
+			if err := handlers.JobCoverAPI(c); err != nil {
+				t.Fatalf("JobCoverAPI() error = %v", err)
+			}
+
+			testutil.AssertResponseStatus(t, rec, tt.expectedStatus)
+			// Covers belong to a user's jobs, so shared caches mustn't keep them
+			if tt.expectedStatus == http.StatusOK && rec.Header().Get("Cache-Control") != "private, max-age=86400" {
+				t.Errorf("Cache-Control = %q", rec.Header().Get("Cache-Control"))
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+
+	"github.com/labstack/echo/v4"
+)
+
+// JobOriginalAPI downloads the file uploaded for a job, if it was retained.
+func (h *Handlers) JobOriginalAPI(c echo.Context) error {
+	job, err := h.userDB(c).GetJob(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Job not found",
+		})
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(job.OriginalFilename))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return h.serveStoredFile(c, job.SourceHash, job.OriginalFilename, contentType)
+}
+
+// JobOutputAPI downloads the KEPUB a job produced, if it was retained.
+func (h *Handlers) JobOutputAPI(c echo.Context) error {
+	job, err := h.userDB(c).GetJob(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Job not found",
+		})
+	}
+	return h.serveStoredFile(c, job.OutputHash, job.ProcessedFilename, epubType)
+}
+
+func (h *Handlers) serveStoredFile(c echo.Context, hash, filename, contentType string) error {
+	if h.Store == nil || !h.Store.Has(hash) {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "File not retained",
+		})
+	}
+
+	file, err := h.Store.Open(hash)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "File not retained",
+		})
+	}
+	defer func() {
+		if closeErr := file.Close(); closeErr != nil {
+			log.Printf("Warning: Failed to close stored file: %v", closeErr)
+		}
+	}()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	c.Response().Header().Set(echo.HeaderContentType, contentType)
+	c.Response().Header().Set(echo.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	http.ServeContent(c.Response(), c.Request(), filename, info.ModTime(), file)
+	return nil
+}
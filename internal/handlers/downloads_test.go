@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bookify/internal/db"
+	"bookify/internal/services"
+	"bookify/internal/testutil"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestHandlers_JobDownloads(t *testing.T) {
+	testDB := testutil.SetupTestDB(t)
+	err := testDB.AutoMigrate(&db.Account{}, &db.Job{}, &db.StoredFile{})
+	if err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	dbService := db.NewService(testDB)
+	store := services.NewStoreService(dbService, filepath.Join(t.TempDir(), "store"), services.StoreOptions{})
+
+	dir := t.TempDir()
+	original := filepath.Join(dir, "original.epub")
+	output := filepath.Join(dir, "output.epub")
+	_ = os.WriteFile(original, []byte("original content"), 0644)
+	_ = os.WriteFile(output, []byte("converted content"), 0644)
+	sourceHash, _ := store.Put(original)
+	outputHash, _ := store.Put(output)
+
+	account, _ := dbService.CreateAccount("test-account", "folder-123")
+	job := &db.Job{
+		AccountID:         account.ID,
+		OriginalFilename:  "My Book.epub",
+		ProcessedFilename: "My Book.kepub.epub",
+		SourceHash:        sourceHash,
+		OutputHash:        outputHash,
+		Retained:          true,
+	}
+	if err := dbService.QueueJob(job); err != nil {
+		t.Fatalf("Failed to create job: %v", err)
+	}
+	unretained := &db.Job{AccountID: account.ID, OriginalFilename: "gone.epub"}
+	if err := dbService.QueueJob(unretained); err != nil {
+		t.Fatalf("Failed to create job: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		handlers    *Handlers
+		jobID       string
+		output      bool
+		status      int
+		body        string
+		disposition string
+	}{
+		{name: "original", handlers: &Handlers{DB: dbService, Store: store}, jobID: job.ID, status: http.StatusOK, body: "original content", disposition: `filename="My Book.epub"`},
+		{name: "output", handlers: &Handlers{DB: dbService, Store: store}, jobID: job.ID, output: true, status: http.StatusOK, body: "converted content", disposition: `filename="My Book.kepub.epub"`},
+		{name: "not retained", handlers: &Handlers{DB: dbService, Store: store}, jobID: unretained.ID, status: http.StatusNotFound},
+		{name: "store disabled", handlers: &Handlers{DB: dbService}, jobID: job.ID, status: http.StatusNotFound},
+		{name: "unknown job", handlers: &Handlers{DB: dbService, Store: store}, jobID: "missing", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("id")
+			c.SetParamValues(tt.jobID)
+
+			handler := tt.handlers.JobOriginalAPI
+			if tt.output {
+				handler = tt.handlers.JobOutputAPI
+			}
+			if err := handler(c); err != nil {
+				t.Fatalf("handler error = %v", err)
+			}
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.body != "" && rec.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.body)
+			}
+			if tt.disposition != "" && !strings.Contains(rec.Header().Get(echo.HeaderContentDisposition), tt.disposition) {
+				t.Errorf("Content-Disposition = %q, want %s", rec.Header().Get(echo.HeaderContentDisposition), tt.disposition)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"bookify/internal/db"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// A Kobo whose api_endpoint points at /kobo/<token> talks to these handlers
+// instead of the Kobo store. Only the parts of the store API needed to sync
+// books are implemented; everything else gets an empty answer.
+
+const (
+	koboDeviceKey     = "koboDevice"
+	koboSyncBatchSize = 100
+	koboTimeFormat    = "2006-01-02T15:04:05Z"
+
+	// Kobo's catch-all category and genre
+	koboDefaultCategory = "00000000-0000-0000-0000-000000000001"
+)
+
+// KoboAuth finds the device from the token in the URL.
+func (h *Handlers) KoboAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		device, err := h.DB.GetKoboDeviceByToken(c.Param("token"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Unknown device")
+		}
+		c.Set(koboDeviceKey, device)
+		return next(c)
+	}
+}
+
+func (h *Handlers) KoboInitialization(c echo.Context) error {
+	base := koboBaseURL(c)
+	c.Response().Header().Set("x-kobo-apitoken", "e30=")
+	return c.JSON(http.StatusOK, map[string]any{
+		"Resources": map[string]any{
+			"image_host":                 c.Scheme() + "://" + c.Request().Host,
+			"image_url_template":         base + "/{ImageId}/{Width}/{Height}/false/image.jpg",
+			"image_url_quality_template": base + "/{ImageId}/{Width}/{Height}/{Quality}/{IsGreyscale}/image.jpg",
+			"library_sync":               base + "/v1/library/sync",
+			"library_items":              base + "/v1/library",
+			"library_metadata":           base + "/v1/library/{Ids}/metadata",
+		},
+	})
+}
+
+type koboSyncItem struct {
+	NewEntitlement     *koboEntitlement `json:"NewEntitlement,omitempty"`
+	ChangedEntitlement *koboEntitlement `json:"ChangedEntitlement,omitempty"`
+}
+
+type koboEntitlement struct {
+	BookEntitlement map[string]any `json:"BookEntitlement"`
+	BookMetadata    map[string]any `json:"BookMetadata"`
+}
+
+// KoboLibrarySync sends the books the device doesn't have yet, and those
+// reprocessed since it got them, in batches.
+// The device asks again while the response says to continue.
+func (h *Handlers) KoboLibrarySync(c echo.Context) error {
+	device := c.Get(koboDeviceKey).(*db.KoboDevice)
+
+	books, more, err := h.DB.ListUnsyncedBooks(device, koboSyncBatchSize)
+	if err != nil {
+		return err
+	}
+
+	synced, err := h.DB.SyncedBookIDs(device, books)
+	if err != nil {
+		return err
+	}
+
+	base := koboBaseURL(c)
+	items := []koboSyncItem{}
+	for _, book := range books {
+		entitlement := &koboEntitlement{
+			BookEntitlement: koboBookEntitlement(book),
+			BookMetadata:    koboBookMetadata(base, book),
+		}
+		if synced[book.ID] {
+			items = append(items, koboSyncItem{ChangedEntitlement: entitlement})
+		} else {
+			items = append(items, koboSyncItem{NewEntitlement: entitlement})
+		}
+	}
+
+	if err := h.DB.MarkBooksSynced(device, books); err != nil {
+		return err
+	}
+	if len(books) > 0 {
+		log.Printf("Kobo device %q synced %d books", device.Name, len(books))
+	}
+
+	// Which books were sent is tracked here, so the sync token only has to
+	// be something the device can hand back
+	syncToken := c.Request().Header.Get("x-kobo-synctoken")
+	if syncToken == "" {
+		syncToken = "e30="
+	}
+	c.Response().Header().Set("x-kobo-synctoken", syncToken)
+	if more {
+		c.Response().Header().Set("x-kobo-sync", "continue")
+	}
+	return c.JSON(http.StatusOK, items)
+}
+
+func (h *Handlers) KoboBookMetadata(c echo.Context) error {
+	device := c.Get(koboDeviceKey).(*db.KoboDevice)
+	book, err := h.koboBook(device, c.Param("uuid"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, []map[string]any{koboBookMetadata(koboBaseURL(c), *book)})
+}
+
+func (h *Handlers) KoboDownload(c echo.Context) error {
+	device := c.Get(koboDeviceKey).(*db.KoboDevice)
+	book, err := h.koboBook(device, c.Param("uuid"))
+	if err != nil {
+		return err
+	}
+	return h.serveBookFile(c, &device.Account, book)
+}
+
+func (h *Handlers) KoboCover(c echo.Context) error {
+	device := c.Get(koboDeviceKey).(*db.KoboDevice)
+	book, err := h.koboBook(device, c.Param("uuid"))
+	if err != nil {
+		return err
+	}
+	if h.Covers == nil || !book.HasCover || !h.Covers.HasThumbnail(book.JobID) {
+		return echo.NewHTTPError(http.StatusNotFound, "Cover not found")
+	}
+	return c.File(h.Covers.ThumbnailPath(book.JobID))
+}
+
+// KoboFallback answers the store endpoints Bookify doesn't implement, such
+// as reading state and recommendations, so the device carries on syncing.
+func (h *Handlers) KoboFallback(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]any{})
+}
+
+// koboBook looks up a book by UUID, hiding other accounts' books.
+func (h *Handlers) koboBook(device *db.KoboDevice, id string) (*db.Book, error) {
+	book, err := h.DB.GetBookByUUID(id)
+	if err != nil || book.AccountID != device.AccountID {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Book not found")
+	}
+	return book, nil
+}
+
+func koboBaseURL(c echo.Context) string {
+	return c.Scheme() + "://" + c.Request().Host + "/kobo/" + c.Param("token")
+}
+
+func koboBookEntitlement(book db.Book) map[string]any {
+	delivered := book.DeliveredAt.UTC().Format(koboTimeFormat)
+	return map[string]any{
+		"Accessibility":       "Full",
+		"ActivePeriod":        map[string]any{"From": delivered},
+		"Created":             delivered,
+		"CrossRevisionId":     book.UUID,
+		"Id":                  book.UUID,
+		"IsHiddenFromArchive": false,
+		"IsLocked":            false,
+		"IsRemoved":           false,
+		"LastModified":        book.UpdatedAt.UTC().Format(koboTimeFormat),
+		"OriginCategory":      "Imported",
+		"RevisionId":          book.UUID,
+		"Status":              "Active",
+	}
+}
+
+func koboBookMetadata(base string, book db.Book) map[string]any {
+	language := book.Language
+	if language == "" {
+		language = "en"
+	}
+	metadata := map[string]any{
+		"Categories":              []string{koboDefaultCategory},
+		"CoverImageId":            book.UUID,
+		"CrossRevisionId":         book.UUID,
+		"CurrentDisplayPrice":     map[string]any{"CurrencyCode": "USD", "TotalAmount": 0},
+		"CurrentLoveDisplayPrice": map[string]any{"TotalAmount": 0},
+		"Description":             book.Description,
+		"DownloadUrls": []map[string]any{{
+			"Format":   "KEPUB",
+			"Platform": "Generic",
+			"Size":     book.FileSize,
+			"Url":      base + "/download/" + book.UUID,
+		}},
+		"EntitlementId":          book.UUID,
+		"ExternalIds":            []string{},
+		"Genre":                  koboDefaultCategory,
+		"IsEligibleForKoboLove":  false,
+		"IsInternetArchive":      false,
+		"IsPreOrder":             false,
+		"IsSocialEnabled":        true,
+		"Language":               language,
+		"PhoneticPronunciations": map[string]any{},
+		"PublicationDate":        book.DeliveredAt.UTC().Format(koboTimeFormat),
+		"Publisher":              map[string]any{"Imprint": "", "Name": book.Publisher},
+		"RevisionId":             book.UUID,
+		"Title":                  book.Title,
+		"WorkId":                 book.UUID,
+	}
+	if book.Author != "" {
+		metadata["Contributors"] = []string{book.Author}
+		metadata["ContributorRoles"] = []map[string]any{{"Name": book.Author}}
+	}
+	if book.Series != "" {
+		metadata["Series"] = map[string]any{
+			"Name":        book.Series,
+			"Number":      int(book.SeriesIndex),
+			"NumberFloat": book.SeriesIndex,
+			// The same series gets the same ID on every sync
+			"Id": uuid.NewSHA1(uuid.NameSpaceURL, []byte("bookify:series:"+book.Series)).String(),
+		}
+	}
+	return metadata
+}
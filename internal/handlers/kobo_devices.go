@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"bookify/internal/db"
+	"bookify/internal/templates"
+
+	"github.com/labstack/echo/v4"
+)
+
+func (h *Handlers) KoboDevicesPage(c echo.Context) error {
+	account, err := h.accountFromParam(c)
+	if err != nil {
+		return c.Redirect(http.StatusFound, "/accounts")
+	}
+	return h.renderKoboDevices(c, account, "", "")
+}
+
+func (h *Handlers) CreateKoboDevice(c echo.Context) error {
+	account, err := h.accountFromParam(c)
+	if err != nil {
+		return c.Redirect(http.StatusFound, "/accounts")
+	}
+
+	name := strings.TrimSpace(c.FormValue("name"))
+	if name == "" {
+		return h.renderKoboDevices(c, account, "", "Device name is required")
+	}
+	if _, err := h.userDB(c).CreateKoboDevice(account.ID, name); err != nil {
+		return h.renderKoboDevices(c, account, "", "Failed to add device")
+	}
+	return h.renderKoboDevices(c, account, fmt.Sprintf("Added %s", name), "")
+}
+
+func (h *Handlers) DeleteKoboDevice(c echo.Context) error {
+	account, device, err := h.koboDeviceFromParam(c)
+	if err != nil {
+		return c.Redirect(http.StatusFound, "/accounts")
+	}
+	if err := h.userDB(c).DeleteKoboDevice(device.ID); err != nil {
+		return h.renderKoboDevices(c, account, "", "Failed to remove device")
+	}
+	return h.renderKoboDevices(c, account, fmt.Sprintf("Removed %s", device.Name), "")
+}
+
+func (h *Handlers) ResetKoboDevice(c echo.Context) error {
+	account, device, err := h.koboDeviceFromParam(c)
+	if err != nil {
+		return c.Redirect(http.StatusFound, "/accounts")
+	}
+	if err := h.userDB(c).ResetKoboSync(device.ID); err != nil {
+		return h.renderKoboDevices(c, account, "", "Failed to reset sync")
+	}
+	return h.renderKoboDevices(c, account, fmt.Sprintf("%s will get every book again on its next sync", device.Name), "")
+}
+
+func (h *Handlers) renderKoboDevices(c echo.Context, account *db.Account, message, errorMsg string) error {
+	devices, err := h.userDB(c).ListKoboDevices(account.ID)
+	if err != nil {
+		return err
+	}
+	baseURL := c.Scheme() + "://" + c.Request().Host + "/kobo/"
+	return render(c, templates.KoboDevicesPage(*account, devices, baseURL, message, errorMsg))
+}
+
+func (h *Handlers) accountFromParam(c echo.Context) (*db.Account, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	return h.userDB(c).GetAccount(uint(id))
+}
+
+func (h *Handlers) koboDeviceFromParam(c echo.Context) (*db.Account, *db.KoboDevice, error) {
+	account, err := h.accountFromParam(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	id, err := strconv.ParseUint(c.Param("device"), 10, 32)
+	if err != nil {
+		return nil, nil, err
+	}
+	device, err := h.userDB(c).GetKoboDevice(uint(id))
+	if err != nil {
+		return nil, nil, err
+	}
+	if device.AccountID != account.ID {
+		return nil, nil, fmt.Errorf("device %d belongs to another account", device.ID)
+	}
+	return account, device, nil
+}
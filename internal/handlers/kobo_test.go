@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"bookify/internal/db"
+	"bookify/internal/testutil"
+
+	"github.com/labstack/echo/v4"
+)
+
+var contentLengthHeader = regexp.MustCompile(`(?m)^Content-Length: \d+\r$`)
+
+// koboFixture reads a request recorded from a Kobo, filling in the device
+// token and book UUID.
+func koboFixture(t *testing.T, name, token, bookUUID string) *http.Request {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", "kobo", name))
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+	raw := strings.NewReplacer("{{token}}", token, "{{uuid}}", bookUUID).Replace(string(data))
+	if head, body, ok := strings.Cut(raw, "\r\n\r\n"); ok && body != "" {
+		head = contentLengthHeader.ReplaceAllString(head, "Content-Length: "+strconv.Itoa(len(body))+"\r")
+		raw = head + "\r\n\r\n" + body
+	}
+
+	req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(raw)))
+	if err != nil {
+		t.Fatalf("Failed to parse fixture %s: %v", name, err)
+	}
+	req.RequestURI = ""
+	return req
+}
+
+func setupKobo(t *testing.T) (*echo.Echo, *db.Service, *db.KoboDevice, []db.Book) {
+	t.Helper()
+
+	testDB := testutil.SetupTestDB(t)
+	err := testDB.AutoMigrate(&db.Account{}, &db.Job{}, &db.Book{}, &db.KoboDevice{}, &db.KoboSyncedBook{})
+	if err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	dbService := db.NewService(testDB)
+	handlers := &Handlers{DB: dbService}
+
+	home, _ := dbService.CreateAccount("Home", "folder1")
+	work, _ := dbService.CreateAccount("Work", "folder2")
+	device, err := dbService.CreateKoboDevice(home.ID, "Libra Colour")
+	if err != nil {
+		t.Fatalf("CreateKoboDevice() failed: %v", err)
+	}
+
+	day := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	books := []db.Book{
+		{AccountID: home.ID, Title: "Dune", Author: "Frank Herbert", Series: "Dune", SeriesIndex: 1, FileSize: 1234, DriveFileID: "file-1", DeliveredAt: day},
+		{AccountID: home.ID, Title: "Emma", Author: "Jane Austen", Language: "en-GB", DeliveredAt: day.AddDate(0, 0, 1)},
+		{AccountID: work.ID, Title: "Work Manual", DeliveredAt: day.AddDate(0, 0, 2)},
+	}
+	for i := range books {
+		books[i].JobID = fmt.Sprintf("job-%d", i)
+		if err := dbService.CreateBook(&books[i]); err != nil {
+			t.Fatalf("CreateBook() failed: %v", err)
+		}
+	}
+
+	e := echo.New()
+	kobo := e.Group("/kobo/:token", handlers.KoboAuth)
+	kobo.GET("/v1/initialization", handlers.KoboInitialization)
+	kobo.GET("/v1/library/sync", handlers.KoboLibrarySync)
+	kobo.GET("/v1/library/:uuid/metadata", handlers.KoboBookMetadata)
+	kobo.GET("/download/:uuid", handlers.KoboDownload)
+	kobo.GET("/:uuid/:width/:height/false/image.jpg", handlers.KoboCover)
+	kobo.Any("/*", handlers.KoboFallback)
+	return e, dbService, device, books
+}
+
+func serveKobo(e *echo.Echo, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandlers_KoboInitialization(t *testing.T) {
+	e, _, device, _ := setupKobo(t)
+
+	rec := serveKobo(e, koboFixture(t, "initialization.http", device.Token, ""))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+
+	var body struct {
+		Resources map[string]string
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	want := "http://bookify.local:8080/kobo/" + device.Token + "/v1/library/sync"
+	if body.Resources["library_sync"] != want {
+		t.Errorf("library_sync = %q, want %q", body.Resources["library_sync"], want)
+	}
+
+	rec = serveKobo(e, koboFixture(t, "initialization.http", "not-a-token", ""))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("unknown token status = %d, want 401", rec.Code)
+	}
+}
+
+func TestHandlers_KoboLibrarySync(t *testing.T) {
+	e, dbService, device, books := setupKobo(t)
+
+	rec := serveKobo(e, koboFixture(t, "library_sync.http", device.Token, ""))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	if rec.Header().Get("x-kobo-synctoken") != "eyJ2ZXJzaW9uIjoiMS0xLTAifQ==" {
+		t.Errorf("sync token = %q, want the device's token back", rec.Header().Get("x-kobo-synctoken"))
+	}
+	if rec.Header().Get("x-kobo-sync") != "" {
+		t.Errorf("sync should not continue after sending every book")
+	}
+
+	var items []struct {
+		NewEntitlement struct {
+			BookEntitlement map[string]any
+			BookMetadata    map[string]any
+		}
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &items); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("synced %d books, want this account's 2", len(items))
+	}
+
+	dune := items[0].NewEntitlement
+	if dune.BookEntitlement["Id"] != books[0].UUID || dune.BookMetadata["Title"] != "Dune" {
+		t.Errorf("first entitlement = %+v", dune)
+	}
+	downloads, _ := dune.BookMetadata["DownloadUrls"].([]any)
+	if len(downloads) != 1 || downloads[0].(map[string]any)["Url"] != "http://bookify.local:8080/kobo/"+device.Token+"/download/"+books[0].UUID {
+		t.Errorf("download URLs = %v", downloads)
+	}
+	if series, _ := dune.BookMetadata["Series"].(map[string]any); series["Name"] != "Dune" || series["NumberFloat"] != 1.0 {
+		t.Errorf("series = %v", dune.BookMetadata["Series"])
+	}
+
+	// The device already has everything
+	rec = serveKobo(e, koboFixture(t, "library_sync.http", device.Token, ""))
+	if strings.TrimSpace(rec.Body.String()) != "[]" {
+		t.Errorf("second sync = %s, want nothing new", rec.Body.String())
+	}
+
+	synced, _ := dbService.GetKoboDevice(device.ID)
+	if synced.LastSyncAt == nil {
+		t.Errorf("last sync time not recorded")
+	}
+
+	// A reprocessed book is sent again as a change
+	books[0].Title = "Dune (revised)"
+	if err := dbService.UpdateBook(&books[0]); err != nil {
+		t.Fatalf("UpdateBook() failed: %v", err)
+	}
+	rec = serveKobo(e, koboFixture(t, "library_sync.http", device.Token, ""))
+	var changed []struct {
+		NewEntitlement     map[string]any
+		ChangedEntitlement struct {
+			BookEntitlement map[string]any
+			BookMetadata    map[string]any
+		}
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &changed); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if len(changed) != 1 || changed[0].NewEntitlement != nil || changed[0].ChangedEntitlement.BookMetadata["Title"] != "Dune (revised)" {
+		t.Fatalf("sync after reprocessing = %s, want the changed book", rec.Body.String())
+	}
+	if changed[0].ChangedEntitlement.BookEntitlement["Id"] != books[0].UUID {
+		t.Errorf("changed entitlement is for %v, want %s", changed[0].ChangedEntitlement.BookEntitlement["Id"], books[0].UUID)
+	}
+	rec = serveKobo(e, koboFixture(t, "library_sync.http", device.Token, ""))
+	if strings.TrimSpace(rec.Body.String()) != "[]" {
+		t.Errorf("sync after the change = %s, want nothing new", rec.Body.String())
+	}
+
+	if err := dbService.ResetKoboSync(device.ID); err != nil {
+		t.Fatalf("ResetKoboSync() failed: %v", err)
+	}
+	rec = serveKobo(e, koboFixture(t, "library_sync.http", device.Token, ""))
+	if err := json.Unmarshal(rec.Body.Bytes(), &items); err != nil || len(items) != 2 {
+		t.Errorf("sync after reset sent %d books, want 2", len(items))
+	}
+}
+
+func TestHandlers_KoboBookMetadata(t *testing.T) {
+	e, _, device, books := setupKobo(t)
+
+	rec := serveKobo(e, koboFixture(t, "library_metadata.http", device.Token, books[1].UUID))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	var metadata []map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &metadata); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if len(metadata) != 1 || metadata[0]["Title"] != "Emma" || metadata[0]["Language"] != "en-GB" {
+		t.Errorf("metadata = %v", metadata)
+	}
+
+	// Books of other accounts are hidden
+	rec = serveKobo(e, koboFixture(t, "library_metadata.http", device.Token, books[2].UUID))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("other account's book status = %d, want 404", rec.Code)
+	}
+}
+
+func TestHandlers_KoboFallback(t *testing.T) {
+	e, _, device, books := setupKobo(t)
+
+	rec := serveKobo(e, koboFixture(t, "reading_state.http", device.Token, books[0].UUID))
+	if rec.Code != http.StatusOK {
+		t.Errorf("reading state status = %d, want 200", rec.Code)
+	}
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+
+	"bookify/internal/db"
+	"bookify/internal/templates"
+
+	"github.com/labstack/echo/v4"
+)
+
+func (h *Handlers) LibraryPage(c echo.Context) error {
+	return h.renderLibrary(c, parseBookFilter(c), "", "")
+}
+
+func (h *Handlers) renderLibrary(c echo.Context, filter db.BookFilter, message, errorMsg string) error {
+	books, total, err := h.userDB(c).ListBooks(filter)
+	if err != nil {
+		return err
+	}
+	authors, err := h.userDB(c).ListBookAuthors(0)
+	if err != nil {
+		return err
+	}
+	series, err := h.userDB(c).ListBookSeries(0)
+	if err != nil {
+		return err
+	}
+	accounts, err := h.userDB(c).ListAccounts()
+	if err != nil {
+		return err
+	}
+
+	view := templates.LibraryView{
+		Books:    books,
+		Total:    total,
+		Filter:   filter,
+		Authors:  authors,
+		Series:   series,
+		Accounts: accounts,
+		// Reprocessing converts the originals kept in the store
+		CanReprocess: h.Store != nil,
+		Message:      message,
+		Error:        errorMsg,
+	}
+	if filter.Page > 1 {
+		view.PrevURL = libraryPageURL(c, filter.Page-1)
+	}
+	if int64(filter.Page*filter.PageSize) < total {
+		view.NextURL = libraryPageURL(c, filter.Page+1)
+	}
+
+	return render(c, templates.LibraryPage(view))
+}
+
+func parseBookFilter(c echo.Context) db.BookFilter {
+	filter := db.BookFilter{
+		Query:    c.QueryParam("q"),
+		Author:   c.QueryParam("author"),
+		Series:   c.QueryParam("series"),
+		Page:     1,
+		PageSize: db.DefaultBookPageSize,
+	}
+	if page, err := strconv.Atoi(c.QueryParam("page")); err == nil && page > 1 {
+		filter.Page = page
+	}
+	if accountID, err := strconv.ParseUint(c.QueryParam("account"), 10, 32); err == nil {
+		filter.AccountID = uint(accountID)
+	}
+	if from, err := time.ParseInLocation("2006-01-02", c.QueryParam("from"), time.Local); err == nil {
+		filter.From = from
+	}
+	// The end date is inclusive
+	if to, err := time.ParseInLocation("2006-01-02", c.QueryParam("to"), time.Local); err == nil {
+		filter.To = to.AddDate(0, 0, 1)
+	}
+	return filter
+}
+
+// libraryPageURL keeps the current filters when moving between pages.
+func libraryPageURL(c echo.Context, page int) string {
+	query := url.Values{}
+	for key, values := range c.QueryParams() {
+		if key != "page" && len(values) > 0 && values[0] != "" {
+			query.Set(key, values[0])
+		}
+	}
+	query.Set("page", strconv.Itoa(page))
+	return "/library?" + query.Encode()
+}
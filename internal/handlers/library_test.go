@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"bookify/internal/db"
+	"bookify/internal/testutil"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestHandlers_LibraryPage(t *testing.T) {
+	testDB := testutil.SetupTestDB(t)
+	err := testDB.AutoMigrate(&db.Account{}, &db.Job{}, &db.Book{})
+	if err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+
+	dbService := db.NewService(testDB)
+	handlers := &Handlers{DB: dbService}
+
+	account, err := dbService.CreateAccount("test-account", "folder-123")
+	if err != nil {
+		t.Fatalf("Failed to create test account: %v", err)
+	}
+	for i := 1; i <= db.DefaultBookPageSize+2; i++ {
+		book := &db.Book{
+			JobID:       fmt.Sprintf("job-%d", i),
+			AccountID:   account.ID,
+			Title:       fmt.Sprintf("Book %d", i),
+			Author:      "Prolific Writer",
+			DriveURL:    "https://drive.google.com/file/d/abc/view",
+			DeliveredAt: time.Now().Add(time.Duration(i) * time.Minute),
+		}
+		if i == 1 {
+			book.Author = "Rare <Writer>"
+		}
+		if err := dbService.CreateBook(book); err != nil {
+			t.Fatalf("Failed to create book: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name          string
+		query         string
+		expectContent []string
+		rejectContent []string
+	}{
+		{
+			name:          "first page links to the next",
+			query:         "",
+			expectContent: []string{"26 books", "Book 26", "/library?page=2"},
+			rejectContent: []string{"Previous"},
+		},
+		{
+			name:          "filters survive pagination",
+			query:         "?author=Prolific+Writer&page=1",
+			expectContent: []string{"25 books", "author=Prolific+Writer&amp;page=2"},
+		},
+		{
+			name:          "author filter escapes names",
+			query:         "?author=" + "Rare+%3CWriter%3E",
+			expectContent: []string{"1 books", "Book 1", "Rare &lt;Writer&gt;"},
+			rejectContent: []string{"Book 2<", "Next"},
+		},
+		{
+			name:          "search with no results",
+			query:         "?q=missing",
+			expectContent: []string{"No books found."},
+		},
+	}
+
+	e := echo.New()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/library"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+
+			if err := handlers.LibraryPage(c); err != nil {
+				t.Fatalf("LibraryPage() error = %v", err)
+			}
+			testutil.AssertResponseStatus(t, rec, http.StatusOK)
+			for _, want := range tt.expectContent {
+				testutil.AssertResponseContains(t, rec, want)
+			}
+			for _, unwanted := range tt.rejectContent {
+				if strings.Contains(rec.Body.String(), unwanted) {
+					t.Errorf("response should not contain %q", unwanted)
+				}
+			}
+		})
+	}
+}
@@ -26,6 +26,7 @@ type OAuthHandlers struct {
 
 type OAuthState struct {
 	State       string
+	UserID      uint
 	AccountName string
 	FolderID    string
 	CreatedAt   time.Time
@@ -87,12 +88,16 @@ func (h *OAuthHandlers) StartOAuth(c echo.Context) error {
 		return c.Redirect(http.StatusFound, "/setup?error=state_generation_failed")
 	}
 
-	h.stateStore[state] = &OAuthState{
+	oauthState := &OAuthState{
 		State:       state,
 		AccountName: accountName,
 		FolderID:    folderID,
 		CreatedAt:   time.Now(),
 	}
+	if user := currentUser(c); user != nil {
+		oauthState.UserID = user.ID
+	}
+	h.stateStore[state] = oauthState
 
 	authURL := h.oauth2Config.AuthCodeURL(state,
 		oauth2.AccessTypeOffline,
@@ -157,6 +162,7 @@ func (h *OAuthHandlers) OAuthCallback(c echo.Context) error {
 	}
 
 	account := &db.Account{
+		UserID:       oauthState.UserID,
 		Name:         oauthState.AccountName,
 		FolderID:     oauthState.FolderID,
 		AccessToken:  token.AccessToken,
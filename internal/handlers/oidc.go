@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"bookify/internal/db"
+	"bookify/internal/services"
+	"bookify/internal/templates"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcStateCookie = "bookify_oidc_state"
+	oidcStateTTL    = 10 * time.Minute
+)
+
+// OIDCLogin signs users in to the web interface with an OpenID Connect
+// provider, such as Authelia, Keycloak or Authentik.
+type OIDCLogin struct {
+	Provider *services.OIDCProvider
+	// Shown on the login button
+	Name string
+	// Let the provider sign in existing users with the same username, instead
+	// of refusing them
+	LinkByUsername bool
+
+	mu      sync.Mutex
+	pending map[string]*oidcPending
+}
+
+// oidcPending is a sign-in that went to the provider and hasn't come back.
+type oidcPending struct {
+	Nonce     string
+	Verifier  string
+	Next      string
+	CreatedAt time.Time
+}
+
+func NewOIDCLogin(provider *services.OIDCProvider, name string, linkByUsername bool) *OIDCLogin {
+	if name == "" {
+		name = "single sign-on"
+	}
+	return &OIDCLogin{
+		Provider:       provider,
+		Name:           name,
+		LinkByUsername: linkByUsername,
+		pending:        make(map[string]*oidcPending),
+	}
+}
+
+func (o *OIDCLogin) start(state string, pending *oidcPending) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	now := time.Now()
+	for key, p := range o.pending {
+		if now.Sub(p.CreatedAt) > oidcStateTTL {
+			delete(o.pending, key)
+		}
+	}
+	o.pending[state] = pending
+}
+
+// finish takes the pending sign-in for the state, which can only be used
+// once.
+func (o *OIDCLogin) finish(state string) (*oidcPending, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	pending, ok := o.pending[state]
+	delete(o.pending, state)
+	if !ok || time.Since(pending.CreatedAt) > oidcStateTTL {
+		return nil, false
+	}
+	return pending, true
+}
+
+// oidcName is the provider's name for the login page, or empty without one.
+func (h *Handlers) oidcName() string {
+	if h.OIDC == nil {
+		return ""
+	}
+	return h.OIDC.Name
+}
+
+// LoginOIDC sends the browser to the provider to sign in.
+func (h *Handlers) LoginOIDC(c echo.Context) error {
+	if h.OIDC == nil {
+		return echo.ErrNotFound
+	}
+	state, err := generateStateToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate state: %w", err)
+	}
+	nonce, err := generateStateToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	pending := &oidcPending{
+		Nonce:     nonce,
+		Verifier:  oauth2.GenerateVerifier(),
+		Next:      safeRedirect(c.QueryParam("next")),
+		CreatedAt: time.Now(),
+	}
+	authURL, err := h.OIDC.Provider.AuthCodeURL(c.Request().Context(), state, pending.Nonce, pending.Verifier)
+	if err != nil {
+		log.Printf("Warning: OpenID Connect sign-in failed: %v", err)
+		return h.oidcFailed(c, http.StatusBadGateway, fmt.Sprintf("Can't reach %s right now", h.OIDC.Name))
+	}
+	h.OIDC.start(state, pending)
+
+	// The callback must come back to the browser that started the sign-in
+	c.SetCookie(&http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/login/oidc",
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	return c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback finishes signing in when the provider sends the browser back,
+// finding or creating the user it signed in as.
+func (h *Handlers) OIDCCallback(c echo.Context) error {
+	if h.OIDC == nil {
+		return echo.ErrNotFound
+	}
+	state := c.QueryParam("state")
+	cookie, err := c.Cookie(oidcStateCookie)
+	if err != nil || state == "" || cookie.Value != state {
+		return h.oidcFailed(c, http.StatusBadRequest, "Sign-in expired, please try again")
+	}
+	c.SetCookie(&http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     "/login/oidc",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	pending, ok := h.OIDC.finish(state)
+	if !ok {
+		return h.oidcFailed(c, http.StatusBadRequest, "Sign-in expired, please try again")
+	}
+	if problem := c.QueryParam("error"); problem != "" {
+		log.Printf("Warning: OpenID Connect provider refused sign-in: %s %s", problem, c.QueryParam("error_description"))
+		return h.oidcFailed(c, http.StatusUnauthorized, fmt.Sprintf("%s didn't sign you in", h.OIDC.Name))
+	}
+
+	identity, err := h.OIDC.Provider.Exchange(c.Request().Context(), c.QueryParam("code"), pending.Nonce, pending.Verifier)
+	if errors.Is(err, services.ErrOIDCNotAllowed) {
+		return h.oidcFailed(c, http.StatusForbidden, "Your account isn't allowed to use Bookify")
+	}
+	if err != nil {
+		log.Printf("Warning: OpenID Connect sign-in failed: %v", err)
+		return h.oidcFailed(c, http.StatusUnauthorized, fmt.Sprintf("Signing in with %s failed", h.OIDC.Name))
+	}
+
+	user, problem, err := h.oidcUser(identity)
+	if err != nil {
+		return err
+	}
+	if problem != "" {
+		return h.oidcFailed(c, http.StatusForbidden, problem)
+	}
+	return h.startSession(c, user, pending.Next)
+}
+
+// oidcUser finds the user the provider signed in, creating them the first
+// time, and keeps their role in step with the provider's groups.
+func (h *Handlers) oidcUser(identity *services.OIDCIdentity) (*db.User, string, error) {
+	user, err := h.DB.GetUserByOIDCSubject(identity.Subject)
+	if err != nil {
+		existing, err := h.DB.GetUserByUsername(identity.Username)
+		switch {
+		case err == nil && !h.OIDC.LinkByUsername:
+			return nil, fmt.Sprintf("A user named %s already exists; ask an admin to let them sign in with %s", identity.Username, h.OIDC.Name), nil
+		case err == nil && existing.OIDCSubject != "":
+			return nil, fmt.Sprintf("%s already signs in as someone else", identity.Username), nil
+		case err == nil:
+			user = existing
+		default:
+			role := db.RoleUser
+			if identity.Admin != nil && *identity.Admin {
+				role = db.RoleAdmin
+			}
+			user, err = h.DB.CreateUser(identity.Username, "", role)
+			if err != nil {
+				return nil, "", fmt.Errorf("failed to create user: %w", err)
+			}
+			log.Printf("Created user %s (%s) from OpenID Connect", user.Username, user.Role)
+		}
+		if err := h.DB.LinkUserOIDC(user.ID, identity.Subject); err != nil {
+			return nil, "", fmt.Errorf("failed to link user: %w", err)
+		}
+		user.OIDCSubject = identity.Subject
+	}
+
+	if identity.Admin != nil {
+		h.syncRole(user, *identity.Admin)
+	}
+	return user, "", nil
+}
+
+// syncRole makes a user an admin or not, as their sign-in provider says.
+// The last admin stays one.
+func (h *Handlers) syncRole(user *db.User, admin bool) {
+	role := db.RoleUser
+	if admin {
+		role = db.RoleAdmin
+	}
+	if role == user.Role {
+		return
+	}
+	if err := h.DB.SetUserRole(user.ID, role); err != nil {
+		log.Printf("Warning: Failed to make %s %s: %v", user.Username, role, err)
+		return
+	}
+	user.Role = role
+}
+
+func (h *Handlers) oidcFailed(c echo.Context, status int, problem string) error {
+	c.Response().WriteHeader(status)
+	return render(c, templates.LoginPage(templates.LoginView{
+		Next:     "/",
+		Error:    problem,
+		OIDCName: h.oidcName(),
+	}))
+}
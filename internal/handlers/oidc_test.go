@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"bookify/internal/db"
+	"bookify/internal/services"
+	"bookify/internal/testutil"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+func setupOIDC(t *testing.T, linkByUsername bool) (*echo.Echo, *db.Service, *testutil.MockOIDC) {
+	t.Helper()
+
+	e, dbService := setupLogin(t)
+	mock := testutil.NewMockOIDC(t, "bookify", "secret")
+	provider := services.NewOIDCProvider(services.OIDCConfig{
+		Issuer:        mock.URL,
+		ClientID:      "bookify",
+		ClientSecret:  "secret",
+		RedirectURL:   "http://bookify.test/login/oidc/callback",
+		RolesClaim:    "groups",
+		AdminValues:   []string{"bookify-admins"},
+		AllowedValues: []string{"bookify-users"},
+	})
+	handlers := &Handlers{DB: dbService, OIDC: NewOIDCLogin(provider, "Authelia", linkByUsername)}
+	e.GET("/login", handlers.LoginPage)
+	e.GET("/login/oidc", handlers.LoginOIDC)
+	e.GET("/login/oidc/callback", handlers.OIDCCallback)
+	return e, dbService, mock
+}
+
+// oidcSignIn starts signing in at Bookify, signs in at the provider and
+// brings the browser back, returning Bookify's last response.
+func oidcSignIn(t *testing.T, e *echo.Echo, next string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	rec := browse(e, http.MethodGet, "/login/oidc?next="+url.QueryEscape(next), "", nil)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("starting sign-in = %d %s", rec.Code, rec.Body.String())
+	}
+	var stateCookie *http.Cookie
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == oidcStateCookie {
+			stateCookie = cookie
+		}
+	}
+	if stateCookie == nil || !stateCookie.HttpOnly {
+		t.Fatalf("state cookie = %+v", stateCookie)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("Failed to sign in at the provider: %v", err)
+	}
+	_ = resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("provider sent the browser to %q", resp.Header.Get("Location"))
+	}
+
+	req := httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+	req.AddCookie(stateCookie)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandlers_OIDCLogin(t *testing.T) {
+	e, dbService, mock := setupOIDC(t, false)
+
+	if body := browse(e, http.MethodGet, "/login", "", nil).Body.String(); !strings.Contains(body, "Sign in with Authelia") {
+		t.Error("the login page doesn't offer the provider")
+	}
+
+	// The first sign-in creates the user, with the role from their groups
+	_, _ = dbService.CreateUser("admin", "", db.RoleAdmin)
+	mock.Claims = jwt.MapClaims{"sub": "alice-sub", "preferred_username": "alice", "groups": []string{"bookify-users", "bookify-admins"}}
+	rec := oidcSignIn(t, e, "/library")
+	session := sessionFrom(rec)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/library" || session == "" {
+		t.Fatalf("signing in = %d %s %s", rec.Code, rec.Header().Get("Location"), rec.Body.String())
+	}
+	alice, err := dbService.GetUserByOIDCSubject("alice-sub")
+	if err != nil || alice.Username != "alice" || alice.Role != db.RoleAdmin {
+		t.Fatalf("alice = %+v, %v", alice, err)
+	}
+	if rec := browse(e, http.MethodGet, "/users", session, nil); rec.Code != http.StatusOK {
+		t.Errorf("users page for an OIDC admin = %d", rec.Code)
+	}
+
+	// Later sign-ins find the same user and follow changes to their groups
+	mock.Claims = jwt.MapClaims{"sub": "alice-sub", "preferred_username": "alice", "groups": []string{"bookify-users"}}
+	if rec := oidcSignIn(t, e, "/"); sessionFrom(rec) == "" {
+		t.Fatalf("signing in again = %d %s", rec.Code, rec.Body.String())
+	}
+	if alice, _ := dbService.GetUser(alice.ID); alice.Role != db.RoleUser {
+		t.Errorf("role after leaving the admins = %s", alice.Role)
+	}
+	if users, _ := dbService.ListUsers(); len(users) != 2 {
+		t.Errorf("%d users, want 2", len(users))
+	}
+
+	// Users outside the allowed groups can't sign in
+	mock.Claims = jwt.MapClaims{"sub": "mallory-sub", "preferred_username": "mallory", "groups": []string{"guests"}}
+	if rec := oidcSignIn(t, e, "/"); rec.Code != http.StatusForbidden || sessionFrom(rec) != "" {
+		t.Errorf("signing in outside the allowed groups = %d", rec.Code)
+	}
+
+	// Nor can someone else at the provider take over a local user
+	mock.Claims = jwt.MapClaims{"sub": "other-sub", "preferred_username": "admin", "groups": []string{"bookify-users"}}
+	if rec := oidcSignIn(t, e, "/"); rec.Code != http.StatusForbidden || sessionFrom(rec) != "" {
+		t.Errorf("signing in as an existing user = %d", rec.Code)
+	}
+}
+
+func TestHandlers_OIDCLogin_LinkByUsername(t *testing.T) {
+	e, dbService, mock := setupOIDC(t, true)
+	admin, _ := dbService.CreateUser("admin", "", db.RoleAdmin)
+
+	mock.Claims = jwt.MapClaims{"sub": "admin-sub", "preferred_username": "admin", "groups": []string{"bookify-users"}}
+	if rec := oidcSignIn(t, e, "/"); sessionFrom(rec) == "" {
+		t.Fatalf("signing in = %d %s", rec.Code, rec.Body.String())
+	}
+	user, err := dbService.GetUserByOIDCSubject("admin-sub")
+	if err != nil || user.ID != admin.ID {
+		t.Fatalf("linked user = %+v, %v", user, err)
+	}
+	// Not being in the admins' group can't demote the last admin
+	if user.Role != db.RoleAdmin {
+		t.Errorf("the last admin became a %s", user.Role)
+	}
+
+	// A second subject with the same username can't take the user over
+	mock.Claims = jwt.MapClaims{"sub": "impostor-sub", "preferred_username": "admin", "groups": []string{"bookify-users"}}
+	if rec := oidcSignIn(t, e, "/"); rec.Code != http.StatusForbidden || sessionFrom(rec) != "" {
+		t.Errorf("signing in as a linked user = %d", rec.Code)
+	}
+}
+
+func TestHandlers_OIDCCallback_State(t *testing.T) {
+	e, _, _ := setupOIDC(t, false)
+
+	// A callback without the browser's state cookie is refused
+	rec := browse(e, http.MethodGet, "/login/oidc/callback?state=forged&code=x", "", nil)
+	if rec.Code != http.StatusBadRequest || sessionFrom(rec) != "" {
+		t.Errorf("forged callback = %d", rec.Code)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/login/oidc/callback?state=forged&code=x", nil)
+	req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: "forged"})
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest || sessionFrom(rec) != "" {
+		t.Errorf("callback with an unknown state = %d", rec.Code)
+	}
+}
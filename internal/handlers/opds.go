@@ -0,0 +1,343 @@
+package handlers
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"bookify/internal/db"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// The OPDS catalogue is served twice from the same handlers: OPDS 1.2 (Atom)
+// under /opds and OPDS 2.0 (JSON) under /opds/v2.
+const (
+	opdsBase   = "/opds"
+	opdsV2Base = "/opds/v2"
+
+	opdsAccountKey = "opdsAccount"
+
+	atomNavigationType  = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	atomAcquisitionType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+	opdsJSONType        = "application/opds+json"
+	openSearchType      = "application/opensearchdescription+xml"
+	epubType            = "application/epub+zip"
+
+	relAcquisition = "http://opds-spec.org/acquisition"
+	relImage       = "http://opds-spec.org/image"
+	relThumbnail   = "http://opds-spec.org/image/thumbnail"
+)
+
+// OPDSAuth checks the HTTP Basic credentials set in an account's settings.
+// Each login sees only that account's books.
+func (h *Handlers) OPDSAuth() echo.MiddlewareFunc {
+	return middleware.BasicAuthWithConfig(middleware.BasicAuthConfig{
+		Realm: "Bookify",
+		Validator: func(username, password string, c echo.Context) (bool, error) {
+			if username == "" {
+				return false, nil
+			}
+			account, err := h.DB.GetAccountByOPDSUsername(username)
+			if err != nil || account.OPDSPasswordHash == "" {
+				return false, nil
+			}
+			if bcrypt.CompareHashAndPassword([]byte(account.OPDSPasswordHash), []byte(password)) != nil {
+				return false, nil
+			}
+			c.Set(opdsAccountKey, account)
+			return true, nil
+		},
+	})
+}
+
+type opdsFeed struct {
+	Title       string
+	Self        string
+	Acquisition bool
+	Navigation  []opdsNavigation
+	Books       []db.Book
+	Total       int64
+	Page        int
+	PrevURL     string
+	NextURL     string
+}
+
+type opdsNavigation struct {
+	Title       string
+	Href        string
+	Acquisition bool
+}
+
+func (h *Handlers) OPDSRoot(c echo.Context) error {
+	base := opdsFeedBase(c)
+	return writeOPDSFeed(c, &opdsFeed{
+		Title: "Bookify",
+		Self:  base,
+		Navigation: []opdsNavigation{
+			{Title: "Recently added", Href: base + "/books", Acquisition: true},
+			{Title: "By author", Href: base + "/authors"},
+			{Title: "By series", Href: base + "/series"},
+		},
+	})
+}
+
+func (h *Handlers) OPDSBooks(c echo.Context) error {
+	account := c.Get(opdsAccountKey).(*db.Account)
+	base := opdsFeedBase(c)
+
+	filter := db.BookFilter{
+		Query:     strings.TrimSpace(c.QueryParam("q")),
+		Author:    c.QueryParam("author"),
+		Series:    c.QueryParam("series"),
+		AccountID: account.ID,
+		Page:      1,
+		PageSize:  db.DefaultBookPageSize,
+	}
+	if page, err := strconv.Atoi(c.QueryParam("page")); err == nil && page > 1 {
+		filter.Page = page
+	}
+
+	books, total, err := h.DB.ListBooks(filter)
+	if err != nil {
+		return err
+	}
+
+	feed := &opdsFeed{
+		Title:       "Recently added",
+		Self:        opdsBooksURL(base, filter, filter.Page),
+		Acquisition: true,
+		Books:       books,
+		Total:       total,
+		Page:        filter.Page,
+	}
+	switch {
+	case filter.Query != "":
+		feed.Title = "Search: " + filter.Query
+	case filter.Series != "":
+		feed.Title = filter.Series
+	case filter.Author != "":
+		feed.Title = filter.Author
+	}
+	if filter.Page > 1 {
+		feed.PrevURL = opdsBooksURL(base, filter, filter.Page-1)
+	}
+	if int64(filter.Page*filter.PageSize) < total {
+		feed.NextURL = opdsBooksURL(base, filter, filter.Page+1)
+	}
+	return writeOPDSFeed(c, feed)
+}
+
+func (h *Handlers) OPDSAuthors(c echo.Context) error {
+	account := c.Get(opdsAccountKey).(*db.Account)
+	base := opdsFeedBase(c)
+
+	authors, err := h.DB.ListBookAuthors(account.ID)
+	if err != nil {
+		return err
+	}
+	feed := &opdsFeed{Title: "By author", Self: base + "/authors"}
+	for _, author := range authors {
+		feed.Navigation = append(feed.Navigation, opdsNavigation{
+			Title:       author,
+			Href:        base + "/books?" + url.Values{"author": {author}}.Encode(),
+			Acquisition: true,
+		})
+	}
+	return writeOPDSFeed(c, feed)
+}
+
+func (h *Handlers) OPDSSeries(c echo.Context) error {
+	account := c.Get(opdsAccountKey).(*db.Account)
+	base := opdsFeedBase(c)
+
+	series, err := h.DB.ListBookSeries(account.ID)
+	if err != nil {
+		return err
+	}
+	feed := &opdsFeed{Title: "By series", Self: base + "/series"}
+	for _, name := range series {
+		feed.Navigation = append(feed.Navigation, opdsNavigation{
+			Title:       name,
+			Href:        base + "/books?" + url.Values{"series": {name}}.Encode(),
+			Acquisition: true,
+		})
+	}
+	return writeOPDSFeed(c, feed)
+}
+
+type openSearchDescription struct {
+	XMLName        xml.Name      `xml:"http://a9.com/-/spec/opensearch/1.1/ OpenSearchDescription"`
+	ShortName      string        `xml:"ShortName"`
+	Description    string        `xml:"Description"`
+	InputEncoding  string        `xml:"InputEncoding"`
+	OutputEncoding string        `xml:"OutputEncoding"`
+	URL            openSearchURL `xml:"Url"`
+}
+
+type openSearchURL struct {
+	Type     string `xml:"type,attr"`
+	Template string `xml:"template,attr"`
+}
+
+func (h *Handlers) OPDSOpenSearch(c echo.Context) error {
+	description := openSearchDescription{
+		ShortName:      "Bookify",
+		Description:    "Search the Bookify library",
+		InputEncoding:  "UTF-8",
+		OutputEncoding: "UTF-8",
+		URL: openSearchURL{
+			Type:     atomAcquisitionType,
+			Template: c.Scheme() + "://" + c.Request().Host + opdsBase + "/books?q={searchTerms}",
+		},
+	}
+	return writeXML(c, openSearchType, description)
+}
+
+// OPDSDownload serves a book's KEPUB, fetched from the account's Drive.
+func (h *Handlers) OPDSDownload(c echo.Context) error {
+	account := c.Get(opdsAccountKey).(*db.Account)
+	book, err := h.opdsBook(c, account)
+	if err != nil {
+		return err
+	}
+	return h.serveBookFile(c, account, book)
+}
+
+// serveBookFile sends a delivered book from the store if it was retained, or
+// streams it back from the account's Drive.
+func (h *Handlers) serveBookFile(c echo.Context, account *db.Account, book *db.Book) error {
+	if h.Store != nil {
+		if job, err := h.DB.GetJob(book.JobID); err == nil && h.Store.Has(job.OutputHash) {
+			return h.serveStoredFile(c, job.OutputHash, book.Filename, epubType)
+		}
+	}
+
+	if book.DriveFileID == "" || h.Drive == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "File not available")
+	}
+
+	file, err := h.Drive.DownloadFile(account, book.DriveFileID)
+	if err != nil {
+		log.Printf("Failed to download book %d from Drive: %v", book.ID, err)
+		return echo.NewHTTPError(http.StatusBadGateway, "Failed to download the file from Google Drive")
+	}
+	defer func() {
+		if closeErr := file.Close(); closeErr != nil {
+			log.Printf("Warning: Failed to close download: %v", closeErr)
+		}
+	}()
+
+	c.Response().Header().Set(echo.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": book.Filename}))
+	if book.FileSize > 0 {
+		c.Response().Header().Set(echo.HeaderContentLength, strconv.FormatInt(book.FileSize, 10))
+	}
+	return c.Stream(http.StatusOK, epubType, file)
+}
+
+func (h *Handlers) OPDSCover(c echo.Context) error {
+	account := c.Get(opdsAccountKey).(*db.Account)
+	book, err := h.opdsBook(c, account)
+	if err != nil {
+		return err
+	}
+	if h.Covers == nil || !book.HasCover || !h.Covers.HasThumbnail(book.JobID) {
+		return echo.NewHTTPError(http.StatusNotFound, "Cover not found")
+	}
+
+	c.Response().Header().Set("Cache-Control", "private, max-age=86400")
+	return c.File(h.Covers.ThumbnailPath(book.JobID))
+}
+
+// opdsBook looks up the book in the URL, hiding other accounts' books.
+func (h *Handlers) opdsBook(c echo.Context, account *db.Account) (*db.Book, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Book not found")
+	}
+	book, err := h.DB.GetBook(uint(id))
+	if err != nil || book.AccountID != account.ID {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Book not found")
+	}
+	return book, nil
+}
+
+func opdsFeedBase(c echo.Context) string {
+	if strings.HasPrefix(c.Request().URL.Path, opdsV2Base) {
+		return opdsV2Base
+	}
+	return opdsBase
+}
+
+func opdsBooksURL(base string, filter db.BookFilter, page int) string {
+	query := url.Values{}
+	if filter.Query != "" {
+		query.Set("q", filter.Query)
+	}
+	if filter.Author != "" {
+		query.Set("author", filter.Author)
+	}
+	if filter.Series != "" {
+		query.Set("series", filter.Series)
+	}
+	if page > 1 {
+		query.Set("page", strconv.Itoa(page))
+	}
+	if len(query) == 0 {
+		return base + "/books"
+	}
+	return base + "/books?" + query.Encode()
+}
+
+func writeOPDSFeed(c echo.Context, feed *opdsFeed) error {
+	if opdsFeedBase(c) == opdsV2Base {
+		data, err := json.Marshal(opdsJSONFeed(feed))
+		if err != nil {
+			return fmt.Errorf("failed to encode feed: %w", err)
+		}
+		return c.Blob(http.StatusOK, opdsJSONType, data)
+	}
+	return writeXML(c, atomFeedType(feed.Acquisition), atomOPDSFeed(feed))
+}
+
+func writeXML(c echo.Context, contentType string, v any) error {
+	data, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode feed: %w", err)
+	}
+	return c.Blob(http.StatusOK, contentType+";charset=utf-8", append([]byte(xml.Header), data...))
+}
+
+// opdsSummary describes a book in one piece of text, since OPDS 1.2 has no
+// place for series.
+func opdsSummary(book db.Book) string {
+	var parts []string
+	if book.Series != "" {
+		series := book.Series
+		if book.SeriesIndex != 0 {
+			series += " #" + strconv.FormatFloat(book.SeriesIndex, 'f', -1, 64)
+		}
+		parts = append(parts, series)
+	}
+	if book.Description != "" {
+		parts = append(parts, book.Description)
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+func opdsUpdated(books []db.Book) time.Time {
+	updated := time.Now()
+	if len(books) > 0 {
+		updated = books[0].DeliveredAt
+	}
+	return updated
+}
@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"strconv"
+	"time"
+
+	"bookify/internal/db"
+)
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	DC      string      `xml:"xmlns:dc,attr"`
+	OPDS    string      `xml:"xmlns:opds,attr"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel   string `xml:"rel,attr,omitempty"`
+	Href  string `xml:"href,attr"`
+	Type  string `xml:"type,attr,omitempty"`
+	Title string `xml:"title,attr,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	ID         string       `xml:"id"`
+	Title      string       `xml:"title"`
+	Updated    string       `xml:"updated"`
+	Authors    []atomAuthor `xml:"author"`
+	Language   string       `xml:"dc:language,omitempty"`
+	Publisher  string       `xml:"dc:publisher,omitempty"`
+	Identifier string       `xml:"dc:identifier,omitempty"`
+	Summary    *atomText    `xml:"summary"`
+	Content    *atomText    `xml:"content"`
+	Links      []atomLink   `xml:"link"`
+}
+
+func atomFeedType(acquisition bool) string {
+	if acquisition {
+		return atomAcquisitionType
+	}
+	return atomNavigationType
+}
+
+func atomTimestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func atomOPDSFeed(feed *opdsFeed) *atomFeed {
+	updated := atomTimestamp(opdsUpdated(feed.Books))
+	feedType := atomFeedType(feed.Acquisition)
+
+	atom := &atomFeed{
+		DC:      "http://purl.org/dc/terms/",
+		OPDS:    "http://opds-spec.org/2010/catalog",
+		ID:      "urn:bookify:opds:" + feed.Self,
+		Title:   feed.Title,
+		Updated: updated,
+		Author:  atomAuthor{Name: "Bookify"},
+		Links: []atomLink{
+			{Rel: "self", Href: feed.Self, Type: feedType},
+			{Rel: "start", Href: opdsBase, Type: atomNavigationType},
+			{Rel: "search", Href: opdsBase + "/opensearch.xml", Type: openSearchType},
+		},
+	}
+	if feed.PrevURL != "" {
+		atom.Links = append(atom.Links, atomLink{Rel: "previous", Href: feed.PrevURL, Type: feedType})
+	}
+	if feed.NextURL != "" {
+		atom.Links = append(atom.Links, atomLink{Rel: "next", Href: feed.NextURL, Type: feedType})
+	}
+
+	for _, nav := range feed.Navigation {
+		atom.Entries = append(atom.Entries, atomEntry{
+			ID:      "urn:bookify:opds:" + nav.Href,
+			Title:   nav.Title,
+			Updated: updated,
+			Content: &atomText{Type: "text", Text: nav.Title},
+			Links:   []atomLink{{Rel: "subsection", Href: nav.Href, Type: atomFeedType(nav.Acquisition)}},
+		})
+	}
+
+	for _, book := range feed.Books {
+		entry := atomEntry{
+			ID:         "urn:bookify:book:" + strconv.FormatUint(uint64(book.ID), 10),
+			Title:      book.Title,
+			Updated:    atomTimestamp(book.DeliveredAt),
+			Language:   book.Language,
+			Publisher:  book.Publisher,
+			Identifier: book.Identifier,
+			Links:      opdsBookLinks(book),
+		}
+		if book.Author != "" {
+			entry.Authors = []atomAuthor{{Name: book.Author}}
+		}
+		if summary := opdsSummary(book); summary != "" {
+			entry.Summary = &atomText{Type: "text", Text: summary}
+		}
+		atom.Entries = append(atom.Entries, entry)
+	}
+	return atom
+}
+
+func opdsBookLinks(book db.Book) []atomLink {
+	bookURL := opdsBase + "/books/" + strconv.FormatUint(uint64(book.ID), 10)
+
+	var links []atomLink
+	if book.DriveFileID != "" {
+		links = append(links, atomLink{Rel: relAcquisition, Href: bookURL + "/file", Type: epubType, Title: "KEPUB"})
+	}
+	if book.HasCover {
+		links = append(links,
+			atomLink{Rel: relImage, Href: bookURL + "/cover", Type: "image/jpeg"},
+			atomLink{Rel: relThumbnail, Href: bookURL + "/cover", Type: "image/jpeg"},
+		)
+	}
+	return links
+}
+
+// OPDS 2.0 is JSON following the Readium Web Publication Manifest.
+
+type opds2Feed struct {
+	Metadata     opds2FeedMetadata  `json:"metadata"`
+	Links        []opds2Link        `json:"links"`
+	Navigation   []opds2Link        `json:"navigation,omitempty"`
+	Publications []opds2Publication `json:"publications,omitempty"`
+}
+
+type opds2FeedMetadata struct {
+	Title         string `json:"title"`
+	NumberOfItems int64  `json:"numberOfItems,omitempty"`
+	ItemsPerPage  int    `json:"itemsPerPage,omitempty"`
+	CurrentPage   int    `json:"currentPage,omitempty"`
+}
+
+type opds2Link struct {
+	Rel       string `json:"rel,omitempty"`
+	Href      string `json:"href"`
+	Type      string `json:"type,omitempty"`
+	Title     string `json:"title,omitempty"`
+	Templated bool   `json:"templated,omitempty"`
+}
+
+type opds2Publication struct {
+	Metadata opds2PublicationMetadata `json:"metadata"`
+	Links    []opds2Link              `json:"links"`
+	Images   []opds2Link              `json:"images,omitempty"`
+}
+
+type opds2PublicationMetadata struct {
+	Type        string          `json:"@type"`
+	Title       string          `json:"title"`
+	Author      string          `json:"author,omitempty"`
+	Identifier  string          `json:"identifier,omitempty"`
+	Language    string          `json:"language,omitempty"`
+	Publisher   string          `json:"publisher,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Modified    string          `json:"modified"`
+	BelongsTo   *opds2BelongsTo `json:"belongsTo,omitempty"`
+}
+
+type opds2BelongsTo struct {
+	Series []opds2Series `json:"series"`
+}
+
+type opds2Series struct {
+	Name     string  `json:"name"`
+	Position float64 `json:"position,omitempty"`
+}
+
+func opdsJSONFeed(feed *opdsFeed) *opds2Feed {
+	out := &opds2Feed{
+		Metadata: opds2FeedMetadata{Title: feed.Title},
+		Links: []opds2Link{
+			{Rel: "self", Href: feed.Self, Type: opdsJSONType},
+			{Rel: "start", Href: opdsV2Base, Type: opdsJSONType},
+			{Rel: "search", Href: opdsV2Base + "/books{?q}", Type: opdsJSONType, Templated: true},
+		},
+	}
+	if feed.Acquisition {
+		out.Metadata.NumberOfItems = feed.Total
+		out.Metadata.ItemsPerPage = db.DefaultBookPageSize
+		out.Metadata.CurrentPage = feed.Page
+	}
+	if feed.PrevURL != "" {
+		out.Links = append(out.Links, opds2Link{Rel: "previous", Href: feed.PrevURL, Type: opdsJSONType})
+	}
+	if feed.NextURL != "" {
+		out.Links = append(out.Links, opds2Link{Rel: "next", Href: feed.NextURL, Type: opdsJSONType})
+	}
+
+	for _, nav := range feed.Navigation {
+		out.Navigation = append(out.Navigation, opds2Link{Rel: "subsection", Href: nav.Href, Type: opdsJSONType, Title: nav.Title})
+	}
+
+	for _, book := range feed.Books {
+		publication := opds2Publication{
+			Metadata: opds2PublicationMetadata{
+				Type:        "http://schema.org/Book",
+				Title:       book.Title,
+				Author:      book.Author,
+				Identifier:  book.Identifier,
+				Language:    book.Language,
+				Publisher:   book.Publisher,
+				Description: book.Description,
+				Modified:    atomTimestamp(book.DeliveredAt),
+			},
+			Links: []opds2Link{},
+		}
+		if book.Series != "" {
+			publication.Metadata.BelongsTo = &opds2BelongsTo{
+				Series: []opds2Series{{Name: book.Series, Position: book.SeriesIndex}},
+			}
+		}
+		for _, link := range opdsBookLinks(book) {
+			converted := opds2Link{Rel: link.Rel, Href: link.Href, Type: link.Type}
+			if link.Rel == relAcquisition {
+				publication.Links = append(publication.Links, converted)
+			} else if link.Rel == relImage {
+				converted.Rel = ""
+				publication.Images = append(publication.Images, converted)
+			}
+		}
+		out.Publications = append(out.Publications, publication)
+	}
+	return out
+}
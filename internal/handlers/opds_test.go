@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"bookify/internal/db"
+	"bookify/internal/testutil"
+
+	"github.com/labstack/echo/v4"
+)
+
+func setupOPDS(t *testing.T) (*echo.Echo, *db.Service) {
+	t.Helper()
+
+	testDB := testutil.SetupTestDB(t)
+	err := testDB.AutoMigrate(&db.Account{}, &db.Job{}, &db.Book{})
+	if err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	dbService := db.NewService(testDB)
+	handlers := &Handlers{DB: dbService}
+
+	home, _ := dbService.CreateAccount("Home", "folder1")
+	work, _ := dbService.CreateAccount("Work", "folder2")
+	if msg := setOPDSLogin(dbService, home, "reader", "secret"); msg != "" {
+		t.Fatalf("setOPDSLogin() = %q", msg)
+	}
+	if err := dbService.UpdateAccount(home); err != nil {
+		t.Fatalf("Failed to save account: %v", err)
+	}
+
+	day := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	books := []db.Book{
+		{AccountID: home.ID, Title: "Dune", Author: "Frank Herbert", Series: "Dune", SeriesIndex: 1, DriveFileID: "file-1", Filename: "Dune.kepub.epub", DeliveredAt: day},
+		{AccountID: home.ID, Title: "Dune Messiah", Author: "Frank Herbert", Series: "Dune", SeriesIndex: 2, HasCover: true, DeliveredAt: day.AddDate(0, 0, 1)},
+		{AccountID: home.ID, Title: "Emma", Author: "Jane Austen", Description: "Handsome, clever & rich.", DeliveredAt: day.AddDate(0, 0, 2)},
+		{AccountID: work.ID, Title: "Work Manual", Author: "Someone Else", DeliveredAt: day.AddDate(0, 0, 3)},
+	}
+	for i := range books {
+		books[i].JobID = fmt.Sprintf("job-%d", i)
+		if err := dbService.CreateBook(&books[i]); err != nil {
+			t.Fatalf("CreateBook() failed: %v", err)
+		}
+	}
+
+	e := echo.New()
+	opds := e.Group("/opds", handlers.OPDSAuth())
+	opds.GET("", handlers.OPDSRoot)
+	opds.GET("/books", handlers.OPDSBooks)
+	opds.GET("/authors", handlers.OPDSAuthors)
+	opds.GET("/series", handlers.OPDSSeries)
+	opds.GET("/opensearch.xml", handlers.OPDSOpenSearch)
+	opds.GET("/books/:id/file", handlers.OPDSDownload)
+	opds.GET("/v2", handlers.OPDSRoot)
+	opds.GET("/v2/books", handlers.OPDSBooks)
+	return e, dbService
+}
+
+func opdsRequest(e *echo.Echo, target, username, password string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandlers_OPDSAuth(t *testing.T) {
+	e, _ := setupOPDS(t)
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		status   int
+	}{
+		{name: "no credentials", status: http.StatusUnauthorized},
+		{name: "wrong password", username: "reader", password: "guess", status: http.StatusUnauthorized},
+		{name: "unknown user", username: "nobody", password: "secret", status: http.StatusUnauthorized},
+		{name: "valid login", username: "reader", password: "secret", status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := opdsRequest(e, "/opds", tt.username, tt.password)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.status == http.StatusUnauthorized && !strings.HasPrefix(strings.ToLower(rec.Header().Get("WWW-Authenticate")), "basic") {
+				t.Errorf("missing Basic auth challenge")
+			}
+		})
+	}
+}
+
+func TestHandlers_OPDSAtom(t *testing.T) {
+	e, _ := setupOPDS(t)
+
+	rec := opdsRequest(e, "/opds", "reader", "secret")
+	if !strings.Contains(rec.Header().Get(echo.HeaderContentType), "kind=navigation") {
+		t.Errorf("root Content-Type = %q, want a navigation feed", rec.Header().Get(echo.HeaderContentType))
+	}
+	for _, want := range []string{`href="/opds/books"`, `href="/opds/authors"`, `href="/opds/series"`, `rel="search"`} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("root feed missing %s", want)
+		}
+	}
+
+	rec = opdsRequest(e, "/opds/books", "reader", "secret")
+	var feed atomFeed
+	if err := xml.Unmarshal(rec.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("books feed is not valid XML: %v\n%s", err, rec.Body.String())
+	}
+	var titles []string
+	for _, entry := range feed.Entries {
+		titles = append(titles, entry.Title)
+	}
+	if strings.Join(titles, ",") != "Emma,Dune Messiah,Dune" {
+		t.Errorf("books feed = %v, want this account's books newest first", titles)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`<link rel="http://opds-spec.org/acquisition" href="/opds/books/1/file" type="application/epub+zip"`,
+		`href="/opds/books/2/cover"`,
+		"Handsome, clever &amp; rich.",
+		"Dune #2",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("books feed missing %s", want)
+		}
+	}
+
+	rec = opdsRequest(e, "/opds/books?series=Dune", "reader", "secret")
+	if !strings.Contains(rec.Body.String(), "<title>Dune</title>") || strings.Contains(rec.Body.String(), "Emma") {
+		t.Errorf("series feed = %s", rec.Body.String())
+	}
+
+	rec = opdsRequest(e, "/opds/authors", "reader", "secret")
+	if !strings.Contains(rec.Body.String(), `href="/opds/books?author=Jane+Austen"`) || strings.Contains(rec.Body.String(), "Someone Else") {
+		t.Errorf("authors feed = %s", rec.Body.String())
+	}
+
+	rec = opdsRequest(e, "/opds/opensearch.xml", "reader", "secret")
+	if !strings.Contains(rec.Body.String(), `template="http://example.com/opds/books?q={searchTerms}"`) {
+		t.Errorf("OpenSearch description = %s", rec.Body.String())
+	}
+}
+
+func TestHandlers_OPDSJSON(t *testing.T) {
+	e, _ := setupOPDS(t)
+
+	rec := opdsRequest(e, "/opds/v2/books?q=dune", "reader", "secret")
+	if rec.Header().Get(echo.HeaderContentType) != opdsJSONType {
+		t.Errorf("Content-Type = %q", rec.Header().Get(echo.HeaderContentType))
+	}
+
+	var feed opds2Feed
+	if err := json.Unmarshal(rec.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("feed is not valid JSON: %v", err)
+	}
+	if feed.Metadata.NumberOfItems != 2 || len(feed.Publications) != 2 {
+		t.Fatalf("feed has %d of %d publications, want 2", len(feed.Publications), feed.Metadata.NumberOfItems)
+	}
+	dune := feed.Publications[1]
+	if dune.Metadata.BelongsTo == nil || dune.Metadata.BelongsTo.Series[0].Position != 1 {
+		t.Errorf("publication series = %+v", dune.Metadata.BelongsTo)
+	}
+	if len(dune.Links) != 1 || dune.Links[0].Href != "/opds/books/1/file" {
+		t.Errorf("publication links = %+v", dune.Links)
+	}
+
+	rec = opdsRequest(e, "/opds/v2", "reader", "secret")
+	if !strings.Contains(rec.Body.String(), `"href":"/opds/v2/authors"`) {
+		t.Errorf("root feed = %s", rec.Body.String())
+	}
+}
+
+func TestHandlers_OPDSDownload_OtherAccount(t *testing.T) {
+	e, _ := setupOPDS(t)
+
+	// Book 4 belongs to an account without this login
+	rec := opdsRequest(e, "/opds/books/4/file", "reader", "secret")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", rec.Code)
+	}
+}
+
+func TestSetOPDSLogin(t *testing.T) {
+	_, dbService := setupOPDS(t)
+	work, _ := dbService.GetAccountByName("Work")
+
+	if msg := setOPDSLogin(dbService, work, "reader", "other"); msg == "" {
+		t.Errorf("setOPDSLogin() accepted a username used by another account")
+	}
+	if msg := setOPDSLogin(dbService, work, "worker", ""); msg == "" {
+		t.Errorf("setOPDSLogin() accepted a new login without a password")
+	}
+	if msg := setOPDSLogin(dbService, work, "worker", "pass"); msg != "" || work.OPDSPasswordHash == "" || work.OPDSPasswordHash == "pass" {
+		t.Errorf("setOPDSLogin() = %q, hash %q", msg, work.OPDSPasswordHash)
+	}
+	if msg := setOPDSLogin(dbService, work, " ", ""); msg != "" || work.OPDSUsername != "" || work.OPDSPasswordHash != "" {
+		t.Errorf("clearing the username should turn the catalogue off")
+	}
+
+	// A login taken between the check and the save is still refused
+	work.OPDSUsername = "reader"
+	if err := dbService.UpdateAccount(work); !errors.Is(err, db.ErrOPDSUsernameTaken) {
+		t.Errorf("UpdateAccount() with a taken login = %v, want ErrOPDSUsernameTaken", err)
+	}
+}
@@ -0,0 +1,325 @@
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"bookify/internal/db"
+
+	"github.com/labstack/echo/v4"
+)
+
+// apiRoute is an endpoint of the v1 API. The same table registers the
+// routes and describes them in the OpenAPI document.
+type apiRoute struct {
+	Method  string
+	Path    string
+	Summary string
+	// Scope an API token needs for the route
+	Scope   string
+	Handler echo.HandlerFunc
+	Params  []apiParam
+	// Form fields of the request body, for uploads
+	Form []apiParam
+	// Status and data of a successful response; a slice is a paginated list
+	Status   int
+	Response interface{}
+	Errors   []int
+}
+
+type apiParam struct {
+	Name        string
+	In          string
+	Type        string
+	Description string
+	Required    bool
+	Enum        []string
+}
+
+var (
+	pathIDParam  = apiParam{Name: "id", In: "path", Type: "string", Required: true}
+	pageParams   = []apiParam{{Name: "page", In: "query", Type: "integer", Description: "Page number, from 1"}, {Name: "per_page", In: "query", Type: "integer", Description: "Items per page, at most 100"}}
+	accountParam = apiParam{Name: "account_id", In: "query", Type: "integer", Description: "Only this account's"}
+)
+
+func (h *Handlers) apiRoutes() []apiRoute {
+	return []apiRoute{
+		{
+			Method: http.MethodGet, Path: "/jobs", Summary: "List jobs, newest first", Scope: db.ScopeRead,
+			Handler: h.APIListJobs,
+			Params: append([]apiParam{
+				accountParam,
+				{Name: "batch_id", In: "query", Type: "string", Description: "Only the jobs of this batch"},
+				{Name: "status", In: "query", Type: "string", Enum: db.JobStatuses},
+			}, pageParams...),
+			Status: http.StatusOK, Response: []apiJob{}, Errors: []int{http.StatusBadRequest},
+		},
+		{
+			Method: http.MethodGet, Path: "/jobs/:id", Summary: "Get a job", Scope: db.ScopeRead,
+			Handler: h.APIGetJob, Params: []apiParam{pathIDParam},
+			Status: http.StatusOK, Response: apiJob{}, Errors: []int{http.StatusNotFound},
+		},
+		{
+			Method: http.MethodPost, Path: "/jobs/:id/cancel", Summary: "Cancel a queued job", Scope: db.ScopeUpload,
+			Handler: h.APICancelJob, Params: []apiParam{pathIDParam},
+			Status: http.StatusOK, Response: apiJob{}, Errors: []int{http.StatusNotFound, http.StatusConflict},
+		},
+		{
+			Method: http.MethodPost, Path: "/jobs/:id/retry", Summary: "Queue a failed or cancelled job again", Scope: db.ScopeUpload,
+			Handler: h.APIRetryJob, Params: []apiParam{pathIDParam},
+			Status: http.StatusOK, Response: apiJob{}, Errors: []int{http.StatusNotFound, http.StatusConflict},
+		},
+		{
+			Method: http.MethodGet, Path: "/accounts", Summary: "List accounts", Scope: db.ScopeRead,
+			Handler: h.APIListAccounts,
+			Status:  http.StatusOK, Response: []apiAccount{},
+		},
+		{
+			Method: http.MethodGet, Path: "/accounts/:id", Summary: "Get an account", Scope: db.ScopeRead,
+			Handler: h.APIGetAccount, Params: []apiParam{{Name: "id", In: "path", Type: "integer", Required: true}},
+			Status: http.StatusOK, Response: apiAccount{}, Errors: []int{http.StatusNotFound},
+		},
+		{
+			Method: http.MethodGet, Path: "/batches", Summary: "List upload batches, newest first", Scope: db.ScopeRead,
+			Handler: h.APIListBatches, Params: append([]apiParam{accountParam}, pageParams...),
+			Status: http.StatusOK, Response: []apiBatch{}, Errors: []int{http.StatusBadRequest},
+		},
+		{
+			Method: http.MethodGet, Path: "/batches/:id", Summary: "Get an upload batch with its progress", Scope: db.ScopeRead,
+			Handler: h.APIGetBatch, Params: []apiParam{pathIDParam},
+			Status: http.StatusOK, Response: apiBatch{}, Errors: []int{http.StatusNotFound},
+		},
+		{
+			Method: http.MethodPost, Path: "/uploads", Summary: "Upload books or add them by URL, in a new batch", Scope: db.ScopeUpload,
+			Handler: h.APIUpload,
+			Form: []apiParam{
+				{Name: "account_id", Type: "integer", Required: true},
+				{Name: "files", Type: "file", Description: "Books, or ZIP archives of books; repeatable"},
+				{Name: "url", Type: "string", Description: "Link to a book to download; repeatable"},
+				{Name: "title", Type: "string", Description: "Title for text, Markdown and HTML files"},
+				{Name: "author", Type: "string", Description: "Author for text, Markdown and HTML files"},
+				{Name: "force", Type: "boolean", Description: "Convert files even if they were converted before"},
+			},
+			Status: http.StatusCreated, Response: apiBatch{},
+			Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusUnprocessableEntity},
+		},
+	}
+}
+
+var echoPathParam = regexp.MustCompile(`:(\w+)`)
+
+// openAPIDocument describes the routes, with schemas generated from the
+// types they return.
+func openAPIDocument(routes []apiRoute) map[string]interface{} {
+	schemas := map[string]interface{}{}
+	paths := map[string]map[string]interface{}{}
+
+	errorSchema := schemaFor(reflect.TypeOf(struct {
+		Error apiErrorBody `json:"error"`
+	}{}), schemas)
+	schemas["Error"] = errorSchema
+	errorRef := map[string]interface{}{"$ref": "#/components/schemas/Error"}
+
+	for _, route := range routes {
+		data := schemaFor(reflect.TypeOf(route.Response), schemas)
+		body := map[string]interface{}{"data": data}
+		if reflect.TypeOf(route.Response).Kind() == reflect.Slice {
+			body["pagination"] = schemaFor(reflect.TypeOf(apiPagination{}), schemas)
+		}
+		responses := map[string]interface{}{
+			strconv.Itoa(route.Status): map[string]interface{}{
+				"description": http.StatusText(route.Status),
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{
+						"schema": objectSchema(body),
+					},
+				},
+			},
+		}
+		statuses := append(route.Errors, http.StatusUnauthorized, http.StatusForbidden, http.StatusInternalServerError)
+		for _, status := range statuses {
+			responses[strconv.Itoa(status)] = map[string]interface{}{
+				"description": http.StatusText(status),
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": errorRef},
+				},
+			}
+		}
+
+		operation := map[string]interface{}{
+			"summary":     route.Summary,
+			"description": "Needs an API token with the " + route.Scope + " or admin scope.",
+			"operationId": operationID(route),
+			"responses":   responses,
+			"security":    []interface{}{map[string]interface{}{"bearerAuth": []string{}}},
+		}
+		var params []interface{}
+		for _, param := range route.Params {
+			schema := map[string]interface{}{"type": param.Type}
+			if len(param.Enum) > 0 {
+				schema["enum"] = param.Enum
+			}
+			p := map[string]interface{}{"name": param.Name, "in": param.In, "required": param.Required, "schema": schema}
+			if param.Description != "" {
+				p["description"] = param.Description
+			}
+			params = append(params, p)
+		}
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+		if len(route.Form) > 0 {
+			operation["requestBody"] = formBody(route.Form)
+		}
+
+		path := echoPathParam.ReplaceAllString(route.Path, "{$1}")
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+		paths[path][strings.ToLower(route.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Bookify API",
+			"version": "1.0.0",
+		},
+		"servers": []interface{}{map[string]interface{}{"url": "/api/v1"}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+func formBody(fields []apiParam) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+	for _, field := range fields {
+		schema := map[string]interface{}{"type": field.Type}
+		if field.Type == "file" {
+			schema = map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string", "format": "binary"}}
+		}
+		if field.Description != "" {
+			schema["description"] = field.Description
+		}
+		properties[field.Name] = schema
+		if field.Required {
+			required = append(required, field.Name)
+		}
+	}
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return map[string]interface{}{
+		"required": true,
+		"content": map[string]interface{}{
+			"multipart/form-data":               map[string]interface{}{"schema": schema},
+			"application/x-www-form-urlencoded": map[string]interface{}{"schema": schema},
+		},
+	}
+}
+
+// objectSchema describes an object whose properties are always present.
+func objectSchema(properties map[string]interface{}) map[string]interface{} {
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(properties) > 0 {
+		required := make([]string, 0, len(properties))
+		for name := range properties {
+			required = append(required, name)
+		}
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
+
+// operationID names an operation after its method and path, such as
+// postJobsIdCancel.
+func operationID(route apiRoute) string {
+	id := strings.ToLower(route.Method)
+	for _, part := range strings.Split(route.Path, "/") {
+		part = strings.TrimPrefix(part, ":")
+		if part != "" {
+			id += string(unicode.ToUpper(rune(part[0]))) + part[1:]
+		}
+	}
+	return id
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaFor describes a Go type as the encoding/json package writes it.
+// Named structs become shared schemas, referenced by name.
+func schemaFor(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Ptr:
+		schema := schemaFor(t.Elem(), schemas)
+		if _, ref := schema["$ref"]; !ref {
+			schema["nullable"] = true
+		}
+		return schema
+	case t.Kind() == reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem(), schemas)}
+	case t.Kind() == reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case t.Kind() == reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case t.Kind() != reflect.Struct:
+		return map[string]interface{}{"type": "object"}
+	}
+
+	name := schemaName(t)
+	if name != "" {
+		if _, done := schemas[name]; done {
+			return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+		}
+		// Placeholder for types that refer to themselves
+		schemas[name] = map[string]interface{}{}
+	}
+	properties := map[string]interface{}{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("json"), ",")[0]
+		if !field.IsExported() || tag == "-" {
+			continue
+		}
+		if tag == "" {
+			tag = field.Name
+		}
+		properties[tag] = schemaFor(field.Type, schemas)
+	}
+	schema := objectSchema(properties)
+	if name == "" {
+		return schema
+	}
+	schemas[name] = schema
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+// schemaName is a named type's name without the api prefix, such as Job for
+// apiJob.
+func schemaName(t reflect.Type) string {
+	name := strings.TrimPrefix(t.Name(), "api")
+	if name == "" {
+		return ""
+	}
+	return string(unicode.ToUpper(rune(name[0]))) + name[1:]
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strings"
+
+	"bookify/internal/db"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ProxyAuth trusts a reverse proxy that signs users in, such as Authelia or
+// oauth2-proxy, to name the user in a header.
+type ProxyAuth struct {
+	// Header naming the user, such as Remote-User or X-Forwarded-Email
+	Header string
+	// Proxies allowed to set the header; it's ignored from anywhere else
+	Trusted []*net.IPNet
+	// Header listing the user's groups, comma-separated, and the groups
+	// whose members are admins
+	GroupsHeader string
+	AdminGroups  []string
+}
+
+// NewProxyAuth trusts the header from the proxies, given as CIDRs or single
+// addresses.
+func NewProxyAuth(header string, trusted []string) (*ProxyAuth, error) {
+	if header == "" {
+		return nil, errors.New("no header to trust")
+	}
+	auth := &ProxyAuth{Header: header}
+	for _, cidr := range trusted {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy address %q", cidr)
+			}
+			if v4 := ip.To4(); v4 != nil {
+				ip = v4
+			}
+			auth.Trusted = append(auth.Trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy address %q", cidr)
+		}
+		auth.Trusted = append(auth.Trusted, network)
+	}
+	if len(auth.Trusted) == 0 {
+		return nil, fmt.Errorf("no proxies to trust the %s header from", header)
+	}
+	return auth, nil
+}
+
+// trusts reports whether the request came straight from a trusted proxy.
+// Forwarded addresses don't count, as anyone can send them.
+func (p *ProxyAuth) trusts(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	return slices.ContainsFunc(p.Trusted, func(network *net.IPNet) bool { return network.Contains(ip) })
+}
+
+// proxyUser returns the user a trusted proxy signed in, adding them the
+// first time they're seen, or nil if the request didn't come through one.
+func (h *Handlers) proxyUser(c echo.Context) (*db.User, error) {
+	if h.ProxyAuth == nil {
+		return nil, nil
+	}
+	req := c.Request()
+	username := strings.TrimSpace(req.Header.Get(h.ProxyAuth.Header))
+	if username == "" {
+		return nil, nil
+	}
+	if !h.ProxyAuth.trusts(req.RemoteAddr) {
+		log.Printf("Warning: Ignoring %s header from untrusted address %s", h.ProxyAuth.Header, req.RemoteAddr)
+		return nil, nil
+	}
+
+	var admin *bool
+	if h.ProxyAuth.GroupsHeader != "" && len(h.ProxyAuth.AdminGroups) > 0 {
+		isAdmin := slices.ContainsFunc(strings.Split(req.Header.Get(h.ProxyAuth.GroupsHeader), ","), func(group string) bool {
+			return slices.Contains(h.ProxyAuth.AdminGroups, strings.TrimSpace(group))
+		})
+		admin = &isAdmin
+	}
+
+	user, err := h.DB.GetUserByUsername(username)
+	if err != nil {
+		role := db.RoleUser
+		if admin != nil && *admin {
+			role = db.RoleAdmin
+		}
+		user, err = h.DB.CreateUser(username, "", role)
+		if err != nil {
+			// Another request may have added them first
+			if existing, getErr := h.DB.GetUserByUsername(username); getErr == nil {
+				return existing, nil
+			}
+			return nil, fmt.Errorf("failed to create user: %w", err)
+		}
+		log.Printf("Created user %s (%s) from the %s header", user.Username, user.Role, h.ProxyAuth.Header)
+	}
+	if admin != nil {
+		h.syncRole(user, *admin)
+	}
+	return user, nil
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"bookify/internal/db"
+
+	"github.com/labstack/echo/v4"
+)
+
+func setupProxyAuth(t *testing.T) (*echo.Echo, *db.Service) {
+	t.Helper()
+
+	e, dbService := setupLogin(t)
+	proxyAuth, err := NewProxyAuth("Remote-User", []string{"10.0.0.0/8", "fd00::1"})
+	if err != nil {
+		t.Fatalf("NewProxyAuth() failed: %v", err)
+	}
+	proxyAuth.GroupsHeader = "Remote-Groups"
+	proxyAuth.AdminGroups = []string{"bookify-admins"}
+	handlers := &Handlers{DB: dbService, ProxyAuth: proxyAuth}
+	e.GET("/login", handlers.LoginPage)
+	app := e.Group("", handlers.RequireLogin)
+	app.GET("/", handlers.IndexPage)
+	app.GET("/api/queue", handlers.QueueStatusAPI)
+	users := app.Group("/users", handlers.RequireAdmin)
+	users.GET("", handlers.UsersPage)
+	return e, dbService
+}
+
+func proxied(e *echo.Echo, target, remoteAddr string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.RemoteAddr = remoteAddr
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandlers_ProxyAuth(t *testing.T) {
+	e, dbService := setupProxyAuth(t)
+	_, _ = dbService.CreateUser("admin", "", db.RoleAdmin)
+
+	// The first request from a trusted proxy adds the user
+	rec := proxied(e, "/api/queue", "10.1.2.3:5000", map[string]string{"Remote-User": "alice"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("trusted proxy = %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+	alice, err := dbService.GetUserByUsername("alice")
+	if err != nil || alice.Role != db.RoleUser {
+		t.Fatalf("alice = %+v, %v", alice, err)
+	}
+	proxied(e, "/", "10.1.2.3:5000", map[string]string{"Remote-User": "alice"})
+	if users, _ := dbService.ListUsers(); len(users) != 2 {
+		t.Errorf("%d users, want 2", len(users))
+	}
+	if rec := proxied(e, "/login?next=/library", "10.1.2.3:5000", map[string]string{"Remote-User": "alice"}); rec.Header().Get("Location") != "/library" {
+		t.Errorf("login page for a proxied user = %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+
+	// The groups header decides who's an admin
+	headers := map[string]string{"Remote-User": "alice", "Remote-Groups": "readers, bookify-admins"}
+	if rec := proxied(e, "/users", "[fd00::1]:5000", headers); rec.Code != http.StatusOK {
+		t.Errorf("users page for a proxied admin = %d", rec.Code)
+	}
+	if alice, _ := dbService.GetUser(alice.ID); alice.Role != db.RoleAdmin {
+		t.Errorf("alice's role = %s, want admin", alice.Role)
+	}
+	headers["Remote-Groups"] = "readers"
+	if rec := proxied(e, "/users", "10.1.2.3:5000", headers); rec.Code != http.StatusForbidden {
+		t.Errorf("users page after leaving the admins = %d", rec.Code)
+	}
+
+	// The header means nothing from anywhere else
+	for _, remoteAddr := range []string{"192.0.2.1:5000", "[fd00::2]:5000", "garbage"} {
+		rec := proxied(e, "/", remoteAddr, map[string]string{"Remote-User": "admin", "X-Forwarded-For": "10.1.2.3"})
+		if rec.Code != http.StatusFound {
+			t.Errorf("header from %s = %d, want a redirect to log in", remoteAddr, rec.Code)
+		}
+	}
+	if rec := proxied(e, "/api/queue", "10.1.2.3:5000", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("trusted proxy without the header = %d", rec.Code)
+	}
+	if _, err := dbService.GetUserByUsername(""); err == nil {
+		t.Error("an empty header added a user")
+	}
+}
+
+func TestNewProxyAuth(t *testing.T) {
+	tests := []struct {
+		header  string
+		trusted []string
+		valid   bool
+	}{
+		{"Remote-User", []string{"172.16.0.0/12", "127.0.0.1", "::1"}, true},
+		{"Remote-User", nil, false},
+		{"Remote-User", []string{"not-an-address"}, false},
+		{"Remote-User", []string{"10.0.0.0/33"}, false},
+		{"", []string{"127.0.0.1"}, false},
+	}
+	for _, tt := range tests {
+		_, err := NewProxyAuth(tt.header, tt.trusted)
+		if (err == nil) != tt.valid {
+			t.Errorf("NewProxyAuth(%q, %v) error = %v, want valid %v", tt.header, tt.trusted, err, tt.valid)
+		}
+	}
+
+	proxyAuth, _ := NewProxyAuth("Remote-User", []string{"127.0.0.1", "::1"})
+	for remoteAddr, want := range map[string]bool{"127.0.0.1:80": true, "[::1]:80": true, "127.0.0.2:80": false, "[::ffff:127.0.0.1]:80": true} {
+		if got := proxyAuth.trusts(remoteAddr); got != want {
+			t.Errorf("trusts(%s) = %v, want %v", remoteAddr, got, want)
+		}
+	}
+}
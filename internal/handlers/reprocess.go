@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"bookify/internal/db"
+	"bookify/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ReprocessBooks queues the chosen books, or every book of an account, to be
+// converted again from their retained originals.
+func (h *Handlers) ReprocessBooks(c echo.Context) error {
+	filter := parseBookFilter(c)
+	if h.Store == nil {
+		return h.renderLibrary(c, filter, "", "Reprocessing needs the local store to be enabled")
+	}
+
+	var bookIDs []uint
+	if c.FormValue("scope") == "account" {
+		accountID, err := strconv.ParseUint(c.FormValue("account_id"), 10, 32)
+		if err != nil {
+			return h.renderLibrary(c, filter, "", "Choose an account to reprocess")
+		}
+		bookIDs, err = h.userDB(c).ListBookIDs(uint(accountID))
+		if err != nil {
+			return err
+		}
+	} else {
+		form, err := c.FormParams()
+		if err != nil {
+			return h.renderLibrary(c, filter, "", "Invalid form")
+		}
+		for _, value := range form["book"] {
+			if id, err := strconv.ParseUint(value, 10, 32); err == nil {
+				bookIDs = append(bookIDs, uint(id))
+			}
+		}
+	}
+	if len(bookIDs) == 0 {
+		return h.renderLibrary(c, filter, "", "No books selected")
+	}
+
+	// Without a profile of its own, each job uses its account's settings
+	profile := ""
+	if c.FormValue("profile") == "custom" {
+		profile = services.ConversionProfile{
+			Smartypants:     c.FormValue("smartypants") == "1",
+			Hyphenate:       c.FormValue("hyphenate") == "1",
+			FullScreenFixes: c.FormValue("full_screen_fixes") == "1",
+		}.String()
+	}
+
+	queued, skipped, err := queueReprocess(h.userDB(c), h.Store, bookIDs, profile)
+	if err != nil {
+		return err
+	}
+	message := fmt.Sprintf("Queued %d book(s) for reprocessing", queued)
+	if skipped > 0 {
+		message += fmt.Sprintf("; skipped %d without a retained original or already queued", skipped)
+	}
+	return h.renderLibrary(c, filter, message, "")
+}
+
+// queueReprocess creates a reprocess job for each book whose original is
+// still in the store.
+func queueReprocess(dbService *db.Service, store *services.StoreService, bookIDs []uint, profile string) (int, int, error) {
+	queued, skipped := 0, 0
+	for _, id := range bookIDs {
+		book, err := dbService.GetBook(id)
+		if err != nil {
+			skipped++
+			continue
+		}
+		source, err := dbService.GetJob(book.JobID)
+		if err != nil || !store.Has(source.SourceHash) {
+			skipped++
+			continue
+		}
+		pending, err := dbService.HasPendingReprocess(book.ID)
+		if err != nil {
+			return queued, skipped, err
+		}
+		if pending {
+			skipped++
+			continue
+		}
+
+		job := &db.Job{
+			Type:             db.JobTypeReprocess,
+			BookID:           book.ID,
+			AccountID:        book.AccountID,
+			OriginalFilename: source.OriginalFilename,
+			Title:            source.Title,
+			Author:           source.Author,
+			Series:           source.Series,
+			SeriesIndex:      source.SeriesIndex,
+			Tags:             source.Tags,
+			Rating:           source.Rating,
+			SourceHash:       source.SourceHash,
+			Profile:          profile,
+		}
+		if err := dbService.QueueJob(job); err != nil {
+			return queued, skipped, fmt.Errorf("failed to queue reprocess job: %w", err)
+		}
+		queued++
+	}
+	if queued > 0 {
+		log.Printf("Queued %d book(s) for reprocessing", queued)
+	}
+	return queued, skipped, nil
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"bookify/internal/db"
+	"bookify/internal/services"
+	"bookify/internal/testutil"
+
+	"github.com/labstack/echo/v4"
+)
+
+func setupReprocess(t *testing.T) (*Handlers, *db.Service, []db.Book) {
+	t.Helper()
+
+	testDB := testutil.SetupTestDB(t)
+	err := testDB.AutoMigrate(&db.Account{}, &db.Job{}, &db.Book{}, &db.StoredFile{})
+	if err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	dbService := db.NewService(testDB)
+	store := services.NewStoreService(dbService, filepath.Join(t.TempDir(), "store"), services.StoreOptions{})
+
+	original := filepath.Join(t.TempDir(), "dune.epub")
+	_ = os.WriteFile(original, []byte("original content"), 0644)
+	sourceHash, err := store.Put(original)
+	if err != nil {
+		t.Fatalf("Put() failed: %v", err)
+	}
+
+	home, _ := dbService.CreateAccount("Home", "folder1")
+	work, _ := dbService.CreateAccount("Work", "folder2")
+
+	// Only the first book's original is still in the store
+	sources := []*db.Job{
+		{AccountID: home.ID, OriginalFilename: "dune.epub", SourceHash: sourceHash, Retained: true},
+		{AccountID: home.ID, OriginalFilename: "emma.epub", SourceHash: "gone"},
+		{AccountID: work.ID, OriginalFilename: "dune.epub", SourceHash: sourceHash, Retained: true},
+	}
+	var books []db.Book
+	for _, source := range sources {
+		if err := dbService.QueueJob(source); err != nil {
+			t.Fatalf("Failed to create job: %v", err)
+		}
+		source.Status = "completed"
+		_ = dbService.UpdateJob(source)
+
+		book := db.Book{JobID: source.ID, AccountID: source.AccountID, Title: source.OriginalFilename, DriveFileID: "file-" + source.ID}
+		if err := dbService.CreateBook(&book); err != nil {
+			t.Fatalf("CreateBook() failed: %v", err)
+		}
+		books = append(books, book)
+	}
+
+	return &Handlers{DB: dbService, Store: store}, dbService, books
+}
+
+func postReprocess(t *testing.T, handlers *Handlers, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/library/reprocess", strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	rec := httptest.NewRecorder()
+	if err := handlers.ReprocessBooks(e.NewContext(req, rec)); err != nil {
+		t.Fatalf("ReprocessBooks() error = %v", err)
+	}
+	return rec
+}
+
+func reprocessJobs(t *testing.T, dbService *db.Service) []db.Job {
+	t.Helper()
+
+	jobs, err := dbService.ListRecentJobs(50)
+	if err != nil {
+		t.Fatalf("ListRecentJobs() failed: %v", err)
+	}
+	var reprocess []db.Job
+	for _, job := range jobs {
+		if job.Type == db.JobTypeReprocess {
+			reprocess = append(reprocess, job)
+		}
+	}
+	return reprocess
+}
+
+func TestHandlers_ReprocessBooks_Selected(t *testing.T) {
+	handlers, dbService, books := setupReprocess(t)
+
+	form := url.Values{
+		"scope":       {"selected"},
+		"book":        {strconv.Itoa(int(books[0].ID)), strconv.Itoa(int(books[1].ID))},
+		"profile":     {"custom"},
+		"smartypants": {"1"},
+	}
+	rec := postReprocess(t, handlers, form)
+	testutil.AssertResponseContains(t, rec, "Queued 1 book(s) for reprocessing; skipped 1")
+
+	jobs := reprocessJobs(t, dbService)
+	if len(jobs) != 1 {
+		t.Fatalf("queued %d reprocess jobs, want 1", len(jobs))
+	}
+	job := jobs[0]
+	if job.BookID != books[0].ID || job.Status != "queued" || job.OriginalFilename != "dune.epub" || job.SourceHash == "" {
+		t.Errorf("reprocess job = %+v", job)
+	}
+	if profile := services.JobConversionProfile(&job); !profile.Smartypants || profile.Hyphenate {
+		t.Errorf("job profile = %+v, want smart punctuation only", profile)
+	}
+
+	// A book already waiting is not queued twice
+	rec = postReprocess(t, handlers, form)
+	testutil.AssertResponseContains(t, rec, "Queued 0 book(s)")
+}
+
+func TestHandlers_ReprocessBooks_Account(t *testing.T) {
+	handlers, dbService, books := setupReprocess(t)
+
+	rec := postReprocess(t, handlers, url.Values{
+		"scope":      {"account"},
+		"account_id": {strconv.Itoa(int(books[2].AccountID))},
+		"profile":    {"account"},
+	})
+	testutil.AssertResponseContains(t, rec, "Queued 1 book(s) for reprocessing")
+
+	jobs := reprocessJobs(t, dbService)
+	if len(jobs) != 1 || jobs[0].BookID != books[2].ID {
+		t.Fatalf("reprocess jobs = %+v, want one for the Work book", jobs)
+	}
+	if jobs[0].Profile != "" {
+		t.Errorf("Profile = %q, want the account settings", jobs[0].Profile)
+	}
+}
+
+func TestHandlers_ReprocessBooks_Errors(t *testing.T) {
+	handlers, _, books := setupReprocess(t)
+
+	rec := postReprocess(t, handlers, url.Values{"scope": {"selected"}})
+	testutil.AssertResponseContains(t, rec, "No books selected")
+
+	handlers.Store = nil
+	rec = postReprocess(t, handlers, url.Values{"book": {strconv.Itoa(int(books[0].ID))}})
+	testutil.AssertResponseContains(t, rec, "needs the local store")
+}
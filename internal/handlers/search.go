@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"bookify/internal/templates"
+
+	"github.com/labstack/echo/v4"
+)
+
+const searchPageSize = 20
+
+func (h *Handlers) SearchPage(c echo.Context) error {
+	view, err := h.search(c)
+	if err != nil {
+		return err
+	}
+	return render(c, templates.SearchPage(view))
+}
+
+// SearchAPI returns just the results, for updating the page as the user types.
+func (h *Handlers) SearchAPI(c echo.Context) error {
+	view, err := h.search(c)
+	if err != nil {
+		return err
+	}
+	return render(c, templates.SearchResults(view))
+}
+
+func (h *Handlers) search(c echo.Context) (templates.SearchView, error) {
+	query := strings.TrimSpace(c.QueryParam("q"))
+	page := 1
+	if p, err := strconv.Atoi(c.QueryParam("page")); err == nil && p > 1 {
+		page = p
+	}
+
+	view := templates.SearchView{Query: query}
+	if query == "" {
+		return view, nil
+	}
+
+	results, total, err := h.userDB(c).SearchBooks(query, searchPageSize, (page-1)*searchPageSize)
+	if err != nil {
+		return view, err
+	}
+	view.Results = results
+	view.Total = total
+	if page > 1 {
+		view.PrevURL = searchPageURL(query, page-1)
+	}
+	if int64(page*searchPageSize) < total {
+		view.NextURL = searchPageURL(query, page+1)
+	}
+	return view, nil
+}
+
+func searchPageURL(query string, page int) string {
+	return "/search?" + url.Values{"q": {query}, "page": {strconv.Itoa(page)}}.Encode()
+}
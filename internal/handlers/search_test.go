@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"bookify/internal/db"
+	"bookify/internal/testutil"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestHandlers_SearchPage(t *testing.T) {
+	testDB := testutil.SetupTestDB(t)
+	err := testDB.AutoMigrate(&db.Account{}, &db.Job{}, &db.Book{}, &db.BookContent{})
+	if err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+
+	dbService := db.NewService(testDB)
+	handlers := &Handlers{DB: dbService}
+
+	account, err := dbService.CreateAccount("test-account", "folder-123")
+	if err != nil {
+		t.Fatalf("Failed to create test account: %v", err)
+	}
+	book := &db.Book{JobID: "job-1", AccountID: account.ID, Title: "Markup Book", DeliveredAt: time.Now()}
+	if err := dbService.CreateBook(book); err != nil {
+		t.Fatalf("Failed to create book: %v", err)
+	}
+	if err := dbService.IndexBook(book, "The <script>tag</script> appears in the text."); err != nil {
+		t.Fatalf("Failed to index book: %v", err)
+	}
+
+	e := echo.New()
+	for _, target := range []string{"/search?q=tag", "/api/search?q=tag"} {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		handler := handlers.SearchPage
+		if strings.HasPrefix(target, "/api/") {
+			handler = handlers.SearchAPI
+		}
+		if err := handler(c); err != nil {
+			t.Fatalf("%s error = %v", target, err)
+		}
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s status = %d, want 200", target, rec.Code)
+		}
+
+		body := rec.Body.String()
+		for _, want := range []string{"1 books", "Markup Book", "&lt;script&gt;", "<mark", "tag</mark>"} {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s body missing %q", target, want)
+			}
+		}
+		if strings.Contains(body, "<script>tag") {
+			t.Errorf("%s did not escape the snippet", target)
+		}
+	}
+}
@@ -16,7 +16,19 @@ import (
 type Handlers struct {
 	DB      *db.Service
 	Drive   *services.DriveService
+	Covers  *services.CoverService
+	Store   *services.StoreService
 	TempDir string
+	// Calibre library folder books can be imported from, if any
+	CalibreLibrary string
+	// Directory non-admins may pick hot folders inside, if any
+	HotFolderRoot string
+	// Limits for books added by URL
+	Fetch services.FetchOptions
+	// Sign-in with an OpenID Connect provider, if configured
+	OIDC *OIDCLogin
+	// Users named by a trusted reverse proxy, if configured
+	ProxyAuth *ProxyAuth
 }
 
 func render(c echo.Context, template templ.Component) error {
@@ -24,7 +36,7 @@ func render(c echo.Context, template templ.Component) error {
 }
 
 func (h *Handlers) IndexPage(c echo.Context) error {
-	accounts, err := h.DB.ListAccounts()
+	accounts, err := h.userDB(c).ListAccounts()
 	if err != nil {
 		return err
 	}
@@ -33,12 +45,12 @@ func (h *Handlers) IndexPage(c echo.Context) error {
 		return c.Redirect(http.StatusFound, "/setup")
 	}
 
-	jobs, err := h.DB.ListRecentJobs(50)
+	jobs, err := h.userDB(c).ListRecentJobs(50)
 	if err != nil {
 		return err
 	}
 
-	return render(c, templates.MainPage(accounts, jobs))
+	return render(c, templates.MainPage(currentUser(c), accounts, jobs))
 }
 
 func (h *Handlers) SetupPage(c echo.Context) error {
@@ -54,7 +66,7 @@ func (h *Handlers) CreateAccount(c echo.Context) error {
 	}
 
 	// Check if account name already exists
-	_, err := h.DB.GetAccountByName(name)
+	_, err := h.userDB(c).GetAccountByName(name)
 	if err == nil {
 		return render(c, templates.SetupPageWithError("An account with this name already exists"))
 	}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"bookify/internal/db"
+	"bookify/internal/templates"
+
+	"github.com/labstack/echo/v4"
+)
+
+const apiTokenKey = "apiToken"
+
+// RequireAPIToken lets a request through if it carries an unexpired bearer
+// token with the scope. The request acts as the token's user.
+func (h *Handlers) RequireAPIToken(scope string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			secret, ok := bearerToken(c.Request())
+			if !ok {
+				c.Response().Header().Set("WWW-Authenticate", `Bearer realm="bookify"`)
+				return apiError(c, http.StatusUnauthorized, "An API token is required in the Authorization header")
+			}
+			now := time.Now()
+			token, err := h.DB.GetAPITokenBySecret(secret)
+			if err != nil || token.Expired(now) || token.User.ID == 0 {
+				c.Response().Header().Set("WWW-Authenticate", `Bearer realm="bookify", error="invalid_token"`)
+				return apiError(c, http.StatusUnauthorized, "The API token is invalid, revoked or expired")
+			}
+			if !token.HasScope(scope) {
+				return apiError(c, http.StatusForbidden, fmt.Sprintf("The API token lacks the %s scope", scope))
+			}
+			if err := h.DB.TouchAPIToken(token, now); err != nil {
+				log.Printf("Warning: Failed to record use of API token %d: %v", token.ID, err)
+			}
+			c.Set(apiTokenKey, token)
+			c.Set(userKey, &token.User)
+			return next(c)
+		}
+	}
+}
+
+// RequireLoginOrAPIToken is RequireAPIToken for requests with a bearer
+// token, so scripts can use the routes, and RequireLogin for the rest.
+func (h *Handlers) RequireLoginOrAPIToken(scope string) echo.MiddlewareFunc {
+	requireToken := h.RequireAPIToken(scope)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		withToken, withLogin := requireToken(next), h.RequireLogin(next)
+		return func(c echo.Context) error {
+			if _, ok := bearerToken(c.Request()); ok {
+				return withToken(c)
+			}
+			return withLogin(c)
+		}
+	}
+}
+
+func bearerToken(req *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(req.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+func (h *Handlers) TokensPage(c echo.Context) error {
+	return h.renderTokens(c, templates.TokensView{})
+}
+
+func (h *Handlers) CreateAPIToken(c echo.Context) error {
+	name := strings.TrimSpace(c.FormValue("name"))
+	if name == "" {
+		return h.renderTokens(c, templates.TokensView{Error: "Token name is required"})
+	}
+	form, err := c.FormParams()
+	if err != nil {
+		return h.renderTokens(c, templates.TokensView{Error: "Failed to parse form"})
+	}
+	scopes := form["scope"]
+	if len(scopes) == 0 {
+		return h.renderTokens(c, templates.TokensView{Error: "Choose at least one scope"})
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(db.APIScopes, scope) {
+			return h.renderTokens(c, templates.TokensView{Error: "Unknown scope " + scope})
+		}
+	}
+
+	var expiresAt *time.Time
+	if value := c.FormValue("expires_days"); value != "" && value != "0" {
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 0 {
+			return h.renderTokens(c, templates.TokensView{Error: "Invalid expiry"})
+		}
+		expiry := time.Now().AddDate(0, 0, days)
+		expiresAt = &expiry
+	}
+
+	secret, _, err := h.userDB(c).CreateAPIToken(name, scopes, expiresAt)
+	if err != nil {
+		return h.renderTokens(c, templates.TokensView{Error: "Failed to create token"})
+	}
+	return h.renderTokens(c, templates.TokensView{
+		Message:  fmt.Sprintf("Created %s. Copy the token now; it won't be shown again.", name),
+		NewToken: secret,
+	})
+}
+
+func (h *Handlers) RevokeAPIToken(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return c.Redirect(http.StatusFound, "/tokens")
+	}
+	if err := h.userDB(c).DeleteAPIToken(uint(id)); err != nil {
+		return h.renderTokens(c, templates.TokensView{Error: "Failed to revoke token"})
+	}
+	return h.renderTokens(c, templates.TokensView{Message: "Token revoked"})
+}
+
+func (h *Handlers) renderTokens(c echo.Context, view templates.TokensView) error {
+	tokens, err := h.userDB(c).ListAPITokens()
+	if err != nil {
+		return err
+	}
+	view.Tokens = tokens
+	return render(c, templates.TokensPage(view))
+}
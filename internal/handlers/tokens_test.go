@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"bookify/internal/db"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestRequireAPIToken(t *testing.T) {
+	e, _, dbService, _, admin := setupAPIv1(t)
+	user, _ := dbService.GetUserByUsername("admin")
+
+	readOnly, readToken, _ := dbService.ForUser(user).CreateAPIToken("reader", []string{db.ScopeRead}, nil)
+	past := time.Now().Add(-time.Hour)
+	expired, _, _ := dbService.ForUser(user).CreateAPIToken("old", []string{db.ScopeAdmin}, &past)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/jobs", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized || !strings.HasPrefix(rec.Header().Get("WWW-Authenticate"), "Bearer") {
+		t.Errorf("no token = %d %v", rec.Code, rec.Header())
+	}
+	status, body := apiCall(t, e, "", http.MethodGet, "/api/v1/jobs", nil, "")
+	assertAPIError(t, status, body, http.StatusUnauthorized, "unauthorized")
+	status, body = apiCall(t, e, "bk_not-a-token", http.MethodGet, "/api/v1/jobs", nil, "")
+	assertAPIError(t, status, body, http.StatusUnauthorized, "unauthorized")
+	status, body = apiCall(t, e, expired, http.MethodGet, "/api/v1/jobs", nil, "")
+	assertAPIError(t, status, body, http.StatusUnauthorized, "unauthorized")
+
+	// Scopes
+	status, _ = apiCall(t, e, readOnly, http.MethodGet, "/api/v1/jobs", nil, "")
+	if status != http.StatusOK {
+		t.Errorf("read token listing jobs = %d", status)
+	}
+	status, body = apiCall(t, e, readOnly, http.MethodPost, "/api/v1/uploads", strings.NewReader("account_id=1"), echo.MIMEApplicationForm)
+	assertAPIError(t, status, body, http.StatusForbidden, "forbidden")
+	status, body = apiCall(t, e, admin, http.MethodPost, "/api/v1/uploads", strings.NewReader("account_id=1"), echo.MIMEApplicationForm)
+	assertAPIError(t, status, body, http.StatusBadRequest, "invalid_request")
+
+	tokens, _ := dbService.ListAPITokens()
+	for _, token := range tokens {
+		if token.ID == readToken.ID && token.LastUsedAt == nil {
+			t.Error("the token's last use wasn't recorded")
+		}
+	}
+
+	// Revoked tokens stop working
+	if err := dbService.DeleteAPIToken(readToken.ID); err != nil {
+		t.Fatalf("Failed to revoke token: %v", err)
+	}
+	status, body = apiCall(t, e, readOnly, http.MethodGet, "/api/v1/jobs", nil, "")
+	assertAPIError(t, status, body, http.StatusUnauthorized, "unauthorized")
+
+	// The API description is public
+	status, _ = apiCall(t, e, "", http.MethodGet, "/api/v1/openapi.json", nil, "")
+	if status != http.StatusOK {
+		t.Errorf("openapi.json without a token = %d", status)
+	}
+}
+
+func TestRequireLoginOrAPIToken(t *testing.T) {
+	e, handlers, dbService, _, admin := setupAPIv1(t)
+	e.GET("/api/queue", handlers.QueueStatusAPI, handlers.RequireLoginOrAPIToken(db.ScopeRead))
+	user, _ := dbService.GetUserByUsername("admin")
+	uploadOnly, _, _ := dbService.ForUser(user).CreateAPIToken("uploader", []string{db.ScopeUpload}, nil)
+
+	tests := []struct {
+		token string
+		want  int
+	}{
+		{"", http.StatusUnauthorized},
+		{"bk_not-a-token", http.StatusUnauthorized},
+		{uploadOnly, http.StatusForbidden},
+		{admin, http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/queue", nil)
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("queue with token %q = %d, want %d", tt.token, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestHandlers_APITokens(t *testing.T) {
+	e, handlers, dbService, _, _ := setupAPIv1(t)
+	e.POST("/tokens", handlers.CreateAPIToken)
+	e.POST("/tokens/:id/revoke", handlers.RevokeAPIToken)
+
+	post := func(target string, form url.Values) string {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+
+	body := post("/tokens", url.Values{"name": {"scripts"}, "scope": {db.ScopeUpload, db.ScopeRead}, "expires_days": {"30"}})
+	secret := regexp.MustCompile(`bk_[0-9a-f]{48}`).FindString(body)
+	if secret == "" {
+		t.Fatalf("the new token wasn't shown: %s", body)
+	}
+	token, err := dbService.GetAPITokenBySecret(secret)
+	if err != nil || token.Name != "scripts" || !token.HasScope(db.ScopeUpload) || token.HasScope(db.ScopeAdmin) || token.ExpiresAt == nil {
+		t.Fatalf("token = %+v, %v", token, err)
+	}
+	if token.Hash == secret || strings.Contains(token.Hash, secret[len("bk_"):]) {
+		t.Error("the token was stored in the clear")
+	}
+
+	if body := post("/tokens", url.Values{"name": {"scripts"}}); !strings.Contains(body, "Choose at least one scope") {
+		t.Errorf("token without scopes: %s", body)
+	}
+	if body := post("/tokens", url.Values{"name": {"x"}, "scope": {"root"}}); !strings.Contains(body, "Unknown scope") {
+		t.Errorf("token with an unknown scope: %s", body)
+	}
+
+	// The secret is only shown once
+	req := httptest.NewRequest(http.MethodGet, "/tokens", nil)
+	rec := httptest.NewRecorder()
+	if err := handlers.TokensPage(e.NewContext(req, rec)); err != nil {
+		t.Fatalf("TokensPage() error = %v", err)
+	}
+	if strings.Contains(rec.Body.String(), secret) || !strings.Contains(rec.Body.String(), token.Prefix) {
+		t.Error("the tokens page should show the prefix only")
+	}
+
+	post(fmt.Sprintf("/tokens/%d/revoke", token.ID), nil)
+	if _, err := dbService.GetAPITokenBySecret(secret); err == nil {
+		t.Error("the token wasn't revoked")
+	}
+}
@@ -1,13 +1,20 @@
 package handlers
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"log"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 
+	"bookify/internal/db"
+	"bookify/internal/services"
 	"bookify/internal/templates"
 
 	"github.com/labstack/echo/v4"
@@ -24,7 +31,7 @@ func (h *Handlers) UploadHandler(c echo.Context) error {
 		return render(c, templates.UploadError("Invalid account ID"))
 	}
 
-	account, err := h.DB.GetAccount(uint(accountID))
+	account, err := h.userDB(c).GetAccount(uint(accountID))
 	if err != nil {
 		return render(c, templates.UploadError("Account not found"))
 	}
@@ -35,65 +42,328 @@ func (h *Handlers) UploadHandler(c echo.Context) error {
 	}
 
 	files := form.File["files"]
-	if len(files) == 0 {
+	var links []string
+	for _, link := range form.Value["url"] {
+		if link = strings.TrimSpace(link); link != "" {
+			links = append(links, link)
+		}
+	}
+	if len(files) == 0 && len(links) == 0 {
 		return render(c, templates.UploadError("No files provided"))
 	}
 
-	var jobIDs []string
-	tempDir := h.TempDir
-	if tempDir == "" {
-		tempDir = "./temp"
+	opts := uploadOptions{
+		Title:          strings.TrimSpace(c.FormValue("title")),
+		Author:         strings.TrimSpace(c.FormValue("author")),
+		SkipDuplicates: account.DuplicatePolicy != db.DuplicatePolicyForce && c.FormValue("force") != "1",
 	}
-	if err := os.MkdirAll(tempDir, 0755); err != nil {
+
+	if err := os.MkdirAll(h.tempDir(), 0755); err != nil {
 		return render(c, templates.UploadError("Failed to create temp directory"))
 	}
 
+	result, err := h.uploadBatch(account, files, links, opts)
+	if err != nil {
+		return render(c, templates.UploadError("Failed to create batch"))
+	}
+	if result.Batch == nil {
+		return render(c, templates.UploadError(result.noJobsMessage()))
+	}
+
+	message := fmt.Sprintf("Successfully queued %d files for processing", len(result.JobIDs))
+	if result.Duplicates > 0 {
+		message += fmt.Sprintf("; skipped %d duplicate(s), see the queue for links to the existing files", result.Duplicates)
+	}
+	if len(result.BundleNotes) > 0 {
+		message += "; " + strings.Join(result.BundleNotes, "; ")
+	}
+	if len(result.Failures) > 0 {
+		message += "; failed to add " + strings.Join(result.Failures, "; ")
+	}
+	return render(c, templates.UploadSuccess(message, result.Batch.ID))
+}
+
+// uploadResult is what became of the files and links of an upload.
+type uploadResult struct {
+	// Nil when nothing was queued
+	Batch  *db.Batch
+	JobIDs []string
+	// Jobs recorded as skipped duplicates
+	Duplicates int
+	// Files and links that weren't queued, with the reason
+	Skipped []db.BatchSkip
+	// Links that couldn't be downloaded and archives that couldn't be read
+	Failures []string
+	// What was left out of each archive of books
+	BundleNotes []string
+}
+
+// noJobsMessage explains why none of an upload's files were queued.
+func (r *uploadResult) noJobsMessage() string {
+	if len(r.Failures) > 0 {
+		return "Failed to add " + strings.Join(r.Failures, "; ")
+	}
+	if len(r.BundleNotes) > 0 {
+		return "No books were queued; " + strings.Join(r.BundleNotes, "; ")
+	}
+	return "No supported files were uploaded"
+}
+
+// uploadBatch queues the files and links of an upload in a new batch. The
+// batch is deleted again if none of them became a job.
+func (h *Handlers) uploadBatch(account *db.Account, files []*multipart.FileHeader, links []string, opts uploadOptions) (*uploadResult, error) {
+	batch, err := h.DB.CreateBatch(account.ID, batchName(files, links))
+	if err != nil {
+		return nil, err
+	}
+	opts.BatchID = batch.ID
+
+	result := &uploadResult{}
+	addJob := func(job *db.Job) {
+		if job.Status == "skipped" {
+			result.Duplicates++
+		} else {
+			result.JobIDs = append(result.JobIDs, job.ID)
+		}
+	}
+	skip := func(name, reason string) {
+		result.Skipped = append(result.Skipped, db.BatchSkip{Name: name, Reason: reason})
+	}
+
 	for _, file := range files {
-		if !isValidEPUB(file.Filename) {
+		if !services.IsSupportedFormat(file.Filename) {
+			skip(file.Filename, "unsupported format")
 			continue
 		}
 
 		src, err := file.Open()
 		if err != nil {
+			skip(file.Filename, "failed to read upload")
 			continue
 		}
-		defer func() {
-			_ = src.Close() // Error ignored in cleanup
-		}()
-
-		tempPath := filepath.Join(tempDir, file.Filename)
-		dst, err := os.Create(tempPath)
+		if isZipArchive(file.Filename) {
+			bundle, err := h.queueBundle(account, src, opts)
+			if err == nil {
+				_ = src.Close() // Error ignored in cleanup
+				for _, job := range bundle.Jobs {
+					addJob(job)
+				}
+				for _, entry := range bundle.Skipped {
+					skip(file.Filename+"/"+entry.Name, entry.Reason)
+				}
+				if len(bundle.Skipped) > 0 {
+					result.BundleNotes = append(result.BundleNotes, fmt.Sprintf("left out %d file(s) of %s: %s",
+						len(bundle.Skipped), file.Filename, summarizeSkips(bundle.Skipped)))
+				}
+				continue
+			}
+			if !errors.Is(err, errNotABundle) {
+				_ = src.Close() // Error ignored in cleanup
+				result.Failures = append(result.Failures, fmt.Sprintf("%s: %v", file.Filename, err))
+				skip(file.Filename, err.Error())
+				continue
+			}
+			// A comic; upload the archive itself
+			if _, err := src.Seek(0, io.SeekStart); err != nil {
+				_ = src.Close() // Error ignored in cleanup
+				skip(file.Filename, "failed to read upload")
+				continue
+			}
+		}
+		job, err := h.queueUpload(account, file.Filename, src, opts)
+		_ = src.Close() // Error ignored in cleanup
 		if err != nil {
+			skip(file.Filename, err.Error())
 			continue
 		}
+		addJob(job)
+	}
 
-		_, err = io.Copy(dst, src)
-		_ = dst.Close() // Error ignored in cleanup
+	for _, link := range links {
+		job, err := h.queueURL(account, link, opts)
 		if err != nil {
-			_ = os.Remove(tempPath) // Error ignored
+			result.Failures = append(result.Failures, fmt.Sprintf("%s: %v", link, err))
+			skip(link, err.Error())
 			continue
 		}
+		addJob(job)
+	}
 
-		if !validateEPUBMagicBytes(tempPath) {
-			_ = os.Remove(tempPath) // Error ignored
-			continue
+	if len(result.JobIDs) == 0 && result.Duplicates == 0 {
+		if err := h.DB.DeleteBatch(batch.ID); err != nil {
+			log.Printf("Warning: Failed to delete empty batch: %v", err)
 		}
+		return result, nil
+	}
+	result.Batch = batch
+	if len(result.Skipped) > 0 {
+		if err := h.DB.SetBatchSkipped(batch, result.Skipped); err != nil {
+			log.Printf("Warning: Failed to record skipped files: %v", err)
+		}
+	}
+	return result, nil
+}
+
+// batchName names an upload after its first file or link.
+func batchName(files []*multipart.FileHeader, links []string) string {
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Filename)
+	}
+	names = append(names, links...)
+	if len(names) == 1 {
+		return names[0]
+	}
+	return fmt.Sprintf("%s and %d more", names[0], len(names)-1)
+}
 
-		job, err := h.DB.CreateJob(account.ID, file.Filename)
-		if err == nil {
-			jobIDs = append(jobIDs, job.ID)
+// UploadURLAPI queues the book at a link for an account, taking account_id,
+// url and force as form or JSON fields. It answers like the v1 API: the job
+// under data, or the error envelope.
+func (h *Handlers) UploadURLAPI(c echo.Context) error {
+	var req struct {
+		AccountID uint   `json:"account_id" form:"account_id"`
+		URL       string `json:"url" form:"url"`
+		Force     bool   `json:"force" form:"force"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return apiError(c, http.StatusBadRequest, "Invalid request")
+	}
+	if req.URL == "" {
+		return apiError(c, http.StatusBadRequest, "url is required")
+	}
+	account, err := h.userDB(c).GetAccount(req.AccountID)
+	if err != nil {
+		return apiError(c, http.StatusNotFound, "Account not found")
+	}
+
+	job, err := h.queueURL(account, req.URL, uploadOptions{
+		SkipDuplicates: account.DuplicatePolicy != db.DuplicatePolicyForce && !req.Force,
+	})
+	if err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, errInvalidUpload) || errors.Is(err, services.ErrNotABook) ||
+			errors.Is(err, services.ErrBadURLScheme) || errors.Is(err, services.ErrTooLarge) {
+			status = http.StatusUnprocessableEntity
 		}
+		return apiError(c, status, err.Error())
 	}
+	return apiData(c, http.StatusCreated, newAPIJob(*job))
+}
+
+var errInvalidUpload = errors.New("file is not a valid book")
 
-	if len(jobIDs) == 0 {
-		return render(c, templates.UploadError("No valid EPUB files were uploaded"))
+// uploadOptions apply to every file of an upload.
+type uploadOptions struct {
+	// Metadata for text, Markdown and HTML files
+	Title          string
+	Author         string
+	SkipDuplicates bool
+	// Link the file was downloaded from
+	SourceURL string
+	// Batch of the archive the file came out of
+	BatchID string
+}
+
+func (h *Handlers) tempDir() string {
+	if h.TempDir == "" {
+		return "./temp"
 	}
+	return h.TempDir
+}
 
-	return render(c, templates.UploadSuccess(fmt.Sprintf("Successfully queued %d files for processing", len(jobIDs))))
+// queueUpload saves a file to the temp directory and queues it, or records
+// it as skipped if it duplicates an earlier upload.
+func (h *Handlers) queueUpload(account *db.Account, filename string, src io.ReadSeeker, opts uploadOptions) (*db.Job, error) {
+	sourceHash, err := services.HashReader(src)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	if opts.SkipDuplicates {
+		duplicate, err := h.DB.FindDuplicateJob(account.ID, sourceHash)
+		if err != nil {
+			log.Printf("Warning: Failed to check for duplicates: %v", err)
+		}
+		if duplicate != nil {
+			job, err := h.DB.CreateSkippedJob(account.ID, filename, sourceHash, duplicate)
+			if err != nil || (opts.SourceURL == "" && opts.BatchID == "") {
+				return job, err
+			}
+			job.SourceURL = opts.SourceURL
+			job.BatchID = opts.BatchID
+			return job, h.DB.UpdateJob(job)
+		}
+	}
+
+	// Books of an archive can share a name in different folders
+	dst, filename, err := services.CreateTempFile(h.tempDir(), filename)
+	if err != nil {
+		return nil, err
+	}
+	tempPath := dst.Name()
+
+	_, err = io.Copy(dst, src)
+	_ = dst.Close() // Error ignored in cleanup
+	if err != nil {
+		_ = os.Remove(tempPath) // Error ignored
+		return nil, err
+	}
+
+	if !validateMagicBytes(tempPath, filename) {
+		_ = os.Remove(tempPath) // Error ignored
+		return nil, errInvalidUpload
+	}
+
+	job := &db.Job{
+		AccountID:        account.ID,
+		OriginalFilename: filename,
+		SourceHash:       sourceHash,
+		SourceURL:        opts.SourceURL,
+		BatchID:          opts.BatchID,
+	}
+	if opts.SourceURL != "" {
+		job.Message = "Downloaded from " + opts.SourceURL
+	}
+	if services.IsTextFormat(filename) {
+		job.Title = opts.Title
+		job.Author = opts.Author
+	}
+	if err := h.DB.QueueJob(job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// queueURL downloads a book and queues it like an uploaded file.
+func (h *Handlers) queueURL(account *db.Account, link string, opts uploadOptions) (*db.Job, error) {
+	fetched, err := services.FetchURL(link, h.tempDir(), h.Fetch)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := os.Remove(fetched.Path); err != nil {
+			log.Printf("Warning: Failed to remove download: %v", err)
+		}
+	}()
+
+	src, err := os.Open(fetched.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = src.Close() // Error ignored in cleanup
+	}()
+
+	opts.SourceURL = link
+	return h.queueUpload(account, fetched.Filename, src, opts)
 }
 
 func (h *Handlers) QueueStatusAPI(c echo.Context) error {
-	jobs, err := h.DB.ListRecentJobs(50)
+	jobs, err := h.userDB(c).ListRecentJobs(50)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error": err.Error(),
@@ -117,7 +387,7 @@ func (h *Handlers) QueueStatusAPI(c echo.Context) error {
 
 func (h *Handlers) JobStatusAPI(c echo.Context) error {
 	jobID := c.Param("id")
-	job, err := h.DB.GetJob(jobID)
+	job, err := h.userDB(c).GetJob(jobID)
 	if err != nil {
 		return c.JSON(http.StatusNotFound, map[string]string{
 			"error": "Job not found",
@@ -127,12 +397,29 @@ func (h *Handlers) JobStatusAPI(c echo.Context) error {
 	return c.JSON(http.StatusOK, job)
 }
 
-func isValidEPUB(filename string) bool {
-	ext := filepath.Ext(filename)
-	return ext == ".epub"
+func (h *Handlers) JobCoverAPI(c echo.Context) error {
+	jobID := c.Param("id")
+	job, err := h.userDB(c).GetJob(jobID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Job not found",
+		})
+	}
+
+	if h.Covers == nil || !job.HasCover || !h.Covers.HasThumbnail(job.ID) {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "Cover not found",
+		})
+	}
+
+	c.Response().Header().Set("Cache-Control", "private, max-age=86400")
+	return c.File(h.Covers.ThumbnailPath(job.ID))
 }
 
-func validateEPUBMagicBytes(filePath string) bool {
+// validateMagicBytes checks the file starts like its extension claims: RAR
+// for CBR, text without NUL bytes for documents and FB2, ZIP for everything
+// else.
+func validateMagicBytes(filePath, filename string) bool {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return false
@@ -141,12 +428,22 @@ func validateEPUBMagicBytes(filePath string) bool {
 		_ = file.Close() // Error ignored in validation
 	}()
 
-	header := make([]byte, 4)
-	_, err = file.Read(header)
-	if err != nil {
+	header := make([]byte, 512)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
 		return false
 	}
+	header = header[:n]
 
+	if services.IsTextFormat(filename) || strings.EqualFold(filepath.Ext(filename), ".fb2") {
+		return bytes.IndexByte(header, 0) == -1
+	}
+	if len(header) < 4 {
+		return false
+	}
+	if strings.EqualFold(filepath.Ext(filename), ".cbr") {
+		return string(header[:4]) == "Rar!"
+	}
 	return header[0] == 0x50 && header[1] == 0x4B
 }
 
@@ -21,7 +21,7 @@ import (
 func TestUploadHandler_MultipleEPUBs(t *testing.T) {
 	// Create test database
 	testDB := testutil.SetupTestDB(t)
-	err := testDB.AutoMigrate(&db.Account{}, &db.Job{})
+	err := testDB.AutoMigrate(&db.Account{}, &db.Job{}, &db.Batch{})
 	if err != nil {
 		t.Fatalf("Failed to migrate test database: %v", err)
 	}
@@ -157,7 +157,7 @@ func TestUploadHandler_MultipleEPUBs(t *testing.T) {
 func TestUploadHandler_MultipleEPUBs_ProcessingOrder(t *testing.T) {
 	// Create test database
 	testDB := testutil.SetupTestDB(t)
-	err := testDB.AutoMigrate(&db.Account{}, &db.Job{})
+	err := testDB.AutoMigrate(&db.Account{}, &db.Job{}, &db.Batch{})
 	if err != nil {
 		t.Fatalf("Failed to migrate test database: %v", err)
 	}
@@ -259,3 +259,125 @@ func TestUploadHandler_MultipleEPUBs_ProcessingOrder(t *testing.T) {
 		}
 	}
 }
+
+func TestUploadHandler_ComicFormats(t *testing.T) {
+	testDB := testutil.SetupTestDB(t)
+	err := testDB.AutoMigrate(&db.Account{}, &db.Job{}, &db.Batch{})
+	if err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+
+	dbService := db.NewService(testDB)
+	handlers := &Handlers{DB: dbService, TempDir: t.TempDir()}
+
+	account, err := dbService.CreateAccount("test-account", "folder-123")
+	if err != nil {
+		t.Fatalf("Failed to create test account: %v", err)
+	}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	if err := writer.WriteField("account_id", fmt.Sprintf("%d", account.ID)); err != nil {
+		t.Fatalf("Failed to write account_id field: %v", err)
+	}
+
+	files := map[string]string{
+		"issue1.cbz": "PK" + strings.Repeat("\x00", 100),
+		"manga.cbr":  "Rar!\x1a\x07\x00" + strings.Repeat("\x00", 100),
+		// A CBR must really be a RAR archive
+		"renamed.cbr": "PK" + strings.Repeat("\x00", 100),
+		"scan.pdf":    "%PDF-1.4",
+	}
+	for filename, content := range files {
+		part, err := writer.CreateFormFile("files", filename)
+		if err != nil {
+			t.Fatalf("Failed to create form file: %v", err)
+		}
+		if _, err := io.WriteString(part, content); err != nil {
+			t.Fatalf("Failed to write mock content: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to close multipart writer: %v", err)
+	}
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/upload", body)
+	req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	if err := handlers.UploadHandler(c); err != nil {
+		t.Fatalf("UploadHandler() error = %v", err)
+	}
+	testutil.AssertResponseContains(t, rec, "Successfully queued 2 files")
+
+	jobs, err := dbService.ListRecentJobs(10)
+	if err != nil {
+		t.Fatalf("Failed to list jobs: %v", err)
+	}
+	queued := map[string]bool{}
+	for _, job := range jobs {
+		queued[job.OriginalFilename] = true
+	}
+	if !queued["issue1.cbz"] || !queued["manga.cbr"] || len(queued) != 2 {
+		t.Errorf("queued jobs = %v, want issue1.cbz and manga.cbr", queued)
+	}
+}
+
+func TestUploadHandler_Duplicates(t *testing.T) {
+	testDB := testutil.SetupTestDB(t)
+	err := testDB.AutoMigrate(&db.Account{}, &db.Job{}, &db.Batch{})
+	if err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+
+	dbService := db.NewService(testDB)
+	handlers := &Handlers{DB: dbService, TempDir: t.TempDir()}
+
+	account, err := dbService.CreateAccount("test-account", "folder-123")
+	if err != nil {
+		t.Fatalf("Failed to create test account: %v", err)
+	}
+	epubPath := testutil.CreateValidEPUB(t, "book.epub", nil)
+
+	upload := func(fields map[string]string) *httptest.ResponseRecorder {
+		t.Helper()
+		fields["account_id"] = fmt.Sprintf("%d", account.ID)
+		req := testutil.CreateMultipartRequest(t, http.MethodPost, "/upload", map[string]string{"files": epubPath}, fields)
+		rec := httptest.NewRecorder()
+		if err := handlers.UploadHandler(echo.New().NewContext(req, rec)); err != nil {
+			t.Fatalf("UploadHandler() error = %v", err)
+		}
+		return rec
+	}
+
+	upload(map[string]string{})
+	original, err := dbService.GetNextQueuedJob()
+	if err != nil || original == nil {
+		t.Fatalf("Expected a queued job, got %v (%v)", original, err)
+	}
+	if original.SourceHash == "" {
+		t.Errorf("Queued job has no source hash")
+	}
+	_ = dbService.MarkJobCompleted(original.ID, "book.kepub.epub", "https://drive.example.com/book")
+
+	rec := upload(map[string]string{})
+	testutil.AssertResponseContains(t, rec, "skipped 1 duplicate")
+
+	jobs, _ := dbService.ListRecentJobs(10)
+	if len(jobs) != 2 || jobs[0].Status != "skipped" || jobs[0].DriveURL != "https://drive.example.com/book" {
+		t.Fatalf("Expected a skipped job linking to the existing file, got %+v", jobs[0])
+	}
+
+	// Forcing the upload, per upload or per account, queues it again
+	rec = upload(map[string]string{"force": "1"})
+	testutil.AssertResponseContains(t, rec, "Successfully queued 1 files")
+
+	account.DuplicatePolicy = db.DuplicatePolicyForce
+	if err := dbService.UpdateAccount(account); err != nil {
+		t.Fatalf("Failed to update account: %v", err)
+	}
+	rec = upload(map[string]string{})
+	testutil.AssertResponseContains(t, rec, "Successfully queued 1 files")
+}
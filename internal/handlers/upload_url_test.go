@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bookify/internal/db"
+	"bookify/internal/services"
+	"bookify/internal/testutil"
+
+	"github.com/labstack/echo/v4"
+)
+
+func setupUploadURL(t *testing.T) (*Handlers, *db.Service, *db.Account, *httptest.Server) {
+	t.Helper()
+
+	testDB := testutil.SetupTestDB(t)
+	if err := testDB.AutoMigrate(&db.Account{}, &db.Job{}, &db.Batch{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	dbService := db.NewService(testDB)
+	account, _ := dbService.CreateAccount("test-account", "folder-123")
+
+	epub, err := os.ReadFile(testutil.CreateValidEPUB(t, "book.epub", nil))
+	if err != nil {
+		t.Fatalf("Failed to read EPUB: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/book.epub", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/epub+zip")
+		_, _ = w.Write(epub)
+	})
+	mux.HandleFunc("/fake.epub", func(w http.ResponseWriter, r *http.Request) {
+		// Claims to be an EPUB but isn't a ZIP
+		_, _ = w.Write([]byte("not really an epub"))
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	// The test server is on loopback
+	fetch := services.DefaultFetchOptions
+	fetch.AllowPrivate = true
+	return &Handlers{DB: dbService, TempDir: t.TempDir(), Fetch: fetch}, dbService, account, server
+}
+
+func TestHandlers_UploadURLAPI(t *testing.T) {
+	handlers, dbService, account, server := setupUploadURL(t)
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/upload/url", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		if err := handlers.UploadURLAPI(echo.New().NewContext(req, rec)); err != nil {
+			t.Fatalf("UploadURLAPI() error = %v", err)
+		}
+		return rec
+	}
+
+	rec := post(fmt.Sprintf(`{"account_id": %d, "url": %q}`, account.ID, server.URL+"/book.epub"))
+	testutil.AssertResponseStatus(t, rec, http.StatusCreated)
+	var created struct {
+		Data apiJob `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatalf("Failed to decode job: %v", err)
+	}
+	if created.Data.Status != "queued" || created.Data.Filename != "book.epub" || created.Data.SourceURL != server.URL+"/book.epub" || created.Data.AccountID != account.ID {
+		t.Errorf("job = %+v", created.Data)
+	}
+	if strings.Contains(rec.Body.String(), `"account":`) {
+		t.Errorf("response includes the account: %s", rec.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(handlers.TempDir, "book.epub")); err != nil {
+		t.Errorf("book not in the temp directory: %v", err)
+	}
+
+	// Once converted, downloading the same file again is a duplicate
+	job, _ := dbService.GetJob(created.Data.ID)
+	job.Status = "completed"
+	if err := dbService.UpdateJob(job); err != nil {
+		t.Fatalf("Failed to update job: %v", err)
+	}
+	rec = post(fmt.Sprintf(`{"account_id": %d, "url": %q}`, account.ID, server.URL+"/book.epub"))
+	testutil.AssertResponseStatus(t, rec, http.StatusCreated)
+	testutil.AssertResponseContains(t, rec, `"status":"skipped"`)
+
+	rec = post(fmt.Sprintf(`{"account_id": %d, "url": %q}`, account.ID, server.URL+"/fake.epub"))
+	testutil.AssertResponseStatus(t, rec, http.StatusUnprocessableEntity)
+	testutil.AssertResponseContains(t, rec, `"code":"unprocessable"`)
+
+	rec = post(fmt.Sprintf(`{"account_id": %d, "url": "file:///etc/passwd"}`, account.ID))
+	testutil.AssertResponseStatus(t, rec, http.StatusUnprocessableEntity)
+
+	rec = post(fmt.Sprintf(`{"account_id": %d, "url": %q}`, account.ID+1, server.URL+"/book.epub"))
+	testutil.AssertResponseStatus(t, rec, http.StatusNotFound)
+
+	rec = post(fmt.Sprintf(`{"account_id": %d}`, account.ID))
+	testutil.AssertResponseStatus(t, rec, http.StatusBadRequest)
+
+	jobs, _ := dbService.ListRecentJobs(10)
+	if len(jobs) != 2 {
+		t.Errorf("got %d jobs, want 2", len(jobs))
+	}
+	// Only queued books stay in the temp directory
+	if entries, _ := os.ReadDir(handlers.TempDir); len(entries) != 1 {
+		t.Errorf("temp directory has %d files, want 1", len(entries))
+	}
+}
+
+func TestUploadHandler_URL(t *testing.T) {
+	handlers, dbService, account, server := setupUploadURL(t)
+
+	req := testutil.CreateMultipartRequest(t, http.MethodPost, "/upload", nil, map[string]string{
+		"account_id": fmt.Sprintf("%d", account.ID),
+		"url":        server.URL + "/book.epub",
+	})
+	rec := httptest.NewRecorder()
+	if err := handlers.UploadHandler(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatalf("UploadHandler() error = %v", err)
+	}
+	testutil.AssertResponseContains(t, rec, "Successfully queued 1 files")
+
+	jobs, _ := dbService.ListRecentJobs(10)
+	if len(jobs) != 1 || jobs[0].Message != "Downloaded from "+server.URL+"/book.epub" {
+		t.Errorf("jobs = %+v", jobs)
+	}
+
+	req = testutil.CreateMultipartRequest(t, http.MethodPost, "/upload", nil, map[string]string{
+		"account_id": fmt.Sprintf("%d", account.ID),
+		"url":        server.URL + "/missing.epub",
+	})
+	rec = httptest.NewRecorder()
+	if err := handlers.UploadHandler(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatalf("UploadHandler() error = %v", err)
+	}
+	testutil.AssertResponseContains(t, rec, "404 Not Found")
+}
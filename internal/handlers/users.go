@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"bookify/internal/db"
+	"bookify/internal/templates"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	userKey = "user"
+
+	sessionCookie     = "bookify_session"
+	sessionTTL        = 30 * 24 * time.Hour
+	minPasswordLength = 8
+)
+
+// currentUser is the signed-in user, or nil on routes without a login.
+func currentUser(c echo.Context) *db.User {
+	user, _ := c.Get(userKey).(*db.User)
+	return user
+}
+
+// userDB is the database as the signed-in user may see it.
+func (h *Handlers) userDB(c echo.Context) *db.Service {
+	if user := currentUser(c); user != nil {
+		return h.DB.ForUser(user)
+	}
+	return h.DB
+}
+
+// RequireLogin lets a request through if it has a session cookie or comes
+// from a trusted proxy naming the user, sending browsers to the login page
+// otherwise.
+func (h *Handlers) RequireLogin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, err := h.proxyUser(c)
+		if err != nil {
+			return err
+		}
+		if user != nil {
+			c.Set(userKey, user)
+			return next(c)
+		}
+		if cookie, err := c.Cookie(sessionCookie); err == nil && cookie.Value != "" {
+			session, err := h.DB.GetSessionBySecret(cookie.Value, time.Now())
+			if err == nil {
+				c.Set(userKey, &session.User)
+				return next(c)
+			}
+		}
+
+		req := c.Request()
+		target := "/login"
+		if req.Header.Get("HX-Request") == "true" {
+			// Back to the page that made the request, not the fragment
+			if current, err := url.Parse(req.Header.Get("HX-Current-URL")); err == nil && current.Path != "" {
+				target += "?next=" + url.QueryEscape(current.RequestURI())
+			}
+			c.Response().Header().Set("HX-Redirect", target)
+			return c.NoContent(http.StatusUnauthorized)
+		}
+		if strings.HasPrefix(req.URL.Path, "/api/") {
+			return c.JSON(http.StatusUnauthorized, map[string]string{
+				"error": "Sign in required",
+			})
+		}
+		if req.Method == http.MethodGet {
+			target += "?next=" + url.QueryEscape(req.URL.RequestURI())
+		}
+		return c.Redirect(http.StatusFound, target)
+	}
+}
+
+// RequireAdmin lets only admins through. It goes after RequireLogin.
+func (h *Handlers) RequireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if user := currentUser(c); user == nil || user.Role != db.RoleAdmin {
+			return c.String(http.StatusForbidden, "Only admins can do that")
+		}
+		return next(c)
+	}
+}
+
+func (h *Handlers) LoginPage(c echo.Context) error {
+	// Users the proxy signed in have nothing to do here
+	if user, err := h.proxyUser(c); err != nil {
+		return err
+	} else if user != nil {
+		return c.Redirect(http.StatusFound, safeRedirect(c.QueryParam("next")))
+	}
+	count, err := h.DB.CountUsers()
+	if err != nil {
+		return err
+	}
+	return render(c, templates.LoginPage(templates.LoginView{
+		Next:      safeRedirect(c.QueryParam("next")),
+		FirstUser: count == 0,
+		OIDCName:  h.oidcName(),
+	}))
+}
+
+// Login signs a user in. Until there are users, it creates the first one,
+// who is an admin.
+func (h *Handlers) Login(c echo.Context) error {
+	username := strings.TrimSpace(c.FormValue("username"))
+	password := c.FormValue("password")
+	view := templates.LoginView{Next: safeRedirect(c.FormValue("next")), Username: username, OIDCName: h.oidcName()}
+
+	count, err := h.DB.CountUsers()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		view.FirstUser = true
+		hash, problem := hashPassword(username, password)
+		if problem != "" {
+			view.Error = problem
+			return render(c, templates.LoginPage(view))
+		}
+		user, err := h.DB.CreateUser(username, hash, db.RoleAdmin)
+		if err != nil {
+			return fmt.Errorf("failed to create user: %w", err)
+		}
+		log.Printf("Created admin user %s", user.Username)
+		return h.startSession(c, user, view.Next)
+	}
+
+	user, err := h.DB.GetUserByUsername(username)
+	if err != nil || user.PasswordHash == "" ||
+		bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+		view.Error = "Wrong username or password"
+		c.Response().WriteHeader(http.StatusUnauthorized)
+		return render(c, templates.LoginPage(view))
+	}
+	return h.startSession(c, user, view.Next)
+}
+
+// startSession signs the browser in as the user and sends it on.
+func (h *Handlers) startSession(c echo.Context, user *db.User, next string) error {
+	if err := h.setSessionCookie(c, user); err != nil {
+		return err
+	}
+	return c.Redirect(http.StatusSeeOther, next)
+}
+
+func (h *Handlers) setSessionCookie(c echo.Context, user *db.User) error {
+	now := time.Now()
+	if err := h.DB.DeleteExpiredSessions(now); err != nil {
+		log.Printf("Warning: Failed to delete expired sessions: %v", err)
+	}
+	secret, err := h.DB.CreateSession(user.ID, now.Add(sessionTTL))
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	c.SetCookie(&http.Cookie{
+		Name:     sessionCookie,
+		Value:    secret,
+		Path:     "/",
+		Expires:  now.Add(sessionTTL),
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+func (h *Handlers) Logout(c echo.Context) error {
+	if cookie, err := c.Cookie(sessionCookie); err == nil && cookie.Value != "" {
+		if err := h.DB.DeleteSession(cookie.Value); err != nil {
+			log.Printf("Warning: Failed to delete session: %v", err)
+		}
+	}
+	c.SetCookie(&http.Cookie{
+		Name:     sessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	return c.Redirect(http.StatusSeeOther, "/login")
+}
+
+// safeRedirect keeps redirects after login on this site.
+func safeRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// hashPassword checks a new login and hashes its password, returning what's
+// wrong with it instead if anything.
+func hashPassword(username, password string) (string, string) {
+	if username == "" {
+		return "", "Username is required"
+	}
+	if len(password) < minPasswordLength {
+		return "", fmt.Sprintf("Passwords need at least %d characters", minPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", "Failed to hash password"
+	}
+	return string(hash), ""
+}
+
+func (h *Handlers) UsersPage(c echo.Context) error {
+	return h.renderUsers(c, "", "")
+}
+
+func (h *Handlers) CreateUser(c echo.Context) error {
+	username := strings.TrimSpace(c.FormValue("username"))
+	role := c.FormValue("role")
+	if !slices.Contains([]string{db.RoleUser, db.RoleAdmin}, role) {
+		return h.renderUsers(c, "", "Unknown role "+role)
+	}
+	hash, problem := hashPassword(username, c.FormValue("password"))
+	if problem != "" {
+		return h.renderUsers(c, "", problem)
+	}
+	if _, err := h.DB.GetUserByUsername(username); err == nil {
+		return h.renderUsers(c, "", "A user with this name already exists")
+	}
+	if _, err := h.DB.CreateUser(username, hash, role); err != nil {
+		return h.renderUsers(c, "", "Failed to create user")
+	}
+	return h.renderUsers(c, fmt.Sprintf("Added %s", username), "")
+}
+
+func (h *Handlers) ResetUserPassword(c echo.Context) error {
+	user, err := h.userFromParam(c)
+	if err != nil {
+		return c.Redirect(http.StatusFound, "/users")
+	}
+	hash, problem := hashPassword(user.Username, c.FormValue("password"))
+	if problem != "" {
+		return h.renderUsers(c, "", problem)
+	}
+	if err := h.DB.SetUserPassword(user.ID, hash); err != nil {
+		return h.renderUsers(c, "", "Failed to change password")
+	}
+	return h.renderUsers(c, fmt.Sprintf("Changed the password of %s", user.Username), "")
+}
+
+// DeleteUser removes a user, handing their accounts and jobs to the admin
+// who removed them.
+func (h *Handlers) DeleteUser(c echo.Context) error {
+	user, err := h.userFromParam(c)
+	if err != nil {
+		return c.Redirect(http.StatusFound, "/users")
+	}
+	admin := currentUser(c)
+	if user.ID == admin.ID {
+		return h.renderUsers(c, "", "You can't remove yourself")
+	}
+	if err := h.DB.DeleteUser(user.ID, admin.ID); err != nil {
+		if errors.Is(err, db.ErrLastAdmin) {
+			return h.renderUsers(c, "", "The last admin can't be removed")
+		}
+		return h.renderUsers(c, "", "Failed to remove user")
+	}
+	return h.renderUsers(c, fmt.Sprintf("Removed %s; their accounts are now yours", user.Username), "")
+}
+
+func (h *Handlers) userFromParam(c echo.Context) (*db.User, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	return h.DB.GetUser(uint(id))
+}
+
+func (h *Handlers) renderUsers(c echo.Context, message, errorMsg string) error {
+	users, err := h.DB.ListUsers()
+	if err != nil {
+		return err
+	}
+	return render(c, templates.UsersPage(users, currentUser(c), message, errorMsg))
+}
+
+func (h *Handlers) PasswordPage(c echo.Context) error {
+	return render(c, templates.PasswordPage("", ""))
+}
+
+// ChangePassword lets the signed-in user pick a new password, which signs
+// them out everywhere else too.
+func (h *Handlers) ChangePassword(c echo.Context) error {
+	user := currentUser(c)
+	if user.PasswordHash == "" ||
+		bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(c.FormValue("current_password"))) != nil {
+		return render(c, templates.PasswordPage("", "Your current password is wrong"))
+	}
+	hash, problem := hashPassword(user.Username, c.FormValue("password"))
+	if problem != "" {
+		return render(c, templates.PasswordPage("", problem))
+	}
+	if err := h.DB.SetUserPassword(user.ID, hash); err != nil {
+		return render(c, templates.PasswordPage("", "Failed to change password"))
+	}
+	// Changing the password ended this session too
+	if err := h.setSessionCookie(c, user); err != nil {
+		return err
+	}
+	return render(c, templates.PasswordPage("Password changed", ""))
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"bookify/internal/db"
+	"bookify/internal/testutil"
+
+	"github.com/labstack/echo/v4"
+)
+
+func setupLogin(t *testing.T) (*echo.Echo, *db.Service) {
+	t.Helper()
+
+	testDB := testutil.SetupTestDB(t)
+	err := testDB.AutoMigrate(&db.Account{}, &db.Job{}, &db.Batch{}, &db.APIToken{}, &db.User{}, &db.Session{})
+	if err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	dbService := db.NewService(testDB)
+	handlers := &Handlers{DB: dbService, TempDir: t.TempDir()}
+
+	e := echo.New()
+	e.GET("/login", handlers.LoginPage)
+	e.POST("/login", handlers.Login)
+	e.POST("/logout", handlers.Logout)
+	app := e.Group("", handlers.RequireLogin)
+	app.GET("/", handlers.IndexPage)
+	app.GET("/api/queue", handlers.QueueStatusAPI)
+	app.GET("/api/job/:id", handlers.JobStatusAPI)
+	users := app.Group("/users", handlers.RequireAdmin)
+	users.GET("", handlers.UsersPage)
+	users.POST("", handlers.CreateUser)
+	handlers.RegisterAPIv1(e.Group("/api/v1"))
+	return e, dbService
+}
+
+// browse sends a request with the session cookie, if any, and returns the
+// response.
+func browse(e *echo.Echo, method, target, session string, form url.Values) *httptest.ResponseRecorder {
+	var req *http.Request
+	if form != nil {
+		req = httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	} else {
+		req = httptest.NewRequest(method, target, nil)
+	}
+	if session != "" {
+		req.AddCookie(&http.Cookie{Name: sessionCookie, Value: session})
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func sessionFrom(rec *httptest.ResponseRecorder) string {
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == sessionCookie {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+func TestHandlers_Login(t *testing.T) {
+	e, dbService := setupLogin(t)
+
+	rec := browse(e, http.MethodGet, "/library?q=x", "", nil)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/login?next=%2Flibrary%3Fq%3Dx" {
+		t.Errorf("signed out = %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+	if rec := browse(e, http.MethodGet, "/api/queue", "", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("signed out API = %d", rec.Code)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/queue", nil)
+	req.Header.Set("HX-Request", "true")
+	req.Header.Set("HX-Current-URL", "http://bookify.example/batches/42")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Header().Get("HX-Redirect") != "/login?next=%2Fbatches%2F42" {
+		t.Errorf("htmx redirect = %q", rec.Header().Get("HX-Redirect"))
+	}
+
+	// The first login creates the admin
+	if body := browse(e, http.MethodGet, "/login", "", nil).Body.String(); !strings.Contains(body, "Create admin") {
+		t.Error("the login page didn't offer to create the admin")
+	}
+	rec = browse(e, http.MethodPost, "/login", "", url.Values{"username": {"admin"}, "password": {"short"}})
+	if !strings.Contains(rec.Body.String(), "at least 8 characters") {
+		t.Errorf("short password: %s", rec.Body.String())
+	}
+	rec = browse(e, http.MethodPost, "/login", "", url.Values{"username": {"admin"}, "password": {"correct horse"}, "next": {"//evil.example"}})
+	adminSession := sessionFrom(rec)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/" || adminSession == "" {
+		t.Fatalf("creating the admin = %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+	cookie := rec.Result().Cookies()[0]
+	if !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+		t.Errorf("session cookie = %+v", cookie)
+	}
+	if admin, _ := dbService.GetUserByUsername("admin"); admin.Role != db.RoleAdmin {
+		t.Errorf("first user role = %s", admin.Role)
+	}
+
+	// The admin adds a user, who signs in
+	rec = browse(e, http.MethodPost, "/users", adminSession, url.Values{"username": {"alice"}, "password": {"wonderland"}, "role": {"user"}})
+	if !strings.Contains(rec.Body.String(), "Added alice") {
+		t.Fatalf("adding a user: %s", rec.Body.String())
+	}
+	rec = browse(e, http.MethodPost, "/login", "", url.Values{"username": {"alice"}, "password": {"wrong password"}})
+	if rec.Code != http.StatusUnauthorized || sessionFrom(rec) != "" {
+		t.Errorf("wrong password = %d", rec.Code)
+	}
+	rec = browse(e, http.MethodPost, "/login", "", url.Values{"username": {"alice"}, "password": {"wonderland"}, "next": {"/library"}})
+	aliceSession := sessionFrom(rec)
+	if rec.Header().Get("Location") != "/library" || aliceSession == "" {
+		t.Fatalf("alice signing in = %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+	if rec := browse(e, http.MethodGet, "/users", aliceSession, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("users page for a non-admin = %d", rec.Code)
+	}
+
+	// Signing out ends the session
+	browse(e, http.MethodPost, "/logout", aliceSession, nil)
+	if rec := browse(e, http.MethodGet, "/api/queue", aliceSession, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("after signing out = %d", rec.Code)
+	}
+}
+
+func TestHandlers_UserIsolation(t *testing.T) {
+	e, dbService := setupLogin(t)
+
+	admin, _ := dbService.CreateUser("admin", "", db.RoleAdmin)
+	alice, _ := dbService.CreateUser("alice", "", db.RoleUser)
+	bob, _ := dbService.CreateUser("bob", "", db.RoleUser)
+	aliceAccount, _ := dbService.ForUser(alice).CreateAccount("Alice's Kobo", "folder1")
+	bobAccount, _ := dbService.ForUser(bob).CreateAccount("Bob's Kobo", "folder2")
+	aliceJob, _ := dbService.CreateJob(aliceAccount.ID, "alice.epub")
+	bobJob, _ := dbService.CreateJob(bobAccount.ID, "bob.epub")
+
+	session := func(user *db.User) string {
+		secret, err := dbService.CreateSession(user.ID, time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatalf("Failed to create session: %v", err)
+		}
+		return secret
+	}
+	aliceSession, adminSession := session(alice), session(admin)
+
+	body := browse(e, http.MethodGet, "/", aliceSession, nil).Body.String()
+	if !strings.Contains(body, "Alice&#39;s Kobo") || strings.Contains(body, "Bob&#39;s Kobo") || strings.Contains(body, "bob.epub") {
+		t.Error("alice's home page shows bob's account or jobs")
+	}
+	if strings.Contains(body, `href="/users"`) {
+		t.Error("alice's home page links to the users page")
+	}
+	if rec := browse(e, http.MethodGet, "/api/job/"+bobJob.ID, aliceSession, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("alice reading bob's job = %d", rec.Code)
+	}
+	if rec := browse(e, http.MethodGet, "/api/job/"+aliceJob.ID, aliceSession, nil); rec.Code != http.StatusOK {
+		t.Errorf("alice reading her job = %d", rec.Code)
+	}
+	body = browse(e, http.MethodGet, "/api/queue", adminSession, nil).Body.String()
+	if !strings.Contains(body, "alice.epub") || !strings.Contains(body, "bob.epub") {
+		t.Error("the admin doesn't see every job")
+	}
+
+	// API tokens act as their user
+	token, _, _ := dbService.ForUser(alice).CreateAPIToken("alice", []string{db.ScopeRead}, nil)
+	status, resp := apiCall(t, e, token, http.MethodGet, "/api/v1/jobs/"+bobJob.ID, nil, "")
+	assertAPIError(t, status, resp, http.StatusNotFound, "not_found")
+	status, resp = apiCall(t, e, token, http.MethodGet, "/api/v1/accounts", nil, "")
+	if data, _ := resp["data"].([]interface{}); status != http.StatusOK || len(data) != 1 {
+		t.Errorf("alice's accounts = %d %v", status, resp)
+	}
+}
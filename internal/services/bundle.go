@@ -0,0 +1,231 @@
+package services
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// BundleLimits guard against ZIP bombs when expanding an archive of books.
+type BundleLimits struct {
+	MaxEntries   int
+	MaxEntrySize int64
+	MaxTotalSize int64
+	// Largest uncompressed to compressed size ratio of an entry
+	MaxRatio int64
+}
+
+var DefaultBundleLimits = BundleLimits{
+	MaxEntries:   1000,
+	MaxEntrySize: 100 << 20,
+	MaxTotalSize: 4 << 30,
+	MaxRatio:     100,
+}
+
+var ErrBundleTooLarge = errors.New("archive expands to more than the size limit")
+
+// BundleEntry is a book taken out of an archive.
+type BundleEntry struct {
+	// Path inside the archive
+	Name string
+	// Where the book was extracted to
+	Path string
+}
+
+// Filename is the entry's name without its folders.
+func (e BundleEntry) Filename() string {
+	return path.Base(e.Name)
+}
+
+// BundleSkip is an entry of an archive that wasn't extracted.
+type BundleSkip struct {
+	Name   string
+	Reason string
+}
+
+// Formats of the books found in an archive of books. A ZIP of anything else
+// is a comic.
+func isBundledBook(name string) bool {
+	switch formatExtension(name) {
+	case ".epub", ".fb2", ".fb2.zip", ".cbz", ".cbr":
+		return true
+	}
+	return false
+}
+
+// IsBookBundle reports whether a ZIP holds books rather than comic pages.
+func IsBookBundle(zipPath string) bool {
+	reader, err := openBundle(zipPath)
+	if err != nil {
+		return false
+	}
+	defer func() {
+		_ = reader.Close() // Error ignored, only read
+	}()
+	for _, f := range reader.File {
+		if !f.FileInfo().IsDir() && isBundledBook(f.Name) && !isHiddenEntry(f.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// openBundle opens an archive even if it has unsafe names, which
+// ExtractBundle skips one by one.
+func openBundle(zipPath string) (*zip.ReadCloser, error) {
+	reader, err := zip.OpenReader(zipPath)
+	if errors.Is(err, zip.ErrInsecurePath) {
+		return reader, nil
+	}
+	return reader, err
+}
+
+func isHiddenEntry(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if isHiddenFile(part) || part == "__MACOSX" {
+			return true
+		}
+	}
+	return false
+}
+
+// ExtractBundle extracts the books of an archive, including those in
+// subfolders, into dir. Entries that aren't books, or that look unsafe, are
+// skipped with the reason.
+func ExtractBundle(zipPath, dir string, limits BundleLimits) ([]BundleEntry, []BundleSkip, error) {
+	reader, err := openBundle(zipPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			log.Printf("Warning: Failed to close archive: %v", err)
+		}
+	}()
+
+	var books []*zip.File
+	var skipped []BundleSkip
+	var declared uint64
+	for _, f := range reader.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if reason := unsafePath(f.Name); reason != "" {
+			skipped = append(skipped, BundleSkip{Name: f.Name, Reason: reason})
+			continue
+		}
+		if isHiddenEntry(f.Name) {
+			continue
+		}
+		if !isBundledBook(f.Name) {
+			skipped = append(skipped, BundleSkip{Name: f.Name, Reason: "not a supported book"})
+			continue
+		}
+		books = append(books, f)
+		declared += f.UncompressedSize64
+	}
+	if len(books) > limits.MaxEntries {
+		return nil, nil, fmt.Errorf("archive has %d books, more than the limit of %d", len(books), limits.MaxEntries)
+	}
+	if declared > uint64(limits.MaxTotalSize) {
+		return nil, nil, ErrBundleTooLarge
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+	var entries []BundleEntry
+	var total int64
+	for _, f := range books {
+		if reason := unsafeEntry(f, limits); reason != "" {
+			skipped = append(skipped, BundleSkip{Name: f.Name, Reason: reason})
+			continue
+		}
+		dest, err := os.CreateTemp(dir, "entry-*"+formatExtension(f.Name))
+		if err != nil {
+			return entries, skipped, fmt.Errorf("failed to create file: %w", err)
+		}
+		// The sizes in the archive can lie; count what is actually read
+		written, err := extractEntry(f, dest, limits.MaxEntrySize)
+		if closeErr := dest.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			removeTempFile(dest.Name())
+			skipped = append(skipped, BundleSkip{Name: f.Name, Reason: err.Error()})
+			continue
+		}
+		total += written
+		if total > limits.MaxTotalSize {
+			removeTempFile(dest.Name())
+			return entries, skipped, ErrBundleTooLarge
+		}
+		entries = append(entries, BundleEntry{Name: f.Name, Path: dest.Name()})
+	}
+	return entries, skipped, nil
+}
+
+// unsafePath returns why an entry's name could lead outside the directory
+// it's extracted to, or "". Only the base name is ever used, but such an
+// archive is suspect.
+func unsafePath(name string) string {
+	slashed := strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(slashed, "/") || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "absolute path"
+	}
+	for _, part := range strings.Split(slashed, "/") {
+		if part == ".." {
+			return "path leaves the archive"
+		}
+	}
+	return ""
+}
+
+// unsafeEntry returns why an entry looks like part of a ZIP bomb, or "".
+func unsafeEntry(f *zip.File, limits BundleLimits) string {
+	if f.UncompressedSize64 > uint64(limits.MaxEntrySize) {
+		return "larger than the size limit"
+	}
+	if f.CompressedSize64 > 0 && f.UncompressedSize64 > 1<<20 &&
+		f.UncompressedSize64/f.CompressedSize64 > uint64(limits.MaxRatio) {
+		return "suspiciously high compression ratio"
+	}
+	return ""
+}
+
+func extractEntry(f *zip.File, dest io.Writer, maxSize int64) (int64, error) {
+	src, err := f.Open()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read: %w", err)
+	}
+	defer func() {
+		_ = src.Close() // Error ignored, only read
+	}()
+	written, err := io.Copy(dest, io.LimitReader(src, maxSize+1))
+	if err != nil {
+		return written, fmt.Errorf("failed to read: %w", err)
+	}
+	if written > maxSize {
+		return written, errors.New("larger than the size limit")
+	}
+	return written, nil
+}
+
+// readLimited reads all of r, failing with ErrBundleTooLarge once it goes
+// past maxSize bytes.
+func readLimited(r io.Reader, maxSize int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, ErrBundleTooLarge
+	}
+	return data, nil
+}
@@ -0,0 +1,182 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"bookify/internal/testutil"
+)
+
+// writeZip creates a ZIP with the given entries, in name order.
+func writeZip(t *testing.T, entries map[string][]byte) string {
+	t.Helper()
+
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for _, name := range names {
+		w, err := writer.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to create entry %s: %v", name, err)
+		}
+		if _, err := w.Write(entries[name]); err != nil {
+			t.Fatalf("Failed to write entry %s: %v", name, err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to finalize archive: %v", err)
+	}
+
+	zipPath := filepath.Join(t.TempDir(), "books.zip")
+	if err := os.WriteFile(zipPath, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write archive: %v", err)
+	}
+	return zipPath
+}
+
+func TestIsBookBundle(t *testing.T) {
+	epub, _ := os.ReadFile(testutil.CreateValidEPUB(t, "book.epub", nil))
+
+	if !IsBookBundle(writeZip(t, map[string][]byte{"Author/book.epub": epub})) {
+		t.Error("an archive of EPUBs should be a bundle")
+	}
+	if IsBookBundle(writeZip(t, map[string][]byte{"001.jpg": {0xFF, 0xD8}, "002.jpg": {0xFF, 0xD8}})) {
+		t.Error("an archive of pages is a comic")
+	}
+	if IsBookBundle(writeZip(t, map[string][]byte{"__MACOSX/._book.epub": epub, "001.png": {0x89}})) {
+		t.Error("resource forks aren't books")
+	}
+	if IsBookBundle(filepath.Join(t.TempDir(), "missing.zip")) {
+		t.Error("a missing file isn't a bundle")
+	}
+}
+
+func TestExtractBundle(t *testing.T) {
+	epub, _ := os.ReadFile(testutil.CreateValidEPUB(t, "book.epub", nil))
+	zipPath := writeZip(t, map[string][]byte{
+		"Austen/Emma.epub":             epub,
+		"Austen/Persuasion.epub":       epub,
+		"Dickens/Volume 1/Emma.epub":   epub,
+		"notes.txt":                    []byte("not a book in a bundle"),
+		"cover.jpg":                    {0xFF, 0xD8},
+		".DS_Store":                    {0},
+		"__MACOSX/Austen/._Emma.epub":  {0},
+		"../escape.epub":               epub,
+		"/etc/absolute.epub":           epub,
+		"Dickens/../../sneaky.epub":    epub,
+		"Dickens/Volume 1/.hidden.fb2": []byte("<FictionBook/>"),
+	})
+
+	dir := t.TempDir()
+	entries, skipped, err := ExtractBundle(zipPath, dir, DefaultBundleLimits)
+	if err != nil {
+		t.Fatalf("ExtractBundle() failed: %v", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+		if !strings.HasPrefix(entry.Path, dir+string(filepath.Separator)) {
+			t.Errorf("%s extracted outside the directory: %s", entry.Name, entry.Path)
+		}
+		data, err := os.ReadFile(entry.Path)
+		if err != nil || !bytes.Equal(data, epub) {
+			t.Errorf("%s extracted wrongly: %v", entry.Name, err)
+		}
+	}
+	want := []string{"Austen/Emma.epub", "Austen/Persuasion.epub", "Dickens/Volume 1/Emma.epub"}
+	if strings.Join(names, "|") != strings.Join(want, "|") {
+		t.Errorf("extracted %v, want %v", names, want)
+	}
+	if entries[2].Filename() != "Emma.epub" {
+		t.Errorf("Filename() = %q", entries[2].Filename())
+	}
+
+	reasons := map[string]string{}
+	for _, skip := range skipped {
+		reasons[skip.Name] = skip.Reason
+	}
+	wantReasons := map[string]string{
+		"notes.txt":                 "not a supported book",
+		"cover.jpg":                 "not a supported book",
+		"../escape.epub":            "path leaves the archive",
+		"/etc/absolute.epub":        "absolute path",
+		"Dickens/../../sneaky.epub": "path leaves the archive",
+	}
+	for name, reason := range wantReasons {
+		if reasons[name] != reason {
+			t.Errorf("skipped %s for %q, want %q", name, reasons[name], reason)
+		}
+	}
+	if len(skipped) != len(wantReasons) {
+		t.Errorf("skipped %+v", skipped)
+	}
+
+	// Nothing was written next to the directory
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "escape.epub")); !os.IsNotExist(err) {
+		t.Error("an entry escaped the directory")
+	}
+}
+
+func TestExtractBundle_Limits(t *testing.T) {
+	epub, _ := os.ReadFile(testutil.CreateValidEPUB(t, "book.epub", nil))
+	// Zeros compress to almost nothing
+	bomb := make([]byte, 4<<20)
+
+	t.Run("compression ratio", func(t *testing.T) {
+		zipPath := writeZip(t, map[string][]byte{"bomb.epub": bomb, "book.epub": epub})
+		entries, skipped, err := ExtractBundle(zipPath, t.TempDir(), DefaultBundleLimits)
+		if err != nil {
+			t.Fatalf("ExtractBundle() failed: %v", err)
+		}
+		if len(entries) != 1 || len(skipped) != 1 || skipped[0].Reason != "suspiciously high compression ratio" {
+			t.Errorf("entries = %+v, skipped = %+v", entries, skipped)
+		}
+	})
+
+	t.Run("entry size", func(t *testing.T) {
+		limits := DefaultBundleLimits
+		limits.MaxEntrySize = 1 << 20
+		zipPath := writeZip(t, map[string][]byte{"bomb.epub": bomb, "book.epub": epub})
+		entries, skipped, err := ExtractBundle(zipPath, t.TempDir(), limits)
+		if err != nil {
+			t.Fatalf("ExtractBundle() failed: %v", err)
+		}
+		if len(entries) != 1 || len(skipped) != 1 || skipped[0].Reason != "larger than the size limit" {
+			t.Errorf("entries = %+v, skipped = %+v", entries, skipped)
+		}
+	})
+
+	t.Run("total size", func(t *testing.T) {
+		limits := DefaultBundleLimits
+		limits.MaxTotalSize = int64(len(epub)) * 2
+		zipPath := writeZip(t, map[string][]byte{"a.epub": epub, "b.epub": epub, "c.epub": epub})
+		dir := t.TempDir()
+		if _, _, err := ExtractBundle(zipPath, dir, limits); !errors.Is(err, ErrBundleTooLarge) {
+			t.Errorf("ExtractBundle() error = %v, want %v", err, ErrBundleTooLarge)
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("left %d file(s) behind", len(entries))
+		}
+	})
+
+	t.Run("entry count", func(t *testing.T) {
+		limits := DefaultBundleLimits
+		limits.MaxEntries = 2
+		zipPath := writeZip(t, map[string][]byte{"a.epub": epub, "b.epub": epub, "c.epub": epub})
+		if _, _, err := ExtractBundle(zipPath, t.TempDir(), limits); err == nil {
+			t.Error("ExtractBundle() should refuse an archive with too many books")
+		}
+	})
+}
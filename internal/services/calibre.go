@@ -0,0 +1,339 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"bookify/internal/db"
+)
+
+// Formats taken from a Calibre library, most preferred first
+var calibreFormats = []string{"EPUB", "FB2", "CBZ", "CBR"}
+
+// Custom columns whose values are treated as shelves, matched on their
+// lookup name without the #
+var calibreShelfColumns = []string{"shelf", "shelves", "collections"}
+
+// CalibreBook is a book in a Calibre library, with the file Bookify would
+// convert.
+type CalibreBook struct {
+	ID          int
+	Title       string
+	Authors     []string
+	Series      string
+	SeriesIndex float64
+	Tags        []string
+	Shelves     []string
+	// Out of 10, as Calibre stores it
+	Rating int
+	// File relative to the library folder, empty without a format Bookify
+	// can convert
+	File string
+}
+
+// Author returns the authors joined the way Calibre shows them.
+func (b *CalibreBook) Author() string {
+	return strings.Join(b.Authors, " & ")
+}
+
+// Filename is the name the book is queued and delivered under, so the
+// Drive file carries the series and author.
+func (b *CalibreBook) Filename() string {
+	name := b.Title
+	if b.Series != "" {
+		name = fmt.Sprintf("%s %s - %s", b.Series, strconv.FormatFloat(b.SeriesIndex, 'f', -1, 64), b.Title)
+	}
+	if author := b.Author(); author != "" {
+		name += " - " + author
+	}
+	return strings.NewReplacer("/", "_", "\\", "_").Replace(name) + strings.ToLower(filepath.Ext(b.File))
+}
+
+// CalibreFilter picks books by tag, series and shelf. Empty fields match
+// every book.
+type CalibreFilter struct {
+	Tag    string
+	Series string
+	Shelf  string
+}
+
+func (f CalibreFilter) Matches(book *CalibreBook) bool {
+	if f.Series != "" && book.Series != f.Series {
+		return false
+	}
+	if f.Tag != "" && !containsString(book.Tags, f.Tag) {
+		return false
+	}
+	if f.Shelf != "" && !containsString(book.Shelves, f.Shelf) {
+		return false
+	}
+	return true
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// CalibreLibrary reads a Calibre library folder and its metadata.db. The
+// database is opened read-only, so Calibre can keep using it.
+type CalibreLibrary struct {
+	Dir string
+	db  *gorm.DB
+}
+
+func OpenCalibreLibrary(dir string) (*CalibreLibrary, error) {
+	path := filepath.Join(dir, "metadata.db")
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("no Calibre library in %s: %w", dir, err)
+	}
+	database, err := gorm.Open(sqlite.Open("file:"+path+"?mode=ro"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Calibre library: %w", err)
+	}
+	return &CalibreLibrary{Dir: dir, db: database}, nil
+}
+
+func (l *CalibreLibrary) Close() error {
+	sqlDB, err := l.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+// Books returns every book in the library, sorted by title.
+func (l *CalibreLibrary) Books() ([]CalibreBook, error) {
+	var rows []struct {
+		ID          int
+		Title       string
+		Path        string
+		SeriesIndex float64
+	}
+	if err := l.db.Raw("SELECT id, title, path, series_index FROM books ORDER BY sort, id").Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to read Calibre books: %w", err)
+	}
+
+	books := make([]CalibreBook, len(rows))
+	byID := make(map[int]*CalibreBook, len(rows))
+	for i, row := range rows {
+		books[i] = CalibreBook{ID: row.ID, Title: row.Title, SeriesIndex: row.SeriesIndex}
+		byID[row.ID] = &books[i]
+	}
+
+	var links []struct {
+		Book  int
+		Value string
+	}
+	if err := l.db.Raw("SELECT l.book, a.name AS value FROM books_authors_link l JOIN authors a ON a.id = l.author ORDER BY l.id").Scan(&links).Error; err != nil {
+		return nil, fmt.Errorf("failed to read Calibre authors: %w", err)
+	}
+	for _, link := range links {
+		if book := byID[link.Book]; book != nil {
+			book.Authors = append(book.Authors, link.Value)
+		}
+	}
+
+	links = nil
+	if err := l.db.Raw("SELECT l.book, s.name AS value FROM books_series_link l JOIN series s ON s.id = l.series").Scan(&links).Error; err != nil {
+		return nil, fmt.Errorf("failed to read Calibre series: %w", err)
+	}
+	for _, link := range links {
+		if book := byID[link.Book]; book != nil {
+			book.Series = link.Value
+		}
+	}
+
+	links = nil
+	if err := l.db.Raw("SELECT l.book, t.name AS value FROM books_tags_link l JOIN tags t ON t.id = l.tag ORDER BY t.name").Scan(&links).Error; err != nil {
+		return nil, fmt.Errorf("failed to read Calibre tags: %w", err)
+	}
+	for _, link := range links {
+		if book := byID[link.Book]; book != nil {
+			book.Tags = append(book.Tags, link.Value)
+		}
+	}
+
+	links = nil
+	if err := l.db.Raw("SELECT l.book, CAST(r.rating AS TEXT) AS value FROM books_ratings_link l JOIN ratings r ON r.id = l.rating").Scan(&links).Error; err != nil {
+		return nil, fmt.Errorf("failed to read Calibre ratings: %w", err)
+	}
+	for _, link := range links {
+		if book := byID[link.Book]; book != nil {
+			book.Rating, _ = strconv.Atoi(link.Value)
+		}
+	}
+
+	if err := l.readShelves(byID); err != nil {
+		return nil, err
+	}
+
+	var formats []struct {
+		Book   int
+		Format string
+		Name   string
+	}
+	if err := l.db.Raw("SELECT book, format, name FROM data").Scan(&formats).Error; err != nil {
+		return nil, fmt.Errorf("failed to read Calibre formats: %w", err)
+	}
+	files := make(map[int]map[string]string)
+	for _, f := range formats {
+		if files[f.Book] == nil {
+			files[f.Book] = make(map[string]string)
+		}
+		files[f.Book][strings.ToUpper(f.Format)] = f.Name + "." + strings.ToLower(f.Format)
+	}
+	for i, row := range rows {
+		for _, format := range calibreFormats {
+			if name, ok := files[row.ID][format]; ok {
+				books[i].File = filepath.Join(filepath.FromSlash(row.Path), name)
+				break
+			}
+		}
+	}
+
+	return books, nil
+}
+
+// readShelves fills in the shelves from the first shelf custom column.
+func (l *CalibreLibrary) readShelves(byID map[int]*CalibreBook) error {
+	var columns []struct {
+		ID       int
+		Label    string
+		Datatype string
+	}
+	if err := l.db.Raw("SELECT id, label, datatype FROM custom_columns").Scan(&columns).Error; err != nil {
+		// Libraries that never had custom columns may lack the table
+		log.Printf("Warning: Failed to read Calibre custom columns: %v", err)
+		return nil
+	}
+	for _, column := range columns {
+		if column.Datatype != "text" || !containsString(calibreShelfColumns, strings.ToLower(column.Label)) {
+			continue
+		}
+		var links []struct {
+			Book  int
+			Value string
+		}
+		query := fmt.Sprintf("SELECT l.book, v.value FROM books_custom_column_%d_link l JOIN custom_column_%d v ON v.id = l.value ORDER BY v.value", column.ID, column.ID)
+		if err := l.db.Raw(query).Scan(&links).Error; err != nil {
+			return fmt.Errorf("failed to read Calibre shelves: %w", err)
+		}
+		for _, link := range links {
+			if book := byID[link.Book]; book != nil {
+				book.Shelves = append(book.Shelves, link.Value)
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
+// CalibreChoices lists the tags, series and shelves used by the books, for
+// choosing what to import.
+func CalibreChoices(books []CalibreBook) (tags, series, shelves []string) {
+	seen := map[string]map[string]bool{"tag": {}, "series": {}, "shelf": {}}
+	add := func(kind, value string, list *[]string) {
+		if value != "" && !seen[kind][value] {
+			seen[kind][value] = true
+			*list = append(*list, value)
+		}
+	}
+	for _, book := range books {
+		for _, tag := range book.Tags {
+			add("tag", tag, &tags)
+		}
+		add("series", book.Series, &series)
+		for _, shelf := range book.Shelves {
+			add("shelf", shelf, &shelves)
+		}
+	}
+	sort.Strings(tags)
+	sort.Strings(series)
+	sort.Strings(shelves)
+	return tags, series, shelves
+}
+
+// QueueCalibreBooks copies the books' files to the temp directory and queues
+// them for the account with their Calibre metadata. It returns how many were
+// queued and how many were skipped as duplicates.
+func QueueCalibreBooks(dbService *db.Service, tempDir string, account *db.Account, library *CalibreLibrary, books []CalibreBook) (int, int, error) {
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		return 0, 0, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+
+	queued, skipped := 0, 0
+	for i := range books {
+		book := &books[i]
+		if book.File == "" {
+			skipped++
+			continue
+		}
+		path := filepath.Join(library.Dir, book.File)
+		sourceHash, err := HashFile(path)
+		if err != nil {
+			return queued, skipped, fmt.Errorf("failed to read %s: %w", book.Title, err)
+		}
+
+		if account.DuplicatePolicy != db.DuplicatePolicyForce {
+			duplicate, err := dbService.FindDuplicateJob(account.ID, sourceHash)
+			if err != nil {
+				log.Printf("Warning: Failed to check for duplicates: %v", err)
+			}
+			if duplicate != nil {
+				if _, err := dbService.CreateSkippedJob(account.ID, book.Filename(), sourceHash, duplicate); err != nil {
+					return queued, skipped, fmt.Errorf("failed to record skipped book: %w", err)
+				}
+				skipped++
+				continue
+			}
+		}
+
+		tempFile, filename, err := CreateTempFile(tempDir, book.Filename())
+		if err != nil {
+			return queued, skipped, fmt.Errorf("failed to create temp file: %w", err)
+		}
+		tempPath := tempFile.Name()
+		_ = tempFile.Close() // Only the name is needed; copyFile writes the book
+		if err := copyFile(path, tempPath); err != nil {
+			removeTempFile(tempPath)
+			return queued, skipped, err
+		}
+		job := &db.Job{
+			AccountID:        account.ID,
+			OriginalFilename: filename,
+			SourceHash:       sourceHash,
+			Title:            book.Title,
+			Author:           book.Author(),
+			Series:           book.Series,
+			SeriesIndex:      book.SeriesIndex,
+			Tags:             strings.Join(book.Tags, ", "),
+			Rating:           book.Rating,
+			Message:          "Imported from Calibre",
+		}
+		if err := dbService.QueueJob(job); err != nil {
+			removeTempFile(tempPath)
+			return queued, skipped, fmt.Errorf("failed to queue job: %w", err)
+		}
+		queued++
+	}
+	if queued > 0 {
+		log.Printf("Queued %d book(s) from Calibre for %s", queued, account.Name)
+	}
+	return queued, skipped, nil
+}
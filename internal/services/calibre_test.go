@@ -0,0 +1,164 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"bookify/internal/db"
+	"bookify/internal/testutil"
+)
+
+func openTestCalibreLibrary(t *testing.T) (*CalibreLibrary, []CalibreBook) {
+	t.Helper()
+
+	library, err := OpenCalibreLibrary(testutil.CreateCalibreLibrary(t))
+	if err != nil {
+		t.Fatalf("OpenCalibreLibrary() failed: %v", err)
+	}
+	t.Cleanup(func() { _ = library.Close() })
+	books, err := library.Books()
+	if err != nil {
+		t.Fatalf("Books() failed: %v", err)
+	}
+	return library, books
+}
+
+func TestCalibreLibrary_Books(t *testing.T) {
+	_, books := openTestCalibreLibrary(t)
+
+	if len(books) != 3 {
+		t.Fatalf("got %d books, want 3", len(books))
+	}
+	// Sorted by Calibre's title sort
+	fifth, obelisk, notes := books[0], books[1], books[2]
+	if fifth.Title != "The Fifth Season" || obelisk.Title != "The Obelisk Gate" || notes.Title != "Scanned Notes" {
+		t.Fatalf("books = %q, %q, %q", fifth.Title, obelisk.Title, notes.Title)
+	}
+
+	if fifth.Author() != "N. K. Jemisin" || fifth.Series != "The Broken Earth" || fifth.SeriesIndex != 1 || fifth.Rating != 10 {
+		t.Errorf("fifth season = %+v", fifth)
+	}
+	if len(fifth.Tags) != 2 || fifth.Tags[0] != "Award winner" || fifth.Tags[1] != "Fantasy" {
+		t.Errorf("tags = %v", fifth.Tags)
+	}
+	if want := filepath.Join("N. K. Jemisin", "The Fifth Season (1)", "The Fifth Season - N. K. Jemisin.epub"); fifth.File != want {
+		t.Errorf("File = %q, want %q", fifth.File, want)
+	}
+	if len(obelisk.Shelves) != 1 || obelisk.Shelves[0] != "To read" {
+		t.Errorf("shelves = %v", obelisk.Shelves)
+	}
+	if notes.File != "" {
+		t.Errorf("a PDF-only book should have no file, got %q", notes.File)
+	}
+
+	if got, want := obelisk.Filename(), "The Broken Earth 2 - The Obelisk Gate - N. K. Jemisin.epub"; got != want {
+		t.Errorf("Filename() = %q, want %q", got, want)
+	}
+
+	tags, series, shelves := CalibreChoices(books)
+	if len(tags) != 2 || len(series) != 1 || len(shelves) != 1 {
+		t.Errorf("CalibreChoices() = %v, %v, %v", tags, series, shelves)
+	}
+}
+
+func TestCalibreFilter_Matches(t *testing.T) {
+	_, books := openTestCalibreLibrary(t)
+
+	tests := []struct {
+		name   string
+		filter CalibreFilter
+		want   int
+	}{
+		{"everything", CalibreFilter{}, 3},
+		{"tag", CalibreFilter{Tag: "Award winner"}, 1},
+		{"series", CalibreFilter{Series: "The Broken Earth"}, 2},
+		{"shelf", CalibreFilter{Shelf: "To read"}, 1},
+		{"tag and series", CalibreFilter{Tag: "Fantasy", Series: "The Broken Earth"}, 2},
+		{"no match", CalibreFilter{Tag: "Romance"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matched := 0
+			for i := range books {
+				if tt.filter.Matches(&books[i]) {
+					matched++
+				}
+			}
+			if matched != tt.want {
+				t.Errorf("matched %d books, want %d", matched, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueueCalibreBooks(t *testing.T) {
+	library, books := openTestCalibreLibrary(t)
+
+	testDB := testutil.SetupTestDB(t)
+	if err := testDB.AutoMigrate(&db.Account{}, &db.Job{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	dbService := db.NewService(testDB)
+	account, _ := dbService.CreateAccount("test-account", "folder-123")
+	tempDir := t.TempDir()
+
+	queued, skipped, err := QueueCalibreBooks(dbService, tempDir, account, library, books)
+	if err != nil {
+		t.Fatalf("QueueCalibreBooks() failed: %v", err)
+	}
+	if queued != 2 || skipped != 1 {
+		t.Errorf("queued %d, skipped %d, want 2 and 1", queued, skipped)
+	}
+
+	jobs, _ := dbService.ListRecentJobs(10)
+	var job *db.Job
+	for i := range jobs {
+		if jobs[i].Title == "The Fifth Season" {
+			job = &jobs[i]
+		}
+	}
+	if job == nil {
+		t.Fatalf("no job for The Fifth Season in %+v", jobs)
+	}
+	if job.OriginalFilename != "The Broken Earth 1 - The Fifth Season - N. K. Jemisin.epub" ||
+		job.Author != "N. K. Jemisin" || job.Series != "The Broken Earth" || job.SeriesIndex != 1 ||
+		job.Tags != "Award winner, Fantasy" || job.Rating != 10 {
+		t.Errorf("job = %+v", job)
+	}
+	if data, err := os.ReadFile(filepath.Join(tempDir, job.OriginalFilename)); err != nil || string(data) != "fifth season" {
+		t.Errorf("temp file = %q, %v", data, err)
+	}
+
+	// Importing again skips the books already converted
+	for i := range jobs {
+		jobs[i].Status = "completed"
+		_ = dbService.UpdateJob(&jobs[i])
+	}
+	queued, skipped, err = QueueCalibreBooks(dbService, tempDir, account, library, books)
+	if err != nil {
+		t.Fatalf("second QueueCalibreBooks() failed: %v", err)
+	}
+	if queued != 0 || skipped != 3 {
+		t.Errorf("second import queued %d, skipped %d, want 0 and 3", queued, skipped)
+	}
+}
+
+func TestNewLibraryBook_JobMetadata(t *testing.T) {
+	job := &db.Job{
+		ID:               "job-1",
+		OriginalFilename: "book.epub",
+		Title:            "Calibre Title",
+		Series:           "Calibre Series",
+		SeriesIndex:      3,
+		Tags:             "Fantasy",
+		Rating:           8,
+	}
+	metadata := &BookMetadata{Title: "EPUB Title", Author: "EPUB Author", Series: "EPUB Series", SeriesIndex: 1}
+
+	book := newLibraryBook(job, metadata, "book.kepub.epub", "")
+	if book.Title != "Calibre Title" || book.Author != "EPUB Author" || book.Series != "Calibre Series" ||
+		book.SeriesIndex != 3 || book.Tags != "Fantasy" || book.Rating != 8 {
+		t.Errorf("book = %+v", book)
+	}
+}
@@ -0,0 +1,410 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/nwaples/rardecode/v2"
+	_ "golang.org/x/image/webp"
+)
+
+var ErrNoComicPages = errors.New("archive contains no images")
+
+// comicLimits bound how much of a comic archive is read into memory, as
+// every page is held until the EPUB is written.
+var comicLimits = BundleLimits{
+	MaxEntries:   5000,
+	MaxEntrySize: 50 << 20,
+	MaxTotalSize: 1 << 30,
+	MaxRatio:     100,
+}
+
+type comicPage struct {
+	name   string
+	data   []byte
+	format string
+	width  int
+	height int
+}
+
+// comicInfo is the subset of ComicRack's ComicInfo.xml that Bookify uses.
+type comicInfo struct {
+	Title       string `xml:"Title"`
+	Series      string `xml:"Series"`
+	Number      string `xml:"Number"`
+	Writer      string `xml:"Writer"`
+	Publisher   string `xml:"Publisher"`
+	Summary     string `xml:"Summary"`
+	LanguageISO string `xml:"LanguageISO"`
+	Manga       string `xml:"Manga"`
+}
+
+// buildComicEPUB turns a CBZ/CBR archive into a fixed-layout EPUB 3 with one
+// page per image.
+func buildComicEPUB(inputPath, outputPath string, isRAR bool, opts ImportOptions, fallbackTitle string) error {
+	var pages []comicPage
+	var info *comicInfo
+	var err error
+	if isRAR {
+		pages, info, err = readRARComic(inputPath)
+	} else {
+		pages, info, err = readZIPComic(inputPath)
+	}
+	if err != nil {
+		return err
+	}
+	if len(pages) == 0 {
+		return ErrNoComicPages
+	}
+
+	sort.Slice(pages, func(i, j int) bool {
+		return naturalLess(pages[i].name, pages[j].name)
+	})
+
+	rightToLeft := opts.RightToLeft
+	if info != nil && strings.EqualFold(info.Manga, "YesAndRightToLeft") {
+		rightToLeft = true
+	}
+
+	var decoded []comicPage
+	for _, page := range pages {
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(page.data))
+		if err != nil {
+			log.Printf("Skipping unreadable comic page %s: %v", page.name, err)
+			continue
+		}
+		if err := checkImageSize(cfg); err != nil {
+			log.Printf("Skipping comic page %s: %v", page.name, err)
+			continue
+		}
+		page.width, page.height = cfg.Width, cfg.Height
+
+		// Kobo devices cannot display WebP, so those pages become JPEGs
+		if format == "webp" {
+			img, _, err := image.Decode(bytes.NewReader(page.data))
+			if err != nil {
+				log.Printf("Skipping unreadable comic page %s: %v", page.name, err)
+				continue
+			}
+			var buf bytes.Buffer
+			if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+				return fmt.Errorf("failed to convert %s: %w", page.name, err)
+			}
+			page.data, format = buf.Bytes(), "jpeg"
+		}
+		page.format = format
+
+		if opts.SplitSpreads && page.width > page.height {
+			halves, err := splitSpread(page, rightToLeft)
+			if err != nil {
+				return err
+			}
+			decoded = append(decoded, halves...)
+			continue
+		}
+		decoded = append(decoded, page)
+	}
+	if len(decoded) == 0 {
+		return ErrNoComicPages
+	}
+
+	builder := newEPUBBuilder(fallbackTitle)
+	if info != nil {
+		applyComicInfo(builder, info)
+	}
+	if opts.Title != "" {
+		builder.title = opts.Title
+	}
+	if opts.Author != "" {
+		builder.author = opts.Author
+	}
+	builder.fixedLayout = true
+	builder.rightToLeft = rightToLeft
+	builder.spread = "landscape"
+	if opts.SplitSpreads {
+		builder.spread = "none"
+	}
+	builder.resolution = fmt.Sprintf("%dx%d", decoded[0].width, decoded[0].height)
+
+	for i, page := range decoded {
+		number := i + 1
+		imageID := fmt.Sprintf("img-%04d", number)
+		imageHref := fmt.Sprintf("images/page-%04d.%s", number, imageExtension(page.format))
+		builder.addFile(imageID, imageHref, "image/"+page.format, page.data)
+		if i == 0 {
+			builder.setCover(imageID)
+		}
+
+		tocTitle := ""
+		if i == 0 {
+			tocTitle = builder.title
+		}
+		builder.addDocument(
+			fmt.Sprintf("page-%04d", number),
+			fmt.Sprintf("text/page-%04d.xhtml", number),
+			tocTitle,
+			[]byte(comicPageDocument(number, "../"+imageHref, page.width, page.height)),
+		)
+	}
+
+	return builder.write(outputPath)
+}
+
+func readZIPComic(inputPath string) ([]comicPage, *comicInfo, error) {
+	zipReader, err := zip.OpenReader(inputPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open comic archive: %w", err)
+	}
+	defer func() {
+		if closeErr := zipReader.Close(); closeErr != nil {
+			log.Printf("Warning: Failed to close ZIP reader: %v", closeErr)
+		}
+	}()
+
+	var pages []comicPage
+	var info *comicInfo
+	var total int64
+	for _, f := range zipReader.File {
+		if f.FileInfo().IsDir() || skipArchiveEntry(f.Name) {
+			continue
+		}
+		isInfo := strings.EqualFold(path.Base(f.Name), "ComicInfo.xml")
+		if !isInfo && comicImageFormat(f.Name) == "" {
+			continue
+		}
+		if len(pages) >= comicLimits.MaxEntries {
+			return nil, nil, ErrBundleTooLarge
+		}
+		if reason := unsafeEntry(f, comicLimits); reason != "" {
+			return nil, nil, fmt.Errorf("%s is %s: %w", f.Name, reason, ErrBundleTooLarge)
+		}
+		data, err := readZipFileLimit(f, min(comicLimits.MaxEntrySize, comicLimits.MaxTotalSize-total))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read %s: %w", f.Name, err)
+		}
+		total += int64(len(data))
+		if isInfo {
+			info = parseComicInfo(data)
+			continue
+		}
+		pages = append(pages, comicPage{name: f.Name, data: data})
+	}
+	return pages, info, nil
+}
+
+func readRARComic(inputPath string) ([]comicPage, *comicInfo, error) {
+	rarReader, err := rardecode.OpenReader(inputPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open comic archive: %w", err)
+	}
+	defer func() {
+		if closeErr := rarReader.Close(); closeErr != nil {
+			log.Printf("Warning: Failed to close RAR reader: %v", closeErr)
+		}
+	}()
+
+	var pages []comicPage
+	var info *comicInfo
+	var total int64
+	for {
+		header, err := rarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read comic archive: %w", err)
+		}
+		if header.IsDir || skipArchiveEntry(header.Name) {
+			continue
+		}
+		isInfo := strings.EqualFold(path.Base(header.Name), "ComicInfo.xml")
+		if !isInfo && comicImageFormat(header.Name) == "" {
+			continue
+		}
+		if len(pages) >= comicLimits.MaxEntries {
+			return nil, nil, ErrBundleTooLarge
+		}
+		data, err := readLimited(rarReader, min(comicLimits.MaxEntrySize, comicLimits.MaxTotalSize-total))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read %s: %w", header.Name, err)
+		}
+		total += int64(len(data))
+		if isInfo {
+			info = parseComicInfo(data)
+			continue
+		}
+		pages = append(pages, comicPage{name: header.Name, data: data})
+	}
+	return pages, info, nil
+}
+
+func parseComicInfo(data []byte) *comicInfo {
+	var info comicInfo
+	if err := xml.Unmarshal(data, &info); err != nil {
+		log.Printf("Warning: Ignoring unreadable ComicInfo.xml: %v", err)
+		return nil
+	}
+	return &info
+}
+
+func applyComicInfo(builder *epubBuilder, info *comicInfo) {
+	switch {
+	case info.Title != "":
+		builder.title = info.Title
+	case info.Series != "" && info.Number != "":
+		builder.title = info.Series + " #" + info.Number
+	case info.Series != "":
+		builder.title = info.Series
+	}
+	builder.author = info.Writer
+	builder.publisher = info.Publisher
+	builder.description = info.Summary
+	builder.series = info.Series
+	builder.seriesIndex = info.Number
+	if info.LanguageISO != "" {
+		builder.language = info.LanguageISO
+	}
+}
+
+// splitSpread cuts a double-page spread into its two pages, in reading order.
+func splitSpread(page comicPage, rightToLeft bool) ([]comicPage, error) {
+	img, _, err := decodeImage(page.data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode spread %s: %w", page.name, err)
+	}
+
+	bounds := img.Bounds()
+	mid := bounds.Min.X + bounds.Dx()/2
+	left := image.Rect(bounds.Min.X, bounds.Min.Y, mid, bounds.Max.Y)
+	right := image.Rect(mid, bounds.Min.Y, bounds.Max.X, bounds.Max.Y)
+	order := []image.Rectangle{left, right}
+	if rightToLeft {
+		order = []image.Rectangle{right, left}
+	}
+
+	format := page.format
+	if format != "png" {
+		format = "jpeg"
+	}
+
+	var halves []comicPage
+	for i, rect := range order {
+		half := image.NewNRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+		for y := 0; y < rect.Dy(); y++ {
+			for x := 0; x < rect.Dx(); x++ {
+				half.Set(x, y, img.At(rect.Min.X+x, rect.Min.Y+y))
+			}
+		}
+
+		var buf bytes.Buffer
+		if format == "png" {
+			err = png.Encode(&buf, half)
+		} else {
+			err = jpeg.Encode(&buf, half, &jpeg.Options{Quality: 90})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode spread half: %w", err)
+		}
+
+		halves = append(halves, comicPage{
+			name:   fmt.Sprintf("%s#%d", page.name, i),
+			data:   buf.Bytes(),
+			format: format,
+			width:  rect.Dx(),
+			height: rect.Dy(),
+		})
+	}
+	return halves, nil
+}
+
+func comicPageDocument(number int, imageHref string, width, height int) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head>
+<title>Page %[1]d</title>
+<meta name="viewport" content="width=%[3]d, height=%[4]d"/>
+<style>html, body { margin: 0; padding: 0; width: %[3]dpx; height: %[4]dpx; } img { display: block; width: %[3]dpx; height: %[4]dpx; }</style>
+</head>
+<body>
+<img src="%[2]s" alt="Page %[1]d"/>
+</body>
+</html>
+`, number, escapeXML(imageHref), width, height)
+}
+
+func comicImageFormat(name string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".jpg", ".jpeg":
+		return "jpeg"
+	case ".png":
+		return "png"
+	case ".gif":
+		return "gif"
+	case ".webp":
+		return "webp"
+	}
+	return ""
+}
+
+func imageExtension(format string) string {
+	if format == "jpeg" {
+		return "jpg"
+	}
+	return format
+}
+
+// skipArchiveEntry filters out hidden files and macOS resource forks.
+func skipArchiveEntry(name string) bool {
+	if strings.HasPrefix(name, "__MACOSX/") {
+		return true
+	}
+	return strings.HasPrefix(path.Base(name), ".")
+}
+
+// naturalLess orders names so that "page2" sorts before "page10".
+func naturalLess(a, b string) bool {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	for a != "" && b != "" {
+		ra, rb := rune(a[0]), rune(b[0])
+		if unicode.IsDigit(ra) && unicode.IsDigit(rb) {
+			na, restA := leadingNumber(a)
+			nb, restB := leadingNumber(b)
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			a, b = restA, restB
+			continue
+		}
+		if ra != rb {
+			return ra < rb
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+// leadingNumber splits the leading run of digits off s, without leading zeros.
+func leadingNumber(s string) (string, string) {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	number := strings.TrimLeft(s[:i], "0")
+	return number, s[i:]
+}
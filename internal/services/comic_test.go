@@ -0,0 +1,196 @@
+package services
+
+import (
+	"archive/zip"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bookify/internal/testutil"
+)
+
+func readEPUBPackageFile(t *testing.T, epubPath string) *epubBook {
+	t.Helper()
+
+	zipReader, err := zip.OpenReader(epubPath)
+	if err != nil {
+		t.Fatalf("Failed to open EPUB: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = zipReader.Close() // Error ignored in test
+	})
+
+	book, err := readEPUBPackage(&zipReader.Reader)
+	if err != nil {
+		t.Fatalf("Failed to read package document: %v", err)
+	}
+	return book
+}
+
+func TestNaturalLess(t *testing.T) {
+	names := []string{"page10.png", "page2.png", "Page1.png", "page02b.png", "extra.png"}
+	want := []string{"extra.png", "Page1.png", "page2.png", "page02b.png", "page10.png"}
+
+	for i := 0; i < len(names); i++ {
+		for j := i + 1; j < len(names); j++ {
+			if naturalLess(names[j], names[i]) {
+				names[i], names[j] = names[j], names[i]
+			}
+		}
+	}
+
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("natural order = %v, want %v", names, want)
+	}
+}
+
+func TestImporterService_Import_CBZ(t *testing.T) {
+	inputPath := testutil.CreateEPUBArchive(t, "comic.cbz", map[string]string{
+		"Comic/page10.png":           testPNG(t, 60, 80),
+		"Comic/page2.png":            testPNG(t, 60, 80),
+		"Comic/page1.png":            testPNG(t, 60, 80),
+		"__MACOSX/Comic/._page1.png": "resource fork",
+		"Comic/.DS_Store":            "finder",
+		"ComicInfo.xml": `<?xml version="1.0"?>
+<ComicInfo>
+  <Title>The Test Issue</Title>
+  <Series>Test Comics</Series>
+  <Number>3</Number>
+  <Writer>Jane Writer</Writer>
+  <Manga>YesAndRightToLeft</Manga>
+</ComicInfo>`,
+	})
+	outputPath := filepath.Join(t.TempDir(), "comic.epub")
+
+	importer := NewImporterService()
+	if err := importer.Import(inputPath, "comic.cbz", outputPath, ImportOptions{}); err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+
+	book := readEPUBPackageFile(t, outputPath)
+	if titles := book.pkg.Metadata.Titles; len(titles) != 1 || titles[0] != "The Test Issue" {
+		t.Errorf("titles = %v, want ComicInfo title", titles)
+	}
+	if len(book.pkg.Spine) != 3 {
+		t.Fatalf("spine has %d pages, want 3", len(book.pkg.Spine))
+	}
+	if page := book.item(book.pkg.Spine[1].IDRef); page == nil || page.Href != "text/page-0002.xhtml" {
+		t.Errorf("second spine item = %+v, want page 2", page)
+	}
+	first, err := readZipEntry(book.zip, "OEBPS/text/page-0001.xhtml")
+	if err != nil || !strings.Contains(string(first), "page-0001.png") {
+		t.Errorf("first page should show the first image")
+	}
+
+	opf, err := readZipEntry(book.zip, book.opfPath)
+	if err != nil {
+		t.Fatalf("Failed to read OPF: %v", err)
+	}
+	for _, want := range []string{`page-progression-direction="rtl"`, "pre-paginated", `properties="cover-image"`, "Jane Writer"} {
+		if !strings.Contains(string(opf), want) {
+			t.Errorf("OPF missing %q", want)
+		}
+	}
+
+	// The result must be a book the rest of the pipeline accepts as is
+	report, err := NewValidatorService().Validate(outputPath, filepath.Join(t.TempDir(), "repaired.epub"))
+	if err != nil {
+		t.Fatalf("Imported comic failed validation: %v", err)
+	}
+	if report.Repaired() {
+		t.Errorf("Imported comic needed repairs: %s", report)
+	}
+}
+
+func TestImporterService_Import_SplitSpreads(t *testing.T) {
+	inputPath := testutil.CreateEPUBArchive(t, "spread.cbz", map[string]string{
+		"01.png": testPNG(t, 60, 80),
+		"02.png": testPNG(t, 120, 80),
+	})
+	outputPath := filepath.Join(t.TempDir(), "spread.epub")
+
+	importer := NewImporterService()
+	err := importer.Import(inputPath, "spread.cbz", outputPath, ImportOptions{SplitSpreads: true})
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+
+	book := readEPUBPackageFile(t, outputPath)
+	if titles := book.pkg.Metadata.Titles; len(titles) != 1 || titles[0] != "spread" {
+		t.Errorf("titles = %v, want filename fallback", titles)
+	}
+	if len(book.pkg.Spine) != 3 {
+		t.Fatalf("spine has %d pages, want 3 after splitting the spread", len(book.pkg.Spine))
+	}
+
+	half, _ := readImageConfig(t, outputPath, "OEBPS/images/page-0002.png")
+	if half.Width != 60 || half.Height != 80 {
+		t.Errorf("split page = %dx%d, want 60x80", half.Width, half.Height)
+	}
+}
+
+func TestImporterService_Import_NoImages(t *testing.T) {
+	inputPath := testutil.CreateEPUBArchive(t, "empty.cbz", map[string]string{
+		"readme.txt": "no pages here",
+	})
+
+	importer := NewImporterService()
+	err := importer.Import(inputPath, "empty.cbz", filepath.Join(t.TempDir(), "empty.epub"), ImportOptions{})
+	if !errors.Is(err, ErrNoComicPages) {
+		t.Errorf("Import() error = %v, want ErrNoComicPages", err)
+	}
+}
+
+func TestImporterService_Import_ComicLimits(t *testing.T) {
+	// Pages claiming more pixels than the limit are skipped, not decoded
+	inputPath := testutil.CreateEPUBArchive(t, "bomb.cbz", map[string]string{
+		"01.png": testPNG(t, 60, 80),
+		"02.png": hugePNG(t, 100000, 100000),
+	})
+	outputPath := filepath.Join(t.TempDir(), "bomb.epub")
+	importer := NewImporterService()
+	if err := importer.Import(inputPath, "bomb.cbz", outputPath, ImportOptions{SplitSpreads: true}); err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if book := readEPUBPackageFile(t, outputPath); len(book.pkg.Spine) != 1 {
+		t.Errorf("spine has %d pages, want only the sensible one", len(book.pkg.Spine))
+	}
+
+	// Archives expanding past the size limit are refused
+	saved := comicLimits
+	t.Cleanup(func() { comicLimits = saved })
+	page := testPNG(t, 60, 80)
+	comicLimits.MaxTotalSize = int64(len(page)) * 3 / 2
+	inputPath = testutil.CreateEPUBArchive(t, "big.cbz", map[string]string{
+		"01.png": page,
+		"02.png": page,
+	})
+	err := importer.Import(inputPath, "big.cbz", filepath.Join(t.TempDir(), "big.epub"), ImportOptions{})
+	if !errors.Is(err, ErrBundleTooLarge) {
+		t.Errorf("Import() error = %v, want ErrBundleTooLarge", err)
+	}
+}
+
+func TestEPUBFilename(t *testing.T) {
+	tests := []struct {
+		filename    string
+		needsImport bool
+		expected    string
+	}{
+		{filename: "book.epub", needsImport: false, expected: "book.epub"},
+		{filename: "Issue 1.CBZ", needsImport: true, expected: "Issue 1.epub"},
+		{filename: "manga.cbr", needsImport: true, expected: "manga.epub"},
+		{filename: "notes.txt", needsImport: true, expected: "notes.epub"},
+		{filename: "scan.pdf", needsImport: false, expected: "scan.pdf"},
+	}
+
+	for _, tt := range tests {
+		if got := NeedsImport(tt.filename); got != tt.needsImport {
+			t.Errorf("NeedsImport(%q) = %v, want %v", tt.filename, got, tt.needsImport)
+		}
+		if got := EPUBFilename(tt.filename); got != tt.expected {
+			t.Errorf("EPUBFilename(%q) = %q, want %q", tt.filename, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	thumbnailMaxWidth  = 300
+	thumbnailMaxHeight = 450
+	thumbnailQuality   = 85
+
+	// coverScanDocuments limits how many spine documents are searched for a first image.
+	coverScanDocuments = 3
+)
+
+var ErrNoCover = errors.New("no cover image found")
+
+var imageRefPattern = regexp.MustCompile(`(?i)<(?:img|image)\b[^>]*?\s(?:src|xlink:href|href)\s*=\s*["']([^"']+)["']`)
+
+type CoverService struct {
+	dataDir string
+}
+
+func NewCoverService(dataDir string) *CoverService {
+	return &CoverService{dataDir: dataDir}
+}
+
+func (c *CoverService) ThumbnailPath(key string) string {
+	return filepath.Join(c.dataDir, "covers", key+".jpg")
+}
+
+func (c *CoverService) HasThumbnail(key string) bool {
+	_, err := os.Stat(c.ThumbnailPath(key))
+	return err == nil
+}
+
+// ExtractCover finds the cover image of the EPUB at epubPath and stores a
+// resized JPEG thumbnail for it under the data directory.
+func (c *CoverService) ExtractCover(epubPath, key string) (string, error) {
+	zipReader, err := zip.OpenReader(epubPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open EPUB as ZIP: %w", err)
+	}
+	defer func() {
+		if closeErr := zipReader.Close(); closeErr != nil {
+			log.Printf("Warning: Failed to close ZIP reader: %v", closeErr)
+		}
+	}()
+
+	book, err := readEPUBPackage(&zipReader.Reader)
+	if err != nil {
+		return "", err
+	}
+
+	coverPath := findCoverImage(book)
+	if coverPath == "" {
+		return "", ErrNoCover
+	}
+
+	data, err := book.read(coverPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read cover image: %w", err)
+	}
+
+	img, _, err := decodeImage(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode cover image %s: %w", coverPath, err)
+	}
+
+	thumbPath := c.ThumbnailPath(key)
+	if err := os.MkdirAll(filepath.Dir(thumbPath), 0755); err != nil {
+		return "", fmt.Errorf("failed to create cover directory: %w", err)
+	}
+
+	if err := writeJPEG(thumbPath, resizeToFit(img, thumbnailMaxWidth, thumbnailMaxHeight), thumbnailQuality); err != nil {
+		return "", err
+	}
+
+	return thumbPath, nil
+}
+
+// findCoverImage returns the archive path of the cover image, trying the
+// EPUB 3 cover-image property, the EPUB 2 cover meta and finally the first
+// image in the book.
+func findCoverImage(book *epubBook) string {
+	for _, item := range book.pkg.Manifest {
+		if hasProperty(item.Properties, "cover-image") && isImageMediaType(item.MediaType) {
+			return book.resolve(item.Href)
+		}
+	}
+
+	for _, meta := range book.pkg.Metadata.Metas {
+		if meta.Name != "cover" || meta.Content == "" {
+			continue
+		}
+		if item := book.item(meta.Content); item != nil && isImageMediaType(item.MediaType) {
+			return book.resolve(item.Href)
+		}
+		// Some books reference the image path rather than the manifest id
+		if item := book.itemByPath(book.resolve(meta.Content)); item != nil && isImageMediaType(item.MediaType) {
+			return book.resolve(item.Href)
+		}
+	}
+
+	for _, item := range book.pkg.Manifest {
+		if isImageMediaType(item.MediaType) && strings.Contains(strings.ToLower(item.ID+" "+item.Href), "cover") {
+			return book.resolve(item.Href)
+		}
+	}
+
+	for i, ref := range book.pkg.Spine {
+		if i >= coverScanDocuments {
+			break
+		}
+		item := book.item(ref.IDRef)
+		if item == nil {
+			continue
+		}
+		docPath := book.resolve(item.Href)
+		content, err := book.read(docPath)
+		if err != nil {
+			continue
+		}
+		for _, match := range imageRefPattern.FindAllSubmatch(content, -1) {
+			src := path.Join(path.Dir(docPath), unescapeHref(string(match[1])))
+			if img := book.itemByPath(src); img != nil && isImageMediaType(img.MediaType) {
+				return src
+			}
+		}
+	}
+
+	for _, item := range book.pkg.Manifest {
+		if isImageMediaType(item.MediaType) && item.MediaType != "image/svg+xml" {
+			return book.resolve(item.Href)
+		}
+	}
+
+	return ""
+}
+
+// resizeToFit scales img down so it fits within maxWidth x maxHeight while
+// keeping its aspect ratio. The result is flattened onto a white background so
+// transparent images encode cleanly as JPEG.
+func resizeToFit(img image.Image, maxWidth, maxHeight int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	newWidth, newHeight := width, height
+	if width > maxWidth || height > maxHeight {
+		scale := float64(maxWidth) / float64(width)
+		if s := float64(maxHeight) / float64(height); s < scale {
+			scale = s
+		}
+		newWidth = max(1, int(float64(width)*scale))
+		newHeight = max(1, int(float64(height)*scale))
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	if newWidth == width && newHeight == height {
+		draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Over)
+	} else {
+		draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+	}
+	return dst
+}
+
+func writeJPEG(filePath string, img image.Image, quality int) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to create image file: %w", err)
+	}
+	defer func() {
+		if closeErr := file.Close(); closeErr != nil {
+			log.Printf("Warning: Failed to close image file: %v", closeErr)
+		}
+	}()
+
+	if err := jpeg.Encode(file, img, &jpeg.Options{Quality: quality}); err != nil {
+		return fmt.Errorf("failed to encode JPEG: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"strings"
+	"testing"
+
+	"bookify/internal/testutil"
+)
+
+func testPNG(t *testing.T, width, height int) string {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x % 256), G: uint8(y % 256), B: 128, A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("Failed to encode test PNG: %v", err)
+	}
+	return buf.String()
+}
+
+// hugePNG is a tiny PNG whose header claims it's width by height pixels.
+func hugePNG(t *testing.T, width, height uint32) string {
+	t.Helper()
+
+	data := []byte(testPNG(t, 1, 1))
+	// The IHDR chunk follows the 8-byte signature and its own length and type
+	ihdr := data[8+8 : 8+8+13]
+	binary.BigEndian.PutUint32(ihdr[0:4], width)
+	binary.BigEndian.PutUint32(ihdr[4:8], height)
+	binary.BigEndian.PutUint32(data[8+8+13:], crc32.ChecksumIEEE(data[8+4:8+8+13]))
+	return string(data)
+}
+
+func opfWithManifest(metadata, manifest string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="bookid">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="bookid">test-book</dc:identifier>
+    <dc:title>Test Book</dc:title>
+    ` + metadata + `
+  </metadata>
+  <manifest>
+    <item id="chapter1" href="chapter1.xhtml" media-type="application/xhtml+xml"/>
+    ` + manifest + `
+  </manifest>
+  <spine>
+    <itemref idref="chapter1"/>
+  </spine>
+</package>`
+}
+
+func TestCoverService_ExtractCover(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides func(t *testing.T) map[string]string
+	}{
+		{
+			name: "EPUB 3 cover-image property",
+			overrides: func(t *testing.T) map[string]string {
+				return map[string]string{
+					"OEBPS/content.opf": opfWithManifest("",
+						`<item id="img1" href="images/other.png" media-type="image/png"/>
+						<item id="cov" href="images/front.png" media-type="image/png" properties="cover-image"/>`),
+					"OEBPS/images/other.png": "not an image",
+					"OEBPS/images/front.png": testPNG(t, 40, 60),
+				}
+			},
+		},
+		{
+			name: "EPUB 2 cover meta",
+			overrides: func(t *testing.T) map[string]string {
+				return map[string]string{
+					"OEBPS/content.opf": opfWithManifest(`<meta name="cover" content="front"/>`,
+						`<item id="img1" href="images/other.png" media-type="image/png"/>
+						<item id="front" href="images/front.png" media-type="image/png"/>`),
+					"OEBPS/images/other.png": "not an image",
+					"OEBPS/images/front.png": testPNG(t, 40, 60),
+				}
+			},
+		},
+		{
+			name: "first image in the spine",
+			overrides: func(t *testing.T) map[string]string {
+				return map[string]string{
+					"OEBPS/content.opf": opfWithManifest("",
+						`<item id="img1" href="images/unused.png" media-type="image/png"/>
+						<item id="img2" href="images/plate%201.png" media-type="image/png"/>`),
+					"OEBPS/chapter1.xhtml":     `<html><body><p><img src="images/plate%201.png" alt=""/></p></body></html>`,
+					"OEBPS/images/unused.png":  "not an image",
+					"OEBPS/images/plate 1.png": testPNG(t, 40, 60),
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			covers := NewCoverService(t.TempDir())
+			epubPath := testutil.CreateValidEPUB(t, "book.epub", tt.overrides(t))
+
+			thumbPath, err := covers.ExtractCover(epubPath, "job-1")
+			if err != nil {
+				t.Fatalf("ExtractCover() error = %v", err)
+			}
+			if thumbPath != covers.ThumbnailPath("job-1") {
+				t.Errorf("ExtractCover() path = %q, want %q", thumbPath, covers.ThumbnailPath("job-1"))
+			}
+			if !covers.HasThumbnail("job-1") {
+				t.Errorf("HasThumbnail() = false after extraction")
+			}
+		})
+	}
+}
+
+func TestCoverService_ExtractCover_Resizes(t *testing.T) {
+	covers := NewCoverService(t.TempDir())
+	epubPath := testutil.CreateValidEPUB(t, "book.epub", map[string]string{
+		"OEBPS/content.opf": opfWithManifest("",
+			`<item id="cov" href="cover.png" media-type="image/png" properties="cover-image"/>`),
+		"OEBPS/cover.png": testPNG(t, 1200, 1800),
+	})
+
+	thumbPath, err := covers.ExtractCover(epubPath, "big")
+	if err != nil {
+		t.Fatalf("ExtractCover() error = %v", err)
+	}
+
+	file, err := os.Open(thumbPath)
+	if err != nil {
+		t.Fatalf("Failed to open thumbnail: %v", err)
+	}
+	defer func() {
+		_ = file.Close() // Error ignored in test
+	}()
+
+	cfg, err := jpeg.DecodeConfig(file)
+	if err != nil {
+		t.Fatalf("Thumbnail is not a JPEG: %v", err)
+	}
+	if cfg.Width != thumbnailMaxWidth || cfg.Height != thumbnailMaxHeight {
+		t.Errorf("Thumbnail size = %dx%d, want %dx%d", cfg.Width, cfg.Height, thumbnailMaxWidth, thumbnailMaxHeight)
+	}
+}
+
+func TestCoverService_ExtractCover_TooManyPixels(t *testing.T) {
+	covers := NewCoverService(t.TempDir())
+	epubPath := testutil.CreateValidEPUB(t, "book.epub", map[string]string{
+		"OEBPS/content.opf": opfWithManifest("",
+			`<item id="cov" href="cover.png" media-type="image/png" properties="cover-image"/>`),
+		"OEBPS/cover.png": hugePNG(t, 60000, 60000),
+	})
+
+	_, err := covers.ExtractCover(epubPath, "bomb")
+	if !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("ExtractCover() error = %v, want ErrImageTooLarge", err)
+	}
+	if covers.HasThumbnail("bomb") {
+		t.Errorf("HasThumbnail() = true for a rejected cover")
+	}
+}
+
+func TestCoverService_ExtractCover_NoCover(t *testing.T) {
+	covers := NewCoverService(t.TempDir())
+	epubPath := testutil.CreateValidEPUB(t, "book.epub", nil)
+
+	_, err := covers.ExtractCover(epubPath, "none")
+	if !errors.Is(err, ErrNoCover) {
+		t.Errorf("ExtractCover() error = %v, want ErrNoCover", err)
+	}
+	if covers.HasThumbnail("none") {
+		t.Errorf("HasThumbnail() = true for book without cover")
+	}
+}
+
+func TestCoverService_ExtractCover_InvalidEPUB(t *testing.T) {
+	covers := NewCoverService(t.TempDir())
+	inputPath := testutil.CreateInvalidFile(t, "invalid.epub")
+
+	_, err := covers.ExtractCover(inputPath, "invalid")
+	if err == nil || !strings.Contains(err.Error(), "failed to open EPUB as ZIP") {
+		t.Errorf("ExtractCover() error = %v, want ZIP error", err)
+	}
+}
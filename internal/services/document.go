@@ -0,0 +1,451 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	gmhtml "github.com/yuin/goldmark/renderer/html"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding/charmap"
+)
+
+var ErrEmptyDocument = errors.New("document has no readable content")
+
+// Lines in plain text files that start a new chapter.
+var textChapterPattern = regexp.MustCompile(`(?i)^(?:(?:chapter|part|book)\s+(?:[0-9]+|[ivxlcdm]+|[a-z]+(?:-[a-z]+)?)\b|(?:prologue|epilogue|interlude|foreword|preface|afterword)\b).{0,80}$`)
+
+// Elements that are dropped with everything inside them.
+var droppedElements = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Iframe: true,
+	atom.Object: true, atom.Embed: true, atom.Form: true, atom.Input: true,
+	atom.Button: true, atom.Select: true, atom.Textarea: true, atom.Link: true,
+	atom.Meta: true, atom.Svg: true, atom.Math: true, atom.Video: true,
+	atom.Audio: true, atom.Canvas: true, atom.Template: true,
+}
+
+var xmlNamePattern = regexp.MustCompile(`^[a-zA-Z_][-a-zA-Z0-9_.]*$`)
+
+type documentChapter struct {
+	title string
+	nodes []*html.Node
+}
+
+// buildDocumentEPUB wraps a plain text, Markdown or HTML file into a reflowable
+// EPUB, with a chapter per top-level heading.
+func buildDocumentEPUB(inputPath, outputPath, format string, opts ImportOptions, fallbackTitle string) error {
+	data, err := os.ReadFile(inputPath)
+	if err != nil {
+		return fmt.Errorf("failed to read document: %w", err)
+	}
+
+	var source []byte
+	switch format {
+	case ".txt":
+		source = textToHTML(decodeText(data))
+	case ".md", ".markdown":
+		source, err = markdownToHTML(decodeText(data))
+		if err != nil {
+			return err
+		}
+	default:
+		encoding, _, _ := charset.DetermineEncoding(data, "text/html")
+		source, err = encoding.NewDecoder().Bytes(data)
+		if err != nil {
+			return fmt.Errorf("failed to decode document: %w", err)
+		}
+	}
+
+	doc, err := html.Parse(bytes.NewReader(source))
+	if err != nil {
+		return fmt.Errorf("failed to parse document: %w", err)
+	}
+
+	builder := newEPUBBuilder(fallbackTitle)
+	if htmlNode := findElement(doc, atom.Html); htmlNode != nil {
+		if lang := attrValue(htmlNode, "lang"); lang != "" {
+			builder.language = lang
+		}
+	}
+	if head := findElement(doc, atom.Head); head != nil {
+		if title := findElement(head, atom.Title); title != nil {
+			if text := nodeText(title); text != "" {
+				builder.title = text
+			}
+		}
+		for _, meta := range findElements(head, atom.Meta) {
+			switch strings.ToLower(attrValue(meta, "name")) {
+			case "author":
+				builder.author = attrValue(meta, "content")
+			case "description":
+				builder.description = attrValue(meta, "content")
+			}
+		}
+	}
+
+	body := findElement(doc, atom.Body)
+	if body == nil {
+		return ErrEmptyDocument
+	}
+	images := &documentImages{builder: builder}
+	sanitizeNode(body, images)
+
+	chapters, titleHeading := splitChapters(contentRoot(body))
+	if titleHeading != "" && builder.title == fallbackTitle {
+		builder.title = titleHeading
+	}
+	if opts.Title != "" {
+		builder.title = opts.Title
+	}
+	if opts.Author != "" {
+		builder.author = opts.Author
+	}
+	if len(chapters) == 0 {
+		return ErrEmptyDocument
+	}
+
+	for i, chapter := range chapters {
+		title := chapter.title
+		if title == "" {
+			title = builder.title
+		}
+		var content bytes.Buffer
+		for _, node := range chapter.nodes {
+			if err := html.Render(&content, node); err != nil {
+				return fmt.Errorf("failed to render chapter: %w", err)
+			}
+		}
+		builder.addDocument(
+			fmt.Sprintf("chapter-%03d", i+1),
+			fmt.Sprintf("text/chapter-%03d.xhtml", i+1),
+			title,
+			[]byte(chapterDocument(title, builder.language, content.String())),
+		)
+	}
+
+	return builder.write(outputPath)
+}
+
+// decodeText returns data as UTF-8, treating anything that isn't valid UTF-8
+// as Windows-1252, which is what most old text files turn out to be.
+func decodeText(data []byte) string {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if utf8.Valid(data) {
+		return string(data)
+	}
+	decoded, err := charmap.Windows1252.NewDecoder().Bytes(data)
+	if err != nil {
+		return strings.ToValidUTF8(string(data), "�")
+	}
+	return string(decoded)
+}
+
+// textToHTML turns plain text into paragraphs, with a heading for every line
+// that looks like the start of a chapter.
+func textToHTML(text string) []byte {
+	text = strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\r", "\n")
+	lines := strings.Split(text, "\n")
+
+	// Hard-wrapped text separates paragraphs with blank lines; otherwise
+	// every line is its own paragraph.
+	blankSeparated := strings.Contains(text, "\n\n")
+
+	var out strings.Builder
+	out.WriteString("<html><body>\n")
+	var paragraph []string
+	flush := func() {
+		if len(paragraph) > 0 {
+			out.WriteString("<p>" + html.EscapeString(strings.Join(paragraph, " ")) + "</p>\n")
+			paragraph = nil
+		}
+	}
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			flush()
+		case textChapterPattern.MatchString(trimmed) && len(paragraph) == 0:
+			out.WriteString("<h2>" + html.EscapeString(trimmed) + "</h2>\n")
+		case blankSeparated:
+			paragraph = append(paragraph, trimmed)
+		default:
+			paragraph = append(paragraph, trimmed)
+			flush()
+		}
+	}
+	flush()
+	out.WriteString("</body></html>\n")
+	return []byte(out.String())
+}
+
+func markdownToHTML(text string) ([]byte, error) {
+	md := goldmark.New(
+		goldmark.WithExtensions(extension.GFM),
+		goldmark.WithRendererOptions(gmhtml.WithXHTML()),
+	)
+	var buf bytes.Buffer
+	buf.WriteString("<html><body>\n")
+	if err := md.Convert([]byte(text), &buf); err != nil {
+		return nil, fmt.Errorf("failed to render Markdown: %w", err)
+	}
+	buf.WriteString("</body></html>\n")
+	return buf.Bytes(), nil
+}
+
+// documentImages moves images embedded as data URIs into the book.
+type documentImages struct {
+	builder *epubBuilder
+	count   int
+}
+
+func (d *documentImages) add(src string) (string, bool) {
+	if !strings.HasPrefix(src, "data:") {
+		return "", false
+	}
+	header, payload, ok := strings.Cut(strings.TrimPrefix(src, "data:"), ",")
+	if !ok || !strings.HasSuffix(header, ";base64") {
+		return "", false
+	}
+	mediaType := strings.TrimSuffix(header, ";base64")
+	var ext string
+	switch mediaType {
+	case "image/jpeg":
+		ext = "jpg"
+	case "image/png":
+		ext = "png"
+	case "image/gif":
+		ext = "gif"
+	default:
+		return "", false
+	}
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", false
+	}
+
+	d.count++
+	href := fmt.Sprintf("images/image-%03d.%s", d.count, ext)
+	d.builder.addFile(fmt.Sprintf("image-%03d", d.count), href, mediaType, data)
+	return "../" + href, true
+}
+
+// sanitizeNode strips scripts, embedded objects and anything else that would
+// not survive as XHTML in an e-reader. Images can only be kept when they are
+// embedded in the document.
+func sanitizeNode(n *html.Node, images *documentImages) {
+	for child := n.FirstChild; child != nil; {
+		next := child.NextSibling
+		switch child.Type {
+		case html.ElementNode:
+			switch {
+			case droppedElements[child.DataAtom]:
+				n.RemoveChild(child)
+			case child.DataAtom == 0 || strings.Contains(child.Data, ":"):
+				// Unknown or namespaced tags (such as Word's <o:p>) are
+				// replaced by their contents
+				sanitizeNode(child, images)
+				for grandchild := child.FirstChild; grandchild != nil; {
+					following := grandchild.NextSibling
+					child.RemoveChild(grandchild)
+					n.InsertBefore(grandchild, child)
+					grandchild = following
+				}
+				n.RemoveChild(child)
+			case child.DataAtom == atom.Img:
+				src, ok := images.add(attrValue(child, "src"))
+				if !ok {
+					n.RemoveChild(child)
+					break
+				}
+				sanitizeAttributes(child)
+				setAttr(child, "src", src)
+				if attrValue(child, "alt") == "" {
+					setAttr(child, "alt", "")
+				}
+			default:
+				sanitizeAttributes(child)
+				sanitizeNode(child, images)
+			}
+		case html.CommentNode, html.DoctypeNode:
+			n.RemoveChild(child)
+		}
+		child = next
+	}
+}
+
+func sanitizeAttributes(n *html.Node) {
+	kept := n.Attr[:0]
+	seen := map[string]bool{}
+	for _, attr := range n.Attr {
+		key := strings.ToLower(attr.Key)
+		if attr.Namespace != "" || !xmlNamePattern.MatchString(key) || seen[key] ||
+			strings.HasPrefix(key, "on") || key == "style" {
+			continue
+		}
+		if key == "href" && strings.HasPrefix(strings.ToLower(strings.TrimSpace(attr.Val)), "javascript:") {
+			continue
+		}
+		seen[key] = true
+		attr.Key = key
+		kept = append(kept, attr)
+	}
+	n.Attr = kept
+}
+
+// contentRoot skips wrappers such as <article> or <div id="content"> that
+// hold the whole document, so that its headings can be found.
+func contentRoot(n *html.Node) *html.Node {
+	for {
+		var only *html.Node
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type == html.TextNode && strings.TrimSpace(child.Data) == "" {
+				continue
+			}
+			if only != nil || child.Type != html.ElementNode {
+				return n
+			}
+			only = child
+		}
+		if only == nil {
+			return n
+		}
+		switch only.DataAtom {
+		case atom.Div, atom.Article, atom.Main, atom.Section:
+			n = only
+		default:
+			return n
+		}
+	}
+}
+
+// splitChapters starts a new chapter at each heading of the highest level that
+// appears more than once. A single <h1> above those headings is the title of
+// the whole document and is returned separately.
+func splitChapters(root *html.Node) ([]documentChapter, string) {
+	counts := map[atom.Atom]int{}
+	var firstH1 *html.Node
+	for child := root.FirstChild; child != nil; child = child.NextSibling {
+		counts[child.DataAtom]++
+		if child.DataAtom == atom.H1 && firstH1 == nil {
+			firstH1 = child
+		}
+	}
+
+	var splitAt atom.Atom
+	for _, level := range []atom.Atom{atom.H1, atom.H2, atom.H3} {
+		if counts[level] >= 2 {
+			splitAt = level
+			break
+		}
+	}
+
+	titleHeading := ""
+	if counts[atom.H1] == 1 {
+		titleHeading = nodeText(firstH1)
+	}
+
+	var chapters []documentChapter
+	current := documentChapter{}
+	for child := root.FirstChild; child != nil; child = child.NextSibling {
+		if splitAt != 0 && child.Type == html.ElementNode && child.DataAtom == splitAt {
+			if hasContent(current.nodes) {
+				chapters = append(chapters, current)
+			}
+			current = documentChapter{title: nodeText(child)}
+		}
+		current.nodes = append(current.nodes, child)
+	}
+	if hasContent(current.nodes) {
+		chapters = append(chapters, current)
+	}
+	return chapters, titleHeading
+}
+
+func hasContent(nodes []*html.Node) bool {
+	for _, n := range nodes {
+		if n.Type == html.ElementNode || strings.TrimSpace(n.Data) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func chapterDocument(title, language, content string) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" lang="%[2]s" xml:lang="%[2]s">
+<head>
+<title>%[1]s</title>
+</head>
+<body>
+%[3]s
+</body>
+</html>
+`, escapeXML(title), escapeXML(language), content)
+}
+
+func findElement(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if found := findElement(child, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func findElements(n *html.Node, a atom.Atom) []*html.Node {
+	var found []*html.Node
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		found = append(found, n)
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		found = append(found, findElements(child, a)...)
+	}
+	return found
+}
+
+func nodeText(n *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+func attrValue(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+func setAttr(n *html.Node, key, val string) {
+	for i, attr := range n.Attr {
+		if attr.Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeDocument(t *testing.T, filename, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), filename)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", filename, err)
+	}
+	return path
+}
+
+func importDocument(t *testing.T, filename, content string, opts ImportOptions) (*epubBook, string) {
+	t.Helper()
+
+	inputPath := writeDocument(t, filename, content)
+	outputPath := filepath.Join(t.TempDir(), "book.epub")
+	if err := NewImporterService().Import(inputPath, filename, outputPath, opts); err != nil {
+		t.Fatalf("Import(%s) error = %v", filename, err)
+	}
+
+	report, err := NewValidatorService().Validate(outputPath, filepath.Join(t.TempDir(), "repaired.epub"))
+	if err != nil {
+		t.Fatalf("Imported %s failed validation: %v", filename, err)
+	}
+	if !report.Empty() {
+		t.Errorf("Imported %s has problems: %s", filename, report)
+	}
+	return readEPUBPackageFile(t, outputPath), outputPath
+}
+
+func readBookFile(t *testing.T, book *epubBook, name string) string {
+	t.Helper()
+
+	data, err := readZipEntry(book.zip, name)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", name, err)
+	}
+	return string(data)
+}
+
+func TestImporterService_Import_Text(t *testing.T) {
+	// Windows-1252 curly quotes, as saved by older editors
+	content := "A short preface.\r\n\r\nChapter 1\r\n\r\nIt was a dark\r\nand stormy night.\r\n\r\n" +
+		"Chapter 2: The \x93Storm\x94\r\n\r\nThe rain fell.\r\n"
+	book, _ := importDocument(t, "my story.txt", content, ImportOptions{Author: "A. Writer"})
+
+	if titles := book.pkg.Metadata.Titles; len(titles) != 1 || titles[0] != "my story" {
+		t.Errorf("titles = %v, want filename", titles)
+	}
+	if creators := book.pkg.Metadata.Creators; len(creators) != 1 || creators[0].Name != "A. Writer" {
+		t.Errorf("creators = %v, want form author", creators)
+	}
+	if len(book.pkg.Spine) != 3 {
+		t.Fatalf("spine has %d chapters, want preface and 2 chapters", len(book.pkg.Spine))
+	}
+
+	chapter1 := readBookFile(t, book, "OEBPS/text/chapter-002.xhtml")
+	if !strings.Contains(chapter1, "<p>It was a dark and stormy night.</p>") {
+		t.Errorf("hard-wrapped lines should be joined into one paragraph:\n%s", chapter1)
+	}
+
+	nav := readBookFile(t, book, "OEBPS/nav.xhtml")
+	if !strings.Contains(nav, "Chapter 2: The “Storm”") {
+		t.Errorf("nav is missing decoded chapter title:\n%s", nav)
+	}
+}
+
+func TestImporterService_Import_Markdown(t *testing.T) {
+	content := `# The Handbook
+
+Intro paragraph.
+
+## Getting Started
+
+Some *emphasis* and a table:
+
+| a | b |
+|---|---|
+| 1 | 2 |
+
+## Going Further
+
+<script>alert("hi")</script>
+Done.
+`
+	book, _ := importDocument(t, "handbook.md", content, ImportOptions{})
+
+	if titles := book.pkg.Metadata.Titles; len(titles) != 1 || titles[0] != "The Handbook" {
+		t.Errorf("titles = %v, want the document heading", titles)
+	}
+	if len(book.pkg.Spine) != 3 {
+		t.Fatalf("spine has %d chapters, want 3", len(book.pkg.Spine))
+	}
+
+	nav := readBookFile(t, book, "OEBPS/nav.xhtml")
+	for _, want := range []string{"Getting Started", "Going Further"} {
+		if !strings.Contains(nav, want) {
+			t.Errorf("nav missing %q", want)
+		}
+	}
+	if chapter := readBookFile(t, book, "OEBPS/text/chapter-003.xhtml"); strings.Contains(chapter, "alert") {
+		t.Errorf("raw HTML should not be copied from Markdown:\n%s", chapter)
+	}
+}
+
+func TestImporterService_Import_HTML(t *testing.T) {
+	content := `<!DOCTYPE html>
+<html lang="fr">
+<head>
+<title>Article Export</title>
+<meta name="author" content="Page Author">
+<script src="tracker.js"></script>
+</head>
+<body>
+<article>
+<h2 onclick="track()">Part One</h2>
+<p>Text&nbsp;with <a href="javascript:void(0)">a link</a><o:p></o:p><br></p>
+<img src="data:image/png;base64,` + "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNk+M9QDwADhgGAWjR9awAAAABJRU5ErkJggg==" + `">
+<img src="https://example.com/remote.png">
+<h2>Part Two</h2>
+<p>More text.</p>
+</article>
+</body>
+</html>`
+	book, _ := importDocument(t, "export.html", content, ImportOptions{Title: "Chosen Title"})
+
+	if titles := book.pkg.Metadata.Titles; len(titles) != 1 || titles[0] != "Chosen Title" {
+		t.Errorf("titles = %v, want form title", titles)
+	}
+	if creators := book.pkg.Metadata.Creators; len(creators) != 1 || creators[0].Name != "Page Author" {
+		t.Errorf("creators = %v, want meta author", creators)
+	}
+	if languages := book.pkg.Metadata.Languages; len(languages) != 1 || languages[0] != "fr" {
+		t.Errorf("languages = %v, want fr", languages)
+	}
+	if len(book.pkg.Spine) != 2 {
+		t.Fatalf("spine has %d chapters, want 2", len(book.pkg.Spine))
+	}
+
+	chapter := readBookFile(t, book, "OEBPS/text/chapter-001.xhtml")
+	for _, unwanted := range []string{"onclick", "javascript:", "o:p", "example.com"} {
+		if strings.Contains(chapter, unwanted) {
+			t.Errorf("chapter should not contain %q:\n%s", unwanted, chapter)
+		}
+	}
+	if !strings.Contains(chapter, `src="../images/image-001.png"`) {
+		t.Errorf("embedded image should be moved into the book:\n%s", chapter)
+	}
+	if book.itemByPath("OEBPS/images/image-001.png") == nil {
+		t.Errorf("embedded image missing from manifest")
+	}
+}
+
+func TestImporterService_Import_EmptyDocument(t *testing.T) {
+	inputPath := writeDocument(t, "empty.txt", "\n\n   \n")
+
+	err := NewImporterService().Import(inputPath, "empty.txt", filepath.Join(t.TempDir(), "empty.epub"), ImportOptions{})
+	if !errors.Is(err, ErrEmptyDocument) {
+		t.Errorf("Import() error = %v, want ErrEmptyDocument", err)
+	}
+}
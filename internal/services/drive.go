@@ -3,8 +3,10 @@ package services
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"golang.org/x/oauth2"
@@ -18,6 +20,8 @@ import (
 type DriveService struct {
 	dbService    *db.Service
 	oauth2Config *oauth2.Config
+	// Drive API URL, overridden in tests
+	endpoint string
 }
 
 func NewDriveService(dbService *db.Service) *DriveService {
@@ -69,7 +73,11 @@ func (d *DriveService) getOAuthClient(account *db.Account) (*drive.Service, erro
 	}
 
 	client := d.oauth2Config.Client(context.Background(), newToken)
-	service, err := drive.NewService(context.Background(), option.WithHTTPClient(client))
+	opts := []option.ClientOption{option.WithHTTPClient(client)}
+	if d.endpoint != "" {
+		opts = append(opts, option.WithEndpoint(d.endpoint))
+	}
+	service, err := drive.NewService(context.Background(), opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Drive service: %w", err)
 	}
@@ -110,6 +118,61 @@ func (d *DriveService) UploadFile(account *db.Account, filePath, fileName string
 	return shareURL, nil
 }
 
+// UpdateFile replaces the contents of an existing Drive file, keeping its ID
+// and share link.
+func (d *DriveService) UpdateFile(account *db.Account, fileID, filePath string) (string, error) {
+	service, err := d.getOAuthClient(account)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer func() {
+		if closeErr := file.Close(); closeErr != nil {
+			log.Printf("Warning: Failed to close file: %v", closeErr)
+		}
+	}()
+
+	res, err := service.Files.Update(fileID, &drive.File{}).
+		Media(file).
+		SupportsAllDrives(true).
+		Do()
+	if err != nil {
+		return "", fmt.Errorf("failed to update file: %w", err)
+	}
+
+	shareURL := fmt.Sprintf("https://drive.google.com/file/d/%s/view", res.Id)
+	return shareURL, nil
+}
+
+// DownloadFile opens a file in the account's Drive for reading. The caller
+// must close it.
+func (d *DriveService) DownloadFile(account *db.Account, fileID string) (io.ReadCloser, error) {
+	service, err := d.getOAuthClient(account)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := service.Files.Get(fileID).SupportsAllDrives(true).Download()
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file: %w", err)
+	}
+	return res.Body, nil
+}
+
+// DriveFileID extracts the file ID from a share URL returned by UploadFile.
+func DriveFileID(shareURL string) string {
+	const prefix = "https://drive.google.com/file/d/"
+	if !strings.HasPrefix(shareURL, prefix) {
+		return ""
+	}
+	id, _, _ := strings.Cut(strings.TrimPrefix(shareURL, prefix), "/")
+	return id
+}
+
 func (d *DriveService) TestConnection(account *db.Account) error {
 	service, err := d.getOAuthClient(account)
 	if err != nil {
@@ -0,0 +1,276 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+
+	"bookify/internal/db"
+)
+
+const (
+	driveInboxInterval = time.Minute
+	driveFolderType    = "application/vnd.google-apps.folder"
+)
+
+// InboxFile is a file found in an account's Drive inbox folder.
+type InboxFile struct {
+	ID   string
+	Name string
+}
+
+// StartInboxWatcher polls the Drive inbox of every account that has one.
+func (d *DriveService) StartInboxWatcher(tempDir string) {
+	log.Println("Starting Drive inbox watcher...")
+	ticker := time.NewTicker(driveInboxInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		d.CheckInboxes(tempDir)
+	}
+}
+
+// CheckInboxes queues new books from every Drive inbox and archives the
+// ones whose job finished.
+func (d *DriveService) CheckInboxes(tempDir string) {
+	accounts, err := d.dbService.ListInboxAccounts()
+	if err != nil {
+		log.Printf("Failed to list Drive inboxes: %v", err)
+		return
+	}
+	for i := range accounts {
+		if err := d.checkInbox(&accounts[i], tempDir); err != nil {
+			log.Printf("Warning: Failed to check the Drive inbox of %s: %v", accounts[i].Name, err)
+		}
+	}
+}
+
+func (d *DriveService) checkInbox(account *db.Account, tempDir string) error {
+	service, err := d.getOAuthClient(account)
+	if err != nil {
+		return err
+	}
+
+	jobs, err := d.dbService.ListInboxJobs(account.ID)
+	if err != nil {
+		return fmt.Errorf("failed to list inbox jobs: %w", err)
+	}
+	// Files with a job, finished or not, are not queued again
+	known := make(map[string]bool)
+	for _, job := range jobs {
+		known[job.InboxFileID] = true
+		switch job.Status {
+		case "completed", "skipped":
+			d.archiveInboxFile(service, account, job.InboxFileID)
+		case "failed", "cancelled":
+			// Failed books stay in the inbox for a look
+		default:
+			continue
+		}
+		if err := d.dbService.ClearInboxFileID(job.ID); err != nil {
+			log.Printf("Warning: Failed to update job %s: %v", job.ID, err)
+		}
+	}
+
+	var files []InboxFile
+	var nextToken string
+	if account.InboxPageToken == "" {
+		// Start with what's in the folder now; later checks only look at
+		// what changed since
+		start, err := service.Changes.GetStartPageToken().SupportsAllDrives(true).Do()
+		if err != nil {
+			return fmt.Errorf("failed to get start page token: %w", err)
+		}
+		nextToken = start.StartPageToken
+		files, err = listInboxFolder(service, account.InboxFolderID)
+		if err != nil {
+			return err
+		}
+	} else {
+		files, nextToken, err = listInboxChanges(service, account)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The page token only moves on once every new book is queued, so books
+	// that failed to download are seen again next time
+	complete := true
+	for _, file := range files {
+		if !isWatchedBook(file.Name) || known[file.ID] {
+			continue
+		}
+		if err := d.queueInboxFile(service, account, file, tempDir); err != nil {
+			log.Printf("Warning: Failed to queue %s from the Drive inbox of %s: %v", file.Name, account.Name, err)
+			complete = false
+		}
+	}
+	if complete && nextToken != account.InboxPageToken {
+		if err := d.dbService.SetInboxPageToken(account.ID, nextToken); err != nil {
+			return fmt.Errorf("failed to save page token: %w", err)
+		}
+		account.InboxPageToken = nextToken
+	}
+	return nil
+}
+
+func listInboxFolder(service *drive.Service, folderID string) ([]InboxFile, error) {
+	var files []InboxFile
+	query := fmt.Sprintf("'%s' in parents and trashed = false and mimeType != '%s'", folderID, driveFolderType)
+	err := service.Files.List().
+		Q(query).
+		Fields("nextPageToken, files(id, name)").
+		SupportsAllDrives(true).
+		IncludeItemsFromAllDrives(true).
+		Pages(context.Background(), func(list *drive.FileList) error {
+			for _, file := range list.Files {
+				files = append(files, InboxFile{ID: file.Id, Name: file.Name})
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list inbox folder: %w", err)
+	}
+	return files, nil
+}
+
+// listInboxChanges returns the files added to the inbox folder since the
+// account's page token, and the token to start from next time.
+func listInboxChanges(service *drive.Service, account *db.Account) ([]InboxFile, string, error) {
+	var files []InboxFile
+	seen := make(map[string]bool)
+	token := account.InboxPageToken
+	for {
+		res, err := service.Changes.List(token).
+			Fields("nextPageToken, newStartPageToken, changes(fileId, removed, file(id, name, mimeType, parents, trashed))").
+			SupportsAllDrives(true).
+			IncludeItemsFromAllDrives(true).
+			Do()
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to list changes: %w", err)
+		}
+
+		for _, change := range res.Changes {
+			file := change.File
+			if change.Removed || file == nil || file.Trashed || file.MimeType == driveFolderType || seen[file.Id] {
+				continue
+			}
+			for _, parent := range file.Parents {
+				if parent == account.InboxFolderID {
+					files = append(files, InboxFile{ID: file.Id, Name: file.Name})
+					seen[file.Id] = true
+					break
+				}
+			}
+		}
+
+		if res.NewStartPageToken != "" {
+			return files, res.NewStartPageToken, nil
+		}
+		token = res.NextPageToken
+	}
+}
+
+func (d *DriveService) queueInboxFile(service *drive.Service, account *db.Account, file InboxFile, tempDir string) error {
+	filename := filepath.Base(file.Name)
+
+	// The queue reads uploads from the temp directory by name
+	tempPath := filepath.Join(tempDir, filename)
+	if _, err := os.Stat(tempPath); err == nil {
+		return fmt.Errorf("another file named %s is waiting to be converted", filename)
+	}
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	if err := downloadDriveFile(service, file.ID, tempPath); err != nil {
+		return err
+	}
+
+	sourceHash, err := HashFile(tempPath)
+	if err != nil {
+		removeTempFile(tempPath)
+		return err
+	}
+
+	if account.DuplicatePolicy != db.DuplicatePolicyForce {
+		duplicate, err := d.dbService.FindDuplicateJob(account.ID, sourceHash)
+		if err != nil {
+			log.Printf("Warning: Failed to check for duplicates: %v", err)
+		}
+		if duplicate != nil {
+			removeTempFile(tempPath)
+			if _, err := d.dbService.CreateSkippedJob(account.ID, filename, sourceHash, duplicate); err != nil {
+				return fmt.Errorf("failed to record skipped file: %w", err)
+			}
+			d.archiveInboxFile(service, account, file.ID)
+			return nil
+		}
+	}
+
+	job := &db.Job{
+		AccountID:        account.ID,
+		OriginalFilename: filename,
+		SourceHash:       sourceHash,
+		InboxFileID:      file.ID,
+	}
+	if err := d.dbService.QueueJob(job); err != nil {
+		removeTempFile(tempPath)
+		return fmt.Errorf("failed to queue job: %w", err)
+	}
+	log.Printf("Queued %s from the Drive inbox of %s", filename, account.Name)
+	return nil
+}
+
+func downloadDriveFile(service *drive.Service, fileID, destPath string) error {
+	res, err := service.Files.Get(fileID).SupportsAllDrives(true).Download()
+	if err != nil {
+		return fmt.Errorf("failed to download file: %w", err)
+	}
+	defer func() {
+		if closeErr := res.Body.Close(); closeErr != nil {
+			log.Printf("Warning: Failed to close download: %v", closeErr)
+		}
+	}()
+
+	dst, err := os.Create(destPath)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	_, err = io.Copy(dst, res.Body)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		removeTempFile(destPath)
+		return fmt.Errorf("failed to download file: %w", err)
+	}
+	return nil
+}
+
+// archiveInboxFile moves a processed book from the inbox to the archive
+// folder. Without an archive folder the book stays where it is.
+func (d *DriveService) archiveInboxFile(service *drive.Service, account *db.Account, fileID string) {
+	if account.ArchiveFolderID == "" {
+		return
+	}
+	_, err := service.Files.Update(fileID, &drive.File{}).
+		AddParents(account.ArchiveFolderID).
+		RemoveParents(account.InboxFolderID).
+		SupportsAllDrives(true).
+		Do()
+	if err != nil {
+		log.Printf("Warning: Failed to archive Drive file %s: %v", fileID, err)
+	}
+}
+
+func removeTempFile(path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: Failed to remove temp file: %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bookify/internal/db"
+	"bookify/internal/testutil"
+)
+
+// fakeDrive serves the parts of the Drive API the inbox uses.
+type fakeDrive struct {
+	mu       sync.Mutex
+	inbox    []map[string]any
+	changes  []map[string]any
+	contents map[string]string
+	moved    map[string]string
+}
+
+func (f *fakeDrive) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	switch {
+	case path == "changes/startPageToken":
+		writeJSON(w, map[string]any{"startPageToken": "100"})
+	case path == "changes":
+		writeJSON(w, map[string]any{"changes": f.changes, "newStartPageToken": "101"})
+	case path == "files" && r.Method == http.MethodGet:
+		writeJSON(w, map[string]any{"files": f.inbox})
+	case strings.HasPrefix(path, "files/") && r.Method == http.MethodPatch:
+		id := strings.TrimPrefix(path, "files/")
+		f.moved[id] = r.URL.Query().Get("addParents")
+		writeJSON(w, map[string]any{"id": id})
+	case strings.HasPrefix(path, "files/") && r.URL.Query().Get("alt") == "media":
+		content, ok := f.contents[strings.TrimPrefix(path, "files/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(content))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func setupDriveInbox(t *testing.T) (*DriveService, *fakeDrive, *db.Service, *db.Account, string) {
+	t.Helper()
+
+	testDB := testutil.SetupTestDB(t)
+	if err := testDB.AutoMigrate(&db.Account{}, &db.Job{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	dbService := db.NewService(testDB)
+
+	account := &db.Account{
+		Name:            "phone",
+		FolderID:        "kobo-folder",
+		InboxFolderID:   "inbox-folder",
+		ArchiveFolderID: "archive-folder",
+		AccessToken:     "access",
+		RefreshToken:    "refresh",
+		TokenExpiry:     time.Now().Add(time.Hour),
+	}
+	if err := dbService.CreateAccountWithOAuth(account); err != nil {
+		t.Fatalf("Failed to create account: %v", err)
+	}
+
+	fake := &fakeDrive{contents: map[string]string{}, moved: map[string]string{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	driveService := NewDriveService(dbService)
+	driveService.endpoint = server.URL + "/"
+	return driveService, fake, dbService, account, t.TempDir()
+}
+
+func inboxJobs(t *testing.T, dbService *db.Service) []db.Job {
+	t.Helper()
+
+	jobs, err := dbService.ListRecentJobs(50)
+	if err != nil {
+		t.Fatalf("ListRecentJobs() failed: %v", err)
+	}
+	return jobs
+}
+
+func TestDriveService_CheckInboxes(t *testing.T) {
+	driveService, fake, dbService, account, tempDir := setupDriveInbox(t)
+
+	// Books already in the inbox are queued on the first check
+	fake.inbox = []map[string]any{
+		{"id": "file-1", "name": "dune.epub"},
+		{"id": "file-2", "name": "notes.txt"},
+	}
+	fake.contents["file-1"] = "dune content"
+
+	driveService.CheckInboxes(tempDir)
+
+	jobs := inboxJobs(t, dbService)
+	if len(jobs) != 1 || jobs[0].OriginalFilename != "dune.epub" || jobs[0].InboxFileID != "file-1" {
+		t.Fatalf("jobs = %+v, want dune.epub queued", jobs)
+	}
+	if data, _ := os.ReadFile(filepath.Join(tempDir, "dune.epub")); string(data) != "dune content" {
+		t.Errorf("downloaded %q", data)
+	}
+	updated, _ := dbService.GetAccount(account.ID)
+	if updated.InboxPageToken != "100" {
+		t.Errorf("InboxPageToken = %q, want the start token", updated.InboxPageToken)
+	}
+
+	// Later checks look at changes, ignoring other folders and pending files
+	fake.changes = []map[string]any{
+		{"fileId": "file-1", "file": map[string]any{"id": "file-1", "name": "dune.epub", "parents": []string{"inbox-folder"}}},
+		{"fileId": "file-3", "file": map[string]any{"id": "file-3", "name": "emma.epub", "parents": []string{"inbox-folder"}}},
+		{"fileId": "file-4", "file": map[string]any{"id": "file-4", "name": "elsewhere.epub", "parents": []string{"other-folder"}}},
+		{"fileId": "file-5", "file": map[string]any{"id": "file-5", "name": "gone.epub", "parents": []string{"inbox-folder"}, "trashed": true}},
+	}
+	fake.contents["file-3"] = "emma content"
+	if err := dbService.MarkJobCompleted(jobs[0].ID, "dune.kepub.epub", "https://drive.google.com/file/d/out/view"); err != nil {
+		t.Fatalf("MarkJobCompleted() failed: %v", err)
+	}
+
+	driveService.CheckInboxes(tempDir)
+
+	if fake.moved["file-1"] != "archive-folder" {
+		t.Errorf("converted file not archived: %v", fake.moved)
+	}
+	jobs = inboxJobs(t, dbService)
+	if len(jobs) != 2 {
+		t.Fatalf("queued %d jobs, want 2", len(jobs))
+	}
+	for _, job := range jobs {
+		if job.OriginalFilename == "dune.epub" && job.InboxFileID != "" {
+			t.Errorf("archived job still points at its inbox file")
+		}
+	}
+	updated, _ = dbService.GetAccount(account.ID)
+	if updated.InboxPageToken != "101" {
+		t.Errorf("InboxPageToken = %q, want the new start token", updated.InboxPageToken)
+	}
+}
+
+func TestDriveService_CheckInboxes_DownloadFailure(t *testing.T) {
+	driveService, fake, dbService, account, tempDir := setupDriveInbox(t)
+
+	// Without contents the download fails
+	fake.inbox = []map[string]any{{"id": "file-1", "name": "dune.epub"}}
+
+	driveService.CheckInboxes(tempDir)
+
+	if jobs := inboxJobs(t, dbService); len(jobs) != 0 {
+		t.Fatalf("queued %d jobs for a failed download", len(jobs))
+	}
+	updated, _ := dbService.GetAccount(account.ID)
+	if updated.InboxPageToken != "" {
+		t.Errorf("InboxPageToken = %q, want it kept so the book is tried again", updated.InboxPageToken)
+	}
+
+	fake.contents["file-1"] = "dune content"
+	driveService.CheckInboxes(tempDir)
+	if jobs := inboxJobs(t, dbService); len(jobs) != 1 {
+		t.Errorf("retry queued %d jobs, want 1", len(jobs))
+	}
+}
@@ -0,0 +1,514 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"bookify/internal/db"
+)
+
+const (
+	emailInterval = time.Minute
+
+	defaultEmailMaxSize = 50 << 20
+)
+
+var ErrEmailTooLarge = errors.New("email is larger than the size limit")
+
+var authResultComment = regexp.MustCompile(`\([^()]*\)`)
+
+// EmailOptions say where forwarded books arrive: an IMAP mailbox, or a
+// Maildir directory kept up to date by a local mail server.
+type EmailOptions struct {
+	IMAPAddr string
+	Username string
+	Password string
+	Mailbox  string
+	// Connect with TLS, usually on port 993
+	TLS     bool
+	Maildir string
+	// Authserv-id of the mail server that receives for the mailbox. When
+	// set, a sender only counts if that server's Authentication-Results
+	// header says their domain passed DMARC, DKIM or SPF. Without it the
+	// From header, which anyone can write, is taken at its word.
+	AuthServID string
+	// Largest message read, attachments included
+	MaxSize int64
+}
+
+// mailMessage is a message waiting in the mailbox.
+type mailMessage struct {
+	ID  string
+	Raw []byte
+}
+
+type mailbox interface {
+	Messages() ([]mailMessage, error)
+	MarkProcessed(msg mailMessage) error
+	Close() error
+}
+
+// Attachment is a file attached to an email.
+type Attachment struct {
+	Filename string
+	Data     []byte
+}
+
+// EmailService queues the books attached to emails from allowed senders for
+// the account each sender belongs to.
+type EmailService struct {
+	db      *db.Service
+	tempDir string
+	opts    EmailOptions
+	stopCh  chan bool
+}
+
+func NewEmailService(dbService *db.Service, tempDir string, opts EmailOptions) *EmailService {
+	if opts.Mailbox == "" {
+		opts.Mailbox = "INBOX"
+	}
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = defaultEmailMaxSize
+	}
+	return &EmailService{db: dbService, tempDir: tempDir, opts: opts, stopCh: make(chan bool)}
+}
+
+func (e *EmailService) StartWatcher() {
+	log.Println("Starting email watcher...")
+	ticker := time.NewTicker(emailInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := e.CheckMail(); err != nil {
+				log.Printf("Warning: Failed to check mail: %v", err)
+			}
+		case <-e.stopCh:
+			log.Println("Email watcher stopped")
+			return
+		}
+	}
+}
+
+func (e *EmailService) Stop() {
+	e.stopCh <- true
+}
+
+func (e *EmailService) open() (mailbox, error) {
+	if e.opts.Maildir != "" {
+		return &maildirMailbox{dir: e.opts.Maildir, maxSize: e.opts.MaxSize}, nil
+	}
+	client, err := dialIMAP(e.opts.IMAPAddr, e.opts.TLS, e.opts.MaxSize)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.Login(e.opts.Username, e.opts.Password); err != nil {
+		_ = client.Close() // Error ignored, login already failed
+		return nil, fmt.Errorf("IMAP login failed: %w", err)
+	}
+	if err := client.Select(e.opts.Mailbox); err != nil {
+		_ = client.Close() // Error ignored, the mailbox is unusable
+		return nil, fmt.Errorf("failed to open mailbox %s: %w", e.opts.Mailbox, err)
+	}
+	return &imapMailbox{client: client}, nil
+}
+
+// CheckMail processes every new message once. Messages are marked as
+// processed even when they are rejected, so they aren't looked at again.
+func (e *EmailService) CheckMail() error {
+	box, err := e.open()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := box.Close(); err != nil {
+			log.Printf("Warning: Failed to close mailbox: %v", err)
+		}
+	}()
+
+	messages, err := box.Messages()
+	if err != nil {
+		return err
+	}
+	for _, msg := range messages {
+		if err := e.processMessage(msg.Raw); err != nil {
+			// Left unprocessed to try again next time
+			log.Printf("Warning: Failed to process email %s: %v", msg.ID, err)
+			continue
+		}
+		if err := box.MarkProcessed(msg); err != nil {
+			log.Printf("Warning: Failed to mark email %s as processed: %v", msg.ID, err)
+		}
+	}
+	return nil
+}
+
+func (e *EmailService) processMessage(raw []byte) error {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		log.Printf("Ignoring unreadable email: %v", err)
+		return nil
+	}
+	from, err := mail.ParseAddress(msg.Header.Get("From"))
+	if err != nil {
+		log.Printf("Ignoring email without a sender: %v", err)
+		return nil
+	}
+	if e.opts.AuthServID != "" && !authenticatedSender(msg.Header, e.opts.AuthServID, from.Address) {
+		log.Printf("Ignoring email from %s, which %s didn't authenticate", from.Address, e.opts.AuthServID)
+		return nil
+	}
+
+	account, err := e.db.GetAccountByEmailSender(from.Address)
+	if err != nil {
+		return fmt.Errorf("failed to look up sender: %w", err)
+	}
+	if account == nil {
+		log.Printf("Ignoring email from %s, who is not on any account's sender list", from.Address)
+		return nil
+	}
+
+	attachments, err := ReadAttachments(msg, e.opts.MaxSize)
+	if err != nil {
+		log.Printf("Ignoring email from %s: %v", from.Address, err)
+		return nil
+	}
+	queued := 0
+	for _, attachment := range attachments {
+		if !isWatchedBook(attachment.Filename) {
+			continue
+		}
+		if err := e.queueAttachment(account, from.Address, attachment); err != nil {
+			return err
+		}
+		queued++
+	}
+	if queued == 0 {
+		log.Printf("Email from %s has no books attached", from.Address)
+	}
+	return nil
+}
+
+func (e *EmailService) queueAttachment(account *db.Account, sender string, attachment Attachment) error {
+	sourceHash, err := HashReader(bytes.NewReader(attachment.Data))
+	if err != nil {
+		return err
+	}
+	filename := filepath.Base(attachment.Filename)
+
+	if account.DuplicatePolicy != db.DuplicatePolicyForce {
+		duplicate, err := e.db.FindDuplicateJob(account.ID, sourceHash)
+		if err != nil {
+			log.Printf("Warning: Failed to check for duplicates: %v", err)
+		}
+		if duplicate != nil {
+			job, err := e.db.CreateSkippedJob(account.ID, filename, sourceHash, duplicate)
+			if err != nil {
+				return fmt.Errorf("failed to record skipped attachment: %w", err)
+			}
+			job.EmailSender = sender
+			return e.db.UpdateJob(job)
+		}
+	}
+
+	if err := os.MkdirAll(e.tempDir, 0755); err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	// Two emails may well attach files of the same name
+	tempFile, filename, err := CreateTempFile(e.tempDir, filename)
+	if err != nil {
+		return fmt.Errorf("failed to save attachment: %w", err)
+	}
+	tempPath := tempFile.Name()
+	_, err = tempFile.Write(attachment.Data)
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		removeTempFile(tempPath)
+		return fmt.Errorf("failed to save attachment: %w", err)
+	}
+
+	job := &db.Job{
+		AccountID:        account.ID,
+		OriginalFilename: filename,
+		SourceHash:       sourceHash,
+		EmailSender:      sender,
+		Message:          "Received by email from " + sender,
+	}
+	if err := e.db.QueueJob(job); err != nil {
+		removeTempFile(tempPath)
+		return fmt.Errorf("failed to queue job: %w", err)
+	}
+	log.Printf("Queued %s from an email by %s for %s", filename, sender, account.Name)
+	return nil
+}
+
+// authenticatedSender reports whether the topmost Authentication-Results
+// header from authServID shows the sender's domain passed DMARC, or DKIM or
+// SPF for that same domain. Headers from other servers are ignored, as the
+// sender could have written them.
+func authenticatedSender(header mail.Header, authServID, sender string) bool {
+	at := strings.LastIndex(sender, "@")
+	if at < 0 {
+		return false
+	}
+	domain := sender[at+1:]
+	sameDomain := func(value string) bool {
+		if at := strings.LastIndex(value, "@"); at >= 0 {
+			value = value[at+1:]
+		}
+		return strings.EqualFold(value, domain)
+	}
+
+	for _, value := range header["Authentication-Results"] {
+		value = authResultComment.ReplaceAllString(value, "")
+		results := strings.Split(value, ";")
+		if id := strings.Fields(results[0]); len(id) == 0 || !strings.EqualFold(id[0], authServID) {
+			continue
+		}
+		for _, result := range results[1:] {
+			fields := strings.Fields(result)
+			if len(fields) == 0 {
+				continue
+			}
+			method, outcome, _ := strings.Cut(strings.ToLower(fields[0]), "=")
+			if outcome != "pass" {
+				continue
+			}
+			props := map[string]string{}
+			for _, field := range fields[1:] {
+				if name, value, ok := strings.Cut(field, "="); ok {
+					props[strings.ToLower(name)] = strings.Trim(value, `"`)
+				}
+			}
+			switch method {
+			case "dmarc":
+				if from, ok := props["header.from"]; !ok || sameDomain(from) {
+					return true
+				}
+			case "dkim":
+				if sameDomain(props["header.d"]) || sameDomain(props["header.i"]) {
+					return true
+				}
+			case "spf":
+				if sameDomain(props["smtp.mailfrom"]) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return false
+}
+
+// ReadAttachments returns the files attached to a message, looking inside
+// nested multipart sections. The attachments may add up to maxSize bytes.
+func ReadAttachments(msg *mail.Message, maxSize int64) ([]Attachment, error) {
+	return readParts(msg.Header, msg.Body, &maxSize)
+}
+
+// partHeader is the header of a message or of one of its parts.
+type partHeader interface {
+	Get(key string) string
+}
+
+// readParts reads the attachments under header, taking their sizes off
+// remaining.
+func readParts(header partHeader, body io.Reader, remaining *int64) ([]Attachment, error) {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		var attachments []Attachment
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return attachments, nil
+			}
+			if err != nil {
+				return attachments, fmt.Errorf("failed to read message part: %w", err)
+			}
+			found, err := readParts(part.Header, part, remaining)
+			if err != nil {
+				return attachments, err
+			}
+			attachments = append(attachments, found...)
+		}
+	}
+
+	filename := attachmentFilename(header, params)
+	if filename == "" {
+		return nil, nil
+	}
+	data, err := readLimited(decodeTransferEncoding(header.Get("Content-Transfer-Encoding"), body), *remaining)
+	if errors.Is(err, ErrBundleTooLarge) {
+		return nil, ErrEmailTooLarge
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode attachment %s: %w", filename, err)
+	}
+	*remaining -= int64(len(data))
+	return []Attachment{{Filename: filename, Data: data}}, nil
+}
+
+func attachmentFilename(header partHeader, typeParams map[string]string) string {
+	decoder := new(mime.WordDecoder)
+	if _, params, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		if name, err := decoder.DecodeHeader(params["filename"]); err == nil {
+			return name
+		}
+		return params["filename"]
+	}
+	if typeParams["name"] != "" {
+		if name, err := decoder.DecodeHeader(typeParams["name"]); err == nil {
+			return name
+		}
+		return typeParams["name"]
+	}
+	return ""
+}
+
+func decodeTransferEncoding(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, &lineSkipper{r: body})
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	}
+	return body
+}
+
+// lineSkipper drops line breaks, which base64 bodies are wrapped with.
+type lineSkipper struct {
+	r io.Reader
+}
+
+func (l *lineSkipper) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	kept := 0
+	for _, b := range p[:n] {
+		if b != '\r' && b != '\n' {
+			p[kept] = b
+			kept++
+		}
+	}
+	return kept, err
+}
+
+type imapMailbox struct {
+	client *imapClient
+}
+
+func (m *imapMailbox) Messages() ([]mailMessage, error) {
+	uids, err := m.client.SearchUnseen()
+	if err != nil {
+		return nil, fmt.Errorf("failed to search mailbox: %w", err)
+	}
+	var messages []mailMessage
+	for _, uid := range uids {
+		raw, err := m.client.Fetch(uid)
+		if errors.Is(err, ErrEmailTooLarge) {
+			log.Printf("Ignoring email %d: %v", uid, err)
+			if err := m.client.MarkSeen(uid); err != nil {
+				log.Printf("Warning: Failed to mark email %d as processed: %v", uid, err)
+			}
+			continue
+		}
+		if err != nil {
+			return messages, fmt.Errorf("failed to fetch message %d: %w", uid, err)
+		}
+		messages = append(messages, mailMessage{ID: strconv.FormatUint(uint64(uid), 10), Raw: raw})
+	}
+	return messages, nil
+}
+
+func (m *imapMailbox) MarkProcessed(msg mailMessage) error {
+	uid, err := strconv.ParseUint(msg.ID, 10, 32)
+	if err != nil {
+		return err
+	}
+	return m.client.MarkSeen(uint32(uid))
+}
+
+func (m *imapMailbox) Close() error {
+	if err := m.client.Logout(); err != nil {
+		log.Printf("Warning: IMAP logout failed: %v", err)
+	}
+	return m.client.Close()
+}
+
+// maildirMailbox reads messages delivered to new/, and unread ones in cur/,
+// and moves them to cur/ flagged as seen.
+type maildirMailbox struct {
+	dir     string
+	maxSize int64
+}
+
+func (m *maildirMailbox) Messages() ([]mailMessage, error) {
+	var messages []mailMessage
+	for _, sub := range []string{"new", "cur"} {
+		entries, err := os.ReadDir(filepath.Join(m.dir, sub))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read maildir: %w", err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			if sub == "cur" && strings.Contains(maildirFlags(entry.Name()), "S") {
+				continue
+			}
+			id := filepath.Join(sub, entry.Name())
+			if info, err := entry.Info(); err == nil && info.Size() > m.maxSize {
+				log.Printf("Ignoring email %s: %v", id, ErrEmailTooLarge)
+				if err := m.MarkProcessed(mailMessage{ID: id}); err != nil {
+					log.Printf("Warning: Failed to mark email %s as processed: %v", id, err)
+				}
+				continue
+			}
+			raw, err := os.ReadFile(filepath.Join(m.dir, id))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read message: %w", err)
+			}
+			messages = append(messages, mailMessage{ID: id, Raw: raw})
+		}
+	}
+	return messages, nil
+}
+
+func (m *maildirMailbox) MarkProcessed(msg mailMessage) error {
+	name := filepath.Base(msg.ID)
+	base, _, _ := strings.Cut(name, ":2,")
+	flags := []rune(maildirFlags(name) + "S")
+	sort.Slice(flags, func(i, j int) bool { return flags[i] < flags[j] })
+	return os.Rename(filepath.Join(m.dir, msg.ID), filepath.Join(m.dir, "cur", base+":2,"+string(flags)))
+}
+
+func (m *maildirMailbox) Close() error {
+	return nil
+}
+
+func maildirFlags(name string) string {
+	_, flags, _ := strings.Cut(name, ":2,")
+	return flags
+}
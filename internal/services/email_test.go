@@ -0,0 +1,409 @@
+package services
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"bookify/internal/db"
+	"bookify/internal/testutil"
+)
+
+// fakeIMAP serves the handful of commands the email watcher sends.
+type fakeIMAP struct {
+	listener net.Listener
+	mu       sync.Mutex
+	messages map[uint32]string
+	seen     map[uint32]bool
+}
+
+func newFakeIMAP(t *testing.T, messages map[uint32]string) *fakeIMAP {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	f := &fakeIMAP{listener: listener, messages: messages, seen: make(map[uint32]bool)}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeIMAP) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	r := bufio.NewReader(conn)
+	fmt.Fprint(conn, "* OK fake IMAP ready\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		tag, command, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		fields := strings.Fields(command)
+
+		f.mu.Lock()
+		switch {
+		case fields[0] == "LOGIN":
+			if fields[2] != `"secret"` {
+				fmt.Fprintf(conn, "%s NO wrong password\r\n", tag)
+				f.mu.Unlock()
+				continue
+			}
+		case fields[0] == "UID" && fields[1] == "SEARCH":
+			var uids []string
+			for uid := range f.messages {
+				if !f.seen[uid] {
+					uids = append(uids, strconv.FormatUint(uint64(uid), 10))
+				}
+			}
+			fmt.Fprintf(conn, "* SEARCH %s\r\n", strings.Join(uids, " "))
+		case fields[0] == "UID" && fields[1] == "FETCH":
+			uid, _ := strconv.ParseUint(fields[2], 10, 32)
+			msg := f.messages[uint32(uid)]
+			fmt.Fprintf(conn, "* 1 FETCH (UID %d BODY[] {%d}\r\n%s)\r\n", uid, len(msg), msg)
+		case fields[0] == "UID" && fields[1] == "STORE":
+			uid, _ := strconv.ParseUint(fields[2], 10, 32)
+			f.seen[uint32(uid)] = true
+		case fields[0] == "LOGOUT":
+			fmt.Fprintf(conn, "* BYE\r\n%s OK LOGOUT completed\r\n", tag)
+			f.mu.Unlock()
+			return
+		}
+		f.mu.Unlock()
+		fmt.Fprintf(conn, "%s OK done\r\n", tag)
+	}
+}
+
+func setupEmail(t *testing.T, opts EmailOptions) (*EmailService, *db.Service, *db.Account) {
+	t.Helper()
+
+	testDB := testutil.SetupTestDB(t)
+	if err := testDB.AutoMigrate(&db.Account{}, &db.Job{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	dbService := db.NewService(testDB)
+
+	account, _ := dbService.CreateAccount("test-account", "folder-123")
+	account.EmailSenders = "reader@example.com\nOther@Example.com"
+	if err := dbService.UpdateAccount(account); err != nil {
+		t.Fatalf("Failed to save account: %v", err)
+	}
+	return NewEmailService(dbService, t.TempDir(), opts), dbService, account
+}
+
+func emailWithAttachment(from, filename, content string) string {
+	encoded := strings.NewReplacer("\n", "\r\n").Replace(wrapBase64(content))
+	return "From: " + from + "\r\n" +
+		"To: books@example.com\r\n" +
+		"Subject: A book\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=outer\r\n" +
+		"\r\n" +
+		"--outer\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Here you go\r\n" +
+		"--outer\r\n" +
+		"Content-Type: application/epub+zip; name=\"" + filename + "\"\r\n" +
+		"Content-Disposition: attachment; filename=\"" + filename + "\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"\r\n" +
+		encoded +
+		"--outer--\r\n"
+}
+
+// wrapBase64 encodes content as a mail client would, in short lines.
+func wrapBase64(content string) string {
+	var b strings.Builder
+	encoded := base64.StdEncoding.EncodeToString([]byte(content))
+	for len(encoded) > 8 {
+		b.WriteString(encoded[:8] + "\n")
+		encoded = encoded[8:]
+	}
+	b.WriteString(encoded + "\n")
+	return b.String()
+}
+
+func emailJobs(t *testing.T, dbService *db.Service) []db.Job {
+	t.Helper()
+
+	jobs, err := dbService.ListRecentJobs(50)
+	if err != nil {
+		t.Fatalf("ListRecentJobs() failed: %v", err)
+	}
+	return jobs
+}
+
+func TestEmailService_CheckMail_IMAP(t *testing.T) {
+	server := newFakeIMAP(t, map[uint32]string{
+		4: emailWithAttachment("Reader <READER@example.com>", "book.epub", "epub content"),
+		7: emailWithAttachment("stranger@example.com", "spam.epub", "unwanted"),
+	})
+	service, dbService, account := setupEmail(t, EmailOptions{
+		IMAPAddr: server.listener.Addr().String(),
+		Username: "books",
+		Password: "secret",
+	})
+
+	if err := service.CheckMail(); err != nil {
+		t.Fatalf("CheckMail() failed: %v", err)
+	}
+
+	jobs := emailJobs(t, dbService)
+	if len(jobs) != 1 {
+		t.Fatalf("queued %d jobs, want 1", len(jobs))
+	}
+	job := jobs[0]
+	if job.AccountID != account.ID || job.OriginalFilename != "book.epub" || job.EmailSender != "READER@example.com" || job.Status != "queued" {
+		t.Errorf("queued job = %+v", job)
+	}
+	if data, err := os.ReadFile(filepath.Join(service.tempDir, "book.epub")); err != nil || string(data) != "epub content" {
+		t.Errorf("temp file = %q, %v", data, err)
+	}
+	server.mu.Lock()
+	if !server.seen[4] || !server.seen[7] {
+		t.Errorf("seen = %v, want both messages marked", server.seen)
+	}
+	server.mu.Unlock()
+
+	// Marked messages are not fetched again
+	if err := service.CheckMail(); err != nil {
+		t.Fatalf("second CheckMail() failed: %v", err)
+	}
+	if jobs := emailJobs(t, dbService); len(jobs) != 1 {
+		t.Errorf("second check left %d jobs, want 1", len(jobs))
+	}
+}
+
+func TestEmailService_CheckMail_IMAPLoginFailure(t *testing.T) {
+	server := newFakeIMAP(t, map[uint32]string{})
+	service, _, _ := setupEmail(t, EmailOptions{
+		IMAPAddr: server.listener.Addr().String(),
+		Username: "books",
+		Password: "wrong",
+	})
+
+	if err := service.CheckMail(); err == nil || !strings.Contains(err.Error(), "login failed") {
+		t.Errorf("CheckMail() error = %v, want a login failure", err)
+	}
+}
+
+func TestEmailService_CheckMail_Maildir(t *testing.T) {
+	maildir := t.TempDir()
+	for _, sub := range []string{"new", "cur", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(maildir, sub), 0755); err != nil {
+			t.Fatalf("Failed to create maildir: %v", err)
+		}
+	}
+	messages := map[string]string{
+		"new/1.host":     emailWithAttachment("other@example.com", "book.epub", "first"),
+		"cur/2.host:2,":  emailWithAttachment("reader@example.com", "book.epub", "second"),
+		"cur/3.host:2,S": emailWithAttachment("reader@example.com", "read.epub", "already read"),
+	}
+	for name, msg := range messages {
+		if err := os.WriteFile(filepath.Join(maildir, name), []byte(msg), 0644); err != nil {
+			t.Fatalf("Failed to write message: %v", err)
+		}
+	}
+	service, dbService, _ := setupEmail(t, EmailOptions{Maildir: maildir})
+
+	if err := service.CheckMail(); err != nil {
+		t.Fatalf("CheckMail() failed: %v", err)
+	}
+
+	names := map[string]bool{}
+	for _, job := range emailJobs(t, dbService) {
+		names[job.OriginalFilename] = true
+	}
+	// Both attachments are called book.epub, so the second one is numbered
+	if len(names) != 2 || !names["book.epub"] || !names["book (2).epub"] {
+		t.Errorf("queued %v, want book.epub and book (2).epub", names)
+	}
+
+	for _, name := range []string{"cur/1.host:2,S", "cur/2.host:2,S", "cur/3.host:2,S"} {
+		if _, err := os.Stat(filepath.Join(maildir, name)); err != nil {
+			t.Errorf("%s missing: %v", name, err)
+		}
+	}
+	if entries, _ := os.ReadDir(filepath.Join(maildir, "new")); len(entries) != 0 {
+		t.Errorf("new/ still has %d messages", len(entries))
+	}
+}
+
+func TestEmailService_Duplicate(t *testing.T) {
+	service, dbService, account := setupEmail(t, EmailOptions{})
+
+	raw := emailWithAttachment("reader@example.com", "book.epub", "epub content")
+	if err := service.processMessage([]byte(raw)); err != nil {
+		t.Fatalf("processMessage() failed: %v", err)
+	}
+	first := emailJobs(t, dbService)[0]
+	first.Status = "completed"
+	if err := dbService.UpdateJob(&first); err != nil {
+		t.Fatalf("Failed to update job: %v", err)
+	}
+
+	if err := service.processMessage([]byte(raw)); err != nil {
+		t.Fatalf("processMessage() failed: %v", err)
+	}
+	jobs := emailJobs(t, dbService)
+	if len(jobs) != 2 {
+		t.Fatalf("got %d jobs, want 2", len(jobs))
+	}
+	for _, job := range jobs {
+		if job.ID != first.ID && (job.Status != "skipped" || job.EmailSender != "reader@example.com" || job.AccountID != account.ID) {
+			t.Errorf("duplicate job = %+v, want a skipped job", job)
+		}
+	}
+}
+
+func TestEmailService_AuthenticatedSenders(t *testing.T) {
+	service, dbService, _ := setupEmail(t, EmailOptions{AuthServID: "mx.bookify.test"})
+
+	raw := emailWithAttachment("reader@example.com", "forged.epub", "forged")
+	forged := "Authentication-Results: mx.bookify.test; dmarc=fail header.from=example.com\r\n" +
+		"Authentication-Results: mx.bookify.test; dmarc=pass header.from=example.com\r\n" + raw
+	for _, msg := range []string{raw, forged, "Authentication-Results: mx.evil.test; dmarc=pass\r\n" + raw} {
+		if err := service.processMessage([]byte(msg)); err != nil {
+			t.Fatalf("processMessage() failed: %v", err)
+		}
+	}
+	if jobs := emailJobs(t, dbService); len(jobs) != 0 {
+		t.Fatalf("queued %d jobs from unauthenticated senders", len(jobs))
+	}
+
+	raw = emailWithAttachment("reader@example.com", "book.epub", "genuine")
+	msg := "Authentication-Results: MX.bookify.test (Postfix);\r\n" +
+		"\tspf=softfail smtp.mailfrom=bounce@lists.example;\r\n" +
+		"\tdkim=pass header.d=example.com header.s=mail\r\n" + raw
+	if err := service.processMessage([]byte(msg)); err != nil {
+		t.Fatalf("processMessage() failed: %v", err)
+	}
+	if jobs := emailJobs(t, dbService); len(jobs) != 1 || jobs[0].OriginalFilename != "book.epub" {
+		t.Errorf("queued %+v, want the authenticated book", jobs)
+	}
+}
+
+func TestAuthenticatedSender(t *testing.T) {
+	tests := []struct {
+		results string
+		want    bool
+	}{
+		{"mx.test; dmarc=pass (p=reject) header.from=example.com", true},
+		{"mx.test 1; spf=pass smtp.mailfrom=reader@Example.com", true},
+		{"mx.test; dkim=pass header.i=@example.com", true},
+		{"mx.test; dkim=pass header.d=evil.test; spf=neutral", false},
+		{"mx.test; dmarc=pass header.from=evil.test", false},
+		{"mx.test; none", false},
+		{"mx.other; dmarc=pass header.from=example.com", false},
+	}
+	for _, tt := range tests {
+		header := mail.Header{"Authentication-Results": {tt.results}}
+		if got := authenticatedSender(header, "mx.test", "reader@example.com"); got != tt.want {
+			t.Errorf("authenticatedSender(%q) = %v, want %v", tt.results, got, tt.want)
+		}
+	}
+}
+
+func TestEmailService_TooLarge(t *testing.T) {
+	big := emailWithAttachment("reader@example.com", "big.epub", strings.Repeat("x", 4096))
+	server := newFakeIMAP(t, map[uint32]string{
+		1: big,
+		2: emailWithAttachment("reader@example.com", "small.epub", "small"),
+	})
+	service, dbService, _ := setupEmail(t, EmailOptions{
+		IMAPAddr: server.listener.Addr().String(),
+		Username: "books",
+		Password: "secret",
+		MaxSize:  2048,
+	})
+
+	if err := service.CheckMail(); err != nil {
+		t.Fatalf("CheckMail() failed: %v", err)
+	}
+	if jobs := emailJobs(t, dbService); len(jobs) != 1 || jobs[0].OriginalFilename != "small.epub" {
+		t.Errorf("queued %+v, want only the small book", jobs)
+	}
+	server.mu.Lock()
+	if !server.seen[1] {
+		t.Error("the oversized email wasn't marked as read")
+	}
+	server.mu.Unlock()
+
+	msg, err := mail.ReadMessage(strings.NewReader(big))
+	if err != nil {
+		t.Fatalf("ReadMessage() failed: %v", err)
+	}
+	if _, err := ReadAttachments(msg, 1024); !errors.Is(err, ErrEmailTooLarge) {
+		t.Errorf("ReadAttachments() error = %v, want ErrEmailTooLarge", err)
+	}
+}
+
+func TestReadAttachments(t *testing.T) {
+	raw := "From: reader@example.com\r\n" +
+		"Content-Type: multipart/mixed; boundary=outer\r\n" +
+		"\r\n" +
+		"--outer\r\n" +
+		"Content-Type: multipart/alternative; boundary=inner\r\n" +
+		"\r\n" +
+		"--inner\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"Plain body\r\n" +
+		"--inner\r\n" +
+		"Content-Type: text/html\r\n" +
+		"\r\n" +
+		"<p>HTML body</p>\r\n" +
+		"--inner--\r\n" +
+		"--outer\r\n" +
+		"Content-Type: application/epub+zip; name=\"=?UTF-8?Q?Caf=C3=A9.epub?=\"\r\n" +
+		"Content-Transfer-Encoding: quoted-printable\r\n" +
+		"\r\n" +
+		"caf=C3=A9\r\n" +
+		"--outer\r\n" +
+		"Content-Type: application/octet-stream\r\n" +
+		"Content-Disposition: attachment; filename=\"story.fb2\"\r\n" +
+		"\r\n" +
+		"<FictionBook/>\r\n" +
+		"--outer--\r\n"
+
+	msg, err := mail.ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ReadMessage() failed: %v", err)
+	}
+	attachments, err := ReadAttachments(msg, defaultEmailMaxSize)
+	if err != nil {
+		t.Fatalf("ReadAttachments() failed: %v", err)
+	}
+
+	want := []Attachment{
+		{Filename: "Café.epub", Data: []byte("café")},
+		{Filename: "story.fb2", Data: []byte("<FictionBook/>")},
+	}
+	if len(attachments) != len(want) {
+		t.Fatalf("got %d attachments, want %d", len(attachments), len(want))
+	}
+	for i := range want {
+		if attachments[i].Filename != want[i].Filename || string(attachments[i].Data) != string(want[i].Data) {
+			t.Errorf("attachment %d = %q %q, want %q %q", i, attachments[i].Filename, attachments[i].Data, want[i].Filename, want[i].Data)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+type epubContainer struct {
+	Rootfiles []struct {
+		FullPath  string `xml:"full-path,attr"`
+		MediaType string `xml:"media-type,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+type epubPackage struct {
+	Version          string       `xml:"version,attr"`
+	UniqueIdentifier string       `xml:"unique-identifier,attr"`
+	Metadata         opfMetadata  `xml:"metadata"`
+	Manifest         []opfItem    `xml:"manifest>item"`
+	Spine            []opfItemRef `xml:"spine>itemref"`
+}
+
+type opfMetadata struct {
+	Titles       []string        `xml:"title"`
+	Creators     []opfCreator    `xml:"creator"`
+	Languages    []string        `xml:"language"`
+	Publishers   []string        `xml:"publisher"`
+	Descriptions []string        `xml:"description"`
+	Identifiers  []opfIdentifier `xml:"identifier"`
+	Subjects     []string        `xml:"subject"`
+	Metas        []opfMeta       `xml:"meta"`
+}
+
+type opfCreator struct {
+	ID     string `xml:"id,attr"`
+	Role   string `xml:"role,attr"`
+	FileAs string `xml:"file-as,attr"`
+	Name   string `xml:",chardata"`
+}
+
+type opfIdentifier struct {
+	ID     string `xml:"id,attr"`
+	Scheme string `xml:"scheme,attr"`
+	Value  string `xml:",chardata"`
+}
+
+type opfMeta struct {
+	ID       string `xml:"id,attr"`
+	Name     string `xml:"name,attr"`
+	Content  string `xml:"content,attr"`
+	Property string `xml:"property,attr"`
+	Refines  string `xml:"refines,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type opfItem struct {
+	ID         string `xml:"id,attr"`
+	Href       string `xml:"href,attr"`
+	MediaType  string `xml:"media-type,attr"`
+	Properties string `xml:"properties,attr"`
+}
+
+type opfItemRef struct {
+	IDRef  string `xml:"idref,attr"`
+	Linear string `xml:"linear,attr"`
+}
+
+// epubBook is an opened EPUB archive together with its parsed package document.
+type epubBook struct {
+	zip     *zip.Reader
+	opfPath string
+	pkg     *epubPackage
+}
+
+func readEPUBPackage(r *zip.Reader) (*epubBook, error) {
+	data, err := readZipEntry(r, "META-INF/container.xml")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read container.xml: %w", err)
+	}
+
+	var container epubContainer
+	if err := xml.Unmarshal(data, &container); err != nil {
+		return nil, fmt.Errorf("failed to parse container.xml: %w", err)
+	}
+	if len(container.Rootfiles) == 0 || container.Rootfiles[0].FullPath == "" {
+		return nil, fmt.Errorf("container.xml does not reference a package document")
+	}
+
+	opfPath := container.Rootfiles[0].FullPath
+	data, err = readZipEntry(r, opfPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read package document: %w", err)
+	}
+
+	var pkg epubPackage
+	if err := xml.Unmarshal(data, &pkg); err != nil {
+		return nil, fmt.Errorf("failed to parse package document: %w", err)
+	}
+
+	return &epubBook{zip: r, opfPath: opfPath, pkg: &pkg}, nil
+}
+
+// resolve turns a manifest href into a path inside the archive.
+func (b *epubBook) resolve(href string) string {
+	if i := strings.IndexAny(href, "#?"); i != -1 {
+		href = href[:i]
+	}
+	return path.Join(path.Dir(b.opfPath), unescapeHref(href))
+}
+
+func (b *epubBook) item(id string) *opfItem {
+	for i := range b.pkg.Manifest {
+		if b.pkg.Manifest[i].ID == id {
+			return &b.pkg.Manifest[i]
+		}
+	}
+	return nil
+}
+
+func (b *epubBook) itemByPath(name string) *opfItem {
+	for i := range b.pkg.Manifest {
+		if b.resolve(b.pkg.Manifest[i].Href) == name {
+			return &b.pkg.Manifest[i]
+		}
+	}
+	return nil
+}
+
+func (b *epubBook) read(name string) ([]byte, error) {
+	return readZipEntry(b.zip, name)
+}
+
+func readZipEntry(r *zip.Reader, name string) ([]byte, error) {
+	for _, f := range r.File {
+		if f.Name == name {
+			return readZipFile(f)
+		}
+	}
+	return nil, fmt.Errorf("%s not found in archive", name)
+}
+
+func unescapeHref(href string) string {
+	if unescaped, err := url.PathUnescape(href); err == nil {
+		return unescaped
+	}
+	return href
+}
+
+func isImageMediaType(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "image/")
+}
+
+func hasProperty(properties, property string) bool {
+	for _, p := range strings.Fields(properties) {
+		if p == property {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// epubBuilder assembles a new EPUB 3 book for the importers. It writes the
+// package document, an EPUB 3 navigation document and an NCX table of
+// contents, which Kobo firmware still uses for KEPUBs.
+type epubBuilder struct {
+	title       string
+	author      string
+	language    string
+	description string
+	publisher   string
+	series      string
+	seriesIndex string
+
+	// Fixed-layout options for comics
+	fixedLayout bool
+	rightToLeft bool
+	spread      string
+	resolution  string
+
+	coverID string
+	items   []epubBuilderItem
+}
+
+type epubBuilderItem struct {
+	id         string
+	href       string
+	mediaType  string
+	properties string
+	data       []byte
+	inSpine    bool
+	tocTitle   string
+}
+
+func newEPUBBuilder(title string) *epubBuilder {
+	return &epubBuilder{title: title, language: "en"}
+}
+
+func (b *epubBuilder) addFile(id, href, mediaType string, data []byte) {
+	b.items = append(b.items, epubBuilderItem{id: id, href: href, mediaType: mediaType, data: data})
+}
+
+// addDocument adds an XHTML content document to the spine. Documents with a
+// tocTitle get an entry in the table of contents.
+func (b *epubBuilder) addDocument(id, href, tocTitle string, data []byte) {
+	b.items = append(b.items, epubBuilderItem{
+		id:        id,
+		href:      href,
+		mediaType: "application/xhtml+xml",
+		data:      data,
+		inSpine:   true,
+		tocTitle:  tocTitle,
+	})
+}
+
+func (b *epubBuilder) setCover(id string) {
+	b.coverID = id
+}
+
+func (b *epubBuilder) write(outputPath string) error {
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create EPUB: %w", err)
+	}
+	defer func() {
+		if closeErr := out.Close(); closeErr != nil {
+			log.Printf("Warning: Failed to close EPUB: %v", closeErr)
+		}
+	}()
+
+	writer := zip.NewWriter(out)
+	w, err := writer.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, epubMimetype); err != nil {
+		return err
+	}
+
+	identifier := "urn:uuid:" + uuid.New().String()
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"META-INF/container.xml", []byte(containerXML("OEBPS/content.opf"))},
+		{"OEBPS/content.opf", []byte(b.packageDocument(identifier))},
+		{"OEBPS/nav.xhtml", []byte(b.navDocument())},
+		{"OEBPS/toc.ncx", []byte(b.ncxDocument(identifier))},
+	}
+	for _, item := range b.items {
+		files = append(files, struct {
+			name string
+			data []byte
+		}{"OEBPS/" + item.href, item.data})
+	}
+
+	for _, f := range files {
+		if err := writeZipEntry(writer, f.name, f.data); err != nil {
+			return fmt.Errorf("failed to write %s: %w", f.name, err)
+		}
+	}
+
+	return writer.Close()
+}
+
+func (b *epubBuilder) packageDocument(identifier string) string {
+	var s strings.Builder
+	s.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	if b.fixedLayout {
+		s.WriteString(`<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="bookid" prefix="rendition: http://www.idpf.org/vocab/rendition/#">` + "\n")
+	} else {
+		s.WriteString(`<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="bookid">` + "\n")
+	}
+
+	s.WriteString(`  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">` + "\n")
+	fmt.Fprintf(&s, "    <dc:identifier id=\"bookid\">%s</dc:identifier>\n", escapeXML(identifier))
+	fmt.Fprintf(&s, "    <dc:title>%s</dc:title>\n", escapeXML(b.title))
+	if b.author != "" {
+		fmt.Fprintf(&s, "    <dc:creator id=\"author\">%s</dc:creator>\n", escapeXML(b.author))
+	}
+	fmt.Fprintf(&s, "    <dc:language>%s</dc:language>\n", escapeXML(b.language))
+	if b.publisher != "" {
+		fmt.Fprintf(&s, "    <dc:publisher>%s</dc:publisher>\n", escapeXML(b.publisher))
+	}
+	if b.description != "" {
+		fmt.Fprintf(&s, "    <dc:description>%s</dc:description>\n", escapeXML(b.description))
+	}
+	fmt.Fprintf(&s, "    <meta property=\"dcterms:modified\">%s</meta>\n", time.Now().UTC().Format("2006-01-02T15:04:05Z"))
+	if b.series != "" {
+		fmt.Fprintf(&s, "    <meta name=\"calibre:series\" content=\"%s\"/>\n", escapeXML(b.series))
+		if b.seriesIndex != "" {
+			fmt.Fprintf(&s, "    <meta name=\"calibre:series_index\" content=\"%s\"/>\n", escapeXML(b.seriesIndex))
+		}
+	}
+	if b.coverID != "" {
+		fmt.Fprintf(&s, "    <meta name=\"cover\" content=\"%s\"/>\n", escapeXML(b.coverID))
+	}
+	if b.fixedLayout {
+		s.WriteString("    <meta property=\"rendition:layout\">pre-paginated</meta>\n")
+		s.WriteString("    <meta property=\"rendition:orientation\">auto</meta>\n")
+		fmt.Fprintf(&s, "    <meta property=\"rendition:spread\">%s</meta>\n", escapeXML(b.spread))
+		s.WriteString("    <meta name=\"book-type\" content=\"comic\"/>\n")
+		if b.resolution != "" {
+			fmt.Fprintf(&s, "    <meta name=\"original-resolution\" content=\"%s\"/>\n", escapeXML(b.resolution))
+		}
+	}
+	s.WriteString("  </metadata>\n")
+
+	s.WriteString("  <manifest>\n")
+	s.WriteString("    <item id=\"nav\" href=\"nav.xhtml\" media-type=\"application/xhtml+xml\" properties=\"nav\"/>\n")
+	s.WriteString("    <item id=\"ncx\" href=\"toc.ncx\" media-type=\"application/x-dtbncx+xml\"/>\n")
+	for _, item := range b.items {
+		properties := item.properties
+		if item.id == b.coverID {
+			properties = strings.TrimSpace(properties + " cover-image")
+		}
+		if properties != "" {
+			fmt.Fprintf(&s, "    <item id=\"%s\" href=\"%s\" media-type=\"%s\" properties=\"%s\"/>\n",
+				escapeXML(item.id), escapeXML(item.href), item.mediaType, properties)
+		} else {
+			fmt.Fprintf(&s, "    <item id=\"%s\" href=\"%s\" media-type=\"%s\"/>\n",
+				escapeXML(item.id), escapeXML(item.href), item.mediaType)
+		}
+	}
+	s.WriteString("  </manifest>\n")
+
+	if b.rightToLeft {
+		s.WriteString("  <spine toc=\"ncx\" page-progression-direction=\"rtl\">\n")
+	} else {
+		s.WriteString("  <spine toc=\"ncx\">\n")
+	}
+	for _, item := range b.items {
+		if item.inSpine {
+			fmt.Fprintf(&s, "    <itemref idref=\"%s\"/>\n", escapeXML(item.id))
+		}
+	}
+	s.WriteString("  </spine>\n")
+	s.WriteString("</package>\n")
+	return s.String()
+}
+
+func (b *epubBuilder) tocEntries() []epubBuilderItem {
+	var entries []epubBuilderItem
+	for _, item := range b.items {
+		if item.inSpine && item.tocTitle != "" {
+			entries = append(entries, item)
+		}
+	}
+	if len(entries) == 0 {
+		for _, item := range b.items {
+			if item.inSpine {
+				item.tocTitle = b.title
+				return []epubBuilderItem{item}
+			}
+		}
+	}
+	return entries
+}
+
+func (b *epubBuilder) navDocument() string {
+	var s strings.Builder
+	s.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>Contents</title></head>
+<body>
+<nav epub:type="toc" id="toc">
+<h1>Contents</h1>
+<ol>
+`)
+	for _, item := range b.tocEntries() {
+		fmt.Fprintf(&s, "<li><a href=\"%s\">%s</a></li>\n", escapeXML(item.href), escapeXML(item.tocTitle))
+	}
+	s.WriteString("</ol>\n</nav>\n</body>\n</html>\n")
+	return s.String()
+}
+
+func (b *epubBuilder) ncxDocument(identifier string) string {
+	var s strings.Builder
+	s.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+<head>
+`)
+	fmt.Fprintf(&s, "<meta name=\"dtb:uid\" content=\"%s\"/>\n", escapeXML(identifier))
+	s.WriteString("</head>\n")
+	fmt.Fprintf(&s, "<docTitle><text>%s</text></docTitle>\n", escapeXML(b.title))
+	s.WriteString("<navMap>\n")
+	for i, item := range b.tocEntries() {
+		fmt.Fprintf(&s, "<navPoint id=\"nav-%d\" playOrder=\"%d\"><navLabel><text>%s</text></navLabel><content src=\"%s\"/></navPoint>\n",
+			i+1, i+1, escapeXML(item.tocTitle), escapeXML(item.href))
+	}
+	s.WriteString("</navMap>\n</ncx>\n")
+	return s.String()
+}
+
+func escapeXML(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s)) // strings.Builder never fails
+	return b.String()
+}
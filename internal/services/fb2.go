@@ -0,0 +1,529 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+var ErrInvalidFB2 = errors.New("file is not a valid FictionBook document")
+
+// fb2Node is an element or text node of a FictionBook document. FB2 mixes
+// text and markup freely, so it is read into a tree rather than structs.
+type fb2Node struct {
+	name     string
+	attrs    []xml.Attr
+	text     string
+	children []*fb2Node
+}
+
+func (n *fb2Node) attr(local string) string {
+	for _, attr := range n.attrs {
+		if attr.Name.Local == local {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+func (n *fb2Node) child(name string) *fb2Node {
+	for _, child := range n.children {
+		if child.name == name {
+			return child
+		}
+	}
+	return nil
+}
+
+func (n *fb2Node) childrenNamed(name string) []*fb2Node {
+	var found []*fb2Node
+	for _, child := range n.children {
+		if child.name == name {
+			found = append(found, child)
+		}
+	}
+	return found
+}
+
+func (n *fb2Node) plainText() string {
+	if n == nil {
+		return ""
+	}
+	var b strings.Builder
+	var walk func(*fb2Node)
+	walk = func(n *fb2Node) {
+		if n.name == "" {
+			b.WriteString(n.text)
+		}
+		for _, child := range n.children {
+			walk(child)
+			if child.name == "p" {
+				b.WriteString(" ")
+			}
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+type fb2Binary struct {
+	itemID    string
+	href      string
+	mediaType string
+}
+
+// fb2Converter renders FictionBook sections as XHTML chapters. Links between
+// chapters and notes are resolved through ids, so every document's ids are
+// collected before anything is rendered.
+type fb2Converter struct {
+	builder  *epubBuilder
+	binaries map[string]fb2Binary
+	idFiles  map[string]string
+}
+
+// buildFB2EPUB converts a FictionBook 2 file, or a ZIP holding one, into an
+// EPUB with a chapter per top-level section and the notes in a document of
+// their own.
+func buildFB2EPUB(inputPath, outputPath string, zipped bool, opts ImportOptions, fallbackTitle string) error {
+	data, err := readFB2File(inputPath, zipped)
+	if err != nil {
+		return err
+	}
+
+	root, err := parseFB2(data)
+	if err != nil {
+		return err
+	}
+
+	conv := &fb2Converter{
+		builder:  newEPUBBuilder(fallbackTitle),
+		binaries: map[string]fb2Binary{},
+		idFiles:  map[string]string{},
+	}
+	conv.applyDescription(root.child("description"))
+	if opts.Title != "" {
+		conv.builder.title = opts.Title
+	}
+	if opts.Author != "" {
+		conv.builder.author = opts.Author
+	}
+
+	for _, binary := range root.childrenNamed("binary") {
+		conv.addBinary(binary)
+	}
+	if coverpage := root.child("description").childPath("title-info", "coverpage", "image"); coverpage != nil {
+		if binary, ok := conv.binaries[strings.TrimPrefix(coverpage.attr("href"), "#")]; ok {
+			conv.builder.setCover(binary.itemID)
+		}
+	}
+
+	var mainBody *fb2Node
+	var noteBodies []*fb2Node
+	for _, body := range root.childrenNamed("body") {
+		switch {
+		case body.attr("name") == "notes" || body.attr("name") == "comments":
+			noteBodies = append(noteBodies, body)
+		case mainBody == nil:
+			mainBody = body
+		}
+	}
+	if mainBody == nil {
+		return fmt.Errorf("%w: no body", ErrInvalidFB2)
+	}
+
+	type fb2Document struct {
+		href  string
+		title string
+		nodes []*fb2Node
+	}
+	var documents []fb2Document
+
+	// Anything before the first section (book title, epigraphs) becomes a
+	// title page
+	var intro []*fb2Node
+	var sections []*fb2Node
+	for _, child := range mainBody.children {
+		if child.name == "section" {
+			sections = append(sections, child)
+		} else if len(sections) == 0 && child.name != "" {
+			intro = append(intro, child)
+		}
+	}
+	if len(intro) > 0 {
+		documents = append(documents, fb2Document{title: conv.builder.title, nodes: intro})
+	}
+	for i, section := range sections {
+		title := section.child("title").plainText()
+		if title == "" {
+			title = fmt.Sprintf("Chapter %d", i+1)
+		}
+		documents = append(documents, fb2Document{title: title, nodes: []*fb2Node{section}})
+	}
+	if len(noteBodies) > 0 {
+		var notes []*fb2Node
+		for _, body := range noteBodies {
+			notes = append(notes, body.children...)
+		}
+		documents = append(documents, fb2Document{title: "Notes", nodes: notes})
+	}
+	if len(documents) == 0 {
+		return fmt.Errorf("%w: empty body", ErrInvalidFB2)
+	}
+
+	for i := range documents {
+		documents[i].href = fmt.Sprintf("chapter-%03d.xhtml", i+1)
+		for _, node := range documents[i].nodes {
+			conv.collectIDs(node, documents[i].href)
+		}
+	}
+
+	for i, doc := range documents {
+		var content strings.Builder
+		for _, node := range doc.nodes {
+			conv.renderBlock(&content, node, 1)
+		}
+		conv.builder.addDocument(
+			fmt.Sprintf("chapter-%03d", i+1),
+			"text/"+doc.href,
+			doc.title,
+			[]byte(chapterDocument(doc.title, conv.builder.language, content.String())),
+		)
+	}
+
+	return conv.builder.write(outputPath)
+}
+
+func (n *fb2Node) childPath(names ...string) *fb2Node {
+	for _, name := range names {
+		if n == nil {
+			return nil
+		}
+		n = n.child(name)
+	}
+	return n
+}
+
+func readFB2File(inputPath string, zipped bool) ([]byte, error) {
+	if !zipped {
+		data, err := os.ReadFile(inputPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read FB2 file: %w", err)
+		}
+		return data, nil
+	}
+
+	zipReader, err := zip.OpenReader(inputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open FB2 archive: %w", err)
+	}
+	defer func() {
+		if closeErr := zipReader.Close(); closeErr != nil {
+			log.Printf("Warning: Failed to close ZIP reader: %v", closeErr)
+		}
+	}()
+
+	for _, f := range zipReader.File {
+		if !skipArchiveEntry(f.Name) && strings.EqualFold(path.Ext(f.Name), ".fb2") {
+			return readZipFile(f)
+		}
+	}
+	return nil, fmt.Errorf("%w: archive contains no .fb2 file", ErrInvalidFB2)
+}
+
+func parseFB2(data []byte) (*fb2Node, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Entity = xml.HTMLEntity
+	decoder.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		encoding, err := htmlindex.Get(label)
+		if err != nil {
+			return nil, fmt.Errorf("unsupported encoding %q", label)
+		}
+		return encoding.NewDecoder().Reader(input), nil
+	}
+
+	var root *fb2Node
+	var stack []*fb2Node
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidFB2, err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &fb2Node{name: t.Name.Local, attrs: t.Attr}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, node)
+			} else if root == nil {
+				root = node
+			}
+			stack = append(stack, node)
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, &fb2Node{text: string(t)})
+			}
+		}
+	}
+
+	if root == nil || root.name != "FictionBook" {
+		return nil, ErrInvalidFB2
+	}
+	return root, nil
+}
+
+func (c *fb2Converter) applyDescription(description *fb2Node) {
+	titleInfo := description.childPath("title-info")
+	if titleInfo == nil {
+		return
+	}
+
+	if title := titleInfo.child("book-title").plainText(); title != "" {
+		c.builder.title = title
+	}
+	var authors []string
+	for _, author := range titleInfo.childrenNamed("author") {
+		var parts []string
+		for _, field := range []string{"first-name", "middle-name", "last-name"} {
+			if part := author.child(field).plainText(); part != "" {
+				parts = append(parts, part)
+			}
+		}
+		if len(parts) == 0 {
+			parts = append(parts, author.child("nickname").plainText())
+		}
+		if name := strings.Join(parts, " "); name != "" {
+			authors = append(authors, name)
+		}
+	}
+	c.builder.author = strings.Join(authors, ", ")
+	if lang := titleInfo.child("lang").plainText(); lang != "" {
+		c.builder.language = lang
+	}
+	c.builder.description = titleInfo.child("annotation").plainText()
+	if sequence := titleInfo.child("sequence"); sequence != nil {
+		c.builder.series = sequence.attr("name")
+		c.builder.seriesIndex = sequence.attr("number")
+	}
+	c.builder.publisher = description.childPath("publish-info", "publisher").plainText()
+}
+
+func (c *fb2Converter) addBinary(binary *fb2Node) {
+	id := binary.attr("id")
+	mediaType := binary.attr("content-type")
+	var ext string
+	switch mediaType {
+	case "image/jpeg", "image/jpg":
+		mediaType, ext = "image/jpeg", "jpg"
+	case "image/png":
+		ext = "png"
+	case "image/gif":
+		ext = "gif"
+	default:
+		return
+	}
+
+	payload := strings.Join(strings.Fields(binary.plainText()), "")
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil || id == "" {
+		log.Printf("Warning: Skipping unreadable FB2 image %q: %v", id, err)
+		return
+	}
+
+	index := len(c.binaries) + 1
+	item := fb2Binary{
+		itemID:    fmt.Sprintf("image-%03d", index),
+		href:      fmt.Sprintf("images/image-%03d.%s", index, ext),
+		mediaType: mediaType,
+	}
+	c.builder.addFile(item.itemID, item.href, item.mediaType, data)
+	c.binaries[id] = item
+}
+
+func (c *fb2Converter) collectIDs(n *fb2Node, href string) {
+	if id := n.attr("id"); id != "" {
+		c.idFiles[id] = href
+	}
+	for _, child := range n.children {
+		c.collectIDs(child, href)
+	}
+}
+
+func (c *fb2Converter) idAttr(n *fb2Node) string {
+	if id := n.attr("id"); id != "" {
+		return fmt.Sprintf(` id="%s"`, escapeXML(id))
+	}
+	return ""
+}
+
+// renderBlock writes block-level FB2 elements. depth is the section nesting
+// level and decides which heading a title becomes.
+func (c *fb2Converter) renderBlock(b *strings.Builder, n *fb2Node, depth int) {
+	switch n.name {
+	case "":
+		if text := strings.TrimSpace(n.text); text != "" {
+			fmt.Fprintf(b, "<p>%s</p>\n", escapeXML(text))
+		}
+	case "section":
+		fmt.Fprintf(b, "<div class=\"section\"%s>\n", c.idAttr(n))
+		for _, child := range n.children {
+			c.renderBlock(b, child, depth+1)
+		}
+		b.WriteString("</div>\n")
+	case "title":
+		level := depth
+		if level > 6 {
+			level = 6
+		}
+		fmt.Fprintf(b, "<h%d%s>", level, c.idAttr(n))
+		c.renderInline(b, n)
+		fmt.Fprintf(b, "</h%d>\n", level)
+	case "p":
+		fmt.Fprintf(b, "<p%s>", c.idAttr(n))
+		c.renderInline(b, n)
+		b.WriteString("</p>\n")
+	case "subtitle":
+		fmt.Fprintf(b, "<p class=\"subtitle\"%s><strong>", c.idAttr(n))
+		c.renderInline(b, n)
+		b.WriteString("</strong></p>\n")
+	case "text-author":
+		b.WriteString("<p class=\"text-author\">")
+		c.renderInline(b, n)
+		b.WriteString("</p>\n")
+	case "empty-line":
+		b.WriteString("<p class=\"empty-line\">\u00a0</p>\n")
+	case "image":
+		if img := c.imageTag(n); img != "" {
+			fmt.Fprintf(b, "<div class=\"image\"%s>%s</div>\n", c.idAttr(n), img)
+		}
+	case "epigraph", "cite", "annotation":
+		fmt.Fprintf(b, "<blockquote class=\"%s\"%s>\n", n.name, c.idAttr(n))
+		for _, child := range n.children {
+			c.renderBlock(b, child, depth)
+		}
+		b.WriteString("</blockquote>\n")
+	case "poem", "stanza":
+		fmt.Fprintf(b, "<div class=\"%s\"%s>\n", n.name, c.idAttr(n))
+		for _, child := range n.children {
+			if child.name == "title" {
+				b.WriteString("<p class=\"poem-title\"><strong>")
+				c.renderInline(b, child)
+				b.WriteString("</strong></p>\n")
+				continue
+			}
+			c.renderBlock(b, child, depth)
+		}
+		b.WriteString("</div>\n")
+	case "v":
+		b.WriteString("<p class=\"verse\">")
+		c.renderInline(b, n)
+		b.WriteString("</p>\n")
+	case "table":
+		b.WriteString("<table>\n")
+		for _, row := range n.childrenNamed("tr") {
+			b.WriteString("<tr>")
+			for _, cell := range row.children {
+				if cell.name != "th" && cell.name != "td" {
+					continue
+				}
+				fmt.Fprintf(b, "<%s>", cell.name)
+				c.renderInline(b, cell)
+				fmt.Fprintf(b, "</%s>", cell.name)
+			}
+			b.WriteString("</tr>\n")
+		}
+		b.WriteString("</table>\n")
+	default:
+		for _, child := range n.children {
+			c.renderBlock(b, child, depth)
+		}
+	}
+}
+
+func (c *fb2Converter) renderInline(b *strings.Builder, n *fb2Node) {
+	paragraphs := 0
+	for _, child := range n.children {
+		switch child.name {
+		case "":
+			b.WriteString(escapeXML(child.text))
+		case "emphasis":
+			c.wrapInline(b, child, "em")
+		case "strong":
+			c.wrapInline(b, child, "strong")
+		case "strikethrough":
+			c.wrapInline(b, child, "del")
+		case "sub", "sup", "code":
+			c.wrapInline(b, child, child.name)
+		case "a":
+			c.renderLink(b, child)
+		case "image":
+			b.WriteString(c.imageTag(child))
+		case "p":
+			// Titles hold paragraphs; keep them on separate lines
+			if paragraphs > 0 {
+				b.WriteString("<br/>")
+			}
+			paragraphs++
+			c.renderInline(b, child)
+		default:
+			c.renderInline(b, child)
+		}
+	}
+}
+
+func (c *fb2Converter) wrapInline(b *strings.Builder, n *fb2Node, tag string) {
+	fmt.Fprintf(b, "<%s>", tag)
+	c.renderInline(b, n)
+	fmt.Fprintf(b, "</%s>", tag)
+}
+
+func (c *fb2Converter) renderLink(b *strings.Builder, n *fb2Node) {
+	href := n.attr("href")
+	if strings.HasPrefix(href, "#") {
+		file, ok := c.idFiles[strings.TrimPrefix(href, "#")]
+		if !ok {
+			c.renderInline(b, n)
+			return
+		}
+		href = file + href
+	} else if !strings.HasPrefix(href, "http://") && !strings.HasPrefix(href, "https://") && !strings.HasPrefix(href, "mailto:") {
+		c.renderInline(b, n)
+		return
+	}
+
+	if n.attr("type") == "note" {
+		fmt.Fprintf(b, "<a href=\"%s\" epub:type=\"noteref\"><sup>", escapeXML(href))
+		c.renderInline(b, n)
+		b.WriteString("</sup></a>")
+		return
+	}
+	fmt.Fprintf(b, "<a href=\"%s\">", escapeXML(href))
+	c.renderInline(b, n)
+	b.WriteString("</a>")
+}
+
+func (c *fb2Converter) imageTag(n *fb2Node) string {
+	binary, ok := c.binaries[strings.TrimPrefix(n.attr("href"), "#")]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("<img src=\"../%s\" alt=\"%s\"/>", escapeXML(binary.href), escapeXML(n.attr("alt")))
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bookify/internal/testutil"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+const testFB2 = `<?xml version="1.0" encoding="UTF-8"?>
+<FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0" xmlns:l="http://www.w3.org/1999/xlink">
+  <description>
+    <title-info>
+      <genre>sf</genre>
+      <author><first-name>Ivan</first-name><last-name>Petrov</last-name></author>
+      <book-title>Distant Stars</book-title>
+      <annotation><p>A voyage.</p></annotation>
+      <coverpage><image l:href="#cover.png"/></coverpage>
+      <lang>ru</lang>
+      <sequence name="Star Cycle" number="2"/>
+    </title-info>
+    <publish-info><publisher>Example Press</publisher></publish-info>
+  </description>
+  <body>
+    <title><p>Distant Stars</p></title>
+    <epigraph><p>Per aspera ad astra.</p><text-author>Seneca</text-author></epigraph>
+    <section id="ch1">
+      <title><p>Chapter One</p><p>Departure</p></title>
+      <p>The ship <emphasis>left</emphasis> at dawn.<a l:href="#n1" type="note">1</a></p>
+      <empty-line/>
+      <image l:href="#pic.png"/>
+      <section>
+        <title><p>Part A</p></title>
+        <p>Nested &amp; escaped &lt;text&gt;.</p>
+      </section>
+    </section>
+    <section>
+      <title><p>Chapter Two</p></title>
+      <poem><stanza><v>Line one</v><v>Line two</v></stanza></poem>
+      <p>See <a l:href="#ch1">chapter one</a>.</p>
+    </section>
+  </body>
+  <body name="notes">
+    <section id="n1"><title><p>1</p></title><p>A footnote.</p></section>
+  </body>
+  <binary id="cover.png" content-type="image/png">iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNk+M9QDwADhgGAWjR9awAAAABJRU5ErkJggg==</binary>
+  <binary id="pic.png" content-type="image/png">
+    iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNk+M9QDwADhgGAWjR9awAAAABJRU5ErkJggg==
+  </binary>
+</FictionBook>`
+
+func TestImporterService_Import_FB2(t *testing.T) {
+	book, _ := importDocument(t, "stars.fb2", testFB2, ImportOptions{})
+
+	metadata := book.pkg.Metadata
+	if len(metadata.Titles) != 1 || metadata.Titles[0] != "Distant Stars" {
+		t.Errorf("titles = %v, want book-title", metadata.Titles)
+	}
+	if len(metadata.Creators) != 1 || metadata.Creators[0].Name != "Ivan Petrov" {
+		t.Errorf("creators = %v, want Ivan Petrov", metadata.Creators)
+	}
+	if len(metadata.Languages) != 1 || metadata.Languages[0] != "ru" {
+		t.Errorf("languages = %v, want ru", metadata.Languages)
+	}
+
+	// Title page, two chapters and the notes
+	if len(book.pkg.Spine) != 4 {
+		t.Fatalf("spine has %d documents, want 4", len(book.pkg.Spine))
+	}
+
+	opf := readBookFile(t, book, book.opfPath)
+	for _, want := range []string{`content="Star Cycle"`, `properties="cover-image"`, "Example Press", "A voyage."} {
+		if !strings.Contains(opf, want) {
+			t.Errorf("OPF missing %q", want)
+		}
+	}
+
+	chapter1 := readBookFile(t, book, "OEBPS/text/chapter-002.xhtml")
+	for _, want := range []string{
+		"<h2>Chapter One<br/>Departure</h2>",
+		"<em>left</em>",
+		`<a href="chapter-004.xhtml#n1" epub:type="noteref">`,
+		`<img src="../images/image-002.png"`,
+		"<h3>Part A</h3>",
+		"Nested &amp; escaped &lt;text&gt;.",
+	} {
+		if !strings.Contains(chapter1, want) {
+			t.Errorf("chapter 1 missing %q:\n%s", want, chapter1)
+		}
+	}
+
+	chapter2 := readBookFile(t, book, "OEBPS/text/chapter-003.xhtml")
+	if !strings.Contains(chapter2, `<a href="chapter-002.xhtml#ch1">chapter one</a>`) {
+		t.Errorf("cross-chapter link not resolved:\n%s", chapter2)
+	}
+
+	notes := readBookFile(t, book, "OEBPS/text/chapter-004.xhtml")
+	if !strings.Contains(notes, `id="n1"`) || !strings.Contains(notes, "A footnote.") {
+		t.Errorf("notes document missing footnote:\n%s", notes)
+	}
+}
+
+func TestImporterService_Import_FB2Encodings(t *testing.T) {
+	// Russian FB2 files are often Windows-1251 encoded
+	source := strings.Replace(testFB2, `encoding="UTF-8"`, `encoding="windows-1251"`, 1)
+	source = strings.Replace(source, "Distant Stars</book-title>", "Далёкие звёзды</book-title>", 1)
+	encoded, err := charmap.Windows1251.NewEncoder().String(source)
+	if err != nil {
+		t.Fatalf("Failed to encode test FB2: %v", err)
+	}
+	book, _ := importDocument(t, "stars.fb2", encoded, ImportOptions{})
+	if titles := book.pkg.Metadata.Titles; len(titles) != 1 || titles[0] != "Далёкие звёзды" {
+		t.Errorf("titles = %v, want decoded Cyrillic title", titles)
+	}
+
+	// .fb2.zip holds the document in a ZIP archive
+	zipped := testutil.CreateEPUBArchive(t, "stars.fb2.zip", map[string]string{"stars.fb2": testFB2})
+	outputPath := filepath.Join(t.TempDir(), "stars.epub")
+	if err := NewImporterService().Import(zipped, "stars.fb2.zip", outputPath, ImportOptions{}); err != nil {
+		t.Fatalf("Import(.fb2.zip) error = %v", err)
+	}
+	if got := EPUBFilename("Stars.FB2.zip"); got != "Stars.epub" {
+		t.Errorf("EPUBFilename(Stars.FB2.zip) = %q, want Stars.epub", got)
+	}
+}
+
+func TestImporterService_Import_InvalidFB2(t *testing.T) {
+	inputPath := writeDocument(t, "broken.fb2", "<html><body>not fiction</body></html>")
+
+	err := NewImporterService().Import(inputPath, "broken.fb2", filepath.Join(t.TempDir(), "broken.epub"), ImportOptions{})
+	if !errors.Is(err, ErrInvalidFB2) {
+		t.Errorf("Import() error = %v, want ErrInvalidFB2", err)
+	}
+}
@@ -0,0 +1,270 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// FetchOptions limit downloads of books by URL.
+type FetchOptions struct {
+	MaxSize      int64
+	Timeout      time.Duration
+	MaxRedirects int
+	// Let links reach loopback, private and link-local addresses, such as
+	// a NAS on the home network. Otherwise anyone who can add a link could
+	// make the server fetch from inside its own network.
+	AllowPrivate bool
+}
+
+var DefaultFetchOptions = FetchOptions{
+	MaxSize:      100 << 20,
+	Timeout:      2 * time.Minute,
+	MaxRedirects: 5,
+}
+
+var (
+	ErrTooLarge         = errors.New("file is larger than the download limit")
+	ErrNotABook         = errors.New("the link doesn't lead to a supported book")
+	ErrTooManyRedirects = errors.New("too many redirects")
+	ErrBadURLScheme     = errors.New("only http and https links can be added")
+	ErrPrivateAddress   = errors.New("links to private network addresses can't be added")
+)
+
+// sharedAddressSpace is the carrier-grade NAT range, private in all but name.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// Extensions for the content types books are served with
+var bookContentTypes = map[string]string{
+	"application/epub+zip":          ".epub",
+	"application/x-fictionbook+xml": ".fb2",
+	"application/vnd.comicbook+zip": ".cbz",
+	"application/vnd.comicbook-rar": ".cbr",
+	"text/markdown":                 ".md",
+	"text/plain":                    ".txt",
+}
+
+// FetchedFile is a book downloaded to a temporary file.
+type FetchedFile struct {
+	Path     string
+	Filename string
+	Size     int64
+}
+
+// FetchURL downloads a book into dir, following a limited number of
+// redirects, and works out its filename from the response. The caller
+// removes the file.
+func FetchURL(rawURL, dir string, opts FetchOptions) (*FetchedFile, error) {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = DefaultFetchOptions.MaxSize
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultFetchOptions.Timeout
+	}
+	if opts.MaxRedirects <= 0 {
+		opts.MaxRedirects = DefaultFetchOptions.MaxRedirects
+	}
+
+	parsed, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %s", rawURL)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, ErrBadURLScheme
+	}
+	if parsed.Host == "" {
+		return nil, fmt.Errorf("invalid URL: %s", rawURL)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if !opts.AllowPrivate {
+		// Checked on every connection, redirects included, after DNS has
+		// resolved the host. A proxy would connect on the server's behalf,
+		// so none is used.
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: refusePrivateAddress}
+		transport.DialContext = dialer.DialContext
+		transport.Proxy = nil
+	}
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   opts.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > opts.MaxRedirects {
+				return ErrTooManyRedirects
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return ErrBadURLScheme
+			}
+			return nil
+		},
+	}
+	req, err := http.NewRequest(http.MethodGet, parsed.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	req.Header.Set("User-Agent", "Bookify")
+	req.Header.Set("Accept", "application/epub+zip, application/octet-stream;q=0.9, */*;q=0.8")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download: %w", err)
+	}
+	defer func() {
+		if closeErr := res.Body.Close(); closeErr != nil {
+			log.Printf("Warning: Failed to close download: %v", closeErr)
+		}
+	}()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download: server answered %s", res.Status)
+	}
+	if res.ContentLength > opts.MaxSize {
+		return nil, ErrTooLarge
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	file, err := os.CreateTemp(dir, ".download-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file: %w", err)
+	}
+	size, err := io.Copy(file, io.LimitReader(res.Body, opts.MaxSize+1))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && size > opts.MaxSize {
+		err = ErrTooLarge
+	}
+	if err != nil {
+		removeTempFile(file.Name())
+		if errors.Is(err, ErrTooLarge) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to download: %w", err)
+	}
+
+	filename, err := fetchedFilename(res, file.Name())
+	if err != nil {
+		removeTempFile(file.Name())
+		return nil, err
+	}
+	return &FetchedFile{Path: file.Name(), Filename: filename, Size: size}, nil
+}
+
+// refusePrivateAddress stops connections to addresses inside the server's
+// own network.
+func refusePrivateAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	ip = ip.Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast() || sharedAddressSpace.Contains(ip) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
+
+// fetchedFilename names a download after its Content-Disposition, or the
+// last part of the URL it came from. Without a book extension there, the
+// content type and then the file's first bytes decide the extension.
+func fetchedFilename(res *http.Response, filePath string) (string, error) {
+	name := ""
+	if _, params, err := mime.ParseMediaType(res.Header.Get("Content-Disposition")); err == nil {
+		name = params["filename"]
+	}
+	if name == "" {
+		name, _ = url.PathUnescape(pathBase(res.Request.URL.Path))
+	}
+	name = strings.TrimSpace(strings.NewReplacer("/", "_", "\\", "_").Replace(name))
+	if name == "" || name == "." || isHiddenFile(name) {
+		name = "download"
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if mediaType == "text/html" && !IsTextFormat(name) {
+		// Usually a landing or login page rather than the book
+		return "", fmt.Errorf("%w: it's a web page", ErrNotABook)
+	}
+	if IsSupportedFormat(name) {
+		return name, nil
+	}
+
+	ext := bookContentTypes[mediaType]
+	if ext == "" {
+		ext = sniffBookExtension(filePath)
+	}
+	if ext == "" {
+		return "", ErrNotABook
+	}
+	return name + ext, nil
+}
+
+func pathBase(p string) string {
+	if p == "" || strings.HasSuffix(p, "/") {
+		return ""
+	}
+	return path.Base(p)
+}
+
+// sniffBookExtension recognises EPUBs, comic archives and FB2 books by
+// their contents.
+func sniffBookExtension(filePath string) string {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return ""
+	}
+	defer func() {
+		_ = file.Close() // Error ignored, only read
+	}()
+
+	header := make([]byte, 512)
+	n, _ := io.ReadFull(file, header)
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, []byte("Rar!")):
+		return ".cbr"
+	case bytes.Contains(header, []byte("<FictionBook")):
+		return ".fb2"
+	case !bytes.HasPrefix(header, []byte("PK")):
+		return ""
+	}
+
+	reader, err := zip.OpenReader(filePath)
+	if err != nil {
+		return ""
+	}
+	defer func() {
+		_ = reader.Close() // Error ignored, only read
+	}()
+	for _, f := range reader.File {
+		if f.Name == "mimetype" {
+			return ".epub"
+		}
+	}
+	for _, f := range reader.File {
+		if comicImageFormat(f.Name) != "" {
+			return ".cbz"
+		}
+	}
+	return ""
+}
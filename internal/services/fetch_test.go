@@ -0,0 +1,165 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"bookify/internal/testutil"
+)
+
+func newBookServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	epub, err := os.ReadFile(testutil.CreateValidEPUB(t, "book.epub", nil))
+	if err != nil {
+		t.Fatalf("Failed to read EPUB: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/books/moby-dick.epub", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/epub+zip")
+		_, _ = w.Write(epub)
+	})
+	mux.HandleFunc("/download", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename="Pride and Prejudice.epub"`)
+		_, _ = w.Write(epub)
+	})
+	mux.HandleFunc("/ebooks/1342", func(w http.ResponseWriter, r *http.Request) {
+		// No extension and a generic type; the contents say it's an EPUB
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = w.Write(epub)
+	})
+	mux.HandleFunc("/landing", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte("<html><body>Download here</body></html>"))
+	})
+	mux.HandleFunc("/big.epub", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/epub+zip")
+		// Streamed without a Content-Length
+		for i := 0; i < 64; i++ {
+			_, _ = w.Write(make([]byte, 1024))
+			w.(http.Flusher).Flush()
+		}
+	})
+	mux.HandleFunc("/slow.epub", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+		_, _ = w.Write(epub)
+	})
+	mux.HandleFunc("/missing.epub", http.NotFound)
+	mux.HandleFunc("/redirect/", func(w http.ResponseWriter, r *http.Request) {
+		hops := strings.TrimPrefix(r.URL.Path, "/redirect/")
+		if hops == "" {
+			http.Redirect(w, r, "/books/moby-dick.epub", http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, "/redirect/"+hops[1:], http.StatusFound)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFetchURL(t *testing.T) {
+	server := newBookServer(t)
+
+	tests := []struct {
+		name     string
+		path     string
+		opts     FetchOptions
+		filename string
+		wantErr  error
+	}{
+		{name: "direct link", path: "/books/moby-dick.epub", filename: "moby-dick.epub"},
+		{name: "content disposition", path: "/download", filename: "Pride and Prejudice.epub"},
+		{name: "sniffed", path: "/ebooks/1342", filename: "1342.epub"},
+		{name: "redirects", path: "/redirect/xxx", filename: "moby-dick.epub"},
+		{name: "too many redirects", path: "/redirect/xxxxxxxx", wantErr: ErrTooManyRedirects},
+		{name: "web page", path: "/landing", wantErr: ErrNotABook},
+		{name: "too large", path: "/big.epub", opts: FetchOptions{MaxSize: 16 << 10}, wantErr: ErrTooLarge},
+		{name: "timeout", path: "/slow.epub", opts: FetchOptions{Timeout: 100 * time.Millisecond}},
+		{name: "not found", path: "/missing.epub"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			// The test server is on loopback
+			tt.opts.AllowPrivate = true
+			fetched, err := FetchURL(server.URL+tt.path, dir, tt.opts)
+
+			if tt.filename == "" {
+				if err == nil {
+					t.Fatalf("FetchURL() = %+v, want an error", fetched)
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Errorf("FetchURL() error = %v, want %v", err, tt.wantErr)
+				}
+				// Nothing is left behind
+				if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+					t.Errorf("left %d file(s) in the temp directory", len(entries))
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("FetchURL() failed: %v", err)
+			}
+			if fetched.Filename != tt.filename {
+				t.Errorf("Filename = %q, want %q", fetched.Filename, tt.filename)
+			}
+			if info, err := os.Stat(fetched.Path); err != nil || info.Size() != fetched.Size || fetched.Size == 0 {
+				t.Errorf("downloaded file = %v, %v, size %d", info, err, fetched.Size)
+			}
+		})
+	}
+}
+
+func TestFetchURL_BadScheme(t *testing.T) {
+	for _, link := range []string{"file:///etc/passwd", "ftp://example.com/book.epub"} {
+		if _, err := FetchURL(link, t.TempDir(), FetchOptions{}); !errors.Is(err, ErrBadURLScheme) {
+			t.Errorf("FetchURL(%q) error = %v, want %v", link, err, ErrBadURLScheme)
+		}
+	}
+	if _, err := FetchURL("not a url", t.TempDir(), FetchOptions{}); err == nil {
+		t.Error("FetchURL() should reject a URL without a host")
+	}
+}
+
+func TestFetchURL_PrivateAddress(t *testing.T) {
+	server := newBookServer(t)
+	localhost := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	for _, link := range []string{server.URL + "/books/moby-dick.epub", localhost + "/books/moby-dick.epub"} {
+		dir := t.TempDir()
+		if _, err := FetchURL(link, dir, FetchOptions{}); !errors.Is(err, ErrPrivateAddress) {
+			t.Errorf("FetchURL(%q) error = %v, want %v", link, err, ErrPrivateAddress)
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("left %d file(s) in the temp directory", len(entries))
+		}
+	}
+
+	// Every connection is checked, so redirects can't get around it either
+	for address, private := range map[string]bool{
+		"93.184.216.34:443":        false,
+		"[2606:2800:220:1::]:443":  false,
+		"127.0.0.1:80":             true,
+		"[::1]:80":                 true,
+		"[::ffff:192.168.1.10]:80": true,
+		"10.1.2.3:8080":            true,
+		"172.16.0.1:80":            true,
+		"169.254.169.254:80":       true,
+		"[fe80::1]:80":             true,
+		"[fd00::1]:80":             true,
+		"100.64.0.1:80":            true,
+		"0.0.0.0:80":               true,
+	} {
+		if err := refusePrivateAddress("tcp", address, nil); (err != nil) != private {
+			t.Errorf("refusePrivateAddress(%s) = %v, want private %v", address, err, private)
+		}
+	}
+}
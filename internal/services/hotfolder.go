@@ -0,0 +1,292 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"bookify/internal/db"
+)
+
+const (
+	hotFolderInterval = 15 * time.Second
+	// A file is picked up once it has stopped changing for this long, so
+	// books still being copied or downloaded are left alone
+	hotFolderSettleTime = 10 * time.Second
+
+	// Subfolders deeper than this are left alone
+	hotFolderMaxDepth = 3
+
+	HotFolderDone   = "done"
+	HotFolderFailed = "failed"
+)
+
+type hotFile struct {
+	size    int64
+	modTime time.Time
+}
+
+// HotFolderService queues the books that appear in each account's hot
+// folder, then moves them to done/ or failed/ once their job has finished.
+type HotFolderService struct {
+	db      *db.Service
+	tempDir string
+	seen    map[string]hotFile
+	stopCh  chan bool
+}
+
+func NewHotFolderService(dbService *db.Service, tempDir string) *HotFolderService {
+	return &HotFolderService{
+		db:      dbService,
+		tempDir: tempDir,
+		seen:    make(map[string]hotFile),
+		stopCh:  make(chan bool),
+	}
+}
+
+func (w *HotFolderService) StartWatcher() {
+	log.Println("Starting hot folder watcher...")
+	ticker := time.NewTicker(hotFolderInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.Scan()
+		case <-w.stopCh:
+			log.Println("Hot folder watcher stopped")
+			return
+		}
+	}
+}
+
+func (w *HotFolderService) Stop() {
+	w.stopCh <- true
+}
+
+// Scan checks every hot folder once.
+func (w *HotFolderService) Scan() {
+	accounts, err := w.db.ListHotFolderAccounts()
+	if err != nil {
+		log.Printf("Failed to list hot folders: %v", err)
+		return
+	}
+
+	visited := make(map[string]bool)
+	for i := range accounts {
+		w.scanAccount(&accounts[i], visited)
+	}
+
+	// Forget files that were moved or deleted
+	for path := range w.seen {
+		if !visited[path] {
+			delete(w.seen, path)
+		}
+	}
+}
+
+func (w *HotFolderService) scanAccount(account *db.Account, visited map[string]bool) {
+	jobs, err := w.db.ListHotFolderJobs(account.ID)
+	if err != nil {
+		log.Printf("Warning: Failed to list hot folder jobs of %s: %v", account.Name, err)
+		return
+	}
+	pending := make(map[string]bool)
+	for _, job := range jobs {
+		switch job.Status {
+		case "completed", "skipped":
+			w.finish(account, &job, HotFolderDone)
+		case "failed", "cancelled":
+			w.finish(account, &job, HotFolderFailed)
+		default:
+			pending[job.HotFolderPath] = true
+		}
+	}
+
+	root := account.HotFolder
+	err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			log.Printf("Warning: Skipping %s in hot folder: %v", path, err)
+			return nil
+		}
+		if entry.IsDir() {
+			if path == root {
+				return nil
+			}
+			if isHiddenFile(entry.Name()) || path == filepath.Join(root, HotFolderDone) || path == filepath.Join(root, HotFolderFailed) {
+				return filepath.SkipDir
+			}
+			if rel, err := filepath.Rel(root, path); err != nil || strings.Count(rel, string(filepath.Separator)) >= hotFolderMaxDepth {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// Links could lead anywhere on the server
+		if !entry.Type().IsRegular() || !isWatchedBook(entry.Name()) || pending[path] {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		visited[path] = true
+		if w.settled(path, info) {
+			w.ingest(account, path)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Warning: Failed to read hot folder of %s: %v", account.Name, err)
+	}
+}
+
+// settled reports whether the file is unchanged since the last scan and
+// hasn't been modified for a while.
+func (w *HotFolderService) settled(path string, info fs.FileInfo) bool {
+	current := hotFile{size: info.Size(), modTime: info.ModTime()}
+	previous, ok := w.seen[path]
+	w.seen[path] = current
+	return ok && previous.size == current.size && previous.modTime.Equal(current.modTime) &&
+		time.Since(current.modTime) >= hotFolderSettleTime
+}
+
+func (w *HotFolderService) ingest(account *db.Account, path string) {
+	filename := filepath.Base(path)
+	sourceHash, err := HashFile(path)
+	if err != nil {
+		log.Printf("Warning: Failed to hash %s: %v", path, err)
+		return
+	}
+
+	if account.DuplicatePolicy != db.DuplicatePolicyForce {
+		duplicate, err := w.db.FindDuplicateJob(account.ID, sourceHash)
+		if err != nil {
+			log.Printf("Warning: Failed to check for duplicates: %v", err)
+		}
+		if duplicate != nil {
+			if _, err := w.db.CreateSkippedJob(account.ID, filename, sourceHash, duplicate); err != nil {
+				log.Printf("Warning: Failed to record skipped file %s: %v", path, err)
+				return
+			}
+			w.move(account, path, HotFolderDone)
+			return
+		}
+	}
+
+	// The queue reads uploads from the temp directory by name; wait for
+	// another file of the same name to be converted first
+	tempPath := filepath.Join(w.tempDir, filename)
+	if _, err := os.Stat(tempPath); err == nil {
+		return
+	}
+	if err := os.MkdirAll(w.tempDir, 0755); err != nil {
+		log.Printf("Warning: Failed to create temp directory: %v", err)
+		return
+	}
+	if err := copyFile(path, tempPath); err != nil {
+		log.Printf("Warning: Failed to copy %s from the hot folder: %v", path, err)
+		return
+	}
+
+	job := &db.Job{
+		AccountID:        account.ID,
+		OriginalFilename: filename,
+		SourceHash:       sourceHash,
+		HotFolderPath:    path,
+	}
+	if err := w.db.QueueJob(job); err != nil {
+		log.Printf("Warning: Failed to queue %s: %v", path, err)
+		if err := os.Remove(tempPath); err != nil {
+			log.Printf("Warning: Failed to remove temp file: %v", err)
+		}
+		return
+	}
+	delete(w.seen, path)
+	log.Printf("Queued %s from the hot folder of %s", filename, account.Name)
+}
+
+// finish moves the file of a finished job out of the hot folder.
+func (w *HotFolderService) finish(account *db.Account, job *db.Job, subfolder string) {
+	if _, err := os.Stat(job.HotFolderPath); err == nil {
+		w.move(account, job.HotFolderPath, subfolder)
+	}
+	if err := w.db.ClearHotFolderPath(job.ID); err != nil {
+		log.Printf("Warning: Failed to update job %s: %v", job.ID, err)
+	}
+}
+
+// move puts a file into done/ or failed/ of the hot folder, numbering it if
+// a file of the same name is already there.
+func (w *HotFolderService) move(account *db.Account, path, subfolder string) {
+	dir := filepath.Join(account.HotFolder, subfolder)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Printf("Warning: Failed to create %s: %v", dir, err)
+		return
+	}
+
+	name := filepath.Base(path)
+	ext := filepath.Ext(name)
+	dest := filepath.Join(dir, name)
+	for i := 2; ; i++ {
+		if _, err := os.Stat(dest); os.IsNotExist(err) {
+			break
+		}
+		dest = filepath.Join(dir, strings.TrimSuffix(name, ext)+" ("+strconv.Itoa(i)+")"+ext)
+	}
+	if err := os.Rename(path, dest); err != nil {
+		log.Printf("Warning: Failed to move %s to %s: %v", path, subfolder, err)
+	}
+}
+
+// isWatchedBook reports whether a file found in a hot folder or Drive inbox
+// should be queued. Text documents are left alone, as they need a title and
+// author from the upload form.
+func isWatchedBook(name string) bool {
+	if isHiddenFile(name) {
+		return false
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".part", ".crdownload", ".download", ".tmp":
+		return false
+	}
+	return IsSupportedFormat(name) && !IsTextFormat(name)
+}
+
+func isHiddenFile(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasPrefix(name, "~")
+}
+
+func copyFile(srcPath, destPath string) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer func() {
+		if closeErr := src.Close(); closeErr != nil {
+			log.Printf("Warning: Failed to close file: %v", closeErr)
+		}
+	}()
+
+	dst, err := os.Create(destPath)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"bookify/internal/db"
+	"bookify/internal/testutil"
+)
+
+func setupHotFolder(t *testing.T) (*HotFolderService, *db.Service, *db.Account) {
+	t.Helper()
+
+	testDB := testutil.SetupTestDB(t)
+	if err := testDB.AutoMigrate(&db.Account{}, &db.Job{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	dbService := db.NewService(testDB)
+
+	account, _ := dbService.CreateAccount("test-account", "folder-123")
+	account.HotFolder = t.TempDir()
+	if err := dbService.UpdateAccount(account); err != nil {
+		t.Fatalf("Failed to save account: %v", err)
+	}
+	return NewHotFolderService(dbService, t.TempDir()), dbService, account
+}
+
+// writeHotFile writes a file that last changed long enough ago to be picked up.
+func writeHotFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	past := time.Now().Add(-time.Minute)
+	if err := os.Chtimes(path, past, past); err != nil {
+		t.Fatalf("Failed to set file time: %v", err)
+	}
+}
+
+func hotFolderJobs(t *testing.T, dbService *db.Service) []db.Job {
+	t.Helper()
+
+	jobs, err := dbService.ListRecentJobs(50)
+	if err != nil {
+		t.Fatalf("ListRecentJobs() failed: %v", err)
+	}
+	return jobs
+}
+
+func TestHotFolderService_Scan(t *testing.T) {
+	watcher, dbService, account := setupHotFolder(t)
+
+	book := filepath.Join(account.HotFolder, "Author", "Title", "book.epub")
+	writeHotFile(t, book, "epub content")
+	writeHotFile(t, filepath.Join(account.HotFolder, "notes.txt"), "not a book")
+	writeHotFile(t, filepath.Join(account.HotFolder, "next.epub.part"), "downloading")
+	writeHotFile(t, filepath.Join(account.HotFolder, HotFolderDone, "old.epub"), "already done")
+
+	// The first scan only notes the file's size
+	watcher.Scan()
+	if jobs := hotFolderJobs(t, dbService); len(jobs) != 0 {
+		t.Fatalf("first scan queued %d jobs, want none", len(jobs))
+	}
+
+	watcher.Scan()
+	jobs := hotFolderJobs(t, dbService)
+	if len(jobs) != 1 {
+		t.Fatalf("second scan queued %d jobs, want 1", len(jobs))
+	}
+	job := jobs[0]
+	if job.OriginalFilename != "book.epub" || job.HotFolderPath != book || job.SourceHash == "" {
+		t.Errorf("queued job = %+v", job)
+	}
+	if data, err := os.ReadFile(filepath.Join(watcher.tempDir, "book.epub")); err != nil || string(data) != "epub content" {
+		t.Errorf("temp copy = %q, %v", data, err)
+	}
+
+	// Waiting jobs are not queued again
+	watcher.Scan()
+	if jobs := hotFolderJobs(t, dbService); len(jobs) != 1 {
+		t.Errorf("scan while the job waits queued %d jobs, want 1", len(jobs))
+	}
+
+	if err := dbService.MarkJobCompleted(job.ID, "book.kepub.epub", "https://drive.google.com/file/d/x/view"); err != nil {
+		t.Fatalf("MarkJobCompleted() failed: %v", err)
+	}
+	watcher.Scan()
+	if _, err := os.Stat(filepath.Join(account.HotFolder, HotFolderDone, "book.epub")); err != nil {
+		t.Errorf("converted file not moved to done/: %v", err)
+	}
+	if _, err := os.Stat(book); !os.IsNotExist(err) {
+		t.Errorf("converted file still in the hot folder")
+	}
+	finished, _ := dbService.GetJob(job.ID)
+	if finished.HotFolderPath != "" {
+		t.Errorf("HotFolderPath = %q, want it cleared", finished.HotFolderPath)
+	}
+}
+
+func TestHotFolderService_Failed(t *testing.T) {
+	watcher, dbService, account := setupHotFolder(t)
+
+	// Another file of the same name is already in failed/
+	writeHotFile(t, filepath.Join(account.HotFolder, HotFolderFailed, "broken.epub"), "earlier")
+	writeHotFile(t, filepath.Join(account.HotFolder, "broken.epub"), "not really an epub")
+	watcher.Scan()
+	watcher.Scan()
+
+	jobs := hotFolderJobs(t, dbService)
+	if len(jobs) != 1 {
+		t.Fatalf("queued %d jobs, want 1", len(jobs))
+	}
+	if err := dbService.MarkJobFailed(jobs[0].ID, "Validation failed"); err != nil {
+		t.Fatalf("MarkJobFailed() failed: %v", err)
+	}
+	watcher.Scan()
+
+	if _, err := os.Stat(filepath.Join(account.HotFolder, HotFolderFailed, "broken (2).epub")); err != nil {
+		t.Errorf("failed file not moved to failed/: %v", err)
+	}
+}
+
+func TestHotFolderService_StillWriting(t *testing.T) {
+	watcher, dbService, account := setupHotFolder(t)
+
+	path := filepath.Join(account.HotFolder, "book.epub")
+	writeHotFile(t, path, "part")
+	watcher.Scan()
+	writeHotFile(t, path, "partial content")
+	watcher.Scan()
+	if jobs := hotFolderJobs(t, dbService); len(jobs) != 0 {
+		t.Fatalf("queued a file that changed between scans")
+	}
+
+	// Recently modified files wait for the settle time
+	if err := os.WriteFile(path, []byte("full content"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	watcher.Scan()
+	watcher.Scan()
+	if jobs := hotFolderJobs(t, dbService); len(jobs) != 0 {
+		t.Fatalf("queued a file modified moments ago")
+	}
+}
+
+func TestHotFolderService_Duplicate(t *testing.T) {
+	watcher, dbService, account := setupHotFolder(t)
+
+	path := filepath.Join(account.HotFolder, "again.epub")
+	writeHotFile(t, path, "same content")
+	hash, _ := HashFile(path)
+	previous := &db.Job{AccountID: account.ID, OriginalFilename: "first.epub", SourceHash: hash}
+	if err := dbService.QueueJob(previous); err != nil {
+		t.Fatalf("Failed to create job: %v", err)
+	}
+	if err := dbService.MarkJobCompleted(previous.ID, "first.kepub.epub", "https://drive.google.com/file/d/x/view"); err != nil {
+		t.Fatalf("MarkJobCompleted() failed: %v", err)
+	}
+
+	watcher.Scan()
+	watcher.Scan()
+
+	jobs := hotFolderJobs(t, dbService)
+	if len(jobs) != 2 || jobs[0].Status != "skipped" && jobs[1].Status != "skipped" {
+		t.Errorf("jobs = %+v, want the duplicate skipped", jobs)
+	}
+	if _, err := os.Stat(filepath.Join(account.HotFolder, HotFolderDone, "again.epub")); err != nil {
+		t.Errorf("skipped file not moved to done/: %v", err)
+	}
+}
+
+func TestHotFolderService_OnlyItsOwnTree(t *testing.T) {
+	watcher, dbService, account := setupHotFolder(t)
+
+	elsewhere := t.TempDir()
+	writeHotFile(t, filepath.Join(elsewhere, "private.epub"), "someone else's")
+	if err := os.Symlink(elsewhere, filepath.Join(account.HotFolder, "linked-dir")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(elsewhere, "private.epub"), filepath.Join(account.HotFolder, "linked.epub")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	writeHotFile(t, filepath.Join(account.HotFolder, "a", "b", "c", "deep-enough.epub"), "deep")
+	writeHotFile(t, filepath.Join(account.HotFolder, "a", "b", "c", "d", "too-deep.epub"), "too deep")
+	writeHotFile(t, filepath.Join(account.HotFolder, HotFolderFailed, "retry.epub"), "failed before")
+
+	watcher.Scan()
+	watcher.Scan()
+	jobs := hotFolderJobs(t, dbService)
+	if len(jobs) != 1 || jobs[0].OriginalFilename != "deep-enough.epub" {
+		t.Fatalf("queued %+v, want only deep-enough.epub", jobs)
+	}
+	if _, err := os.Stat(filepath.Join(elsewhere, "private.epub")); err != nil {
+		t.Errorf("a file outside the hot folder was touched: %v", err)
+	}
+}
+
+func TestIsHotFolderBook(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"book.epub", true},
+		{"Book.EPUB", true},
+		{"comic.cbz", true},
+		{"novel.fb2", true},
+		{"notes.txt", false},
+		{"page.html", false},
+		{"cover.jpg", false},
+		{"metadata.opf", false},
+		{".book.epub", false},
+		{"book.epub.part", false},
+		{"book.epub.crdownload", false},
+	}
+
+	for _, tt := range tests {
+		if got := isWatchedBook(tt.name); got != tt.want {
+			t.Errorf("isWatchedBook(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+const defaultImageQuality = 80
+
+// maxImagePixels is the largest image Bookify will decode, well past any
+// scanned page, so a small file can't claim a canvas that fills memory.
+const maxImagePixels = 50_000_000
+
+var ErrImageTooLarge = errors.New("image is larger than the pixel limit")
+
+type DeviceProfile struct {
+	ID     string
+	Name   string
+	Width  int
+	Height int
+}
+
+var DeviceProfiles = []DeviceProfile{
+	{ID: "clara-hd", Name: "Kobo Clara HD", Width: 1072, Height: 1448},
+	{ID: "clara-2e", Name: "Kobo Clara 2E / BW / Colour", Width: 1072, Height: 1448},
+	{ID: "libra-2", Name: "Kobo Libra 2 / Colour", Width: 1264, Height: 1680},
+	{ID: "sage", Name: "Kobo Sage", Width: 1440, Height: 1920},
+	{ID: "forma", Name: "Kobo Forma", Width: 1440, Height: 1920},
+	{ID: "elipsa", Name: "Kobo Elipsa / Elipsa 2E", Width: 1404, Height: 1872},
+	{ID: "nia", Name: "Kobo Nia", Width: 758, Height: 1024},
+}
+
+func LookupDeviceProfile(id string) (DeviceProfile, bool) {
+	for _, profile := range DeviceProfiles {
+		if profile.ID == id {
+			return profile, true
+		}
+	}
+	return DeviceProfile{}, false
+}
+
+type ImageOptions struct {
+	Device    string
+	Greyscale bool
+	Quality   int
+}
+
+type ImageResult struct {
+	ImagesOptimized int
+	OriginalBytes   int64
+	OptimizedBytes  int64
+}
+
+func (r ImageResult) BytesSaved() int64 {
+	return r.OriginalBytes - r.OptimizedBytes
+}
+
+type ImageOptimizerService struct{}
+
+func NewImageOptimizerService() *ImageOptimizerService {
+	return &ImageOptimizerService{}
+}
+
+// Optimize rewrites the EPUB at inputPath to outputPath with every JPEG and
+// PNG image scaled down to fit the target device's screen and recompressed.
+// Landscape images are fitted to the rotated screen so double-page spreads
+// keep their detail. Without a device, images are only converted to
+// greyscale. Images are only replaced when the result is smaller, or when
+// greyscale was asked for.
+func (o *ImageOptimizerService) Optimize(inputPath, outputPath string, opts ImageOptions) (ImageResult, error) {
+	var result ImageResult
+
+	var profile DeviceProfile
+	if opts.Device != "" {
+		var ok bool
+		if profile, ok = LookupDeviceProfile(opts.Device); !ok {
+			return result, fmt.Errorf("unknown device profile %q", opts.Device)
+		}
+	}
+	quality := opts.Quality
+	if quality <= 0 || quality > 100 {
+		quality = defaultImageQuality
+	}
+
+	zipReader, err := zip.OpenReader(inputPath)
+	if err != nil {
+		return result, fmt.Errorf("failed to open EPUB as ZIP: %w", err)
+	}
+	defer func() {
+		if closeErr := zipReader.Close(); closeErr != nil {
+			log.Printf("Warning: Failed to close ZIP reader: %v", closeErr)
+		}
+	}()
+
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return result, fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer func() {
+		if closeErr := out.Close(); closeErr != nil {
+			log.Printf("Warning: Failed to close output file: %v", closeErr)
+		}
+	}()
+
+	writer := zip.NewWriter(out)
+	for _, f := range zipReader.File {
+		format := imageFormat(f.Name)
+		if format != "" {
+			if reason := unsafeEntry(f, DefaultBundleLimits); reason != "" {
+				log.Printf("Skipping image %s: %s", f.Name, reason)
+				format = ""
+			}
+		}
+		if format == "" {
+			if err := writer.Copy(f); err != nil {
+				return result, fmt.Errorf("failed to copy %s: %w", f.Name, err)
+			}
+			continue
+		}
+
+		data, err := readZipFile(f)
+		if err != nil {
+			return result, fmt.Errorf("failed to read %s: %w", f.Name, err)
+		}
+
+		optimized, err := optimizeImage(data, format, profile, opts.Greyscale, quality)
+		if err != nil || (len(optimized) >= len(data) && !opts.Greyscale) {
+			if err != nil {
+				log.Printf("Skipping image %s: %v", f.Name, err)
+			}
+			if err := writer.Copy(f); err != nil {
+				return result, fmt.Errorf("failed to copy %s: %w", f.Name, err)
+			}
+			continue
+		}
+
+		if err := writeZipEntry(writer, f.Name, optimized); err != nil {
+			return result, fmt.Errorf("failed to write %s: %w", f.Name, err)
+		}
+		result.ImagesOptimized++
+		result.OriginalBytes += int64(len(data))
+		result.OptimizedBytes += int64(len(optimized))
+	}
+
+	if err := writer.Close(); err != nil {
+		return result, fmt.Errorf("failed to finalize EPUB: %w", err)
+	}
+
+	return result, nil
+}
+
+func imageFormat(name string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".jpg", ".jpeg":
+		return "jpeg"
+	case ".png":
+		return "png"
+	}
+	return ""
+}
+
+func optimizeImage(data []byte, format string, profile DeviceProfile, greyscale bool, quality int) ([]byte, error) {
+	img, _, err := decodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+
+	maxWidth, maxHeight := profile.Width, profile.Height
+	bounds := img.Bounds()
+	if bounds.Dx() > bounds.Dy() {
+		maxWidth, maxHeight = maxHeight, maxWidth
+	}
+
+	if greyscale {
+		img = toGreyscale(img)
+	}
+	if maxWidth > 0 {
+		img = scaleDown(img, maxWidth, maxHeight)
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	case "png":
+		encoder := png.Encoder{CompressionLevel: png.BestCompression}
+		err = encoder.Encode(&buf, img)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// scaleDown is like resizeToFit but keeps the source colour model and
+// transparency, so greyscale and PNG images stay compact.
+func scaleDown(img image.Image, maxWidth, maxHeight int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxWidth && height <= maxHeight {
+		return img
+	}
+
+	scale := float64(maxWidth) / float64(width)
+	if s := float64(maxHeight) / float64(height); s < scale {
+		scale = s
+	}
+	rect := image.Rect(0, 0, max(1, int(float64(width)*scale)), max(1, int(float64(height)*scale)))
+
+	var dst draw.Image
+	if _, ok := img.(*image.Gray); ok {
+		dst = image.NewGray(rect)
+	} else {
+		dst = image.NewNRGBA(rect)
+	}
+	draw.CatmullRom.Scale(dst, rect, img, bounds, draw.Src, nil)
+	return dst
+}
+
+// toGreyscale converts img to 8-bit grey, flattening transparency onto white
+// the way an e-ink screen would show it.
+func toGreyscale(img image.Image) image.Image {
+	if gray, ok := img.(*image.Gray); ok {
+		return gray
+	}
+	bounds := img.Bounds()
+	gray := image.NewGray(bounds)
+	draw.Draw(gray, bounds, image.White, image.Point{}, draw.Src)
+	draw.Draw(gray, bounds, img, bounds.Min, draw.Over)
+	return gray
+}
+
+// checkImageSize rejects images whose header claims more pixels than
+// maxImagePixels, before they're decoded.
+func checkImageSize(cfg image.Config) error {
+	if cfg.Width <= 0 || cfg.Height <= 0 || int64(cfg.Width)*int64(cfg.Height) > maxImagePixels {
+		return fmt.Errorf("%w: %dx%d", ErrImageTooLarge, cfg.Width, cfg.Height)
+	}
+	return nil
+}
+
+// decodeImage decodes data once its header passes checkImageSize.
+func decodeImage(data []byte) (image.Image, string, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	if err := checkImageSize(cfg); err != nil {
+		return nil, "", err
+	}
+	return image.Decode(bytes.NewReader(data))
+}
+
+// readZipFile reads an entry of no more than the bundle entry size limit.
+func readZipFile(f *zip.File) ([]byte, error) {
+	return readZipFileLimit(f, DefaultBundleLimits.MaxEntrySize)
+}
+
+// readZipFileLimit is readZipFile for entries that may be no larger than
+// maxSize bytes, whatever their header says.
+func readZipFileLimit(f *zip.File, maxSize int64) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rc.Close() // Error ignored for read-only entry
+	}()
+	return readLimited(rc, maxSize)
+}
+
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,193 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"image"
+	"image/jpeg"
+	"path/filepath"
+	"testing"
+
+	"bookify/internal/testutil"
+)
+
+func testJPEG(t *testing.T, width, height int) string {
+	t.Helper()
+
+	img, _, err := image.Decode(bytes.NewReader([]byte(testPNG(t, width, height))))
+	if err != nil {
+		t.Fatalf("Failed to decode test PNG: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatalf("Failed to encode test JPEG: %v", err)
+	}
+	return buf.String()
+}
+
+func readImageConfig(t *testing.T, epubPath, name string) (image.Config, string) {
+	t.Helper()
+
+	zipReader, err := zip.OpenReader(epubPath)
+	if err != nil {
+		t.Fatalf("Failed to open optimized EPUB: %v", err)
+	}
+	defer func() {
+		_ = zipReader.Close() // Error ignored in test
+	}()
+
+	data, err := readZipEntry(&zipReader.Reader, name)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", name, err)
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to decode %s: %v", name, err)
+	}
+	return cfg, format
+}
+
+func TestLookupDeviceProfile(t *testing.T) {
+	profile, ok := LookupDeviceProfile("libra-2")
+	if !ok {
+		t.Fatalf("LookupDeviceProfile(libra-2) not found")
+	}
+	if profile.Width != 1264 || profile.Height != 1680 {
+		t.Errorf("LookupDeviceProfile(libra-2) = %dx%d, want 1264x1680", profile.Width, profile.Height)
+	}
+
+	if _, ok := LookupDeviceProfile("kindle"); ok {
+		t.Errorf("LookupDeviceProfile(kindle) should not exist")
+	}
+}
+
+func TestImageOptimizerService_Optimize(t *testing.T) {
+	inputPath := testutil.CreateValidEPUB(t, "comic.epub", map[string]string{
+		"OEBPS/images/page.jpg":   testJPEG(t, 1600, 2000),
+		"OEBPS/images/spread.png": testPNG(t, 2000, 1200),
+		"OEBPS/images/small.jpg":  testJPEG(t, 100, 100),
+	})
+	outputPath := filepath.Join(t.TempDir(), "optimized.epub")
+
+	optimizer := NewImageOptimizerService()
+	result, err := optimizer.Optimize(inputPath, outputPath, ImageOptions{Device: "clara-hd", Greyscale: true})
+	if err != nil {
+		t.Fatalf("Optimize() error = %v", err)
+	}
+
+	if result.ImagesOptimized < 2 {
+		t.Errorf("Optimize() optimized %d images, want at least 2", result.ImagesOptimized)
+	}
+	if result.BytesSaved() <= 0 {
+		t.Errorf("Optimize() saved %d bytes, want > 0", result.BytesSaved())
+	}
+
+	page, format := readImageConfig(t, outputPath, "OEBPS/images/page.jpg")
+	if format != "jpeg" {
+		t.Errorf("page.jpg format = %s, want jpeg", format)
+	}
+	if page.Width > 1072 || page.Height > 1448 {
+		t.Errorf("page.jpg = %dx%d, should fit within 1072x1448", page.Width, page.Height)
+	}
+
+	spread, format := readImageConfig(t, outputPath, "OEBPS/images/spread.png")
+	if format != "png" {
+		t.Errorf("spread.png format = %s, want png", format)
+	}
+	if spread.Width != 1448 {
+		t.Errorf("spread.png width = %d, want landscape fit of 1448", spread.Width)
+	}
+
+	// The rest of the book must survive untouched
+	validator := NewValidatorService()
+	report, err := validator.Validate(outputPath, filepath.Join(t.TempDir(), "repaired.epub"))
+	if err != nil {
+		t.Fatalf("Optimized EPUB failed validation: %v", err)
+	}
+	if report.Repaired() {
+		t.Errorf("Optimized EPUB needed repairs: %s", report)
+	}
+}
+
+func TestImageOptimizerService_Optimize_TooManyPixels(t *testing.T) {
+	bomb := hugePNG(t, 60000, 60000)
+	inputPath := testutil.CreateValidEPUB(t, "bomb.epub", map[string]string{
+		"OEBPS/images/bomb.png": bomb,
+	})
+	outputPath := filepath.Join(t.TempDir(), "optimized.epub")
+
+	optimizer := NewImageOptimizerService()
+	result, err := optimizer.Optimize(inputPath, outputPath, ImageOptions{Device: "clara-hd"})
+	if err != nil {
+		t.Fatalf("Optimize() error = %v", err)
+	}
+	if result.ImagesOptimized != 0 {
+		t.Errorf("Optimize() optimized %d images, want the oversized one left alone", result.ImagesOptimized)
+	}
+	if cfg, _ := readImageConfig(t, outputPath, "OEBPS/images/bomb.png"); cfg.Width != 60000 {
+		t.Errorf("bomb.png width = %d, want it copied as is", cfg.Width)
+	}
+}
+
+func TestImageOptimizerService_Optimize_GreyscaleOnly(t *testing.T) {
+	inputPath := testutil.CreateValidEPUB(t, "book.epub", map[string]string{
+		"OEBPS/images/page.png": testPNG(t, 1600, 2000),
+	})
+	outputPath := filepath.Join(t.TempDir(), "optimized.epub")
+
+	optimizer := NewImageOptimizerService()
+	result, err := optimizer.Optimize(inputPath, outputPath, ImageOptions{Greyscale: true})
+	if err != nil {
+		t.Fatalf("Optimize() error = %v", err)
+	}
+	if result.ImagesOptimized != 1 {
+		t.Errorf("Optimize() optimized %d images, want 1", result.ImagesOptimized)
+	}
+
+	zipReader, err := zip.OpenReader(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to open optimized EPUB: %v", err)
+	}
+	defer func() {
+		_ = zipReader.Close() // Error ignored in test
+	}()
+	data, _ := readZipEntry(&zipReader.Reader, "OEBPS/images/page.png")
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to decode page.png: %v", err)
+	}
+	if _, ok := img.(*image.Gray); !ok {
+		t.Errorf("page.png is %T, want greyscale", img)
+	}
+	if img.Bounds().Dx() != 1600 {
+		t.Errorf("page.png width = %d, want it left at 1600 without a device", img.Bounds().Dx())
+	}
+}
+
+func TestImageOptimizerService_Optimize_UnknownDevice(t *testing.T) {
+	inputPath := testutil.CreateValidEPUB(t, "book.epub", nil)
+
+	optimizer := NewImageOptimizerService()
+	_, err := optimizer.Optimize(inputPath, filepath.Join(t.TempDir(), "out.epub"), ImageOptions{Device: "kindle"})
+	if err == nil {
+		t.Errorf("Optimize() expected error for unknown device")
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		bytes    int64
+		expected string
+	}{
+		{bytes: 512, expected: "512 B"},
+		{bytes: 2048, expected: "2.0 KB"},
+		{bytes: 5 * 1024 * 1024, expected: "5.0 MB"},
+	}
+
+	for _, tt := range tests {
+		if got := FormatBytes(tt.bytes); got != tt.expected {
+			t.Errorf("FormatBytes(%d) = %q, want %q", tt.bytes, got, tt.expected)
+		}
+	}
+}
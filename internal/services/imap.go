@@ -0,0 +1,193 @@
+package services
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// imapClient speaks just enough IMAP4rev1 to fetch unread messages and flag
+// them as read.
+type imapClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int
+	// Literals larger than this are skipped rather than read
+	maxLiteral int64
+}
+
+// imapResponse is an untagged response line with any literals it carried.
+type imapResponse struct {
+	Line     string
+	Literals [][]byte
+	// A literal was over the size limit, and left out
+	Oversized bool
+}
+
+var imapLiteral = regexp.MustCompile(`\{(\d+)\}$`)
+
+func dialIMAP(addr string, useTLS bool, maxLiteral int64) (*imapClient, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	var conn net.Conn
+	var err error
+	if useTLS {
+		host, _, _ := net.SplitHostPort(addr)
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to IMAP server: %w", err)
+	}
+
+	c := &imapClient{conn: conn, r: bufio.NewReader(conn), maxLiteral: maxLiteral}
+	greeting, err := c.readLine()
+	if err != nil {
+		_ = conn.Close() // Error ignored, the greeting already failed
+		return nil, fmt.Errorf("failed to read IMAP greeting: %w", err)
+	}
+	if !strings.HasPrefix(greeting, "* OK") && !strings.HasPrefix(greeting, "* PREAUTH") {
+		_ = conn.Close() // Error ignored, the server refused the connection
+		return nil, fmt.Errorf("IMAP server refused connection: %s", greeting)
+	}
+	return c, nil
+}
+
+func (c *imapClient) Close() error {
+	return c.conn.Close()
+}
+
+func (c *imapClient) readLine() (string, error) {
+	if err := c.conn.SetReadDeadline(time.Now().Add(time.Minute)); err != nil {
+		return "", err
+	}
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// command sends a command and collects the untagged responses until the
+// tagged completion, which must be OK.
+func (c *imapClient) command(format string, args ...any) ([]imapResponse, error) {
+	c.tag++
+	tag := "A" + strconv.Itoa(c.tag)
+	if _, err := fmt.Fprintf(c.conn, "%s %s\r\n", tag, fmt.Sprintf(format, args...)); err != nil {
+		return nil, err
+	}
+
+	var responses []imapResponse
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.HasPrefix(line, tag+" ") {
+			status := strings.TrimPrefix(line, tag+" ")
+			if !strings.HasPrefix(status, "OK") {
+				return nil, fmt.Errorf("IMAP command failed: %s", status)
+			}
+			return responses, nil
+		}
+
+		response := imapResponse{Line: line}
+		// A line ending in {n} continues after n bytes of literal data
+		for {
+			match := imapLiteral.FindStringSubmatch(line)
+			if match == nil {
+				break
+			}
+			size, err := strconv.ParseInt(match[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid IMAP literal: %s", line)
+			}
+			if size > c.maxLiteral {
+				// Still read past it, to stay in step with the server
+				if _, err := io.CopyN(io.Discard, c.r, size); err != nil {
+					return nil, err
+				}
+				response.Oversized = true
+			} else {
+				literal := make([]byte, size)
+				if _, err := io.ReadFull(c.r, literal); err != nil {
+					return nil, err
+				}
+				response.Literals = append(response.Literals, literal)
+			}
+			if line, err = c.readLine(); err != nil {
+				return nil, err
+			}
+			response.Line += line
+		}
+		responses = append(responses, response)
+	}
+}
+
+func imapQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func (c *imapClient) Login(username, password string) error {
+	_, err := c.command("LOGIN %s %s", imapQuote(username), imapQuote(password))
+	return err
+}
+
+func (c *imapClient) Select(mailbox string) error {
+	_, err := c.command("SELECT %s", imapQuote(mailbox))
+	return err
+}
+
+// SearchUnseen returns the UIDs of the unread messages.
+func (c *imapClient) SearchUnseen() ([]uint32, error) {
+	responses, err := c.command("UID SEARCH UNSEEN")
+	if err != nil {
+		return nil, err
+	}
+	var uids []uint32
+	for _, response := range responses {
+		if !strings.HasPrefix(response.Line, "* SEARCH") {
+			continue
+		}
+		for _, field := range strings.Fields(strings.TrimPrefix(response.Line, "* SEARCH")) {
+			if uid, err := strconv.ParseUint(field, 10, 32); err == nil {
+				uids = append(uids, uint32(uid))
+			}
+		}
+	}
+	return uids, nil
+}
+
+// Fetch returns the full message without marking it as read.
+func (c *imapClient) Fetch(uid uint32) ([]byte, error) {
+	responses, err := c.command("UID FETCH %d BODY.PEEK[]", uid)
+	if err != nil {
+		return nil, err
+	}
+	for _, response := range responses {
+		if strings.Contains(response.Line, "FETCH") && response.Oversized {
+			return nil, ErrEmailTooLarge
+		}
+		if strings.Contains(response.Line, "FETCH") && len(response.Literals) > 0 {
+			return response.Literals[0], nil
+		}
+	}
+	return nil, fmt.Errorf("message %d not found", uid)
+}
+
+func (c *imapClient) MarkSeen(uid uint32) error {
+	_, err := c.command(`UID STORE %d +FLAGS.SILENT (\Seen)`, uid)
+	return err
+}
+
+func (c *imapClient) Logout() error {
+	_, err := c.command("LOGOUT")
+	return err
+}
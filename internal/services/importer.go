@@ -0,0 +1,91 @@
+package services
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// SupportedExtensions lists the upload formats Bookify can convert to KEPUB.
+var SupportedExtensions = []string{
+	".epub",
+	".cbz", ".cbr", ".zip",
+	".txt", ".md", ".markdown", ".html", ".htm",
+	".fb2", ".fb2.zip",
+}
+
+type ImportOptions struct {
+	Title        string
+	Author       string
+	RightToLeft  bool
+	SplitSpreads bool
+}
+
+type ImporterService struct{}
+
+func NewImporterService() *ImporterService {
+	return &ImporterService{}
+}
+
+func formatExtension(filename string) string {
+	lower := strings.ToLower(filename)
+	if strings.HasSuffix(lower, ".fb2.zip") {
+		return ".fb2.zip"
+	}
+	return filepath.Ext(lower)
+}
+
+// IsTextFormat reports whether the format is a text document rather than an
+// archive.
+func IsTextFormat(filename string) bool {
+	switch formatExtension(filename) {
+	case ".txt", ".md", ".markdown", ".html", ".htm":
+		return true
+	}
+	return false
+}
+
+func IsSupportedFormat(filename string) bool {
+	ext := formatExtension(filename)
+	for _, supported := range SupportedExtensions {
+		if ext == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// NeedsImport reports whether a file has to be turned into an EPUB before it
+// can go through kepubify.
+func NeedsImport(filename string) bool {
+	return IsSupportedFormat(filename) && formatExtension(filename) != ".epub"
+}
+
+// EPUBFilename returns the name the file will have once imported as an EPUB.
+func EPUBFilename(filename string) string {
+	if !NeedsImport(filename) {
+		return filename
+	}
+	return filename[:len(filename)-len(formatExtension(filename))] + ".epub"
+}
+
+// Import converts the non-EPUB file at inputPath into an EPUB at outputPath.
+// The original filename decides which importer is used.
+func (i *ImporterService) Import(inputPath, filename, outputPath string, opts ImportOptions) error {
+	base := filepath.Base(filename)
+	fallbackTitle := base[:len(base)-len(formatExtension(base))]
+
+	switch formatExtension(filename) {
+	case ".cbz", ".zip":
+		return buildComicEPUB(inputPath, outputPath, false, opts, fallbackTitle)
+	case ".cbr":
+		return buildComicEPUB(inputPath, outputPath, true, opts, fallbackTitle)
+	case ".txt", ".md", ".markdown", ".html", ".htm":
+		return buildDocumentEPUB(inputPath, outputPath, formatExtension(filename), opts, fallbackTitle)
+	case ".fb2":
+		return buildFB2EPUB(inputPath, outputPath, false, opts, fallbackTitle)
+	case ".fb2.zip":
+		return buildFB2EPUB(inputPath, outputPath, true, opts, fallbackTitle)
+	}
+	return fmt.Errorf("unsupported format: %s", filepath.Ext(filename))
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"archive/zip"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// BookMetadata is the descriptive metadata of an EPUB's package document.
+type BookMetadata struct {
+	Title       string
+	Author      string
+	Series      string
+	SeriesIndex float64
+	Language    string
+	Publisher   string
+	Description string
+	Identifier  string
+}
+
+func ReadEPUBMetadata(epubPath string) (*BookMetadata, error) {
+	zipReader, err := zip.OpenReader(epubPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open EPUB: %w", err)
+	}
+	defer func() {
+		if closeErr := zipReader.Close(); closeErr != nil {
+			log.Printf("Warning: Failed to close ZIP reader: %v", closeErr)
+		}
+	}()
+
+	book, err := readEPUBPackage(&zipReader.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return packageMetadata(book.pkg), nil
+}
+
+func packageMetadata(pkg *epubPackage) *BookMetadata {
+	m := pkg.Metadata
+	metadata := &BookMetadata{}
+	if len(m.Titles) > 0 {
+		metadata.Title = strings.TrimSpace(m.Titles[0])
+	}
+
+	// EPUB 3 gives creator roles in refining meta elements
+	roles := map[string]string{}
+	for _, meta := range m.Metas {
+		if meta.Property == "role" && meta.Refines != "" {
+			roles[strings.TrimPrefix(meta.Refines, "#")] = strings.TrimSpace(meta.Value)
+		}
+	}
+	var authors []string
+	for _, creator := range m.Creators {
+		role := creator.Role
+		if role == "" {
+			role = roles[creator.ID]
+		}
+		if name := strings.TrimSpace(creator.Name); name != "" && (role == "" || role == "aut") {
+			authors = append(authors, name)
+		}
+	}
+	metadata.Author = strings.Join(authors, ", ")
+
+	if len(m.Languages) > 0 {
+		metadata.Language = strings.TrimSpace(m.Languages[0])
+	}
+	if len(m.Publishers) > 0 {
+		metadata.Publisher = strings.TrimSpace(m.Publishers[0])
+	}
+	if len(m.Descriptions) > 0 {
+		metadata.Description = plainDescription(m.Descriptions[0])
+	}
+	for _, identifier := range m.Identifiers {
+		if identifier.ID == pkg.UniqueIdentifier || metadata.Identifier == "" {
+			metadata.Identifier = strings.TrimSpace(identifier.Value)
+		}
+	}
+
+	// Series come from Calibre's metadata or an EPUB 3 collection
+	var collection *opfMeta
+	positions := map[string]string{}
+	for i, meta := range m.Metas {
+		switch {
+		case meta.Name == "calibre:series":
+			metadata.Series = strings.TrimSpace(meta.Content)
+		case meta.Name == "calibre:series_index":
+			metadata.SeriesIndex, _ = strconv.ParseFloat(strings.TrimSpace(meta.Content), 64)
+		case meta.Property == "belongs-to-collection" && collection == nil:
+			collection = &m.Metas[i]
+		case meta.Property == "group-position" && meta.Refines != "":
+			positions[strings.TrimPrefix(meta.Refines, "#")] = strings.TrimSpace(meta.Value)
+		}
+	}
+	if metadata.Series == "" && collection != nil {
+		metadata.Series = strings.TrimSpace(collection.Value)
+		metadata.SeriesIndex, _ = strconv.ParseFloat(positions[collection.ID], 64)
+	}
+
+	return metadata
+}
+
+// plainDescription strips the HTML that Calibre and many publishers put in
+// descriptions.
+func plainDescription(description string) string {
+	if !strings.Contains(description, "<") {
+		return strings.Join(strings.Fields(description), " ")
+	}
+	doc, err := html.Parse(strings.NewReader(description))
+	if err != nil {
+		return strings.Join(strings.Fields(description), " ")
+	}
+	return nodeText(doc)
+}
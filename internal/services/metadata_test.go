@@ -0,0 +1,61 @@
+package services
+
+import (
+	"testing"
+
+	"bookify/internal/testutil"
+)
+
+func TestReadEPUBMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata string
+		expected BookMetadata
+	}{
+		{
+			name: "Calibre series and HTML description",
+			metadata: `<dc:creator opf:role="aut">Ann Author</dc:creator>
+    <dc:creator opf:role="edt">Ed Itor</dc:creator>
+    <dc:language>en</dc:language>
+    <dc:publisher>Example Press</dc:publisher>
+    <dc:description>&lt;p&gt;A &lt;b&gt;great&lt;/b&gt; book.&lt;/p&gt;</dc:description>
+    <meta name="calibre:series" content="The Saga"/>
+    <meta name="calibre:series_index" content="3.0"/>`,
+			expected: BookMetadata{
+				Title: "Test Book", Author: "Ann Author", Series: "The Saga", SeriesIndex: 3,
+				Language: "en", Publisher: "Example Press", Description: "A great book.", Identifier: "test-book",
+			},
+		},
+		{
+			name: "EPUB 3 roles and collections",
+			metadata: `<dc:creator id="c1">First Writer</dc:creator>
+    <meta refines="#c1" property="role" scheme="marc:relators">aut</meta>
+    <dc:creator id="c2">Illu Strator</dc:creator>
+    <meta refines="#c2" property="role" scheme="marc:relators">ill</meta>
+    <dc:creator id="c3">Second Writer</dc:creator>
+    <meta property="belongs-to-collection" id="series">Trilogy</meta>
+    <meta refines="#series" property="collection-type">series</meta>
+    <meta refines="#series" property="group-position">2</meta>`,
+			expected: BookMetadata{
+				Title: "Test Book", Author: "First Writer, Second Writer", Series: "Trilogy", SeriesIndex: 2,
+				Identifier: "test-book",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			epubPath := testutil.CreateValidEPUB(t, "book.epub", map[string]string{
+				"OEBPS/content.opf": opfWithManifest(tt.metadata, ""),
+			})
+
+			metadata, err := ReadEPUBMetadata(epubPath)
+			if err != nil {
+				t.Fatalf("ReadEPUBMetadata() error = %v", err)
+			}
+			if *metadata != tt.expected {
+				t.Errorf("ReadEPUBMetadata() = %+v, want %+v", *metadata, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"bookify/internal/testutil"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+const oidcTestRedirect = "http://bookify.test/login/oidc/callback"
+
+func newTestOIDC(t *testing.T, config OIDCConfig) (*testutil.MockOIDC, *OIDCProvider) {
+	t.Helper()
+
+	mock := testutil.NewMockOIDC(t, "bookify", "secret")
+	config.Issuer = mock.URL
+	config.ClientID = "bookify"
+	config.ClientSecret = "secret"
+	config.RedirectURL = oidcTestRedirect
+	return mock, NewOIDCProvider(config)
+}
+
+// signIn goes to the provider like a browser would and returns the code it
+// sends back.
+func signIn(t *testing.T, provider *OIDCProvider, nonce, verifier string) string {
+	t.Helper()
+
+	authURL, err := provider.AuthCodeURL(context.Background(), "state-1", nonce, verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL() failed: %v", err)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("Failed to sign in: %v", err)
+	}
+	_ = resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || !strings.HasPrefix(callback.String(), oidcTestRedirect) {
+		t.Fatalf("provider sent the browser to %q", resp.Header.Get("Location"))
+	}
+	if callback.Query().Get("state") != "state-1" {
+		t.Errorf("state = %q", callback.Query().Get("state"))
+	}
+	return callback.Query().Get("code")
+}
+
+func TestOIDCProvider_Exchange(t *testing.T) {
+	mock, provider := newTestOIDC(t, OIDCConfig{
+		RolesClaim:  "realm_access.roles",
+		AdminValues: []string{"bookify-admin"},
+	})
+	mock.Claims = jwt.MapClaims{
+		"sub":                "subject-1",
+		"preferred_username": "alice",
+		"email":              "alice@example.com",
+		"realm_access":       map[string]interface{}{"roles": []string{"reader", "bookify-admin"}},
+	}
+
+	verifier := oauth2.GenerateVerifier()
+	code := signIn(t, provider, "nonce-1", verifier)
+	identity, err := provider.Exchange(context.Background(), code, "nonce-1", verifier)
+	if err != nil {
+		t.Fatalf("Exchange() failed: %v", err)
+	}
+	if identity.Subject != "subject-1" || identity.Username != "alice" || identity.Email != "alice@example.com" {
+		t.Errorf("identity = %+v", identity)
+	}
+	if identity.Admin == nil || !*identity.Admin {
+		t.Errorf("admin = %v, want true", identity.Admin)
+	}
+
+	// The code only works with the verifier it was issued for
+	code = signIn(t, provider, "nonce-1", verifier)
+	if _, err := provider.Exchange(context.Background(), code, "nonce-1", oauth2.GenerateVerifier()); err == nil {
+		t.Error("Exchange() accepted the wrong PKCE verifier")
+	}
+	code = signIn(t, provider, "nonce-1", verifier)
+	if _, err := provider.Exchange(context.Background(), code, "nonce-2", verifier); err == nil {
+		t.Error("Exchange() accepted the wrong nonce")
+	}
+}
+
+func TestOIDCProvider_Identity(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   OIDCConfig
+		claims   jwt.MapClaims
+		username string
+		admin    string
+		err      error
+	}{
+		{
+			name:     "username falls back to email",
+			claims:   jwt.MapClaims{"sub": "s", "email": "bob@example.com"},
+			username: "bob@example.com",
+			admin:    "unset",
+		},
+		{
+			name:     "username falls back to subject",
+			claims:   jwt.MapClaims{"sub": "s"},
+			username: "s",
+			admin:    "unset",
+		},
+		{
+			name:     "custom username claim",
+			config:   OIDCConfig{UsernameClaim: "nickname"},
+			claims:   jwt.MapClaims{"sub": "s", "nickname": "carol", "preferred_username": "c"},
+			username: "carol",
+			admin:    "unset",
+		},
+		{
+			name:     "groups without admin",
+			config:   OIDCConfig{RolesClaim: "groups", AdminValues: []string{"admins"}},
+			claims:   jwt.MapClaims{"sub": "s", "preferred_username": "dave", "groups": []string{"readers"}},
+			username: "dave",
+			admin:    "false",
+		},
+		{
+			name:     "single group as a string",
+			config:   OIDCConfig{RolesClaim: "groups", AdminValues: []string{"admins"}},
+			claims:   jwt.MapClaims{"sub": "s", "preferred_username": "erin", "groups": "admins"},
+			username: "erin",
+			admin:    "true",
+		},
+		{
+			name:   "not in an allowed group",
+			config: OIDCConfig{RolesClaim: "groups", AllowedValues: []string{"bookify"}},
+			claims: jwt.MapClaims{"sub": "s", "groups": []string{"other"}},
+			err:    ErrOIDCNotAllowed,
+		},
+		{
+			name:     "in an allowed group",
+			config:   OIDCConfig{RolesClaim: "groups", AllowedValues: []string{"bookify"}},
+			claims:   jwt.MapClaims{"sub": "s", "preferred_username": "frank", "groups": []string{"bookify"}},
+			username: "frank",
+			admin:    "unset",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock, provider := newTestOIDC(t, tt.config)
+			mock.Claims = tt.claims
+			verifier := oauth2.GenerateVerifier()
+			code := signIn(t, provider, "nonce", verifier)
+			identity, err := provider.Exchange(context.Background(), code, "nonce", verifier)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("Exchange() error = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Exchange() failed: %v", err)
+			}
+			if identity.Username != tt.username {
+				t.Errorf("username = %q, want %q", identity.Username, tt.username)
+			}
+			admin := "unset"
+			if identity.Admin != nil {
+				admin = map[bool]string{true: "true", false: "false"}[*identity.Admin]
+			}
+			if admin != tt.admin {
+				t.Errorf("admin = %s, want %s", admin, tt.admin)
+			}
+		})
+	}
+}
+
+func TestOIDCProvider_VerifyIDToken(t *testing.T) {
+	mock, provider := newTestOIDC(t, OIDCConfig{})
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	sign := func(method jwt.SigningMethod, key interface{}, kid string, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatalf("Failed to sign token: %v", err)
+		}
+		return signed
+	}
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":   mock.URL,
+			"aud":   "bookify",
+			"sub":   "subject-1",
+			"nonce": "nonce",
+			"iat":   time.Now().Unix(),
+			"exp":   time.Now().Add(time.Hour).Unix(),
+		}
+	}
+	with := func(name string, value interface{}) jwt.MapClaims {
+		claims := valid()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+
+	if _, err := provider.VerifyIDToken(context.Background(), mock.IDToken(t, valid()), "nonce"); err != nil {
+		t.Fatalf("VerifyIDToken() rejected a valid token: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"wrong issuer", mock.IDToken(t, with("iss", "https://evil.example"))},
+		{"wrong audience", mock.IDToken(t, with("aud", "someone-else"))},
+		{"several audiences without azp", mock.IDToken(t, with("aud", []string{"bookify", "someone-else"}))},
+		{"expired", mock.IDToken(t, with("exp", time.Now().Add(-time.Hour).Unix()))},
+		{"no expiry", sign(jwt.SigningMethodRS256, mock.Key, mock.KeyID, with("exp", nil))},
+		{"missing nonce", mock.IDToken(t, with("nonce", nil))},
+		{"other key", sign(jwt.SigningMethodRS256, otherKey, mock.KeyID, valid())},
+		{"unknown key", sign(jwt.SigningMethodRS256, otherKey, "other-key", valid())},
+		{"alg none", sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, mock.KeyID, valid())},
+		{"HMAC with the public key", sign(jwt.SigningMethodHS256, mock.Key.N.Bytes(), mock.KeyID, valid())},
+		{"garbage", "not.a.token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := provider.VerifyIDToken(context.Background(), tt.token, "nonce"); err == nil {
+				t.Error("VerifyIDToken() accepted the token")
+			}
+		})
+	}
+
+	claims := with("aud", []string{"bookify", "someone-else"})
+	claims["azp"] = "bookify"
+	if _, err := provider.VerifyIDToken(context.Background(), mock.IDToken(t, claims), "nonce"); err != nil {
+		t.Errorf("VerifyIDToken() rejected a token issued to this client: %v", err)
+	}
+}
+
+func TestOIDCProvider_Discovery(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"issuer":"https://evil.example","authorization_endpoint":"https://evil.example/a","token_endpoint":"https://evil.example/t","jwks_uri":"https://evil.example/k"}`))
+	}))
+	defer server.Close()
+
+	provider := NewOIDCProvider(OIDCConfig{Issuer: server.URL, ClientID: "bookify"})
+	if _, err := provider.AuthCodeURL(context.Background(), "state", "nonce", "verifier"); err == nil {
+		t.Error("AuthCodeURL() trusted a provider claiming another issuer")
+	}
+}
+
+func TestOIDCProvider_TrailingSlashIssuer(t *testing.T) {
+	// Providers such as Authentik and Auth0 publish their issuer with a
+	// trailing slash, and sign tokens with it
+	for _, configured := range []string{"", "/"} {
+		mock, provider := newTestOIDC(t, OIDCConfig{})
+		mock.Issuer = mock.URL + "/"
+		provider.config.Issuer = mock.URL + configured
+		mock.Claims = jwt.MapClaims{"sub": "subject-1", "preferred_username": "alice"}
+
+		verifier := oauth2.GenerateVerifier()
+		code := signIn(t, provider, "nonce-1", verifier)
+		if _, err := provider.Exchange(context.Background(), code, "nonce-1", verifier); err != nil {
+			t.Errorf("Exchange() with issuer %q failed: %v", provider.config.Issuer, err)
+		}
+
+		// The issuer is compared exactly as published
+		token := mock.IDToken(t, jwt.MapClaims{"sub": "subject-1", "nonce": "nonce-1", "iss": mock.URL})
+		if _, err := provider.VerifyIDToken(context.Background(), token, "nonce-1"); err == nil {
+			t.Errorf("VerifyIDToken() with issuer %q accepted a token without the trailing slash", provider.config.Issuer)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package services
 import (
 	"archive/zip"
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
@@ -10,16 +11,67 @@ import (
 	"regexp"
 	"strings"
 
+	"bookify/internal/db"
+
 	"github.com/pgaskin/kepubify/v4/kepub"
 )
 
+// ConversionProfile holds the kepubify options for a conversion.
+type ConversionProfile struct {
+	Smartypants     bool `json:"smartypants"`
+	Hyphenate       bool `json:"hyphenate"`
+	FullScreenFixes bool `json:"full_screen_fixes"`
+}
+
+// AccountConversionProfile returns the profile set in the account settings.
+func AccountConversionProfile(account *db.Account) ConversionProfile {
+	return ConversionProfile{
+		Smartypants:     account.Smartypants,
+		Hyphenate:       account.Hyphenate,
+		FullScreenFixes: account.FullScreenFixes,
+	}
+}
+
+// JobConversionProfile returns the profile chosen for the job, or the
+// account's when none was.
+func JobConversionProfile(job *db.Job) ConversionProfile {
+	if job.Profile != "" {
+		var profile ConversionProfile
+		if err := json.Unmarshal([]byte(job.Profile), &profile); err == nil {
+			return profile
+		}
+		log.Printf("Warning: Invalid conversion profile on job %s, using the account settings", job.ID)
+	}
+	return AccountConversionProfile(&job.Account)
+}
+
+// String encodes the profile for storing on a job.
+func (p ConversionProfile) String() string {
+	data, _ := json.Marshal(p)
+	return string(data)
+}
+
+func (p ConversionProfile) converterOptions() []kepub.ConverterOption {
+	var opts []kepub.ConverterOption
+	if p.Smartypants {
+		opts = append(opts, kepub.ConverterOptionSmartypants())
+	}
+	if p.Hyphenate {
+		opts = append(opts, kepub.ConverterOptionHyphenate(true))
+	}
+	if p.FullScreenFixes {
+		opts = append(opts, kepub.ConverterOptionFullScreenFixes())
+	}
+	return opts
+}
+
 type ProcessorService struct{}
 
 func NewProcessorService() *ProcessorService {
 	return &ProcessorService{}
 }
 
-func (p *ProcessorService) ProcessEPUB(inputPath, outputPath string, progressCallback func(int)) error {
+func (p *ProcessorService) ProcessEPUB(inputPath, outputPath string, profile ConversionProfile, progressCallback func(int)) error {
 	if progressCallback != nil {
 		progressCallback(10)
 	}
@@ -56,7 +108,7 @@ func (p *ProcessorService) ProcessEPUB(inputPath, outputPath string, progressCal
 		progressCallback(50)
 	}
 
-	converter := kepub.NewConverter()
+	converter := kepub.NewConverterWithOptions(profile.converterOptions()...)
 	err = converter.Convert(context.Background(), outputFile, &zipReader.Reader)
 	if err != nil {
 		return fmt.Errorf("kepubify conversion failed: %w", err)
@@ -6,6 +6,7 @@ import (
 	"strings"
 	"testing"
 
+	"bookify/internal/db"
 	"bookify/internal/testutil"
 )
 
@@ -203,7 +204,7 @@ func TestProcessorService_ProcessEPUB(t *testing.T) {
 				progressCalls = append(progressCalls, progress)
 			}
 
-			err := processor.ProcessEPUB(inputPath, outputPath, progressCallback)
+			err := processor.ProcessEPUB(inputPath, outputPath, ConversionProfile{}, progressCallback)
 
 			if tt.expectError {
 				if err == nil {
@@ -244,7 +245,7 @@ func TestProcessorService_ProcessEPUB_ProgressCallback(t *testing.T) {
 	outputPath := filepath.Join(tempDir, "output.kepub.epub")
 
 	// Test without progress callback
-	err := processor.ProcessEPUB(inputPath, outputPath, nil)
+	err := processor.ProcessEPUB(inputPath, outputPath, ConversionProfile{}, nil)
 	if err == nil {
 		t.Log("ProcessEPUB() completed without progress callback")
 	} else {
@@ -257,7 +258,7 @@ func TestProcessorService_ProcessEPUB_ProgressCallback(t *testing.T) {
 		progressCalls = append(progressCalls, progress)
 	}
 
-	err = processor.ProcessEPUB(inputPath, outputPath, progressCallback)
+	err = processor.ProcessEPUB(inputPath, outputPath, ConversionProfile{}, progressCallback)
 	if err != nil {
 		t.Logf("ProcessEPUB() failed as expected: %v", err)
 	}
@@ -301,3 +302,22 @@ func BenchmarkProcessorService_PrepareOutputPath(b *testing.B) {
 		_, _ = processor.PrepareOutputPath(tempDir, filename)
 	}
 }
+
+func TestJobConversionProfile(t *testing.T) {
+	account := db.Account{Smartypants: true, FullScreenFixes: true}
+
+	job := &db.Job{Account: account}
+	if got := JobConversionProfile(job); got != (ConversionProfile{Smartypants: true, FullScreenFixes: true}) {
+		t.Errorf("JobConversionProfile() = %+v, want the account settings", got)
+	}
+
+	job.Profile = ConversionProfile{Hyphenate: true}.String()
+	if got := JobConversionProfile(job); got != (ConversionProfile{Hyphenate: true}) {
+		t.Errorf("JobConversionProfile() = %+v, want the job's own profile", got)
+	}
+
+	job.Profile = "not json"
+	if got := JobConversionProfile(job); !got.Smartypants {
+		t.Errorf("JobConversionProfile() = %+v, want the account settings for an invalid profile", got)
+	}
+}
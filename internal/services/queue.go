@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"bookify/internal/db"
@@ -14,6 +15,11 @@ type QueueService struct {
 	db        *db.Service
 	drive     *DriveService
 	processor *ProcessorService
+	importer  *ImporterService
+	validator *ValidatorService
+	images    *ImageOptimizerService
+	covers    *CoverService
+	store     *StoreService
 	tempDir   string
 	stopCh    chan bool
 }
@@ -23,11 +29,28 @@ func NewQueueService(dbService *db.Service, driveService *DriveService) *QueueSe
 		db:        dbService,
 		drive:     driveService,
 		processor: NewProcessorService(),
+		importer:  NewImporterService(),
+		validator: NewValidatorService(),
+		images:    NewImageOptimizerService(),
+		covers:    NewCoverService("./data"),
 		tempDir:   "./temp",
 		stopCh:    make(chan bool),
 	}
 }
 
+func (q *QueueService) SetTempDir(dir string) {
+	q.tempDir = dir
+}
+
+func (q *QueueService) SetDataDir(dir string) {
+	q.covers = NewCoverService(dir)
+}
+
+// SetStore keeps uploads and converted books in the store after each job.
+func (q *QueueService) SetStore(store *StoreService) {
+	q.store = store
+}
+
 func (q *QueueService) StartWorker() {
 	log.Println("Starting queue worker...")
 	ticker := time.NewTicker(5 * time.Second)
@@ -58,6 +81,13 @@ func (q *QueueService) processNextJob() {
 	if job == nil {
 		return
 	}
+	// The job may have been cancelled since it was read
+	if claimed, err := q.db.ClaimJob(job.ID); err != nil || !claimed {
+		if err != nil {
+			log.Printf("Failed to claim job %s: %v", job.ID, err)
+		}
+		return
+	}
 
 	log.Printf("Processing job %s: %s", job.ID, job.OriginalFilename)
 	q.processJob(job)
@@ -72,24 +102,125 @@ func (q *QueueService) processJob(job *db.Job) {
 	}
 
 	inputPath := filepath.Join(q.tempDir, job.OriginalFilename)
+	if job.Type == db.JobTypeReprocess {
+		inputPath = filepath.Join(q.tempDir, job.ID+"-"+job.OriginalFilename)
+		if err := q.restoreOriginal(job, inputPath); err != nil {
+			q.failJob(job, fmt.Sprintf("Failed to restore original: %v", err))
+			return
+		}
+	}
 	if _, err := os.Stat(inputPath); os.IsNotExist(err) {
 		q.failJob(job, "Input file not found")
 		return
 	}
 
+	epubPath := inputPath
+	if NeedsImport(job.OriginalFilename) {
+		job.Stage = "importing"
+		job.Progress = 5
+		if err := q.db.UpdateJob(job); err != nil {
+			log.Printf("Warning: Failed to update job: %v", err)
+		}
+
+		epubPath = inputPath + ".import.epub"
+		err := q.importer.Import(inputPath, job.OriginalFilename, epubPath, ImportOptions{
+			Title:        job.Title,
+			Author:       job.Author,
+			RightToLeft:  job.Account.ComicRightToLeft,
+			SplitSpreads: job.Account.ComicSplitSpreads,
+		})
+		defer func() {
+			if err := os.Remove(epubPath); err != nil && !os.IsNotExist(err) {
+				log.Printf("Warning: Failed to remove imported file: %v", err)
+			}
+		}()
+		if err != nil {
+			q.failJob(job, fmt.Sprintf("Import failed: %v", err))
+			return
+		}
+	}
+
+	job.Stage = "validating"
+	job.Progress = 10
+	if err := q.db.UpdateJob(job); err != nil {
+		log.Printf("Warning: Failed to update job: %v", err)
+	}
+
+	convertPath := epubPath
+	repairedPath := inputPath + ".repaired"
+	report, err := q.validator.Validate(epubPath, repairedPath)
+	if !report.Empty() {
+		job.ValidationReport = report.String()
+	}
+	if err != nil {
+		if updateErr := q.db.UpdateJob(job); updateErr != nil {
+			log.Printf("Warning: Failed to update job: %v", updateErr)
+		}
+		q.failJob(job, fmt.Sprintf("Validation failed: %v", err))
+		return
+	}
+	if report.Repaired() {
+		convertPath = repairedPath
+		appendJobMessage(job, fmt.Sprintf("Repaired %d problem(s) before conversion", len(report.Repairs)))
+		defer func() {
+			if err := os.Remove(repairedPath); err != nil {
+				log.Printf("Warning: Failed to remove repaired file: %v", err)
+			}
+		}()
+	}
+
+	if device := job.Account.DeviceProfile; device != "" || job.Account.Greyscale {
+		job.Stage = "optimizing images"
+		job.Progress = 15
+		if err := q.db.UpdateJob(job); err != nil {
+			log.Printf("Warning: Failed to update job: %v", err)
+		}
+
+		optimizedPath := inputPath + ".optimized"
+		result, err := q.images.Optimize(convertPath, optimizedPath, ImageOptions{
+			Device:    device,
+			Greyscale: job.Account.Greyscale,
+		})
+		if err != nil {
+			log.Printf("Warning: Image optimisation failed for job %s: %v", job.ID, err)
+			if err := os.Remove(optimizedPath); err != nil && !os.IsNotExist(err) {
+				log.Printf("Warning: Failed to remove optimized file: %v", err)
+			}
+		} else {
+			convertPath = optimizedPath
+			job.ImageBytesSaved = result.BytesSaved()
+			if result.ImagesOptimized > 0 && result.BytesSaved() > 0 {
+				appendJobMessage(job, fmt.Sprintf("Optimised %d image(s), saved %s", result.ImagesOptimized, FormatBytes(result.BytesSaved())))
+			} else if result.ImagesOptimized > 0 {
+				appendJobMessage(job, fmt.Sprintf("Optimised %d image(s)", result.ImagesOptimized))
+			}
+			defer func() {
+				if err := os.Remove(optimizedPath); err != nil {
+					log.Printf("Warning: Failed to remove optimized file: %v", err)
+				}
+			}()
+		}
+	}
+
+	metadata, err := ReadEPUBMetadata(convertPath)
+	if err != nil {
+		log.Printf("Warning: Failed to read metadata for job %s: %v", job.ID, err)
+		metadata = &BookMetadata{}
+	}
+
 	job.Stage = "converting"
 	job.Progress = 25
 	if err := q.db.UpdateJob(job); err != nil {
 		log.Printf("Warning: Failed to update job: %v", err)
 	}
 
-	outputPath, err := q.processor.PrepareOutputPath(q.tempDir, job.OriginalFilename)
+	outputPath, err := q.processor.PrepareOutputPath(q.tempDir, EPUBFilename(job.OriginalFilename))
 	if err != nil {
 		q.failJob(job, fmt.Sprintf("Failed to prepare output path: %v", err))
 		return
 	}
 
-	err = q.processor.ProcessEPUB(inputPath, outputPath, func(progress int) {
+	err = q.processor.ProcessEPUB(convertPath, outputPath, JobConversionProfile(job), func(progress int) {
 		job.Progress = 25 + (progress * 50 / 100)
 		if err := q.db.UpdateJob(job); err != nil {
 			log.Printf("Warning: Failed to update job: %v", err)
@@ -101,6 +232,23 @@ func (q *QueueService) processJob(job *db.Job) {
 		return
 	}
 
+	if outputHash, err := HashFile(outputPath); err != nil {
+		log.Printf("Warning: Failed to hash output of job %s: %v", job.ID, err)
+	} else {
+		job.OutputHash = outputHash
+		q.compareWithPreviousConversion(job)
+	}
+
+	job.Stage = "extracting cover"
+	if _, err := q.covers.ExtractCover(convertPath, job.ID); err != nil {
+		log.Printf("No cover thumbnail for job %s: %v", job.ID, err)
+	} else {
+		job.HasCover = true
+	}
+	if err := q.db.UpdateJob(job); err != nil {
+		log.Printf("Warning: Failed to update job: %v", err)
+	}
+
 	job.Stage = "uploading"
 	job.Progress = 75
 	if err := q.db.UpdateJob(job); err != nil {
@@ -113,13 +261,33 @@ func (q *QueueService) processJob(job *db.Job) {
 		return
 	}
 
-	cleanFilename := q.processor.CleanFilename(job.OriginalFilename)
-	driveURL, err := q.drive.UploadFile(account, outputPath, cleanFilename)
+	cleanFilename := q.processor.CleanFilename(EPUBFilename(job.OriginalFilename))
+	var book *db.Book
+	var driveURL string
+	if job.Type == db.JobTypeReprocess {
+		book, err = q.db.GetBook(job.BookID)
+		if err != nil {
+			q.failJob(job, fmt.Sprintf("Failed to get book: %v", err))
+			return
+		}
+		driveURL, err = q.replaceBookFile(account, book, outputPath, cleanFilename)
+	} else {
+		driveURL, err = q.drive.UploadFile(account, outputPath, cleanFilename)
+	}
 	if err != nil {
 		q.failJob(job, fmt.Sprintf("Upload failed: %v", err))
 		return
 	}
 
+	var outputSize int64
+	if info, err := os.Stat(outputPath); err == nil {
+		outputSize = info.Size()
+	}
+
+	if q.store != nil {
+		q.retainFiles(job, inputPath, outputPath)
+	}
+
 	job.Stage = "cleanup"
 	job.Progress = 90
 	if err := q.db.UpdateJob(job); err != nil {
@@ -138,9 +306,144 @@ func (q *QueueService) processJob(job *db.Job) {
 		log.Printf("Failed to mark job completed: %v", err)
 	}
 
+	if book != nil {
+		book = reprocessedLibraryBook(book, newLibraryBook(job, metadata, cleanFilename, driveURL))
+		book.FileSize = outputSize
+		err = q.db.UpdateBook(book)
+	} else {
+		book = newLibraryBook(job, metadata, cleanFilename, driveURL)
+		book.FileSize = outputSize
+		err = q.db.CreateBook(book)
+	}
+	if err != nil {
+		log.Printf("Warning: Failed to add job %s to the library: %v", job.ID, err)
+	} else if text, err := ReadEPUBText(convertPath); err != nil {
+		log.Printf("Warning: Failed to extract text of job %s for search: %v", job.ID, err)
+	} else if err := q.db.IndexBook(book, text); err != nil {
+		log.Printf("Warning: Failed to index job %s for search: %v", job.ID, err)
+	}
+
 	log.Printf("Job %s completed successfully", job.ID)
 }
 
+func newLibraryBook(job *db.Job, metadata *BookMetadata, filename, driveURL string) *db.Book {
+	title := metadata.Title
+	if title == "" {
+		epubName := EPUBFilename(job.OriginalFilename)
+		title = strings.TrimSuffix(epubName, filepath.Ext(epubName))
+	}
+	book := &db.Book{
+		JobID:       job.ID,
+		AccountID:   job.AccountID,
+		Title:       title,
+		Author:      metadata.Author,
+		Series:      metadata.Series,
+		SeriesIndex: metadata.SeriesIndex,
+		Language:    metadata.Language,
+		Publisher:   metadata.Publisher,
+		Description: metadata.Description,
+		Identifier:  metadata.Identifier,
+		Filename:    filename,
+		DriveURL:    driveURL,
+		DriveFileID: DriveFileID(driveURL),
+		HasCover:    job.HasCover,
+		DeliveredAt: time.Now(),
+		Tags:        job.Tags,
+		Rating:      job.Rating,
+	}
+	// Metadata given with the job, such as from Calibre, wins over the EPUB's
+	if job.Title != "" {
+		book.Title = job.Title
+	}
+	if job.Author != "" {
+		book.Author = job.Author
+	}
+	if job.Series != "" {
+		book.Series = job.Series
+		book.SeriesIndex = job.SeriesIndex
+	}
+	return book
+}
+
+// reprocessedLibraryBook keeps the identity of a book whose file was
+// converted again, taking everything else from the new conversion.
+func reprocessedLibraryBook(book, converted *db.Book) *db.Book {
+	converted.ID = book.ID
+	converted.UUID = book.UUID
+	converted.DeliveredAt = book.DeliveredAt
+	converted.CreatedAt = book.CreatedAt
+	return converted
+}
+
+// restoreOriginal copies the retained upload of a reprocess job from the
+// store to where the conversion expects it.
+func (q *QueueService) restoreOriginal(job *db.Job, inputPath string) error {
+	if q.store == nil || !q.store.Has(job.SourceHash) {
+		return ErrNotStored
+	}
+	if err := os.MkdirAll(q.tempDir, 0755); err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	return q.store.CopyTo(job.SourceHash, inputPath)
+}
+
+// replaceBookFile updates the book's Drive file in place, so share links and
+// synced copies keep pointing at it. Books without a known Drive file get a
+// new one.
+func (q *QueueService) replaceBookFile(account *db.Account, book *db.Book, outputPath, filename string) (string, error) {
+	if book.DriveFileID == "" {
+		return q.drive.UploadFile(account, outputPath, filename)
+	}
+	return q.drive.UpdateFile(account, book.DriveFileID, outputPath)
+}
+
+// compareWithPreviousConversion notes whether converting the same file again,
+// for example after changing the account settings, changed the result.
+func (q *QueueService) compareWithPreviousConversion(job *db.Job) {
+	if job.SourceHash == "" {
+		return
+	}
+	previous, err := q.db.FindPreviousConversion(job)
+	if err != nil {
+		log.Printf("Warning: Failed to look up previous conversion: %v", err)
+		return
+	}
+	if previous == nil {
+		return
+	}
+	if previous.OutputHash == job.OutputHash {
+		appendJobMessage(job, "Output identical to the previous conversion")
+	} else {
+		appendJobMessage(job, "Output differs from the previous conversion")
+	}
+}
+
+func appendJobMessage(job *db.Job, message string) {
+	if job.Message == "" {
+		job.Message = message
+		return
+	}
+	job.Message += "; " + message
+}
+
+// retainFiles copies the upload and the KEPUB into the store before the
+// temporary files are removed.
+func (q *QueueService) retainFiles(job *db.Job, inputPath, outputPath string) {
+	sourceHash, err := q.store.Put(inputPath)
+	if err != nil {
+		log.Printf("Warning: Failed to store upload of job %s: %v", job.ID, err)
+		return
+	}
+	outputHash, err := q.store.Put(outputPath)
+	if err != nil {
+		log.Printf("Warning: Failed to store output of job %s: %v", job.ID, err)
+		return
+	}
+	job.SourceHash = sourceHash
+	job.OutputHash = outputHash
+	job.Retained = true
+}
+
 func (q *QueueService) failJob(job *db.Job, errorMsg string) {
 	log.Printf("Job %s failed: %s", job.ID, errorMsg)
 	if err := q.db.MarkJobFailed(job.ID, errorMsg); err != nil {
@@ -157,6 +460,11 @@ func (q *QueueService) StartCleanupWorker() {
 			select {
 			case <-ticker.C:
 				q.cleanupOldFiles()
+				if q.store != nil {
+					if err := q.store.Prune(); err != nil {
+						log.Printf("Warning: Failed to prune the store: %v", err)
+					}
+				}
 			case <-q.stopCh:
 				return
 			}
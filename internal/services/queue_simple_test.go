@@ -3,6 +3,7 @@ package services
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -131,3 +132,57 @@ func TestQueueService_ProcessNextJob_NoJobs(t *testing.T) {
 
 	// Test passes if no panic occurs
 }
+
+func TestQueueService_ReprocessMissingOriginal(t *testing.T) {
+	store, dbService, _ := setupStore(t, StoreOptions{})
+	queue := NewQueueService(dbService, NewDriveService(dbService))
+	queue.SetTempDir(t.TempDir())
+	queue.SetStore(store)
+
+	account, _ := dbService.CreateAccount("test-account", "folder-123")
+	job := &db.Job{AccountID: account.ID, Type: db.JobTypeReprocess, OriginalFilename: "book.epub", SourceHash: "missing", BookID: 1}
+	if err := dbService.QueueJob(job); err != nil {
+		t.Fatalf("Failed to create job: %v", err)
+	}
+
+	queue.processJob(job)
+
+	failed, _ := dbService.GetJob(job.ID)
+	if failed.Status != "failed" || !strings.Contains(failed.Error, "not in store") {
+		t.Errorf("job = %s: %s, want it to fail for the missing original", failed.Status, failed.Error)
+	}
+}
+
+func TestQueueService_RestoreOriginal(t *testing.T) {
+	store, dbService, _ := setupStore(t, StoreOptions{})
+	queue := NewQueueService(dbService, NewDriveService(dbService))
+	queue.SetTempDir(t.TempDir())
+	queue.SetStore(store)
+
+	hash, err := store.Put(writeTempFile(t, "original"))
+	if err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	inputPath := filepath.Join(queue.tempDir, "job-book.epub")
+	if err := queue.restoreOriginal(&db.Job{SourceHash: hash}, inputPath); err != nil {
+		t.Fatalf("restoreOriginal() error = %v", err)
+	}
+	if data, _ := os.ReadFile(inputPath); string(data) != "original" {
+		t.Errorf("restored %q, want the stored original", data)
+	}
+}
+
+func TestReprocessedLibraryBook(t *testing.T) {
+	delivered := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	book := &db.Book{ID: 7, UUID: "book-uuid", JobID: "old-job", Title: "Old Title", DeliveredAt: delivered}
+	converted := &db.Book{JobID: "new-job", Title: "New Title", DeliveredAt: time.Now()}
+
+	got := reprocessedLibraryBook(book, converted)
+	if got.ID != 7 || got.UUID != "book-uuid" || !got.DeliveredAt.Equal(delivered) {
+		t.Errorf("reprocessed book lost its identity: %+v", got)
+	}
+	if got.JobID != "new-job" || got.Title != "New Title" {
+		t.Errorf("reprocessed book = %+v, want the new conversion's details", got)
+	}
+}
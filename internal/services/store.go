@@ -0,0 +1,162 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"bookify/internal/db"
+)
+
+var ErrNotStored = errors.New("file not in store")
+
+// StoreOptions limit how much the store keeps. Zero values keep everything.
+type StoreOptions struct {
+	Retention time.Duration
+	Quota     int64
+}
+
+// StoreService keeps uploads and converted books after the job is done, in a
+// content-addressed directory where each file is named by its SHA-256.
+type StoreService struct {
+	db   *db.Service
+	dir  string
+	opts StoreOptions
+}
+
+func NewStoreService(dbService *db.Service, dir string, opts StoreOptions) *StoreService {
+	return &StoreService{db: dbService, dir: dir, opts: opts}
+}
+
+func (s *StoreService) Path(hash string) string {
+	if len(hash) < 2 {
+		return filepath.Join(s.dir, hash)
+	}
+	return filepath.Join(s.dir, hash[:2], hash)
+}
+
+// Has reports whether the file is in the store.
+func (s *StoreService) Has(hash string) bool {
+	if hash == "" {
+		return false
+	}
+	_, err := os.Stat(s.Path(hash))
+	return err == nil
+}
+
+// Put copies a file into the store and returns its hash. Storing a file
+// that's already there only marks it as used.
+func (s *StoreService) Put(filePath string) (string, error) {
+	src, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer func() {
+		if closeErr := src.Close(); closeErr != nil {
+			log.Printf("Warning: Failed to close file: %v", closeErr)
+		}
+	}()
+
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create store directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(s.dir, ".incoming-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create store file: %w", err)
+	}
+	defer func() {
+		if err := os.Remove(tmp.Name()); err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: Failed to remove temporary store file: %v", err)
+		}
+	}()
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), src)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to copy file into store: %w", err)
+	}
+	hash := hex.EncodeToString(hasher.Sum(nil))
+
+	if !s.Has(hash) {
+		if err := os.MkdirAll(filepath.Dir(s.Path(hash)), 0755); err != nil {
+			return "", fmt.Errorf("failed to create store directory: %w", err)
+		}
+		if err := os.Rename(tmp.Name(), s.Path(hash)); err != nil {
+			return "", fmt.Errorf("failed to add file to store: %w", err)
+		}
+	}
+	if err := s.db.SaveStoredFile(hash, size); err != nil {
+		return "", fmt.Errorf("failed to record stored file: %w", err)
+	}
+	return hash, nil
+}
+
+// Open opens a stored file for reading and marks it as used.
+func (s *StoreService) Open(hash string) (*os.File, error) {
+	if !s.Has(hash) {
+		return nil, ErrNotStored
+	}
+	file, err := os.Open(s.Path(hash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stored file: %w", err)
+	}
+	if err := s.db.TouchStoredFile(hash); err != nil {
+		log.Printf("Warning: Failed to update last use of stored file %s: %v", hash, err)
+	}
+	return file, nil
+}
+
+// CopyTo copies a stored file to destPath, for example to convert it again.
+func (s *StoreService) CopyTo(hash, destPath string) error {
+	if !s.Has(hash) {
+		return ErrNotStored
+	}
+	if err := s.db.TouchStoredFile(hash); err != nil {
+		log.Printf("Warning: Failed to update last use of stored file %s: %v", hash, err)
+	}
+	return copyFile(s.Path(hash), destPath)
+}
+
+// Prune removes files unused for longer than the retention period, then the
+// least recently used files until the store fits its quota.
+func (s *StoreService) Prune() error {
+	files, err := s.db.ListStoredFiles()
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for _, file := range files {
+		total += file.Size
+	}
+
+	cutoff := time.Now().Add(-s.opts.Retention)
+	for _, file := range files {
+		expired := s.opts.Retention > 0 && file.LastUsedAt.Before(cutoff)
+		overQuota := s.opts.Quota > 0 && total > s.opts.Quota
+		if !expired && !overQuota {
+			// Files are ordered by last use, so the rest are newer
+			break
+		}
+
+		if err := os.Remove(s.Path(file.Hash)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: Failed to remove stored file %s: %v", file.Hash, err)
+			continue
+		}
+		if err := s.db.DeleteStoredFile(file.Hash); err != nil {
+			return err
+		}
+		total -= file.Size
+		log.Printf("Removed %s from the store", file.Hash)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"bookify/internal/db"
+	"bookify/internal/testutil"
+
+	"gorm.io/gorm"
+)
+
+func setupStore(t *testing.T, opts StoreOptions) (*StoreService, *db.Service, *gorm.DB) {
+	t.Helper()
+
+	testDB := testutil.SetupTestDB(t)
+	if err := testDB.AutoMigrate(&db.Account{}, &db.Job{}, &db.StoredFile{}); err != nil {
+		t.Fatalf("Failed to migrate test database: %v", err)
+	}
+	dbService := db.NewService(testDB)
+	return NewStoreService(dbService, filepath.Join(t.TempDir(), "store"), opts), dbService, testDB
+}
+
+func writeTempFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	return path
+}
+
+func TestStoreService_Put(t *testing.T) {
+	store, dbService, _ := setupStore(t, StoreOptions{})
+
+	hash, err := store.Put(writeTempFile(t, "hello"))
+	if err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if want := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"; hash != want {
+		t.Errorf("Put() = %s, want the SHA-256 %s", hash, want)
+	}
+	if store.Path(hash) != filepath.Join(store.dir, "2c", hash) {
+		t.Errorf("Path() = %s, want a two-character shard", store.Path(hash))
+	}
+
+	// The same content is stored once
+	again, err := store.Put(writeTempFile(t, "hello"))
+	if err != nil || again != hash {
+		t.Errorf("second Put() = %s, %v", again, err)
+	}
+	files, _ := dbService.ListStoredFiles()
+	if len(files) != 1 || files[0].Size != 5 {
+		t.Errorf("stored files = %+v, want one of 5 bytes", files)
+	}
+
+	file, err := store.Open(hash)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	data, _ := io.ReadAll(file)
+	_ = file.Close() // Error ignored in test
+	if string(data) != "hello" {
+		t.Errorf("Open() read %q", data)
+	}
+
+	if _, err := store.Open("0000"); err != ErrNotStored {
+		t.Errorf("Open() of a missing file error = %v, want ErrNotStored", err)
+	}
+
+	entries, _ := os.ReadDir(store.dir)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			t.Errorf("temporary file %s left behind", entry.Name())
+		}
+	}
+}
+
+func TestStoreService_Prune(t *testing.T) {
+	store, dbService, testDB := setupStore(t, StoreOptions{Retention: 30 * 24 * time.Hour, Quota: 8})
+
+	old, _ := store.Put(writeTempFile(t, "old file"))
+	middle, _ := store.Put(writeTempFile(t, "middle"))
+	recent, _ := store.Put(writeTempFile(t, "new"))
+
+	account, _ := dbService.CreateAccount("Home", "folder1")
+	job := &db.Job{AccountID: account.ID, OriginalFilename: "old.epub", SourceHash: old, OutputHash: recent, Retained: true}
+	if err := dbService.QueueJob(job); err != nil {
+		t.Fatalf("QueueJob() failed: %v", err)
+	}
+
+	// "old file" is past the retention period; after that the store is
+	// still over its 8 byte quota, so "middle" goes too
+	now := time.Now()
+	ages := map[string]time.Duration{old: 40 * 24 * time.Hour, middle: 2 * time.Hour, recent: time.Hour}
+	for hash, age := range ages {
+		testDB.Model(&db.StoredFile{}).Where("hash = ?", hash).Update("last_used_at", now.Add(-age))
+	}
+
+	if err := store.Prune(); err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+	if store.Has(old) || store.Has(middle) || !store.Has(recent) {
+		t.Errorf("after Prune() has old=%v middle=%v recent=%v, want only recent", store.Has(old), store.Has(middle), store.Has(recent))
+	}
+
+	updated, _ := dbService.GetJob(job.ID)
+	if updated.Retained {
+		t.Errorf("job whose upload was pruned is still marked retained")
+	}
+}
@@ -0,0 +1,27 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// CreateTempFile creates filename in the temp directory for the queue to
+// pick up, numbering it "book (2).epub" and so on if a file of that name is
+// already waiting. Creating the file reserves the name, so two uploads at
+// once never share one. It returns the open file and the name used.
+func CreateTempFile(tempDir, filename string) (*os.File, string, error) {
+	ext := filepath.Ext(filename)
+	name := filename
+	for i := 2; ; i++ {
+		file, err := os.OpenFile(filepath.Join(tempDir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			return file, name, nil
+		}
+		if !os.IsExist(err) {
+			return nil, "", err
+		}
+		name = strings.TrimSuffix(filename, ext) + " (" + strconv.Itoa(i) + ")" + ext
+	}
+}
@@ -0,0 +1,34 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateTempFile(t *testing.T) {
+	tempDir := t.TempDir()
+
+	var names []string
+	for i := 0; i < 3; i++ {
+		file, name, err := CreateTempFile(tempDir, "book.epub")
+		if err != nil {
+			t.Fatalf("CreateTempFile() error = %v", err)
+		}
+		if file.Name() != filepath.Join(tempDir, name) {
+			t.Errorf("file is %s, want it named %s", file.Name(), name)
+		}
+		_ = file.Close() // Error ignored in test
+		names = append(names, name)
+	}
+	want := []string{"book.epub", "book (2).epub", "book (3).epub"}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("name %d = %q, want %q", i, names[i], want[i])
+		}
+	}
+
+	if _, _, err := CreateTempFile(filepath.Join(tempDir, "missing"), "book.epub"); err == nil || os.IsExist(err) {
+		t.Errorf("CreateTempFile() in a missing directory error = %v, want it reported", err)
+	}
+}
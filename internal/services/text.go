@@ -0,0 +1,118 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Longer books are indexed up to this many bytes of text.
+const maxBookText = 4 << 20
+
+// ReadEPUBText extracts the text of the documents in an EPUB's spine, in
+// reading order, one paragraph per line.
+func ReadEPUBText(epubPath string) (string, error) {
+	zipReader, err := zip.OpenReader(epubPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open EPUB: %w", err)
+	}
+	defer func() {
+		if closeErr := zipReader.Close(); closeErr != nil {
+			log.Printf("Warning: Failed to close ZIP reader: %v", closeErr)
+		}
+	}()
+
+	book, err := readEPUBPackage(&zipReader.Reader)
+	if err != nil {
+		return "", err
+	}
+
+	var text strings.Builder
+	for _, ref := range book.pkg.Spine {
+		item := book.item(ref.IDRef)
+		if item == nil {
+			continue
+		}
+		data, err := book.read(book.resolve(item.Href))
+		if err != nil {
+			log.Printf("Warning: Skipping spine item %s: %v", item.Href, err)
+			continue
+		}
+		doc, err := html.Parse(bytes.NewReader(data))
+		if err != nil {
+			log.Printf("Warning: Skipping spine item %s: %v", item.Href, err)
+			continue
+		}
+		body := findElement(doc, atom.Body)
+		if body == nil {
+			continue
+		}
+		writeBlockText(&text, body)
+		if text.Len() >= maxBookText {
+			break
+		}
+	}
+
+	result := text.String()
+	if len(result) > maxBookText {
+		result = strings.ToValidUTF8(result[:maxBookText], "")
+	}
+	return strings.TrimSpace(result), nil
+}
+
+// writeBlockText writes the text of n, starting a new line for each block
+// so that words in adjacent paragraphs don't run together.
+func writeBlockText(b *strings.Builder, n *html.Node) {
+	var line strings.Builder
+	flush := func() {
+		if text := strings.Join(strings.Fields(line.String()), " "); text != "" {
+			b.WriteString(text)
+			b.WriteByte('\n')
+		}
+		line.Reset()
+	}
+
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			line.WriteString(n.Data)
+			return
+		case html.ElementNode:
+			switch n.DataAtom {
+			case atom.Script, atom.Style, atom.Head:
+				return
+			case atom.Br:
+				line.WriteByte(' ')
+				return
+			}
+		}
+		block := n.Type == html.ElementNode && isBlockElement(n.DataAtom)
+		if block {
+			flush()
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+		if block {
+			flush()
+		}
+	}
+	walk(n)
+	flush()
+}
+
+func isBlockElement(a atom.Atom) bool {
+	switch a {
+	case atom.P, atom.Div, atom.Section, atom.Article, atom.Aside, atom.Blockquote,
+		atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6,
+		atom.Li, atom.Dt, atom.Dd, atom.Tr, atom.Pre, atom.Figcaption, atom.Hr:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadEPUBText(t *testing.T) {
+	content := `<html><head><title>Story</title><style>p { color: red; }</style></head><body>
+<h1>Story</h1>
+<h2>One</h2><p>First<br>line.</p><p>Second paragraph.</p>
+<h2>Two</h2><ul><li>Item</li><li>Other item</li></ul><script>alert("no")</script>
+</body></html>`
+	_, epubPath := importDocument(t, "story.html", content, ImportOptions{})
+
+	text, err := ReadEPUBText(epubPath)
+	if err != nil {
+		t.Fatalf("ReadEPUBText() error = %v", err)
+	}
+
+	for _, want := range []string{"One\nFirst line.\nSecond paragraph.\n", "Two\nItem\nOther item"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("ReadEPUBText() = %q, want it to contain %q", text, want)
+		}
+	}
+	if strings.Contains(text, "alert") || strings.Contains(text, "color") {
+		t.Errorf("ReadEPUBText() = %q, includes script or style", text)
+	}
+	if strings.Index(text, "First") > strings.Index(text, "Item") {
+		t.Errorf("ReadEPUBText() = %q, not in reading order", text)
+	}
+}
@@ -0,0 +1,476 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const epubMimetype = "application/epub+zip"
+
+// Encryption algorithms used for font obfuscation rather than DRM.
+var fontObfuscationAlgorithms = map[string]bool{
+	"http://www.idpf.org/2008/embedding": true,
+	"http://ns.adobe.com/pdf/enc#RC":     true,
+}
+
+var (
+	ErrDRMProtected = errors.New("EPUB is DRM protected")
+	ErrInvalidEPUB  = errors.New("EPUB is invalid")
+)
+
+var namedEntityPattern = regexp.MustCompile(`&([A-Za-z][A-Za-z0-9]*);`)
+
+// ValidationReport describes the problems found in an EPUB and the repairs
+// made to it before conversion.
+type ValidationReport struct {
+	DRM      string
+	Errors   []string
+	Warnings []string
+	Repairs  []string
+}
+
+func (r *ValidationReport) Repaired() bool {
+	return len(r.Repairs) > 0
+}
+
+func (r *ValidationReport) Empty() bool {
+	return r.DRM == "" && len(r.Errors) == 0 && len(r.Warnings) == 0 && len(r.Repairs) == 0
+}
+
+func (r *ValidationReport) String() string {
+	if r.Empty() {
+		return "No problems found"
+	}
+
+	var b strings.Builder
+	if r.DRM != "" {
+		fmt.Fprintf(&b, "DRM: %s\n", r.DRM)
+	}
+	writeSection := func(title string, lines []string) {
+		if len(lines) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "%s:\n", title)
+		for _, line := range lines {
+			fmt.Fprintf(&b, "- %s\n", line)
+		}
+	}
+	writeSection("Errors", r.Errors)
+	writeSection("Repaired", r.Repairs)
+	writeSection("Warnings", r.Warnings)
+	return strings.TrimRight(b.String(), "\n")
+}
+
+func (r *ValidationReport) errorf(format string, args ...any) {
+	r.Errors = append(r.Errors, fmt.Sprintf(format, args...))
+}
+
+func (r *ValidationReport) warnf(format string, args ...any) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+func (r *ValidationReport) repairf(format string, args ...any) {
+	r.Repairs = append(r.Repairs, fmt.Sprintf(format, args...))
+}
+
+type ValidatorService struct{}
+
+func NewValidatorService() *ValidatorService {
+	return &ValidatorService{}
+}
+
+// Validate checks the structure of the EPUB at inputPath. Problems that can
+// be fixed safely are repaired into a new archive at repairedPath; the report
+// says whether that happened. An error is returned when the book cannot be
+// converted at all, for example because it is DRM protected.
+func (v *ValidatorService) Validate(inputPath, repairedPath string) (*ValidationReport, error) {
+	report := &ValidationReport{}
+
+	zipReader, err := zip.OpenReader(inputPath)
+	if err != nil {
+		report.errorf("File is not a ZIP archive: %v", err)
+		return report, fmt.Errorf("%w: not a ZIP archive", ErrInvalidEPUB)
+	}
+	defer func() {
+		if closeErr := zipReader.Close(); closeErr != nil {
+			log.Printf("Warning: Failed to close ZIP reader: %v", closeErr)
+		}
+	}()
+
+	files := make(map[string]*zip.File, len(zipReader.File))
+	for _, f := range zipReader.File {
+		files[f.Name] = f
+	}
+
+	if drm := detectDRM(&zipReader.Reader, files); drm != "" {
+		report.DRM = drm
+		report.errorf("Book is protected by %s and cannot be converted", drm)
+		return report, fmt.Errorf("%w: %s", ErrDRMProtected, drm)
+	}
+
+	// Entries to rewrite in the repaired archive, keyed by name
+	replacements := make(map[string][]byte)
+	needsRewrite := false
+
+	if f, ok := files["mimetype"]; !ok {
+		report.repairf("Added missing mimetype entry")
+		needsRewrite = true
+	} else {
+		data, err := readZipEntry(&zipReader.Reader, "mimetype")
+		if err != nil || strings.TrimSpace(string(data)) != epubMimetype {
+			report.repairf("Replaced incorrect mimetype %q with %q", strings.TrimSpace(string(data)), epubMimetype)
+			needsRewrite = true
+		} else if zipReader.File[0] != f || f.Method != zip.Store {
+			report.repairf("Moved mimetype to the start of the archive and stored it uncompressed")
+			needsRewrite = true
+		}
+	}
+
+	if _, ok := files["META-INF/container.xml"]; !ok {
+		opfPath := findSingleOPF(files)
+		if opfPath == "" {
+			report.errorf("META-INF/container.xml is missing and no package document could be found")
+			return report, fmt.Errorf("%w: missing container.xml", ErrInvalidEPUB)
+		}
+		replacements["META-INF/container.xml"] = []byte(containerXML(opfPath))
+		report.repairf("Created missing META-INF/container.xml pointing at %s", opfPath)
+		needsRewrite = true
+	}
+
+	book, err := readRepairedPackage(&zipReader.Reader, replacements)
+	if err != nil {
+		report.errorf("%v", err)
+		return report, fmt.Errorf("%w: %v", ErrInvalidEPUB, err)
+	}
+
+	opfData, err := readZipEntry(&zipReader.Reader, book.opfPath)
+	if err != nil {
+		report.errorf("Failed to read package document: %v", err)
+		return report, fmt.Errorf("%w: %v", ErrInvalidEPUB, err)
+	}
+	opfChanged := false
+
+	// Manifest items must point at files that exist
+	seenIDs := make(map[string]bool)
+	removedIDs := make(map[string]bool)
+	for _, item := range book.pkg.Manifest {
+		if seenIDs[item.ID] {
+			report.warnf("Duplicate manifest id %q", item.ID)
+		}
+		seenIDs[item.ID] = true
+
+		if strings.Contains(item.Href, "://") {
+			continue
+		}
+		name := book.resolve(item.Href)
+		if _, ok := files[name]; ok {
+			continue
+		}
+		updated := removeManifestItem(opfData, item.ID)
+		if pkg, err := parsePackage(updated); err != nil || slices.ContainsFunc(pkg.Manifest, func(i opfItem) bool { return i.ID == item.ID }) {
+			report.warnf("Manifest item %q points at missing file %s but could not be removed", item.ID, name)
+			continue
+		}
+		report.repairf("Removed manifest item %q: file %s is missing", item.ID, name)
+		opfData = updated
+		removedIDs[item.ID] = true
+		opfChanged = true
+	}
+
+	// Spine entries must reference manifest items
+	validSpine := 0
+	for _, ref := range book.pkg.Spine {
+		if removedIDs[ref.IDRef] || !seenIDs[ref.IDRef] {
+			updated := removeSpineItem(opfData, ref.IDRef)
+			if pkg, err := parsePackage(updated); err != nil || slices.ContainsFunc(pkg.Spine, func(r opfItemRef) bool { return r.IDRef == ref.IDRef }) {
+				report.warnf("Spine entry %q has no matching manifest item but could not be removed", ref.IDRef)
+				continue
+			}
+			report.repairf("Removed spine entry %q: no matching manifest item", ref.IDRef)
+			opfData = updated
+			opfChanged = true
+			continue
+		}
+		validSpine++
+	}
+	if validSpine == 0 {
+		report.errorf("Spine has no readable content documents")
+		return report, fmt.Errorf("%w: empty spine", ErrInvalidEPUB)
+	}
+	if opfChanged {
+		replacements[book.opfPath] = opfData
+		needsRewrite = true
+	}
+
+	// Files that are not listed in the manifest are harmless but worth noting
+	var unlisted []string
+	for name, f := range files {
+		if f.FileInfo().IsDir() || name == "mimetype" || name == book.opfPath || strings.HasPrefix(name, "META-INF/") {
+			continue
+		}
+		if book.itemByPath(name) == nil {
+			unlisted = append(unlisted, name)
+		}
+	}
+	sort.Strings(unlisted)
+	for _, name := range unlisted {
+		report.warnf("File %s is not listed in the manifest", name)
+	}
+
+	// Content documents must be well-formed XHTML
+	for _, item := range book.pkg.Manifest {
+		if item.MediaType != "application/xhtml+xml" || removedIDs[item.ID] {
+			continue
+		}
+		name := book.resolve(item.Href)
+		data, err := readZipEntry(&zipReader.Reader, name)
+		if err != nil {
+			continue
+		}
+		wellFormedErr := checkWellFormed(data)
+		if wellFormedErr == nil {
+			continue
+		}
+		if fixed := replaceNamedEntities(data); !bytes.Equal(fixed, data) && checkWellFormed(fixed) == nil {
+			replacements[name] = fixed
+			report.repairf("Replaced HTML named entities in %s", name)
+			needsRewrite = true
+			continue
+		}
+		report.warnf("%s is not well-formed XHTML: %v", name, wellFormedErr)
+	}
+
+	if needsRewrite {
+		if err := writeRepairedEPUB(zipReader.File, replacements, repairedPath); err != nil {
+			return report, fmt.Errorf("failed to write repaired EPUB: %w", err)
+		}
+	}
+
+	return report, nil
+}
+
+func detectDRM(r *zip.Reader, files map[string]*zip.File) string {
+	if _, ok := files["META-INF/rights.xml"]; ok {
+		return "Adobe ADEPT DRM"
+	}
+	if _, ok := files["META-INF/license.lcpl"]; ok {
+		return "Readium LCP DRM"
+	}
+	if _, ok := files["META-INF/sinf.xml"]; ok {
+		return "Apple FairPlay DRM"
+	}
+
+	data, err := readZipEntry(r, "META-INF/encryption.xml")
+	if err != nil {
+		return ""
+	}
+
+	var encryption struct {
+		Data []struct {
+			Method struct {
+				Algorithm string `xml:"Algorithm,attr"`
+			} `xml:"EncryptionMethod"`
+			RetrievalMethod struct {
+				URI string `xml:"URI,attr"`
+			} `xml:"KeyInfo>RetrievalMethod"`
+		} `xml:"EncryptedData"`
+	}
+	if err := xml.Unmarshal(data, &encryption); err != nil {
+		return "unknown DRM (unreadable encryption.xml)"
+	}
+
+	for _, d := range encryption.Data {
+		if fontObfuscationAlgorithms[d.Method.Algorithm] {
+			continue
+		}
+		if strings.Contains(d.RetrievalMethod.URI, "license.lcpl") {
+			return "Readium LCP DRM"
+		}
+		return "encryption (" + d.Method.Algorithm + ")"
+	}
+	return ""
+}
+
+func findSingleOPF(files map[string]*zip.File) string {
+	found := ""
+	for name := range files {
+		if strings.HasSuffix(strings.ToLower(name), ".opf") {
+			if found != "" {
+				return ""
+			}
+			found = name
+		}
+	}
+	return found
+}
+
+func containerXML(opfPath string) string {
+	var fullPath strings.Builder
+	_ = xml.EscapeText(&fullPath, []byte(opfPath)) // Writing to a Builder can't fail
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="` + fullPath.String() + `" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+}
+
+// readRepairedPackage reads the package document, preferring a replacement
+// container.xml when one was generated.
+func readRepairedPackage(r *zip.Reader, replacements map[string][]byte) (*epubBook, error) {
+	container, ok := replacements["META-INF/container.xml"]
+	if !ok {
+		return readEPUBPackage(r)
+	}
+
+	var parsed epubContainer
+	if err := xml.Unmarshal(container, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse container.xml: %w", err)
+	}
+	opfPath := parsed.Rootfiles[0].FullPath
+	data, err := readZipEntry(r, opfPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read package document: %w", err)
+	}
+	pkg, err := parsePackage(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse package document: %w", err)
+	}
+	return &epubBook{zip: r, opfPath: opfPath, pkg: pkg}, nil
+}
+
+func parsePackage(opf []byte) (*epubPackage, error) {
+	var pkg epubPackage
+	if err := xml.Unmarshal(opf, &pkg); err != nil {
+		return nil, err
+	}
+	return &pkg, nil
+}
+
+// removeManifestItem drops the manifest item with the id, whether it's
+// written as an empty element or with an end tag.
+func removeManifestItem(opf []byte, id string) []byte {
+	return removeElement(opf, "item", "id", id)
+}
+
+func removeSpineItem(opf []byte, idref string) []byte {
+	return removeElement(opf, "itemref", "idref", idref)
+}
+
+// removeElement drops the elements named name whose attr is value. The
+// attribute must follow whitespace, so data-id and the like don't match.
+func removeElement(data []byte, name, attr, value string) []byte {
+	pattern := regexp.MustCompile(`<(?:\w+:)?` + name + `\s(?:[^>]*\s)?` + attr + `\s*=\s*(?:"` + regexp.QuoteMeta(value) + `"|'` + regexp.QuoteMeta(value) + `')[^>]*?(?:/>|>(?s:.*?)</(?:\w+:)?` + name + `\s*>)\s*`)
+	return pattern.ReplaceAll(data, nil)
+}
+
+func checkWellFormed(data []byte) error {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = true
+	for {
+		_, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// replaceNamedEntities swaps HTML-only named entities such as &nbsp; for
+// numeric character references, which XHTML parsers always understand.
+func replaceNamedEntities(data []byte) []byte {
+	return namedEntityPattern.ReplaceAllFunc(data, func(match []byte) []byte {
+		name := string(match[1 : len(match)-1])
+		switch name {
+		case "amp", "lt", "gt", "quot", "apos":
+			return match
+		}
+		value, ok := xml.HTMLEntity[name]
+		if !ok {
+			return match
+		}
+		var b strings.Builder
+		for _, r := range value {
+			fmt.Fprintf(&b, "&#%d;", r)
+		}
+		return []byte(b.String())
+	})
+}
+
+func writeRepairedEPUB(files []*zip.File, replacements map[string][]byte, outputPath string) error {
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := out.Close(); closeErr != nil {
+			log.Printf("Warning: Failed to close repaired EPUB: %v", closeErr)
+		}
+	}()
+
+	writer := zip.NewWriter(out)
+
+	w, err := writer.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, epubMimetype); err != nil {
+		return err
+	}
+
+	written := map[string]bool{"mimetype": true}
+	for _, f := range files {
+		if written[f.Name] {
+			continue
+		}
+		written[f.Name] = true
+
+		if data, ok := replacements[f.Name]; ok {
+			if err := writeZipEntry(writer, f.Name, data); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := writer.Copy(f); err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(replacements))
+	for name := range replacements {
+		if !written[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := writeZipEntry(writer, name, replacements[name]); err != nil {
+			return err
+		}
+	}
+
+	return writer.Close()
+}
+
+func writeZipEntry(writer *zip.Writer, name string, data []byte) error {
+	w, err := writer.CreateHeader(&zip.FileHeader{Name: path.Clean(name), Method: zip.Deflate})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
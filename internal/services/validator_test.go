@@ -0,0 +1,247 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bookify/internal/testutil"
+)
+
+const fontObfuscationXML = `<?xml version="1.0" encoding="UTF-8"?>
+<encryption xmlns="urn:oasis:names:tc:opendocument:xmlns:container" xmlns:enc="http://www.w3.org/2001/04/xmlenc#">
+  <enc:EncryptedData>
+    <enc:EncryptionMethod Algorithm="http://www.idpf.org/2008/embedding"/>
+    <enc:CipherData><enc:CipherReference URI="OEBPS/font.otf"/></enc:CipherData>
+  </enc:EncryptedData>
+</encryption>`
+
+const contentEncryptionXML = `<?xml version="1.0" encoding="UTF-8"?>
+<encryption xmlns="urn:oasis:names:tc:opendocument:xmlns:container" xmlns:enc="http://www.w3.org/2001/04/xmlenc#">
+  <enc:EncryptedData>
+    <enc:EncryptionMethod Algorithm="http://www.w3.org/2001/04/xmlenc#aes128-cbc"/>
+    <enc:CipherData><enc:CipherReference URI="OEBPS/chapter1.xhtml"/></enc:CipherData>
+  </enc:EncryptedData>
+</encryption>`
+
+func TestValidatorService_Validate(t *testing.T) {
+	tests := []struct {
+		name          string
+		entries       func() map[string]string
+		expectErr     error
+		expectRepair  string
+		expectWarning string
+	}{
+		{
+			name:    "valid book",
+			entries: testutil.ValidEPUBEntries,
+		},
+		{
+			name: "font obfuscation is not DRM",
+			entries: func() map[string]string {
+				entries := testutil.ValidEPUBEntries()
+				entries["META-INF/encryption.xml"] = fontObfuscationXML
+				return entries
+			},
+		},
+		{
+			name: "Adobe DRM",
+			entries: func() map[string]string {
+				entries := testutil.ValidEPUBEntries()
+				entries["META-INF/rights.xml"] = "<rights/>"
+				return entries
+			},
+			expectErr: ErrDRMProtected,
+		},
+		{
+			name: "encrypted content",
+			entries: func() map[string]string {
+				entries := testutil.ValidEPUBEntries()
+				entries["META-INF/encryption.xml"] = contentEncryptionXML
+				return entries
+			},
+			expectErr: ErrDRMProtected,
+		},
+		{
+			name: "missing mimetype",
+			entries: func() map[string]string {
+				entries := testutil.ValidEPUBEntries()
+				delete(entries, "mimetype")
+				return entries
+			},
+			expectRepair: "Added missing mimetype",
+		},
+		{
+			name: "missing container.xml",
+			entries: func() map[string]string {
+				entries := testutil.ValidEPUBEntries()
+				delete(entries, "META-INF/container.xml")
+				return entries
+			},
+			expectRepair: "Created missing META-INF/container.xml",
+		},
+		{
+			name: "missing container.xml with markup in the package name",
+			entries: func() map[string]string {
+				entries := testutil.ValidEPUBEntries()
+				delete(entries, "META-INF/container.xml")
+				entries[`OEBPS/Tom & "Jerry" <1>.opf`] = entries["OEBPS/content.opf"]
+				delete(entries, "OEBPS/content.opf")
+				return entries
+			},
+			expectRepair: `Created missing META-INF/container.xml pointing at OEBPS/Tom & "Jerry" <1>.opf`,
+		},
+		{
+			name: "manifest references missing file",
+			entries: func() map[string]string {
+				entries := testutil.ValidEPUBEntries()
+				entries["OEBPS/content.opf"] = strings.Replace(entries["OEBPS/content.opf"],
+					`<item id="chapter1"`, `<item id="ghost" href="ghost.xhtml" media-type="application/xhtml+xml"/>
+    <item id="chapter1"`, 1)
+				entries["OEBPS/content.opf"] = strings.Replace(entries["OEBPS/content.opf"],
+					`<itemref idref="chapter1"/>`, `<itemref idref="ghost"/><itemref idref="chapter1"/>`, 1)
+				return entries
+			},
+			expectRepair: `Removed manifest item "ghost"`,
+		},
+		{
+			name: "missing file listed with an end tag",
+			entries: func() map[string]string {
+				entries := testutil.ValidEPUBEntries()
+				entries["OEBPS/content.opf"] = strings.Replace(entries["OEBPS/content.opf"],
+					`<item id="chapter1"`, `<item id="ghost" href="ghost.xhtml" media-type="application/xhtml+xml"></item>
+    <item data-id="ghost" id="chapter1"`, 1)
+				entries["OEBPS/content.opf"] = strings.Replace(entries["OEBPS/content.opf"],
+					`<itemref idref="chapter1"/>`, `<itemref idref="ghost"></itemref><itemref idref="chapter1"/>`, 1)
+				return entries
+			},
+			expectRepair: `Removed spine entry "ghost"`,
+		},
+		{
+			name: "HTML entities in XHTML",
+			entries: func() map[string]string {
+				entries := testutil.ValidEPUBEntries()
+				entries["OEBPS/chapter1.xhtml"] = strings.Replace(entries["OEBPS/chapter1.xhtml"],
+					"dark and", "dark&nbsp;and", 1)
+				return entries
+			},
+			expectRepair: "Replaced HTML named entities in OEBPS/chapter1.xhtml",
+		},
+		{
+			name: "malformed XHTML",
+			entries: func() map[string]string {
+				entries := testutil.ValidEPUBEntries()
+				entries["OEBPS/chapter1.xhtml"] = "<html><body><p>Unclosed</body></html>"
+				return entries
+			},
+			expectWarning: "not well-formed XHTML",
+		},
+		{
+			name: "empty spine",
+			entries: func() map[string]string {
+				entries := testutil.ValidEPUBEntries()
+				entries["OEBPS/content.opf"] = strings.Replace(entries["OEBPS/content.opf"],
+					`<itemref idref="chapter1"/>`, `<itemref idref="missing"/>`, 1)
+				return entries
+			},
+			expectErr: ErrInvalidEPUB,
+		},
+	}
+
+	validator := NewValidatorService()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inputPath := testutil.CreateEPUBArchive(t, "book.epub", tt.entries())
+			repairedPath := filepath.Join(t.TempDir(), "repaired.epub")
+
+			report, err := validator.Validate(inputPath, repairedPath)
+			if tt.expectErr != nil {
+				if !errors.Is(err, tt.expectErr) {
+					t.Fatalf("Validate() error = %v, want %v", err, tt.expectErr)
+				}
+				if len(report.Errors) == 0 {
+					t.Errorf("Validate() report has no errors: %s", report)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Validate() unexpected error: %v", err)
+			}
+
+			if tt.expectWarning != "" && !strings.Contains(report.String(), tt.expectWarning) {
+				t.Errorf("Validate() report = %q, want warning %q", report, tt.expectWarning)
+			}
+
+			if tt.expectRepair == "" {
+				if report.Repaired() {
+					t.Errorf("Validate() made unexpected repairs: %s", report)
+				}
+				if _, err := os.Stat(repairedPath); !os.IsNotExist(err) {
+					t.Errorf("Validate() wrote a repaired file without repairs")
+				}
+				return
+			}
+
+			if !strings.Contains(report.String(), tt.expectRepair) {
+				t.Errorf("Validate() report = %q, want repair %q", report, tt.expectRepair)
+			}
+
+			// The repaired book must validate cleanly
+			again, err := validator.Validate(repairedPath, filepath.Join(t.TempDir(), "again.epub"))
+			if err != nil {
+				t.Fatalf("Validate() of repaired book error = %v", err)
+			}
+			if again.Repaired() || len(again.Errors) > 0 {
+				t.Errorf("Repaired book still has problems: %s", again)
+			}
+		})
+	}
+}
+
+func TestRemoveManifestItem(t *testing.T) {
+	tests := []struct {
+		opf  string
+		want string
+	}{
+		{`<item id="a" href="a.xhtml"/> <item id="b"/>`, `<item id="b"/>`},
+		{`<opf:item href="a.xhtml" id='a'></opf:item><item id="b"/>`, `<item id="b"/>`},
+		{"<item\n  id=\"a\">\n</item>\n<item id=\"b\"/>", `<item id="b"/>`},
+		{`<item data-id="a" id="b"/><item id="ab"/>`, `<item data-id="a" id="b"/><item id="ab"/>`},
+		{`<itemref idref="a"/>`, `<itemref idref="a"/>`},
+	}
+	for _, tt := range tests {
+		if got := string(removeManifestItem([]byte(tt.opf), "a")); got != tt.want {
+			t.Errorf("removeManifestItem(%q) = %q, want %q", tt.opf, got, tt.want)
+		}
+	}
+}
+
+func TestValidatorService_Validate_NotZip(t *testing.T) {
+	validator := NewValidatorService()
+	inputPath := testutil.CreateInvalidFile(t, "invalid.epub")
+
+	report, err := validator.Validate(inputPath, filepath.Join(t.TempDir(), "repaired.epub"))
+	if !errors.Is(err, ErrInvalidEPUB) {
+		t.Errorf("Validate() error = %v, want ErrInvalidEPUB", err)
+	}
+	if report == nil || len(report.Errors) == 0 {
+		t.Errorf("Validate() should report an error for non-ZIP input")
+	}
+}
+
+func TestValidationReport_String(t *testing.T) {
+	report := &ValidationReport{}
+	if report.String() != "No problems found" {
+		t.Errorf("String() = %q for empty report", report.String())
+	}
+
+	report.repairf("Fixed %s", "mimetype")
+	report.warnf("Unlisted file")
+	got := report.String()
+	want := "Repaired:\n- Fixed mimetype\nWarnings:\n- Unlisted file"
+	if got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
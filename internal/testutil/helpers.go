@@ -1,6 +1,7 @@
 package testutil
 
 import (
+	"archive/zip"
 	"bytes"
 	"io"
 	"mime/multipart"
@@ -8,6 +9,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"testing"
 
@@ -27,6 +29,73 @@ func SetupTestDB(t *testing.T) *gorm.DB {
 	return db
 }
 
+// CreateCalibreLibrary builds a library folder with the parts of Calibre's
+// schema Bookify reads: two books in a series, one without a usable format.
+func CreateCalibreLibrary(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	database, err := gorm.Open(sqlite.Open(filepath.Join(dir, "metadata.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to create metadata.db: %v", err)
+	}
+	statements := []string{
+		"CREATE TABLE books (id INTEGER PRIMARY KEY, title TEXT, sort TEXT, path TEXT, series_index REAL DEFAULT 1.0)",
+		"CREATE TABLE authors (id INTEGER PRIMARY KEY, name TEXT)",
+		"CREATE TABLE books_authors_link (id INTEGER PRIMARY KEY, book INTEGER, author INTEGER)",
+		"CREATE TABLE series (id INTEGER PRIMARY KEY, name TEXT)",
+		"CREATE TABLE books_series_link (id INTEGER PRIMARY KEY, book INTEGER, series INTEGER)",
+		"CREATE TABLE tags (id INTEGER PRIMARY KEY, name TEXT)",
+		"CREATE TABLE books_tags_link (id INTEGER PRIMARY KEY, book INTEGER, tag INTEGER)",
+		"CREATE TABLE ratings (id INTEGER PRIMARY KEY, rating INTEGER)",
+		"CREATE TABLE books_ratings_link (id INTEGER PRIMARY KEY, book INTEGER, rating INTEGER)",
+		"CREATE TABLE data (id INTEGER PRIMARY KEY, book INTEGER, format TEXT, name TEXT)",
+		"CREATE TABLE custom_columns (id INTEGER PRIMARY KEY, label TEXT, name TEXT, datatype TEXT)",
+		"CREATE TABLE custom_column_1 (id INTEGER PRIMARY KEY, value TEXT)",
+		"CREATE TABLE books_custom_column_1_link (id INTEGER PRIMARY KEY, book INTEGER, value INTEGER)",
+
+		"INSERT INTO books VALUES (1, 'The Fifth Season', 'Fifth Season, The', 'N. K. Jemisin/The Fifth Season (1)', 1)",
+		"INSERT INTO books VALUES (2, 'The Obelisk Gate', 'Obelisk Gate, The', 'N. K. Jemisin/The Obelisk Gate (2)', 2)",
+		"INSERT INTO books VALUES (3, 'Scanned Notes', 'Scanned Notes', 'Unknown/Scanned Notes (3)', 1)",
+		"INSERT INTO authors VALUES (1, 'N. K. Jemisin')",
+		"INSERT INTO books_authors_link VALUES (1, 1, 1), (2, 2, 1)",
+		"INSERT INTO series VALUES (1, 'The Broken Earth')",
+		"INSERT INTO books_series_link VALUES (1, 1, 1), (2, 2, 1)",
+		"INSERT INTO tags VALUES (1, 'Fantasy'), (2, 'Award winner')",
+		"INSERT INTO books_tags_link VALUES (1, 1, 1), (2, 1, 2), (3, 2, 1)",
+		"INSERT INTO ratings VALUES (1, 10), (2, 7)",
+		"INSERT INTO books_ratings_link VALUES (1, 1, 1), (2, 2, 2)",
+		"INSERT INTO data VALUES (1, 1, 'EPUB', 'The Fifth Season - N. K. Jemisin'), (2, 1, 'MOBI', 'The Fifth Season - N. K. Jemisin')",
+		"INSERT INTO data VALUES (3, 2, 'EPUB', 'The Obelisk Gate - N. K. Jemisin'), (4, 3, 'PDF', 'Scanned Notes - Unknown')",
+		"INSERT INTO custom_columns VALUES (1, 'shelf', 'Shelf', 'text')",
+		"INSERT INTO custom_column_1 VALUES (1, 'To read')",
+		"INSERT INTO books_custom_column_1_link VALUES (1, 2, 1)",
+	}
+	for _, statement := range statements {
+		if err := database.Exec(statement).Error; err != nil {
+			t.Fatalf("Failed to build metadata.db: %s: %v", statement, err)
+		}
+	}
+	sqlDB, _ := database.DB()
+	_ = sqlDB.Close()
+
+	files := map[string]string{
+		"N. K. Jemisin/The Fifth Season (1)/The Fifth Season - N. K. Jemisin.epub": "fifth season",
+		"N. K. Jemisin/The Obelisk Gate (2)/The Obelisk Gate - N. K. Jemisin.epub": "obelisk gate",
+		"Unknown/Scanned Notes (3)/Scanned Notes - Unknown.pdf":                    "pdf",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create book folder: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write book: %v", err)
+		}
+	}
+	return dir
+}
+
 // CreateTestEPUB creates a minimal valid EPUB file for testing
 func CreateTestEPUB(t *testing.T, filename string) string {
 	t.Helper()
@@ -52,6 +121,110 @@ func CreateTestEPUB(t *testing.T, filename string) string {
 	return epubPath
 }
 
+// CreateEPUBArchive creates an EPUB (ZIP) file with the given entries. The
+// mimetype entry is written first and stored uncompressed, as EPUB requires.
+func CreateEPUBArchive(t *testing.T, filename string, entries map[string]string) string {
+	t.Helper()
+
+	tempDir := t.TempDir()
+	epubPath := filepath.Join(tempDir, filename)
+
+	file, err := os.Create(epubPath)
+	if err != nil {
+		t.Fatalf("Failed to create test EPUB: %v", err)
+	}
+	defer func() {
+		_ = file.Close() // Error ignored in test helper
+	}()
+
+	writer := zip.NewWriter(file)
+
+	if mimetype, ok := entries["mimetype"]; ok {
+		w, err := writer.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+		if err != nil {
+			t.Fatalf("Failed to create mimetype entry: %v", err)
+		}
+		if _, err := io.WriteString(w, mimetype); err != nil {
+			t.Fatalf("Failed to write mimetype entry: %v", err)
+		}
+	}
+
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		if name != "mimetype" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		w, err := writer.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to create entry %s: %v", name, err)
+		}
+		if _, err := io.WriteString(w, entries[name]); err != nil {
+			t.Fatalf("Failed to write entry %s: %v", name, err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to finalize test EPUB: %v", err)
+	}
+
+	return epubPath
+}
+
+// ValidEPUBEntries returns the entries of a small but structurally complete EPUB 3 book
+func ValidEPUBEntries() map[string]string {
+	return map[string]string{
+		"mimetype": "application/epub+zip",
+		"META-INF/container.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>`,
+		"OEBPS/content.opf": `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="bookid">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="bookid">urn:uuid:12345678-1234-1234-1234-123456789012</dc:identifier>
+    <dc:title>Test Book</dc:title>
+    <dc:creator>Test Author</dc:creator>
+    <dc:language>en</dc:language>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="chapter1" href="chapter1.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine>
+    <itemref idref="chapter1"/>
+  </spine>
+</package>`,
+		"OEBPS/nav.xhtml": `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>Contents</title></head>
+<body><nav epub:type="toc"><ol><li><a href="chapter1.xhtml">Chapter 1</a></li></ol></nav></body>
+</html>`,
+		"OEBPS/chapter1.xhtml": `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head><title>Chapter 1</title></head>
+<body><h1>Chapter 1</h1><p>It was a dark and stormy night.</p></body>
+</html>`,
+	}
+}
+
+// CreateValidEPUB creates a structurally complete EPUB. Entries in overrides
+// replace or extend the defaults from ValidEPUBEntries.
+func CreateValidEPUB(t *testing.T, filename string, overrides map[string]string) string {
+	t.Helper()
+
+	entries := ValidEPUBEntries()
+	for name, content := range overrides {
+		entries[name] = content
+	}
+	return CreateEPUBArchive(t, filename, entries)
+}
+
 // CreateInvalidFile creates a file that's not a valid EPUB
 func CreateInvalidFile(t *testing.T, filename string) string {
 	t.Helper()
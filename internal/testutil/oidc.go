@@ -0,0 +1,199 @@
+package testutil
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// MockOIDC is a local OpenID Connect provider. Its authorization endpoint
+// signs in straight away, as whoever Claims describe.
+type MockOIDC struct {
+	URL string
+	// Issuer it publishes and signs tokens as, the URL unless set
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Key          *rsa.PrivateKey
+	KeyID        string
+	// Claims of the ID tokens it issues, besides iss, aud, exp, iat and nonce
+	Claims jwt.MapClaims
+
+	mu    sync.Mutex
+	codes map[string]mockOIDCCode
+}
+
+type mockOIDCCode struct {
+	Challenge string
+	Nonce     string
+	Claims    jwt.MapClaims
+}
+
+// NewMockOIDC starts a provider for the client, stopped when the test ends.
+func NewMockOIDC(t *testing.T, clientID, clientSecret string) *MockOIDC {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	m := &MockOIDC{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Key:          key,
+		KeyID:        "test-key",
+		Claims:       jwt.MapClaims{"sub": "subject-1"},
+		codes:        make(map[string]mockOIDCCode),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"issuer":                 m.issuer(),
+			"authorization_endpoint": m.URL + "/authorize",
+			"token_endpoint":         m.URL + "/token",
+			"jwks_uri":               m.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		encode := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": m.KeyID,
+				"use": "sig",
+				"alg": "RS256",
+				"n":   encode(m.Key.N.Bytes()),
+				"e":   encode(big.NewInt(int64(m.Key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/authorize", m.authorize)
+	mux.HandleFunc("/token", m.token)
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	m.URL = server.URL
+	return m
+}
+
+func (m *MockOIDC) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != m.ClientID || query.Get("response_type") != "code" ||
+		query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	random := make([]byte, 16)
+	_, _ = rand.Read(random)
+	code := hex.EncodeToString(random)
+	m.mu.Lock()
+	claims := jwt.MapClaims{}
+	for name, value := range m.Claims {
+		claims[name] = value
+	}
+	m.codes[code] = mockOIDCCode{Challenge: query.Get("code_challenge"), Nonce: query.Get("nonce"), Claims: claims}
+	m.mu.Unlock()
+
+	values := redirect.Query()
+	values.Set("code", code)
+	values.Set("state", query.Get("state"))
+	redirect.RawQuery = values.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (m *MockOIDC) token(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.FormValue("client_id"), r.FormValue("client_secret")
+	}
+	if clientID != m.ClientID || clientSecret != m.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	m.mu.Lock()
+	code, ok := m.codes[r.FormValue("code")]
+	delete(m.codes, r.FormValue("code"))
+	m.mu.Unlock()
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !ok || r.FormValue("grant_type") != "authorization_code" ||
+		base64.RawURLEncoding.EncodeToString(sum[:]) != code.Challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	code.Claims["nonce"] = code.Nonce
+	idToken, err := m.sign(code.Claims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": "access-token",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+// IDToken signs an ID token for the client with the claims, filling in the
+// issuer, audience and times unless they're given.
+func (m *MockOIDC) IDToken(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token, err := m.sign(claims)
+	if err != nil {
+		t.Fatalf("Failed to sign ID token: %v", err)
+	}
+	return token
+}
+
+func (m *MockOIDC) sign(claims jwt.MapClaims) (string, error) {
+	now := time.Now()
+	defaults := jwt.MapClaims{
+		"iss": m.issuer(),
+		"aud": m.ClientID,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+	for name, value := range defaults {
+		if _, ok := claims[name]; !ok {
+			claims[name] = value
+		}
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = m.KeyID
+	return token.SignedString(m.Key)
+}
+
+func (m *MockOIDC) issuer() string {
+	if m.Issuer != "" {
+		return m.Issuer
+	}
+	return m.URL
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}